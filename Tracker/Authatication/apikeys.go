@@ -0,0 +1,394 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"Tracker/internal/audit"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// APIKeyPrefix marks personal API keys so they can be told apart from JWTs
+const APIKeyPrefix = "trk_"
+
+// apiKeyTouchInterval limits how often LastUsedAt is written for a busy key
+const apiKeyTouchInterval = time.Minute
+
+// API key scopes
+const (
+	ScopeEventsWrite     = "events:write"
+	ScopeActivitiesRead  = "activities:read"
+	ScopeActivitiesWrite = "activities:write"
+)
+
+// validScopes lists the scopes that can be granted to an API key
+var validScopes = map[string]bool{
+	ScopeEventsWrite:     true,
+	ScopeActivitiesRead:  true,
+	ScopeActivitiesWrite: true,
+}
+
+var (
+	// ErrAPIKeyNotFound is returned for unknown or revoked API keys
+	ErrAPIKeyNotFound = errors.New("api key not found")
+)
+
+// APIKey is a hashed personal API key
+type APIKey struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID     string             `bson:"userId" json:"userId"`
+	Name       string             `bson:"name" json:"name"`
+	Prefix     string             `bson:"prefix" json:"prefix"`
+	KeyHash    string             `bson:"keyHash" json:"-"`
+	Scopes     []string           `bson:"scopes" json:"scopes"`
+	CreatedAt  time.Time          `bson:"createdAt" json:"createdAt"`
+	LastUsedAt *time.Time         `bson:"lastUsedAt,omitempty" json:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time         `bson:"revokedAt,omitempty" json:"revokedAt,omitempty"`
+}
+
+// HasScope reports whether the key grants a scope
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKeyStore persists personal API keys
+type APIKeyStore interface {
+	Create(ctx context.Context, key *APIKey) error
+	FindByHash(ctx context.Context, keyHash string) (*APIKey, error)
+	FindByID(ctx context.Context, id primitive.ObjectID) (*APIKey, error)
+	ListByUser(ctx context.Context, userID string) ([]APIKey, error)
+	Rename(ctx context.Context, userID string, id primitive.ObjectID, name string) error
+	Revoke(ctx context.Context, userID string, id primitive.ObjectID) error
+	TouchLastUsed(ctx context.Context, id primitive.ObjectID, at time.Time) error
+}
+
+// apiKeyStore is the store used by the API key handlers and middleware
+var apiKeyStore APIKeyStore
+
+// SetAPIKeyStore configures the store used for personal API keys
+func SetAPIKeyStore(store APIKeyStore) {
+	apiKeyStore = store
+}
+
+// MongoAPIKeyStore is an APIKeyStore backed by the api_keys collection
+type MongoAPIKeyStore struct {
+	collection *mongo.Collection
+}
+
+// NewMongoAPIKeyStore creates an API key store on top of the given collection
+func NewMongoAPIKeyStore(collection *mongo.Collection) *MongoAPIKeyStore {
+	return &MongoAPIKeyStore{
+		collection: collection,
+	}
+}
+
+// EnsureIndexes creates the key hash and owner indexes
+func (s *MongoAPIKeyStore) EnsureIndexes(ctx context.Context) error {
+	_, err := s.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "keyHash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "userId", Value: 1}}},
+	})
+	return err
+}
+
+// Create inserts a new API key
+func (s *MongoAPIKeyStore) Create(ctx context.Context, key *APIKey) error {
+	result, err := s.collection.InsertOne(ctx, key)
+	if err != nil {
+		return err
+	}
+	key.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// FindByHash looks up an active API key by the hash of its secret
+func (s *MongoAPIKeyStore) FindByHash(ctx context.Context, keyHash string) (*APIKey, error) {
+	var key APIKey
+	filter := bson.M{"keyHash": keyHash, "revokedAt": bson.M{"$exists": false}}
+	if err := s.collection.FindOne(ctx, filter).Decode(&key); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrAPIKeyNotFound
+		}
+		return nil, err
+	}
+	return &key, nil
+}
+
+// FindByID looks up a key by ID, including revoked ones
+func (s *MongoAPIKeyStore) FindByID(ctx context.Context, id primitive.ObjectID) (*APIKey, error) {
+	var key APIKey
+	if err := s.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&key); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrAPIKeyNotFound
+		}
+		return nil, err
+	}
+	return &key, nil
+}
+
+// ListByUser returns all keys of a user, including revoked ones
+func (s *MongoAPIKeyStore) ListByUser(ctx context.Context, userID string) ([]APIKey, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+	cursor, err := s.collection.Find(ctx, bson.M{"userId": userID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	keys := make([]APIKey, 0)
+	if err := cursor.All(ctx, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Rename changes the display name of a key owned by the user
+func (s *MongoAPIKeyStore) Rename(ctx context.Context, userID string, id primitive.ObjectID, name string) error {
+	result, err := s.collection.UpdateOne(ctx,
+		bson.M{"_id": id, "userId": userID},
+		bson.M{"$set": bson.M{"name": name}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// Revoke disables a key owned by the user
+func (s *MongoAPIKeyStore) Revoke(ctx context.Context, userID string, id primitive.ObjectID) error {
+	result, err := s.collection.UpdateOne(ctx,
+		bson.M{"_id": id, "userId": userID, "revokedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revokedAt": time.Now()}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// TouchLastUsed records when a key was last used
+func (s *MongoAPIKeyStore) TouchLastUsed(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	_, err := s.collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"lastUsedAt": at}})
+	return err
+}
+
+// authenticateAPIKey resolves an API key secret to its key and owner
+func authenticateAPIKey(ctx context.Context, secret string) (*APIKey, *User, error) {
+	if apiKeyStore == nil || userStore == nil {
+		return nil, nil, ErrInvalidAPIKey
+	}
+
+	key, err := apiKeyStore.FindByHash(ctx, hashToken(secret))
+	if err != nil {
+		if errors.Is(err, ErrAPIKeyNotFound) {
+			return nil, nil, ErrInvalidAPIKey
+		}
+		return nil, nil, err
+	}
+
+	user, err := userStore.FindByID(ctx, key.UserID)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return nil, nil, ErrInvalidAPIKey
+		}
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > apiKeyTouchInterval {
+		// LastUsedAt is informational, so a failed write must not reject the key
+		if err := apiKeyStore.TouchLastUsed(ctx, key.ID, now); err != nil {
+			log.Printf("Failed to record use of API key %s: %v", key.ID.Hex(), err)
+		} else {
+			key.LastUsedAt = &now
+		}
+	}
+
+	return key, user, nil
+}
+
+// isAPIKeyRevoked reports whether a key was revoked or deleted after it was
+// used to authenticate
+func isAPIKeyRevoked(ctx context.Context, id primitive.ObjectID) (bool, error) {
+	if apiKeyStore == nil {
+		return true, nil
+	}
+	key, err := apiKeyStore.FindByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, ErrAPIKeyNotFound) {
+			return true, nil
+		}
+		return false, err
+	}
+	return key.RevokedAt != nil, nil
+}
+
+// CreateAPIKeyRequest represents the API key creation request body
+type CreateAPIKeyRequest struct {
+	Name   string   `json:"name" binding:"required,max=100"`
+	Scopes []string `json:"scopes" binding:"required,min=1"`
+}
+
+// RenameAPIKeyRequest represents the API key rename request body
+type RenameAPIKeyRequest struct {
+	Name string `json:"name" binding:"required,max=100"`
+}
+
+// CreateAPIKeyResponse includes the plaintext key, which is only shown once
+type CreateAPIKeyResponse struct {
+	Key    string `json:"key"`
+	APIKey APIKey `json:"apiKey"`
+}
+
+// CreateAPIKeyHandler issues a new personal API key for the current user
+func CreateAPIKeyHandler(c *gin.Context) {
+	var req CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	for _, scope := range req.Scopes {
+		if !validScopes[scope] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown scope: " + scope})
+			return
+		}
+	}
+
+	userID, _ := GetUserID(c)
+	if apiKeyStore == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "API key store not configured"})
+		return
+	}
+
+	secret, err := randomToken(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate API key"})
+		return
+	}
+	plaintext := APIKeyPrefix + secret
+
+	key := &APIKey{
+		UserID:    userID,
+		Name:      strings.TrimSpace(req.Name),
+		Prefix:    plaintext[:len(APIKeyPrefix)+6],
+		KeyHash:   hashToken(plaintext),
+		Scopes:    req.Scopes,
+		CreatedAt: time.Now(),
+	}
+	if err := apiKeyStore.Create(c.Request.Context(), key); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API key"})
+		return
+	}
+
+	RecordAudit(c, audit.Event{
+		Action:   audit.ActionAPIKeyCreate,
+		TargetID: key.ID.Hex(),
+		Details:  map[string]string{"name": key.Name, "prefix": key.Prefix, "scopes": strings.Join(key.Scopes, " ")},
+	})
+
+	c.JSON(http.StatusCreated, CreateAPIKeyResponse{
+		Key:    plaintext,
+		APIKey: *key,
+	})
+}
+
+// ListAPIKeysHandler lists the current user's API keys
+func ListAPIKeysHandler(c *gin.Context) {
+	userID, _ := GetUserID(c)
+	if apiKeyStore == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "API key store not configured"})
+		return
+	}
+
+	keys, err := apiKeyStore.ListByUser(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list API keys"})
+		return
+	}
+
+	c.JSON(http.StatusOK, keys)
+}
+
+// RenameAPIKeyHandler renames one of the current user's API keys
+func RenameAPIKeyHandler(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	var req RenameAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, _ := GetUserID(c)
+	if apiKeyStore == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "API key store not configured"})
+		return
+	}
+
+	if err := apiKeyStore.Rename(c.Request.Context(), userID, id, strings.TrimSpace(req.Name)); err != nil {
+		if errors.Is(err, ErrAPIKeyNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rename API key"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "API key renamed successfully"})
+}
+
+// RevokeAPIKeyHandler revokes one of the current user's API keys
+func RevokeAPIKeyHandler(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return
+	}
+
+	userID, _ := GetUserID(c)
+	if apiKeyStore == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "API key store not configured"})
+		return
+	}
+
+	if err := apiKeyStore.Revoke(c.Request.Context(), userID, id); err != nil {
+		if errors.Is(err, ErrAPIKeyNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke API key"})
+		return
+	}
+
+	RecordAudit(c, audit.Event{
+		Action:   audit.ActionAPIKeyRevoke,
+		TargetID: id.Hex(),
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked successfully"})
+}
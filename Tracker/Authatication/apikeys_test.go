@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// createAPIKey stores a key for a user and returns its plaintext
+func createAPIKey(t *testing.T, keys *memoryAPIKeyStore, user *User) (string, *APIKey) {
+	t.Helper()
+
+	plaintext := APIKeyPrefix + "test-secret-" + user.ID
+	key := &APIKey{UserID: user.ID, Name: "test", KeyHash: hashToken(plaintext), Scopes: []string{ScopeEventsWrite}}
+	if err := keys.Create(context.Background(), key); err != nil {
+		t.Fatal(err)
+	}
+	return plaintext, key
+}
+
+func TestAuthenticateAPIKeyIgnoresTouchFailure(t *testing.T) {
+	users, _ := useMemoryStores(t)
+	keys := useMemoryAPIKeys(t)
+	user, _ := loggedInUser(t, users)
+	plaintext, _ := createAPIKey(t, keys, user)
+
+	keys.touchErr = errors.New("write failed")
+	key, owner, err := authenticateAPIKey(context.Background(), plaintext)
+	if err != nil {
+		t.Fatalf("authenticateAPIKey = %v, want the key despite the failed touch", err)
+	}
+	if owner.ID != user.ID || key.UserID != user.ID {
+		t.Errorf("key resolved to user %s, want %s", owner.ID, user.ID)
+	}
+	if key.LastUsedAt != nil {
+		t.Error("LastUsedAt set although the write failed")
+	}
+}
+
+func TestRevokedAPIKeyEndsWebSocketSession(t *testing.T) {
+	users, _ := useMemoryStores(t)
+	keys := useMemoryAPIKeys(t)
+	user, _ := loggedInUser(t, users)
+	plaintext, key := createAPIKey(t, keys, user)
+
+	ticket := requestTicket(t, plaintext)
+	session, err := redeemWebSocketTicket(context.Background(), ticket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.Principal.APIKey == nil || session.Principal.APIKey.ID != key.ID {
+		t.Fatalf("ticket principal is not tied to key %s", key.ID.Hex())
+	}
+	if revoked, err := session.Revoked(context.Background()); err != nil || revoked {
+		t.Fatalf("Revoked = %v, %v before revocation", revoked, err)
+	}
+
+	unused := requestTicket(t, plaintext)
+	if err := keys.Revoke(context.Background(), user.ID, key.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if revoked, err := session.Revoked(context.Background()); err != nil || !revoked {
+		t.Errorf("Revoked = %v, %v after revocation, want true", revoked, err)
+	}
+	if _, err := redeemWebSocketTicket(context.Background(), unused); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("redeem after revocation = %v, want ErrInvalidAPIKey", err)
+	}
+}
@@ -1,26 +1,34 @@
 package auth
 
 import (
+	"context"
 	"errors"
-	"fmt"
+	"io"
+	"log"
 	"net/http"
 	"os"
-	"strings"
 	"time"
 
+	"Tracker/internal/audit"
+	"Tracker/internal/config"
+
 	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
-	"go.mongodb.org/mongo-driver/bson/primitive"
 	"golang.org/x/crypto/bcrypt"
-	"context"
 )
 
 // User represents the user model
 type User struct {
 	ID       string `json:"id" bson:"_id,omitempty"`
 	Email    string `json:"email" bson:"email"`
-	Password string `json:"password" bson:"password"`
+	Password string `json:"-" bson:"password"`
 	Role     string `json:"role" bson:"role"`
+	TenantID string `json:"tenantId" bson:"tenantId"`
+
+	EmailVerified     bool   `json:"emailVerified" bson:"-"`
+	MFAEnabled        bool   `json:"mfaEnabled" bson:"-"`
+	TOTPSecret        string `json:"-" bson:"-"`
+	PendingTOTPSecret string `json:"-" bson:"-"`
 }
 
 // LoginRequest represents the login request body
@@ -29,66 +37,76 @@ type LoginRequest struct {
 	Password string `json:"password" binding:"required,min=6"`
 }
 
-// RegisterRequest represents the registration request body
+// RegisterRequest represents the registration request body.
+// Self-registered accounts always get the user role; admins are promoted
+// in the database. A join code places the user in an existing organization,
+// otherwise a new organization is created with the user as its owner.
 type RegisterRequest struct {
-	Email    string `json:"email" binding:"required,email"`
-	Password string `json:"password" binding:"required,min=6"`
-	Role     string `json:"role" binding:"required,oneof=user admin"`
+	Email            string `json:"email" binding:"required,email"`
+	Password         string `json:"password" binding:"required,min=6"`
+	JoinCode         string `json:"joinCode"`
+	OrganizationName string `json:"organizationName" binding:"max=100"`
 }
 
+// Role names
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
 // Claims represents the JWT claims
 type Claims struct {
 	UserID string `json:"user_id"`
 	Email  string `json:"email"`
 	Role   string `json:"role"`
+	// TenantID is the organization every query is scoped to
+	TenantID string `json:"tenant_id"`
+	// TokenUse distinguishes access tokens from MFA challenge tokens
+	TokenUse string `json:"token_use"`
+	// MFA is true when the session was established with a second factor
+	MFA bool `json:"mfa,omitempty"`
 	jwt.StandardClaims
 }
 
-// AuthMiddleware is a middleware to check JWT token
-func AuthMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header is required"})
-			c.Abort()
-			return
-		}
-
-		// Check if the Authorization header has the correct format
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header format must be Bearer {token}"})
-			c.Abort()
-			return
-		}
-
-		tokenString := parts[1]
-		claims := &Claims{}
+// Token uses
+const (
+	TokenUseAccess        = "access"
+	TokenUseMFAChallenge  = "mfa_challenge"
+	TokenUseEmailVerify   = "email_verify"
+	TokenUsePasswordReset = "password_reset"
+)
 
-		// Parse and validate the token
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-			return []byte(getJWTSecret()), nil
-		})
+// Context keys set by AuthMiddleware
+const (
+	ContextUserIDKey    = "userID"
+	ContextEmailKey     = "email"
+	ContextRoleKey      = "role"
+	ContextTenantIDKey  = "tenantID"
+	ContextClaimsKey    = "claims"
+	ContextPrincipalKey = "principal"
+	ContextDecisionKey  = "decision"
+)
 
+// AuthMiddleware is a middleware to check the JWT token or personal API key
+func AuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, err := Authenticate(c.Request.Context(), c.Request)
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
-			c.Abort()
-			return
-		}
-
-		if !token.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			status, message := authErrorStatus(err)
+			c.JSON(status, gin.H{"error": message})
 			c.Abort()
 			return
 		}
 
 		// Set user information in context
-		c.Set("userID", claims.UserID)
-		c.Set("email", claims.Email)
-		c.Set("role", claims.Role)
+		c.Set(ContextPrincipalKey, principal)
+		if principal.Claims != nil {
+			c.Set(ContextClaimsKey, principal.Claims)
+		}
+		c.Set(ContextUserIDKey, principal.UserID)
+		c.Set(ContextEmailKey, principal.Email)
+		c.Set(ContextRoleKey, principal.Role)
+		c.Set(ContextTenantIDKey, principal.TenantID)
 		c.Next()
 	}
 }
@@ -96,7 +114,7 @@ func AuthMiddleware() gin.HandlerFunc {
 // RoleMiddleware checks if the user has the required role
 func RoleMiddleware(roles ...string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		userRole, exists := c.Get("role")
+		userRole, exists := c.Get(ContextRoleKey)
 		if !exists {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "User role not found"})
 			c.Abort()
@@ -121,26 +139,58 @@ func RoleMiddleware(roles ...string) gin.HandlerFunc {
 	}
 }
 
-// GenerateToken generates a new JWT token
+// GenerateToken generates a new short-lived JWT access token
 func GenerateToken(user *User) (string, error) {
-	expirationTime := time.Now().Add(24 * time.Hour)
+	token, _, err := generateAccessToken(user, false)
+	return token, err
+}
+
+// generateAccessToken signs an access token with a fresh jti and returns
+// its claims so callers can track the token for revocation
+func generateAccessToken(user *User, mfa bool) (string, *Claims, error) {
+	jti, err := randomToken(16)
+	if err != nil {
+		return "", nil, err
+	}
+
+	expirationTime := time.Now().Add(config.GetAccessTokenTTL())
 	claims := &Claims{
-		UserID: user.ID,
-		Email:  user.Email,
-		Role:   user.Role,
+		UserID:   user.ID,
+		Email:    user.Email,
+		Role:     user.Role,
+		TenantID: user.TenantID,
+		TokenUse: TokenUseAccess,
+		MFA:      mfa,
 		StandardClaims: jwt.StandardClaims{
+			Id:        jti,
+			Issuer:    config.GetJWTIssuer(),
+			Subject:   user.ID,
 			ExpiresAt: expirationTime.Unix(),
 			IssuedAt:  time.Now().Unix(),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(getJWTSecret()))
+	signed, err := getKeySet().sign(claims)
+	if err != nil {
+		return "", nil, err
+	}
+	return signed, claims, nil
 }
 
+// passwordHashCost is the bcrypt cost used for stored passwords
+const passwordHashCost = 14
+
+// hashCost is the cost HashPassword uses. Tests lower it, since a hash at
+// passwordHashCost takes about a second.
+var hashCost = passwordHashCost
+
+// dummyPasswordHash is a bcrypt hash at passwordHashCost that no password
+// matches. LoginHandler checks unknown emails against it.
+const dummyPasswordHash = "$2a$14$4tFYQ7ESDTsunafjR6aFne3RT32SNUzh6EG9Q9k4PzD9.WWH2lCEe"
+
 // HashPassword hashes a password using bcrypt
 func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), hashCost)
 	return string(bytes), err
 }
 
@@ -150,25 +200,42 @@ func CheckPasswordHash(password, hash string) bool {
 	return err == nil
 }
 
+// hashPasswordBounded hashes a password while holding a bcrypt slot
+func hashPasswordBounded(ctx context.Context, password string) (string, error) {
+	var hash string
+	var err error
+	if slotErr := withPasswordSlot(ctx, func() { hash, err = HashPassword(password) }); slotErr != nil {
+		return "", slotErr
+	}
+	return hash, err
+}
+
+// checkPasswordBounded compares a password with its hash while holding a bcrypt slot
+func checkPasswordBounded(ctx context.Context, password, hash string) (bool, error) {
+	var ok bool
+	err := withPasswordSlot(ctx, func() { ok = CheckPasswordHash(password, hash) })
+	return ok, err
+}
+
 // getJWTSecret returns the JWT secret from environment variable
 func getJWTSecret() string {
 	secret := os.Getenv("JWT_SECRET")
 	if secret == "" {
-		// Fallback to a default secret (not recommended for production)
-		return "your-256-bit-secret"
+		// Fallback to a default secret (refused in production by InitKeys)
+		return defaultJWTSecret
 	}
 	return secret
 }
 
-// ValidateToken validates a JWT token
+// ValidateToken validates a JWT access token
 func ValidateToken(tokenString string) (*Claims, error) {
+	return validateTokenUse(tokenString, TokenUseAccess)
+}
+
+// validateTokenUse validates a JWT and checks it was issued for the given use
+func validateTokenUse(tokenString, use string) (*Claims, error) {
 	claims := &Claims{}
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(getJWTSecret()), nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, claims, getKeySet().keyFunc)
 
 	if err != nil {
 		return nil, err
@@ -178,30 +245,76 @@ func ValidateToken(tokenString string) (*Claims, error) {
 		return nil, errors.New("invalid token")
 	}
 
+	if claims.TokenUse != use {
+		return nil, errors.New("token not valid for this use")
+	}
+
 	return claims, nil
 }
 
-// RefreshToken generates a new token with extended expiration
-func RefreshToken(tokenString string) (string, error) {
-	claims, err := ValidateToken(tokenString)
+// RefreshToken exchanges a single-use refresh token for a new token pair.
+// Presenting a token that was already rotated revokes its whole family.
+func RefreshToken(ctx context.Context, refreshToken string) (*TokenPair, error) {
+	if tokenStore == nil {
+		return nil, ErrTokenStoreNotConfigured
+	}
+	if userStore == nil {
+		return nil, errors.New("user store not configured")
+	}
+
+	record, err := tokenStore.FindRefreshToken(ctx, hashToken(refreshToken))
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	// Create a new token with extended expiration
-	expirationTime := time.Now().Add(24 * time.Hour)
-	claims.ExpiresAt = expirationTime.Unix()
-	claims.IssuedAt = time.Now().Unix()
+	if record.RevokedAt != nil || time.Now().After(record.ExpiresAt) {
+		return nil, ErrRefreshTokenInvalid
+	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(getJWTSecret()))
-}
+	// Reuse of a rotated token means it leaked; kill the whole family
+	consumed := false
+	if record.UsedAt == nil {
+		consumed, err = tokenStore.ConsumeRefreshToken(ctx, record.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !consumed {
+		revoked, err := tokenStore.RevokeFamily(ctx, record.FamilyID)
+		if err != nil {
+			return nil, err
+		}
+		if err := revokeRecords(ctx, revoked); err != nil {
+			return nil, err
+		}
+		return nil, ErrRefreshTokenReused
+	}
+
+	// Reload the user so role changes take effect on refresh
+	user, err := userStore.FindByID(ctx, record.UserID)
+	if err != nil {
+		return nil, err
+	}
 
+	return issueTokenPair(ctx, user, record.FamilyID, record.MFA)
+}
 
 // LoginResponse represents the login response
 type LoginResponse struct {
-	Token string `json:"token"`
-	User  User   `json:"user"`
+	Token        string    `json:"token"`
+	RefreshToken string    `json:"refreshToken"`
+	ExpiresAt    time.Time `json:"expiresAt"`
+	User         User      `json:"user"`
+}
+
+// RefreshRequest represents the refresh and logout request body
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken" binding:"required"`
+}
+
+// LogoutRequest represents the logout request body
+type LogoutRequest struct {
+	RefreshToken string `json:"refreshToken"`
 }
 
 // RegisterHandler handles user registration
@@ -213,30 +326,71 @@ func RegisterHandler(c *gin.Context) {
 	}
 
 	// Hash the password
-	hashedPassword, err := HashPassword(req.Password)
+	hashedPassword, err := hashPasswordBounded(c.Request.Context(), req.Password)
 	if err != nil {
+		if errors.Is(err, ErrPasswordCheckBusy) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Server is busy, try again later"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
 		return
 	}
 
-	// Create new user
+	// Create and persist the new user
 	user := &User{
-		ID:       primitive.NewObjectID().Hex(),
 		Email:    req.Email,
 		Password: hashedPassword,
-		Role:     req.Role,
+		Role:     RoleUser,
+	}
+
+	if userStore == nil || tenantStore == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "User store not configured"})
+		return
+	}
+	ctx := c.Request.Context()
+
+	// Place the user in an organization
+	orgName := req.OrganizationName
+	if orgName == "" {
+		orgName = req.Email
+	}
+	if err := createAccount(ctx, user, req.JoinCode, orgName); err != nil {
+		switch {
+		case errors.Is(err, ErrInvalidJoinCode):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid join code"})
+		case errors.Is(err, ErrEmailTaken):
+			c.JSON(http.StatusConflict, gin.H{"error": "Email already registered"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+		}
+		return
+	}
+
+	RecordAudit(c, audit.Event{
+		Action:   audit.ActionRegister,
+		ActorID:  user.ID,
+		TenantID: user.TenantID,
+		TargetID: user.ID,
+		Details:  map[string]string{"email": user.Email, "joined": boolString(req.JoinCode != "")},
+	})
+
+	// Verification mail is best effort; the user can ask for another one
+	if err := sendVerificationEmail(ctx, user); err != nil {
+		log.Printf("Failed to send verification email to %s: %v", user.Email, err)
 	}
 
-	// Generate token
-	token, err := GenerateToken(user)
+	// Generate token pair
+	tokens, err := issueTokenPair(c.Request.Context(), user, "", false)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
 
 	c.JSON(http.StatusCreated, LoginResponse{
-		Token: token,
-		User:  *user,
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		ExpiresAt:    tokens.ExpiresAt,
+		User:         *user,
 	})
 }
 
@@ -248,36 +402,331 @@ func LoginHandler(c *gin.Context) {
 		return
 	}
 
-	// TODO: Implement user lookup from database
-	// For now, we'll return an error
-	c.JSON(http.StatusNotImplemented, gin.H{"error": "Login not implemented"})
+	if userStore == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "User store not configured"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	limiter := getLoginLimiter()
+	clientIP := c.ClientIP()
+
+	// Refuse locked accounts and IPs before doing any bcrypt work
+	if wait, err := limiter.Check(ctx, req.Email, clientIP); err != nil {
+		if errors.Is(err, ErrLoginLocked) {
+			RecordAudit(c, audit.Event{
+				Action:  audit.ActionLoginFailure,
+				Outcome: audit.OutcomeFailure,
+				Details: map[string]string{"email": normalizeEmail(req.Email), "reason": "locked"},
+			})
+			respondLocked(c, wait)
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check login attempts"})
+		return
+	}
+
+	// Look up the user and verify the password. Unknown emails count as
+	// failures too so lockouts do not reveal which accounts exist.
+	user, err := userStore.FindByEmail(ctx, req.Email)
+	if err != nil && !errors.Is(err, ErrUserNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up user"})
+		return
+	}
+
+	// Unknown emails are checked against a dummy hash so the response
+	// takes as long as it does for a real account
+	hash := dummyPasswordHash
+	if user != nil {
+		hash = user.Password
+	}
+	valid, err := checkPasswordBounded(ctx, req.Password, hash)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Server is busy, try again later"})
+		return
+	}
+	valid = valid && user != nil
+
+	if !valid {
+		event := audit.Event{
+			Action:  audit.ActionLoginFailure,
+			Outcome: audit.OutcomeFailure,
+			Details: map[string]string{"email": normalizeEmail(req.Email), "reason": "bad_password"},
+		}
+		if user == nil {
+			event.Details["reason"] = "unknown_email"
+		} else {
+			event.TargetID, event.TenantID = user.ID, user.TenantID
+		}
+		RecordAudit(c, event)
+
+		if err := limiter.Failure(ctx, req.Email, clientIP); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record login attempt"})
+			return
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password"})
+		return
+	}
+
+	// Accounts with a second factor get a challenge token instead of a session
+	if user.MFAEnabled {
+		challenge, expiresAt, err := generateMFAChallenge(user)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate MFA challenge"})
+			return
+		}
+		c.JSON(http.StatusOK, MFAChallengeResponse{
+			MFARequired: true,
+			MFAToken:    challenge,
+			ExpiresAt:   expiresAt,
+		})
+		return
+	}
+
+	// The account counter is only cleared once the login is complete
+	if err := limiter.Success(ctx, req.Email); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record login attempt"})
+		return
+	}
+
+	// Generate token pair
+	tokens, err := issueTokenPair(ctx, user, "", false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	RecordAudit(c, audit.Event{
+		Action:   audit.ActionLoginSuccess,
+		ActorID:  user.ID,
+		TenantID: user.TenantID,
+		TargetID: user.ID,
+		Details:  map[string]string{"method": "password"},
+	})
+
+	c.JSON(http.StatusOK, LoginResponse{
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		ExpiresAt:    tokens.ExpiresAt,
+		User:         *user,
+	})
 }
 
-// RefreshTokenHandler handles token refresh requests
+// RefreshTokenHandler rotates a refresh token into a new token pair
 func RefreshTokenHandler(c *gin.Context) {
-	authHeader := c.GetHeader("Authorization")
-	if authHeader == "" {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header is required"})
+	var req RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tokens, err := RefreshToken(c.Request.Context(), req.RefreshToken)
+	if err != nil {
+		reason := "invalid"
+		if errors.Is(err, ErrRefreshTokenReused) {
+			reason = "reused"
+		}
+		RecordAudit(c, audit.Event{
+			Action:  audit.ActionTokenRefresh,
+			Outcome: audit.OutcomeFailure,
+			Details: map[string]string{"reason": reason},
+		})
+
+		switch {
+		case errors.Is(err, ErrRefreshTokenReused):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token reuse detected, all sessions from this login were revoked"})
+		case errors.Is(err, ErrRefreshTokenInvalid), errors.Is(err, ErrUserNotFound):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh token"})
+		}
+		return
+	}
+
+	RecordAudit(c, audit.Event{
+		Action:   audit.ActionTokenRefresh,
+		ActorID:  tokens.UserID,
+		TargetID: tokens.UserID,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"token":        tokens.AccessToken,
+		"refreshToken": tokens.RefreshToken,
+		"expiresAt":    tokens.ExpiresAt,
+	})
+}
+
+// LogoutHandler revokes the current access token and, when given, the
+// refresh token family it was issued with. Requires AuthMiddleware.
+func LogoutHandler(c *gin.Context) {
+	var req LogoutRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	claims, ok := GetClaims(c)
+	if !ok || tokenStore == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Token revocation unavailable"})
+		return
+	}
+	ctx := c.Request.Context()
+
+	if req.RefreshToken != "" {
+		record, err := tokenStore.FindRefreshToken(ctx, hashToken(req.RefreshToken))
+		if err == nil && record.UserID == claims.UserID {
+			revoked, err := tokenStore.RevokeFamily(ctx, record.FamilyID)
+			if err == nil {
+				err = revokeRecords(ctx, revoked)
+			}
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session"})
+				return
+			}
+		} else if err != nil && !errors.Is(err, ErrRefreshTokenInvalid) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session"})
+			return
+		}
+	}
+
+	if err := tokenStore.DenyJTI(ctx, claims.Id, time.Unix(claims.ExpiresAt, 0)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out"})
+}
+
+// LogoutAllHandler revokes every session of the current user. Requires
+// AuthMiddleware.
+func LogoutAllHandler(c *gin.Context) {
+	claims, ok := GetClaims(c)
+	if !ok || tokenStore == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Token revocation unavailable"})
+		return
+	}
+	ctx := c.Request.Context()
+
+	revoked, err := tokenStore.RevokeUser(ctx, claims.UserID)
+	if err == nil {
+		err = revokeRecords(ctx, revoked)
+	}
+	if err == nil {
+		err = tokenStore.DenyJTI(ctx, claims.Id, time.Unix(claims.ExpiresAt, 0))
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out of all sessions"})
+}
+
+// SetRoleRequest represents the admin request to change a user's role
+type SetRoleRequest struct {
+	Role string `json:"role" binding:"required,oneof=user admin"`
+}
+
+// SetUserRoleHandler changes a user's global role. The user's sessions are
+// revoked so the new role takes effect on their next login.
+func SetUserRoleHandler(c *gin.Context) {
+	var req SetRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if userStore == nil || tokenStore == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Auth stores not configured"})
+		return
+	}
+	ctx := c.Request.Context()
+	targetID := c.Param("id")
+
+	user, err := userStore.FindByID(ctx, targetID)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up user"})
+		return
+	}
+	if user.Role == req.Role {
+		c.JSON(http.StatusOK, gin.H{"message": "Role unchanged", "role": user.Role})
 		return
 	}
 
-	parts := strings.Split(authHeader, " ")
-	if len(parts) != 2 || parts[0] != "Bearer" {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header format must be Bearer {token}"})
+	if err := userStore.SetRole(ctx, targetID, req.Role); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update role"})
 		return
 	}
 
-	tokenString := parts[1]
-	newToken, err := RefreshToken(tokenString)
+	revoked, err := tokenStore.RevokeUser(ctx, targetID)
+	if err == nil {
+		err = revokeRecords(ctx, revoked)
+	}
+
+	RecordAudit(c, audit.Event{
+		Action:   audit.ActionRoleChange,
+		TenantID: user.TenantID,
+		TargetID: targetID,
+		Details:  map[string]string{"from": user.Role, "to": req.Role},
+	})
+
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Role updated but failed to revoke sessions"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"token": newToken})
+	c.JSON(http.StatusOK, gin.H{"message": "Role updated", "role": req.Role})
 }
 
 // Helper function to add claims to context
 func AddClaimsToContext(ctx context.Context, claims *Claims) context.Context {
 	return context.WithValue(ctx, "claims", claims)
-}
\ No newline at end of file
+}
+
+// GetUserID returns the authenticated user's ID set by AuthMiddleware
+func GetUserID(c *gin.Context) (string, bool) {
+	userID := c.GetString(ContextUserIDKey)
+	return userID, userID != ""
+}
+
+// GetRole returns the authenticated user's role set by AuthMiddleware
+func GetRole(c *gin.Context) string {
+	return c.GetString(ContextRoleKey)
+}
+
+// GetClaims returns the validated token claims set by AuthMiddleware
+func GetClaims(c *gin.Context) (*Claims, bool) {
+	value, exists := c.Get(ContextClaimsKey)
+	if !exists {
+		return nil, false
+	}
+	claims, ok := value.(*Claims)
+	return claims, ok
+}
+
+// GetPrincipal returns the authenticated caller set by AuthMiddleware
+func GetPrincipal(c *gin.Context) (*Principal, bool) {
+	value, exists := c.Get(ContextPrincipalKey)
+	if !exists {
+		return nil, false
+	}
+	principal, ok := value.(*Principal)
+	return principal, ok
+}
+
+// GetTenantID returns the authenticated user's organization set by AuthMiddleware
+func GetTenantID(c *gin.Context) string {
+	return c.GetString(ContextTenantIDKey)
+}
+
+// boolString formats a flag for audit details
+func boolString(b bool) string {
+	if b {
+		return "true"
+	}
+	return "false"
+}
@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"Tracker/internal/model"
+)
+
+// accessClaims validates an access token of a test
+func accessClaims(t *testing.T, token string) *Claims {
+	t.Helper()
+
+	claims, err := ValidateToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return claims
+}
+
+// register runs RegisterHandler and decodes a successful response
+func register(t *testing.T, req RegisterRequest) (int, LoginResponse) {
+	t.Helper()
+
+	rec := serveJSON(RegisterHandler, http.MethodPost, "/auth/register", req)
+	var resp LoginResponse
+	if rec.Code == http.StatusCreated {
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rec.Code, resp
+}
+
+// membershipRole returns the organization role recorded for a user
+func membershipRole(tenants *memoryTenantStore, tenantID, userID string) string {
+	tenants.mu.Lock()
+	defer tenants.mu.Unlock()
+	for _, membership := range tenants.memberships {
+		if membership.TenantID == tenantID && membership.UserID == userID {
+			return membership.Role
+		}
+	}
+	return ""
+}
+
+func TestRegisterHandler(t *testing.T) {
+	useMemoryStores(t)
+	useFastHashing(t)
+	tenants := tenantStore.(*memoryTenantStore)
+
+	// A new account owns a new organization
+	code, owner := register(t, RegisterRequest{Email: "alice@example.com", Password: "secret-1", OrganizationName: "Acme"})
+	if code != http.StatusCreated {
+		t.Fatalf("register: status %d", code)
+	}
+	if owner.Token == "" || owner.RefreshToken == "" {
+		t.Error("no tokens issued")
+	}
+	if claims := accessClaims(t, owner.Token); claims.UserID != owner.User.ID || claims.TenantID != owner.User.TenantID {
+		t.Errorf("token claims = %+v, want user %s in %s", claims, owner.User.ID, owner.User.TenantID)
+	}
+	if owner.User.Role != RoleUser || owner.User.TenantID == "" {
+		t.Errorf("user = %+v", owner.User)
+	}
+	if role := membershipRole(tenants, owner.User.TenantID, owner.User.ID); role != model.MemberRoleOwner {
+		t.Errorf("organization role %q, want owner", role)
+	}
+
+	// A join code places the account in that organization
+	joinCode := tenants.organizations[owner.User.TenantID].JoinCode
+	code, member := register(t, RegisterRequest{Email: "bob@example.com", Password: "secret-2", JoinCode: joinCode})
+	if code != http.StatusCreated {
+		t.Fatalf("register with a join code: status %d", code)
+	}
+	if member.User.TenantID != owner.User.TenantID {
+		t.Errorf("joined tenant %s, want %s", member.User.TenantID, owner.User.TenantID)
+	}
+	if role := membershipRole(tenants, member.User.TenantID, member.User.ID); role != model.MemberRoleMember {
+		t.Errorf("organization role %q, want member", role)
+	}
+
+	tests := []struct {
+		name string
+		req  RegisterRequest
+		want int
+	}{
+		{"duplicate email", RegisterRequest{Email: "alice@example.com", Password: "secret-3"}, http.StatusConflict},
+		{"duplicate email in another case", RegisterRequest{Email: "Alice@Example.com", Password: "secret-3"}, http.StatusConflict},
+		{"invalid join code", RegisterRequest{Email: "carol@example.com", Password: "secret-3", JoinCode: "nope"}, http.StatusBadRequest},
+		{"short password", RegisterRequest{Email: "carol@example.com", Password: "short"}, http.StatusBadRequest},
+		{"malformed email", RegisterRequest{Email: "carol", Password: "secret-3"}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if code, _ := register(t, tt.req); code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, code, tt.want)
+		}
+	}
+
+	// Refused registrations leave no organization behind
+	if len(tenants.organizations) != 1 {
+		t.Errorf("%d organizations, want 1", len(tenants.organizations))
+	}
+}
+
+func TestLoginHandler(t *testing.T) {
+	useMemoryStores(t)
+	useFastHashing(t)
+	code, registered := register(t, RegisterRequest{Email: "alice@example.com", Password: "secret-1"})
+	if code != http.StatusCreated {
+		t.Fatalf("register: status %d", code)
+	}
+
+	tests := []struct {
+		name     string
+		email    string
+		password string
+		want     int
+	}{
+		{"valid credentials", "alice@example.com", "secret-1", http.StatusOK},
+		{"email in another case", "ALICE@example.com", "secret-1", http.StatusOK},
+		{"wrong password", "alice@example.com", "secret-2", http.StatusUnauthorized},
+		{"missing password", "alice@example.com", "", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		rec := serveJSON(LoginHandler, http.MethodPost, "/auth/login", LoginRequest{Email: tt.email, Password: tt.password})
+		if rec.Code != tt.want {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, rec.Code, tt.want, rec.Body)
+			continue
+		}
+		if rec.Code != http.StatusOK {
+			continue
+		}
+
+		var resp LoginResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if claims := accessClaims(t, resp.Token); claims.UserID != registered.User.ID {
+			t.Errorf("%s: token for user %s, want %s", tt.name, claims.UserID, registered.User.ID)
+		}
+	}
+}
@@ -0,0 +1,314 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"Tracker/internal/config"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+)
+
+// defaultJWTSecret is the development fallback HMAC secret
+const defaultJWTSecret = "your-256-bit-secret"
+
+// SigningMethodEdDSA implements the EdDSA (Ed25519) JWS algorithm, which
+// jwt-go v3 does not ship
+type SigningMethodEdDSA struct{}
+
+// SigningMethodEd25519 is the EdDSA signing method instance
+var SigningMethodEd25519 = &SigningMethodEdDSA{}
+
+func init() {
+	jwt.RegisterSigningMethod(SigningMethodEd25519.Alg(), func() jwt.SigningMethod {
+		return SigningMethodEd25519
+	})
+}
+
+// Alg returns the JWS algorithm name
+func (m *SigningMethodEdDSA) Alg() string {
+	return "EdDSA"
+}
+
+// Verify checks an Ed25519 signature; key must be an ed25519.PublicKey
+func (m *SigningMethodEdDSA) Verify(signingString, signature string, key interface{}) error {
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return jwt.ErrInvalidKeyType
+	}
+
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+
+	if !ed25519.Verify(publicKey, []byte(signingString), sig) {
+		return errors.New("EdDSA signature verification failed")
+	}
+	return nil
+}
+
+// Sign produces an Ed25519 signature; key must be an ed25519.PrivateKey
+func (m *SigningMethodEdDSA) Sign(signingString string, key interface{}) (string, error) {
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+	return jwt.EncodeSegment(ed25519.Sign(privateKey, []byte(signingString))), nil
+}
+
+// jwtKey is an asymmetric key identified by its kid
+type jwtKey struct {
+	kid        string
+	method     jwt.SigningMethod
+	privateKey crypto.Signer
+	publicKey  crypto.PublicKey
+}
+
+// KeySet holds the active signing key and every key accepted for verification.
+// Retired keys stay in the set as public keys so tokens signed before a
+// rotation remain valid until they expire.
+type KeySet struct {
+	signer     *jwtKey
+	verifiers  map[string]*jwtKey
+	hmacSecret []byte
+}
+
+var (
+	keySet     *KeySet
+	keySetOnce sync.Once
+)
+
+// InitKeys loads the signing keys for the given environment. In production it
+// refuses to fall back to the built-in development secret.
+func InitKeys(env string) error {
+	keys, err := LoadKeySet(config.GetJWTKeysDir(), config.GetJWTSigningKID(), os.Getenv("JWT_SECRET"))
+	if err != nil {
+		return err
+	}
+
+	if env == "production" && keys.signer == nil && string(keys.hmacSecret) == defaultJWTSecret {
+		return errors.New("refusing to start in production with the default JWT secret: set JWT_KEYS_DIR or JWT_SECRET")
+	}
+
+	SetKeySet(keys)
+	return nil
+}
+
+// SetKeySet replaces the key set used to sign and verify tokens
+func SetKeySet(keys *KeySet) {
+	keySetOnce.Do(func() {})
+	keySet = keys
+}
+
+// getKeySet returns the configured key set, defaulting to HMAC with
+// JWT_SECRET when InitKeys was never called
+func getKeySet() *KeySet {
+	keySetOnce.Do(func() {
+		if keySet == nil {
+			keySet = &KeySet{
+				verifiers:  make(map[string]*jwtKey),
+				hmacSecret: []byte(getJWTSecret()),
+			}
+		}
+	})
+	return keySet
+}
+
+// LoadKeySet reads every <kid>.pem file in dir. Private keys can sign and
+// verify, public keys only verify. signingKID selects the active signer and
+// defaults to the last private key in name order. HMAC tokens are only
+// accepted alongside asymmetric keys when hmacSecret is set explicitly.
+func LoadKeySet(dir, signingKID, hmacSecret string) (*KeySet, error) {
+	keys := &KeySet{verifiers: make(map[string]*jwtKey)}
+
+	if dir == "" {
+		if hmacSecret == "" {
+			hmacSecret = defaultJWTSecret
+		}
+		keys.hmacSecret = []byte(hmacSecret)
+		return keys, nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	var lastPrivate *jwtKey
+	for _, file := range files {
+		key, err := loadKeyFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load JWT key %s: %v", file, err)
+		}
+		if existing, ok := keys.verifiers[key.kid]; ok && existing.privateKey != nil {
+			continue
+		}
+		keys.verifiers[key.kid] = key
+		if key.privateKey != nil {
+			lastPrivate = key
+		}
+	}
+
+	if signingKID != "" {
+		key, ok := keys.verifiers[signingKID]
+		if !ok || key.privateKey == nil {
+			return nil, fmt.Errorf("no private key found for JWT_SIGNING_KID %q", signingKID)
+		}
+		keys.signer = key
+	} else {
+		keys.signer = lastPrivate
+	}
+
+	if keys.signer == nil {
+		return nil, fmt.Errorf("no private signing key found in %s", dir)
+	}
+
+	if hmacSecret != "" {
+		keys.hmacSecret = []byte(hmacSecret)
+	}
+	return keys, nil
+}
+
+// loadKeyFile parses a PEM private or public key; the kid is the file name
+// without the .pem (and optional .pub) suffix
+func loadKeyFile(path string) (*jwtKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	kid := strings.TrimSuffix(strings.TrimSuffix(filepath.Base(path), ".pem"), ".pub")
+	key := &jwtKey{kid: kid}
+
+	var parsed interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.method, key.privateKey, key.publicKey = jwt.SigningMethodRS256, k, &k.PublicKey
+	case ed25519.PrivateKey:
+		key.method, key.privateKey, key.publicKey = SigningMethodEd25519, k, k.Public()
+	case *rsa.PublicKey:
+		key.method, key.publicKey = jwt.SigningMethodRS256, k
+	case ed25519.PublicKey:
+		key.method, key.publicKey = SigningMethodEd25519, k
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+
+	return key, nil
+}
+
+// sign signs claims with the active key, adding the kid header for
+// asymmetric keys
+func (k *KeySet) sign(claims jwt.Claims) (string, error) {
+	if k.signer == nil {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(k.hmacSecret)
+	}
+
+	token := jwt.NewWithClaims(k.signer.method, claims)
+	token.Header["kid"] = k.signer.kid
+	return token.SignedString(k.signer.privateKey)
+}
+
+// keyFunc resolves the verification key for a parsed token
+func (k *KeySet) keyFunc(token *jwt.Token) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+		if k.hmacSecret == nil {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return k.hmacSecret, nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	key, ok := k.verifiers[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if key.method.Alg() != token.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.publicKey, nil
+}
+
+// JWK is a JSON Web Key as published in the JWKS document
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKS returns the public verification keys in kid order
+func (k *KeySet) JWKS() []JWK {
+	kids := make([]string, 0, len(k.verifiers))
+	for kid := range k.verifiers {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+
+	jwks := make([]JWK, 0, len(kids))
+	for _, kid := range kids {
+		key := k.verifiers[kid]
+		jwk := JWK{Kid: kid, Use: "sig", Alg: key.method.Alg()}
+
+		switch pub := key.publicKey.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		default:
+			continue
+		}
+		jwks = append(jwks, jwk)
+	}
+	return jwks
+}
+
+// JWKSHandler serves the public keys at /.well-known/jwks.json
+func JWKSHandler(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, gin.H{"keys": getKeySet().JWKS()})
+}
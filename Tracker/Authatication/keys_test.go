@@ -0,0 +1,278 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// testRSAKey is generated on first use and shared, as RSA key generation
+// is slow
+var testRSAKey = sync.OnceValue(func() *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	return key
+})
+
+// writePEM writes a PEM block to dir/name
+func writePEM(t *testing.T, dir, name, blockType string, der []byte) {
+	t.Helper()
+
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(filepath.Join(dir, name), data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeKeys writes an RSA key as 2024-01 and an Ed25519 key as 2025-01,
+// as private keys or public keys only
+func writeKeys(t *testing.T, dir string, rsaPrivate, edPrivate bool, edKey ed25519.PrivateKey) {
+	t.Helper()
+
+	if rsaPrivate {
+		writePEM(t, dir, "2024-01.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(testRSAKey()))
+	} else {
+		der, err := x509.MarshalPKIXPublicKey(&testRSAKey().PublicKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writePEM(t, dir, "2024-01.pub.pem", "PUBLIC KEY", der)
+	}
+
+	if edPrivate {
+		der, err := x509.MarshalPKCS8PrivateKey(edKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writePEM(t, dir, "2025-01.pem", "PRIVATE KEY", der)
+	}
+}
+
+// testClaims returns access token claims expiring in an hour
+func testClaims() *Claims {
+	return &Claims{
+		UserID:   "user-1",
+		TokenUse: TokenUseAccess,
+		StandardClaims: jwt.StandardClaims{
+			Id:        "jti-1",
+			ExpiresAt: time.Now().Add(time.Hour).Unix(),
+		},
+	}
+}
+
+// verify parses a token with a key set
+func verify(keys *KeySet, token string) error {
+	_, err := jwt.ParseWithClaims(token, &Claims{}, keys.keyFunc)
+	return err
+}
+
+func TestLoadKeySetSigner(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	writeKeys(t, dir, true, true, edKey)
+
+	tests := []struct {
+		name       string
+		signingKID string
+		wantKID    string
+		wantAlg    string
+		wantErr    bool
+	}{
+		{"last private key by name", "", "2025-01", "EdDSA", false},
+		{"explicit kid", "2024-01", "2024-01", "RS256", false},
+		{"unknown kid", "2023-01", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := LoadKeySet(dir, tt.signingKID, "")
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("LoadKeySet succeeded")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			token, err := keys.sign(testClaims())
+			if err != nil {
+				t.Fatal(err)
+			}
+			parsed, err := jwt.ParseWithClaims(token, &Claims{}, keys.keyFunc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if parsed.Header["kid"] != tt.wantKID || parsed.Method.Alg() != tt.wantAlg {
+				t.Errorf("signed with kid %v alg %s, want %s %s", parsed.Header["kid"], parsed.Method.Alg(), tt.wantKID, tt.wantAlg)
+			}
+		})
+	}
+}
+
+func TestLoadKeySetWithoutPrivateKey(t *testing.T) {
+	dir := t.TempDir()
+	writeKeys(t, dir, false, false, nil)
+
+	if _, err := LoadKeySet(dir, "", ""); err == nil {
+		t.Error("loaded a key set that cannot sign")
+	}
+}
+
+func TestKeyRotationKeepsOldTokensValid(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Before the rotation only the RSA key exists
+	before := t.TempDir()
+	writeKeys(t, before, true, false, nil)
+	oldKeys, err := LoadKeySet(before, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldToken, err := oldKeys.sign(testClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// After it the RSA key is kept as a public key only
+	after := t.TempDir()
+	writeKeys(t, after, false, true, edKey)
+	newKeys, err := LoadKeySet(after, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newToken, err := newKeys.sign(testClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := verify(newKeys, oldToken); err != nil {
+		t.Errorf("token signed before the rotation rejected: %v", err)
+	}
+	if err := verify(newKeys, newToken); err != nil {
+		t.Errorf("token signed after the rotation rejected: %v", err)
+	}
+	if err := verify(oldKeys, newToken); err == nil {
+		t.Error("old key set accepted a token from an unknown key")
+	}
+}
+
+func TestKeyFuncRejectsMismatchedTokens(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	writeKeys(t, dir, true, true, edKey)
+	keys, err := LoadKeySet(dir, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An RS256 token claiming the Ed25519 key's kid
+	confused := jwt.NewWithClaims(jwt.SigningMethodRS256, testClaims())
+	confused.Header["kid"] = "2025-01"
+	confusedToken, err := confused.SignedString(testRSAKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// HMAC tokens are only accepted when a secret is configured
+	hmacToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims()).SignedString([]byte(defaultJWTSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unknown := jwt.NewWithClaims(SigningMethodEd25519, testClaims())
+	unknown.Header["kid"] = "2099-01"
+	unknownToken, err := unknown.SignedString(edKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, token := range map[string]string{
+		"algorithm mismatch": confusedToken,
+		"hmac":               hmacToken,
+		"unknown kid":        unknownToken,
+	} {
+		if err := verify(keys, token); err == nil {
+			t.Errorf("%s: token accepted", name)
+		}
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	edPublic, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	writeKeys(t, dir, false, true, edKey)
+	keys, err := LoadKeySet(dir, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prev := getKeySet()
+	SetKeySet(keys)
+	t.Cleanup(func() { SetKeySet(prev) })
+
+	rec := serveJSON(JWKSHandler, http.MethodGet, "/.well-known/jwks.json", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d", rec.Code)
+	}
+	var body struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+
+	encode := base64.RawURLEncoding.EncodeToString
+	want := []map[string]string{
+		{
+			"kty": "RSA", "kid": "2024-01", "use": "sig", "alg": "RS256",
+			"n": encode(testRSAKey().N.Bytes()),
+			"e": encode(big.NewInt(int64(testRSAKey().E)).Bytes()),
+		},
+		{
+			"kty": "OKP", "kid": "2025-01", "use": "sig", "alg": "EdDSA",
+			"crv": "Ed25519", "x": encode(edPublic),
+		},
+	}
+	if len(body.Keys) != len(want) {
+		t.Fatalf("published %d keys, want %d", len(body.Keys), len(want))
+	}
+	for i := range want {
+		if len(body.Keys[i]) != len(want[i]) {
+			t.Errorf("key %d = %v, want %v", i, body.Keys[i], want[i])
+			continue
+		}
+		for field, value := range want[i] {
+			if body.Keys[i][field] != value {
+				t.Errorf("key %d %s = %q, want %q", i, field, body.Keys[i][field], value)
+			}
+		}
+	}
+}
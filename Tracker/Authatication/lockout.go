@@ -0,0 +1,455 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"Tracker/internal/audit"
+	"Tracker/internal/config"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	// ErrLoginLocked is returned while an account or client IP is locked out
+	ErrLoginLocked = errors.New("too many failed login attempts")
+	// ErrPasswordCheckBusy is returned when no password check slot frees up in time
+	ErrPasswordCheckBusy = errors.New("too many concurrent password checks")
+)
+
+// passwordCheckWait is how long a request waits for a bcrypt slot
+const passwordCheckWait = 5 * time.Second
+
+// LoginAttempt is the failure counter for one account or client IP
+type LoginAttempt struct {
+	Key           string    `json:"key" bson:"_id"`
+	Failures      int       `json:"failures" bson:"failures"`
+	LastFailureAt time.Time `json:"lastFailureAt" bson:"lastFailureAt"`
+	LockedUntil   time.Time `json:"lockedUntil" bson:"lockedUntil"`
+	ExpiresAt     time.Time `json:"-" bson:"expiresAt"`
+}
+
+// LoginAttemptStore persists login failure counters
+type LoginAttemptStore interface {
+	// Get returns the counter for a key, or nil if there is none
+	Get(ctx context.Context, key string) (*LoginAttempt, error)
+	// RecordFailure increments the counter, restarting it when the previous
+	// failure is older than window
+	RecordFailure(ctx context.Context, key string, now time.Time, window time.Duration) (*LoginAttempt, error)
+	// Lock locks the key until the given time
+	Lock(ctx context.Context, key string, until time.Time, window time.Duration) error
+	// Reset clears the counter and any lock
+	Reset(ctx context.Context, key string) error
+}
+
+// LockoutPolicy configures when a key is locked and for how long
+type LockoutPolicy struct {
+	// MaxFailures is how many failures are allowed before the first lockout
+	MaxFailures int
+	// BaseLockout is the first lockout, doubled for every further failure
+	BaseLockout time.Duration
+	// MaxLockout caps the lockout duration
+	MaxLockout time.Duration
+	// Window is how long failures are remembered without a new failure
+	Window time.Duration
+}
+
+// lockoutFor returns how long to lock a key after the given number of failures
+func (p LockoutPolicy) lockoutFor(failures int) time.Duration {
+	if failures < p.MaxFailures {
+		return 0
+	}
+	lockout := p.BaseLockout
+	for i := p.MaxFailures; i < failures && lockout < p.MaxLockout; i++ {
+		lockout *= 2
+	}
+	if lockout > p.MaxLockout {
+		lockout = p.MaxLockout
+	}
+	return lockout
+}
+
+// LoginLimiter throttles password logins per account and per client IP
+type LoginLimiter struct {
+	store   LoginAttemptStore
+	account LockoutPolicy
+	ip      LockoutPolicy
+}
+
+// NewLoginLimiter creates a limiter with the given account and IP policies
+func NewLoginLimiter(store LoginAttemptStore, account, ip LockoutPolicy) *LoginLimiter {
+	return &LoginLimiter{
+		store:   store,
+		account: account,
+		ip:      ip,
+	}
+}
+
+// DefaultLockoutPolicies returns the account and IP policies from the environment
+func DefaultLockoutPolicies() (account, ip LockoutPolicy) {
+	account = LockoutPolicy{
+		MaxFailures: config.GetLoginAccountMaxFailures(),
+		BaseLockout: config.GetLoginLockoutBase(),
+		MaxLockout:  config.GetLoginLockoutMax(),
+		Window:      config.GetLoginFailureWindow(),
+	}
+	ip = account
+	ip.MaxFailures = config.GetLoginIPMaxFailures()
+	return account, ip
+}
+
+// accountKey returns the counter key for an email address
+func accountKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+// ipKey returns the counter key for a client IP
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Check returns ErrLoginLocked and the remaining lockout when either the
+// account or the client IP is locked
+func (l *LoginLimiter) Check(ctx context.Context, email, ip string) (time.Duration, error) {
+	now := time.Now()
+	var wait time.Duration
+	for _, key := range []string{accountKey(email), ipKey(ip)} {
+		attempt, err := l.store.Get(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+		if attempt != nil && attempt.LockedUntil.After(now) {
+			if remaining := attempt.LockedUntil.Sub(now); remaining > wait {
+				wait = remaining
+			}
+		}
+	}
+	if wait > 0 {
+		return wait, ErrLoginLocked
+	}
+	return 0, nil
+}
+
+// Failure records a failed login for the account and the client IP and
+// locks whichever crossed its threshold
+func (l *LoginLimiter) Failure(ctx context.Context, email, ip string) error {
+	now := time.Now()
+	for _, target := range []struct {
+		key    string
+		policy LockoutPolicy
+	}{
+		{accountKey(email), l.account},
+		{ipKey(ip), l.ip},
+	} {
+		attempt, err := l.store.RecordFailure(ctx, target.key, now, target.policy.Window)
+		if err != nil {
+			return err
+		}
+		if lockout := target.policy.lockoutFor(attempt.Failures); lockout > 0 {
+			if err := l.store.Lock(ctx, target.key, now.Add(lockout), target.policy.Window); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Success clears the account counter. The IP counter is left alone so one
+// valid account cannot be used to reset an attacker's budget.
+func (l *LoginLimiter) Success(ctx context.Context, email string) error {
+	return l.store.Reset(ctx, accountKey(email))
+}
+
+var (
+	loginLimiter     *LoginLimiter
+	loginLimiterOnce sync.Once
+)
+
+// SetLoginLimiter configures the limiter used by the login handlers
+func SetLoginLimiter(limiter *LoginLimiter) {
+	loginLimiter = limiter
+}
+
+// getLoginLimiter returns the configured limiter, defaulting to in-memory
+// counters when SetLoginLimiter was never called
+func getLoginLimiter() *LoginLimiter {
+	loginLimiterOnce.Do(func() {
+		if loginLimiter == nil {
+			account, ip := DefaultLockoutPolicies()
+			loginLimiter = NewLoginLimiter(NewMemoryAttemptStore(), account, ip)
+		}
+	})
+	return loginLimiter
+}
+
+// respondLocked writes the lockout response with a Retry-After header
+func respondLocked(c *gin.Context, wait time.Duration) {
+	c.Header("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed login attempts, try again later"})
+}
+
+// passwordCheckSlots bounds concurrent bcrypt work
+var (
+	passwordCheckSlots     chan struct{}
+	passwordCheckSlotsOnce sync.Once
+)
+
+// withPasswordSlot runs fn once a bcrypt slot is free, giving up after
+// passwordCheckWait or when ctx is done
+func withPasswordSlot(ctx context.Context, fn func()) error {
+	passwordCheckSlotsOnce.Do(func() {
+		passwordCheckSlots = make(chan struct{}, config.GetMaxConcurrentPasswordChecks())
+	})
+
+	timer := time.NewTimer(passwordCheckWait)
+	defer timer.Stop()
+
+	select {
+	case passwordCheckSlots <- struct{}{}:
+	case <-timer.C:
+		return ErrPasswordCheckBusy
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-passwordCheckSlots }()
+
+	fn()
+	return nil
+}
+
+// MemoryAttemptStore is a LoginAttemptStore for single-instance deployments
+type MemoryAttemptStore struct {
+	mu       sync.Mutex
+	attempts map[string]*LoginAttempt
+}
+
+// NewMemoryAttemptStore creates an empty in-memory store
+func NewMemoryAttemptStore() *MemoryAttemptStore {
+	return &MemoryAttemptStore{attempts: make(map[string]*LoginAttempt)}
+}
+
+// Get returns a copy of the counter for a key
+func (s *MemoryAttemptStore) Get(ctx context.Context, key string) (*LoginAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempt := s.live(key, time.Now())
+	if attempt == nil {
+		return nil, nil
+	}
+	copied := *attempt
+	return &copied, nil
+}
+
+// RecordFailure increments the counter for a key
+func (s *MemoryAttemptStore) RecordFailure(ctx context.Context, key string, now time.Time, window time.Duration) (*LoginAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempt := s.live(key, now)
+	if attempt == nil || now.Sub(attempt.LastFailureAt) > window {
+		attempt = &LoginAttempt{Key: key}
+		s.attempts[key] = attempt
+	}
+	attempt.Failures++
+	attempt.LastFailureAt = now
+	if expiresAt := now.Add(window); expiresAt.After(attempt.ExpiresAt) {
+		attempt.ExpiresAt = expiresAt
+	}
+
+	copied := *attempt
+	return &copied, nil
+}
+
+// Lock locks a key until the given time
+func (s *MemoryAttemptStore) Lock(ctx context.Context, key string, until time.Time, window time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempt, ok := s.attempts[key]
+	if !ok {
+		attempt = &LoginAttempt{Key: key}
+		s.attempts[key] = attempt
+	}
+	attempt.LockedUntil = until
+	attempt.ExpiresAt = until.Add(window)
+	return nil
+}
+
+// Reset removes the counter for a key
+func (s *MemoryAttemptStore) Reset(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.attempts, key)
+	return nil
+}
+
+// live returns the counter for a key, dropping it once expired. The caller
+// must hold s.mu.
+func (s *MemoryAttemptStore) live(key string, now time.Time) *LoginAttempt {
+	attempt, ok := s.attempts[key]
+	if !ok {
+		return nil
+	}
+	if now.After(attempt.ExpiresAt) {
+		delete(s.attempts, key)
+		return nil
+	}
+	return attempt
+}
+
+// MongoAttemptStore is a LoginAttemptStore shared by every instance
+type MongoAttemptStore struct {
+	collection *mongo.Collection
+}
+
+// NewMongoAttemptStore creates a store on top of the given collection
+func NewMongoAttemptStore(collection *mongo.Collection) *MongoAttemptStore {
+	return &MongoAttemptStore{collection: collection}
+}
+
+// EnsureIndexes creates the TTL index that expires idle counters
+func (s *MongoAttemptStore) EnsureIndexes(ctx context.Context) error {
+	_, err := s.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+// Get returns the counter for a key
+func (s *MongoAttemptStore) Get(ctx context.Context, key string) (*LoginAttempt, error) {
+	var attempt LoginAttempt
+	err := s.collection.FindOne(ctx, bson.M{"_id": key, "expiresAt": bson.M{"$gt": time.Now()}}).Decode(&attempt)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &attempt, nil
+}
+
+// RecordFailure atomically increments the counter for a key, restarting it
+// when the previous failure fell outside the window
+func (s *MongoAttemptStore) RecordFailure(ctx context.Context, key string, now time.Time, window time.Duration) (*LoginAttempt, error) {
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.D{
+			{Key: "failures", Value: bson.D{{Key: "$cond", Value: bson.A{
+				bson.D{{Key: "$gte", Value: bson.A{"$lastFailureAt", now.Add(-window)}}},
+				bson.D{{Key: "$add", Value: bson.A{"$failures", 1}}},
+				1,
+			}}}},
+			{Key: "lastFailureAt", Value: now},
+			{Key: "expiresAt", Value: bson.D{{Key: "$max", Value: bson.A{"$expiresAt", now.Add(window)}}}},
+		}}},
+	}
+
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	var attempt LoginAttempt
+	if err := s.collection.FindOneAndUpdate(ctx, bson.M{"_id": key}, update, opts).Decode(&attempt); err != nil {
+		return nil, err
+	}
+	return &attempt, nil
+}
+
+// Lock locks a key until the given time
+func (s *MongoAttemptStore) Lock(ctx context.Context, key string, until time.Time, window time.Duration) error {
+	_, err := s.collection.UpdateOne(ctx,
+		bson.M{"_id": key},
+		bson.M{"$set": bson.M{"lockedUntil": until, "expiresAt": until.Add(window)}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// Reset removes the counter for a key
+func (s *MongoAttemptStore) Reset(ctx context.Context, key string) error {
+	_, err := s.collection.DeleteOne(ctx, bson.M{"_id": key})
+	return err
+}
+
+// UnlockRequest represents the admin unlock request body
+type UnlockRequest struct {
+	Email string `json:"email"`
+	IP    string `json:"ip"`
+}
+
+// GetLockoutHandler shows the failure counters for an email and/or IP (admin)
+func GetLockoutHandler(c *gin.Context) {
+	email, ip := c.Query("email"), c.Query("ip")
+	if email == "" && ip == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "email or ip is required"})
+		return
+	}
+
+	limiter := getLoginLimiter()
+	result := gin.H{}
+	if email != "" {
+		attempt, err := limiter.store.Get(c.Request.Context(), accountKey(email))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up lockout"})
+			return
+		}
+		result["account"] = attempt
+	}
+	if ip != "" {
+		attempt, err := limiter.store.Get(c.Request.Context(), ipKey(ip))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up lockout"})
+			return
+		}
+		result["ip"] = attempt
+	}
+
+	RecordAudit(c, audit.Event{
+		Action:  audit.ActionAdminRead,
+		Details: map[string]string{"resource": "lockout", "email": normalizeEmail(email), "ip": ip},
+	})
+
+	c.JSON(http.StatusOK, result)
+}
+
+// UnlockHandler clears the failure counters for an email and/or IP (admin)
+func UnlockHandler(c *gin.Context) {
+	var req UnlockRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Email == "" && req.IP == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "email or ip is required"})
+		return
+	}
+
+	limiter := getLoginLimiter()
+	var keys []string
+	if req.Email != "" {
+		keys = append(keys, accountKey(req.Email))
+	}
+	if req.IP != "" {
+		keys = append(keys, ipKey(req.IP))
+	}
+	for _, key := range keys {
+		if err := limiter.store.Reset(c.Request.Context(), key); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock"})
+			return
+		}
+	}
+
+	RecordAudit(c, audit.Event{
+		Action:  audit.ActionLockoutUnlock,
+		Details: map[string]string{"email": normalizeEmail(req.Email), "ip": req.IP},
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Unlocked successfully"})
+}
@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestLockoutPolicyBackoff(t *testing.T) {
+	policy := LockoutPolicy{
+		MaxFailures: 5,
+		BaseLockout: time.Minute,
+		MaxLockout:  10 * time.Minute,
+		Window:      time.Hour,
+	}
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{4, 0},
+		{5, time.Minute},
+		{6, 2 * time.Minute},
+		{7, 4 * time.Minute},
+		{8, 8 * time.Minute},
+		{9, 10 * time.Minute},
+		{50, 10 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := policy.lockoutFor(tt.failures); got != tt.want {
+			t.Errorf("lockoutFor(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestLoginLimiterLocksAccountAndIP(t *testing.T) {
+	account := LockoutPolicy{MaxFailures: 3, BaseLockout: time.Minute, MaxLockout: time.Hour, Window: time.Hour}
+	ip := account
+	ip.MaxFailures = 5
+	limiter := NewLoginLimiter(NewMemoryAttemptStore(), account, ip)
+	ctx := context.Background()
+
+	// Two failures on one account stay under both thresholds
+	for i := 0; i < 2; i++ {
+		if err := limiter.Failure(ctx, "a@example.com", "10.0.0.1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := limiter.Check(ctx, "a@example.com", "10.0.0.1"); err != nil {
+		t.Fatalf("locked after 2 failures: %v", err)
+	}
+
+	// The third locks the account, but only the account
+	if err := limiter.Failure(ctx, "A@example.com ", "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	wait, err := limiter.Check(ctx, "a@example.com", "10.0.0.2")
+	if !errors.Is(err, ErrLoginLocked) || wait <= 0 || wait > time.Minute {
+		t.Fatalf("account check = %v, %v; want locked for up to a minute", wait, err)
+	}
+	if _, err := limiter.Check(ctx, "b@example.com", "10.0.0.1"); err != nil {
+		t.Fatalf("other account locked too early: %v", err)
+	}
+
+	// Spraying other accounts from the same IP locks the IP
+	for _, email := range []string{"b@example.com", "c@example.com"} {
+		if err := limiter.Failure(ctx, email, "10.0.0.1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := limiter.Check(ctx, "d@example.com", "10.0.0.1"); !errors.Is(err, ErrLoginLocked) {
+		t.Fatalf("IP check = %v, want locked", err)
+	}
+
+	// Success clears the account counter but not the IP counter
+	if err := limiter.Success(ctx, "a@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := limiter.Check(ctx, "a@example.com", "10.0.0.2"); err != nil {
+		t.Fatalf("account still locked after success: %v", err)
+	}
+	if _, err := limiter.Check(ctx, "d@example.com", "10.0.0.1"); !errors.Is(err, ErrLoginLocked) {
+		t.Fatal("success on one account unlocked the IP")
+	}
+}
+
+func TestMemoryAttemptStoreWindowRestartsCount(t *testing.T) {
+	store := NewMemoryAttemptStore()
+	ctx := context.Background()
+	start := time.Now()
+
+	for i := 0; i < 3; i++ {
+		if _, err := store.RecordFailure(ctx, "k", start, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+	attempt, err := store.RecordFailure(ctx, "k", start.Add(2*time.Hour), 3*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempt.Failures != 1 {
+		t.Errorf("failures after a quiet window = %d, want 1", attempt.Failures)
+	}
+}
+
+func TestDummyPasswordHashMatchesHashCost(t *testing.T) {
+	cost, err := bcrypt.Cost([]byte(dummyPasswordHash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cost != passwordHashCost {
+		t.Errorf("dummy hash cost = %d, want %d", cost, passwordHashCost)
+	}
+}
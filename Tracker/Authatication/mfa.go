@@ -0,0 +1,395 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"Tracker/internal/audit"
+	"Tracker/internal/config"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+)
+
+// mfaChallengeTTL is how long a user has to enter a code after their password
+const mfaChallengeTTL = 5 * time.Minute
+
+// recoveryCodeCount is how many recovery codes are issued on enrolment
+const recoveryCodeCount = 10
+
+// MFAChallengeResponse is returned by LoginHandler when a second factor is required
+type MFAChallengeResponse struct {
+	MFARequired bool      `json:"mfaRequired"`
+	MFAToken    string    `json:"mfaToken"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+}
+
+// MFALoginRequest represents the second login step request body. Either a
+// TOTP code or a recovery code must be provided.
+type MFALoginRequest struct {
+	MFAToken     string `json:"mfaToken" binding:"required"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode"`
+}
+
+// TOTPCodeRequest represents a request carrying a single TOTP code
+type TOTPCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// TOTPEnrollResponse carries the secret for manual entry and the otpauth
+// URI for rendering as a QR code
+type TOTPEnrollResponse struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauthUri"`
+}
+
+// generateMFAChallenge signs a short-lived token proving the password step
+// succeeded. It is only accepted by LoginMFAHandler, never as an access token.
+func generateMFAChallenge(user *User) (string, time.Time, error) {
+	jti, err := randomToken(16)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	expiresAt := time.Now().Add(mfaChallengeTTL)
+	claims := &Claims{
+		UserID:   user.ID,
+		Email:    user.Email,
+		Role:     user.Role,
+		TenantID: user.TenantID,
+		TokenUse: TokenUseMFAChallenge,
+		StandardClaims: jwt.StandardClaims{
+			Id:        jti,
+			Issuer:    config.GetJWTIssuer(),
+			Subject:   user.ID,
+			ExpiresAt: expiresAt.Unix(),
+			IssuedAt:  time.Now().Unix(),
+		},
+	}
+
+	token, err := getKeySet().sign(claims)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
+
+// verifyTOTPCode checks a code against the user's active secret and
+// consumes its time step so the same code cannot be replayed
+func verifyTOTPCode(ctx context.Context, user *User, code string) (bool, error) {
+	secret, err := DecodeTOTPSecret(user.TOTPSecret)
+	if err != nil || len(secret) == 0 {
+		return false, nil
+	}
+
+	step, ok := ValidateTOTP(secret, code, time.Now(), DefaultTOTPOptions)
+	if !ok {
+		return false, nil
+	}
+	return userStore.ConsumeTOTPStep(ctx, user.ID, step)
+}
+
+// verifySecondFactor accepts either a TOTP code or a single-use recovery code
+func verifySecondFactor(ctx context.Context, user *User, code, recoveryCode string) (bool, error) {
+	if recoveryCode != "" {
+		return userStore.ConsumeRecoveryCode(ctx, user.ID, hashToken(normalizeRecoveryCode(recoveryCode)))
+	}
+	if code != "" {
+		return verifyTOTPCode(ctx, user, code)
+	}
+	return false, nil
+}
+
+// generateRecoveryCodes returns plaintext codes for the user and their hashes for storage
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+
+	for i := 0; i < recoveryCodeCount; i++ {
+		secret, err := GenerateTOTPSecret()
+		if err != nil {
+			return nil, nil, err
+		}
+		raw := strings.ToLower(secret[:10])
+		code := raw[:5] + "-" + raw[5:]
+
+		codes = append(codes, code)
+		hashes = append(hashes, hashToken(normalizeRecoveryCode(code)))
+	}
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode strips separators and case so codes can be typed loosely
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// currentUser loads the authenticated user's record
+func currentUser(c *gin.Context) (*User, bool) {
+	if userStore == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "User store not configured"})
+		return nil, false
+	}
+
+	userID, _ := GetUserID(c)
+	user, err := userStore.FindByID(c.Request.Context(), userID)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up user"})
+		return nil, false
+	}
+	return user, true
+}
+
+// LoginMFAHandler completes a login that requires a second factor
+func LoginMFAHandler(c *gin.Context) {
+	var req MFALoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Code == "" && req.RecoveryCode == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Either code or recoveryCode is required"})
+		return
+	}
+
+	if userStore == nil || tokenStore == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Auth stores not configured"})
+		return
+	}
+	ctx := c.Request.Context()
+
+	// The challenge token proves the password step succeeded
+	claims, err := validateTokenUse(req.MFAToken, TokenUseMFAChallenge)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired MFA token"})
+		return
+	}
+	if used, err := tokenStore.IsJTIDenied(ctx, claims.Id); err != nil || used {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired MFA token"})
+		return
+	}
+
+	user, err := userStore.FindByID(ctx, claims.UserID)
+	if err != nil || !user.MFAEnabled {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired MFA token"})
+		return
+	}
+
+	// Code guesses share the password lockout budget
+	limiter := getLoginLimiter()
+	if wait, err := limiter.Check(ctx, user.Email, c.ClientIP()); err != nil {
+		if errors.Is(err, ErrLoginLocked) {
+			respondLocked(c, wait)
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check login attempts"})
+		return
+	}
+
+	ok, err := verifySecondFactor(ctx, user, req.Code, req.RecoveryCode)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify code"})
+		return
+	}
+	if !ok {
+		RecordAudit(c, audit.Event{
+			Action:   audit.ActionLoginFailure,
+			Outcome:  audit.OutcomeFailure,
+			TenantID: user.TenantID,
+			TargetID: user.ID,
+			Details:  map[string]string{"email": user.Email, "reason": "bad_second_factor"},
+		})
+		if err := limiter.Failure(ctx, user.Email, c.ClientIP()); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record login attempt"})
+			return
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	// Challenge tokens are single-use. Claiming atomically makes sure two
+	// concurrent requests with the same challenge cannot both log in.
+	claimed, err := tokenStore.ClaimJTI(ctx, claims.Id, time.Unix(claims.ExpiresAt, 0))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to complete login"})
+		return
+	}
+	if !claimed {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired MFA token"})
+		return
+	}
+
+	if err := limiter.Success(ctx, user.Email); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record login attempt"})
+		return
+	}
+
+	tokens, err := issueTokenPair(ctx, user, "", true)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	RecordAudit(c, audit.Event{
+		Action:   audit.ActionLoginSuccess,
+		ActorID:  user.ID,
+		TenantID: user.TenantID,
+		TargetID: user.ID,
+		Details:  map[string]string{"method": "password", "mfa": "true"},
+	})
+
+	c.JSON(http.StatusOK, LoginResponse{
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		ExpiresAt:    tokens.ExpiresAt,
+		User:         *user,
+	})
+}
+
+// EnrollTOTPHandler starts TOTP enrolment by generating a pending secret
+func EnrollTOTPHandler(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	if user.MFAEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate secret"})
+		return
+	}
+
+	if err := userStore.SetPendingTOTPSecret(c.Request.Context(), user.ID, secret); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save secret"})
+		return
+	}
+
+	c.JSON(http.StatusOK, TOTPEnrollResponse{
+		Secret:     secret,
+		OTPAuthURI: TOTPURI(config.GetTOTPIssuer(), user.Email, secret, DefaultTOTPOptions),
+	})
+}
+
+// ConfirmTOTPHandler activates the pending secret after a valid code and
+// returns the recovery codes, which are only shown once
+func ConfirmTOTPHandler(c *gin.Context) {
+	var req TOTPCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	if user.PendingTOTPSecret == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No TOTP enrolment in progress"})
+		return
+	}
+
+	secret, err := DecodeTOTPSecret(user.PendingTOTPSecret)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Stored secret is invalid"})
+		return
+	}
+	if _, valid := ValidateTOTP(secret, req.Code, time.Now(), DefaultTOTPOptions); !valid {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate recovery codes"})
+		return
+	}
+
+	if err := userStore.EnableTOTP(c.Request.Context(), user.ID, user.PendingTOTPSecret, hashes); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enable two-factor authentication"})
+		return
+	}
+
+	RecordAudit(c, audit.Event{
+		Action:   audit.ActionMFAEnable,
+		TenantID: user.TenantID,
+		TargetID: user.ID,
+		Details:  map[string]string{"method": "totp"},
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "Two-factor authentication enabled",
+		"recoveryCodes": codes,
+	})
+}
+
+// DisableTOTPHandler turns off TOTP after re-verifying a current code
+func DisableTOTPHandler(c *gin.Context) {
+	var req TOTPCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	if !user.MFAEnabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is not enabled"})
+		return
+	}
+
+	valid, err := verifyTOTPCode(c.Request.Context(), user, req.Code)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify code"})
+		return
+	}
+	if !valid {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	if err := userStore.DisableTOTP(c.Request.Context(), user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable two-factor authentication"})
+		return
+	}
+
+	RecordAudit(c, audit.Event{
+		Action:   audit.ActionMFADisable,
+		TenantID: user.TenantID,
+		TargetID: user.ID,
+		Details:  map[string]string{"method": "totp"},
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+// RequireMFA rejects sessions that were not established with a second factor
+func RequireMFA() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, ok := GetClaims(c)
+		if !ok || !claims.MFA {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Two-factor authentication is required"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
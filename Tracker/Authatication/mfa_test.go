@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+// enrolledUser creates a user with TOTP enabled and returns its recovery codes
+func enrolledUser(t *testing.T, users *memoryUserStore) (*User, []string) {
+	t.Helper()
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &User{Email: "mfa@example.com", Role: "user"}
+	if err := users.Create(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.EnableTOTP(context.Background(), user.ID, "JBSWY3DPEHPK3PXP", hashes); err != nil {
+		t.Fatal(err)
+	}
+	user, _ = users.FindByID(context.Background(), user.ID)
+	return user, codes
+}
+
+func TestLoginMFAChallengeIsSingleUse(t *testing.T) {
+	users, _ := useMemoryStores(t)
+	user, codes := enrolledUser(t, users)
+
+	challenge, _, err := generateMFAChallenge(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := serveJSON(LoginMFAHandler, http.MethodPost, "/auth/login/mfa",
+		MFALoginRequest{MFAToken: challenge, RecoveryCode: codes[0]})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("first use: status %d, body %s", rec.Code, rec.Body)
+	}
+
+	rec = serveJSON(LoginMFAHandler, http.MethodPost, "/auth/login/mfa",
+		MFALoginRequest{MFAToken: challenge, RecoveryCode: codes[1]})
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("reused challenge: status %d, want 401", rec.Code)
+	}
+}
+
+// barrierUserStore holds every recovery code check until all concurrent
+// requests have reached it, so they all race for the challenge afterwards
+type barrierUserStore struct {
+	*memoryUserStore
+	barrier sync.WaitGroup
+}
+
+func (s *barrierUserStore) ConsumeRecoveryCode(ctx context.Context, id, codeHash string) (bool, error) {
+	ok, err := s.memoryUserStore.ConsumeRecoveryCode(ctx, id, codeHash)
+	s.barrier.Done()
+	s.barrier.Wait()
+	return ok, err
+}
+
+func TestLoginMFAConcurrentChallengeIssuesOneSession(t *testing.T) {
+	users, _ := useMemoryStores(t)
+	user, codes := enrolledUser(t, users)
+
+	const attempts = 8
+	store := &barrierUserStore{memoryUserStore: users}
+	store.barrier.Add(attempts)
+	SetUserStore(store)
+
+	challenge, _, err := generateMFAChallenge(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each request carries a different valid recovery code, so only the
+	// single-use challenge stops more than one from logging in
+	statuses := make([]int, attempts)
+	var wg sync.WaitGroup
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			rec := serveJSON(LoginMFAHandler, http.MethodPost, "/auth/login/mfa",
+				MFALoginRequest{MFAToken: challenge, RecoveryCode: codes[i]})
+			statuses[i] = rec.Code
+		}(i)
+	}
+	wg.Wait()
+
+	succeeded := 0
+	for _, status := range statuses {
+		if status == http.StatusOK {
+			succeeded++
+		}
+	}
+	if succeeded != 1 {
+		t.Fatalf("%d concurrent requests succeeded, want exactly 1 (statuses %v)", succeeded, statuses)
+	}
+}
+
+func TestLoginMFARejectsWrongCode(t *testing.T) {
+	users, _ := useMemoryStores(t)
+	user, codes := enrolledUser(t, users)
+
+	challenge, _, err := generateMFAChallenge(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := serveJSON(LoginMFAHandler, http.MethodPost, "/auth/login/mfa",
+		MFALoginRequest{MFAToken: challenge, Code: "000000"})
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong code: status %d, want 401", rec.Code)
+	}
+
+	// A wrong guess does not burn the challenge
+	rec = serveJSON(LoginMFAHandler, http.MethodPost, "/auth/login/mfa",
+		MFALoginRequest{MFAToken: challenge, RecoveryCode: codes[0]})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("retry with recovery code: status %d, body %s", rec.Code, rec.Body)
+	}
+}
@@ -1,34 +1,201 @@
 package auth
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"strings"
+
+	"Tracker/internal/audit"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Authentication methods recorded on a Principal
+const (
+	AuthMethodJWT    = "jwt"
+	AuthMethodAPIKey = "api_key"
 )
 
+var (
+	// ErrMissingCredentials is returned when a request carries no credentials
+	ErrMissingCredentials = errors.New("authorization header is required")
+	// ErrMalformedAuthHeader is returned for Authorization headers that are not Bearer tokens
+	ErrMalformedAuthHeader = errors.New("authorization header format must be Bearer {token}")
+	// ErrInvalidToken is returned for JWTs that fail validation
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrTokenRevoked is returned for JWTs on the jti denylist
+	ErrTokenRevoked = errors.New("token has been revoked")
+	// ErrInvalidAPIKey is returned for unknown or revoked API keys
+	ErrInvalidAPIKey = errors.New("invalid API key")
+)
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	UserID   string
+	Email    string
+	Role     string
+	TenantID string
+	Method   string
+	// Claims is set for JWT authentication
+	Claims *Claims
+	// APIKey is set for API key authentication
+	APIKey *APIKey
+}
+
+// HasScope reports whether the principal may use a scope. Interactive JWT
+// sessions are unrestricted; API keys only have the scopes they were granted.
+func (p *Principal) HasScope(scope string) bool {
+	if p.Method != AuthMethodAPIKey {
+		return true
+	}
+	return p.APIKey.HasScope(scope)
+}
+
+// Authenticate validates the credentials on a request. It accepts a Bearer
+// JWT, a Bearer personal API key, or an API key in the X-API-Key header.
+func Authenticate(ctx context.Context, r *http.Request) (*Principal, error) {
+	if apiKey := r.Header.Get("X-API-Key"); apiKey != "" {
+		return authenticateCredential(ctx, apiKey)
+	}
+
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		return nil, ErrMissingCredentials
+	}
+
+	// Check if the Authorization header has the correct format
+	parts := strings.Split(authHeader, " ")
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		return nil, ErrMalformedAuthHeader
+	}
+
+	return authenticateCredential(ctx, parts[1])
+}
+
+// authenticateCredential validates a raw JWT or API key
+func authenticateCredential(ctx context.Context, credential string) (*Principal, error) {
+	if strings.HasPrefix(credential, APIKeyPrefix) {
+		key, user, err := authenticateAPIKey(ctx, credential)
+		if err != nil {
+			return nil, err
+		}
+		return &Principal{
+			UserID:   user.ID,
+			Email:    user.Email,
+			Role:     user.Role,
+			TenantID: user.TenantID,
+			Method:   AuthMethodAPIKey,
+			APIKey:   key,
+		}, nil
+	}
+
+	claims, err := ValidateToken(credential)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	// Reject tokens revoked by logout or refresh token reuse
+	revoked, err := isTokenRevoked(ctx, claims)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, ErrTokenRevoked
+	}
+
+	return &Principal{
+		UserID:   claims.UserID,
+		Email:    claims.Email,
+		Role:     claims.Role,
+		TenantID: claims.TenantID,
+		Method:   AuthMethodJWT,
+		Claims:   claims,
+	}, nil
+}
+
+// authErrorStatus maps an Authenticate error to a status code and message
+func authErrorStatus(err error) (int, string) {
+	switch {
+	case errors.Is(err, ErrMissingCredentials):
+		return http.StatusUnauthorized, "Authorization header is required"
+	case errors.Is(err, ErrMalformedAuthHeader):
+		return http.StatusUnauthorized, "Authorization header format must be Bearer {token}"
+	case errors.Is(err, ErrInvalidToken):
+		return http.StatusUnauthorized, "Invalid token"
+	case errors.Is(err, ErrTokenRevoked):
+		return http.StatusUnauthorized, "Token has been revoked"
+	case errors.Is(err, ErrInvalidAPIKey):
+		return http.StatusUnauthorized, "Invalid API key"
+	default:
+		return http.StatusInternalServerError, "Failed to authenticate request"
+	}
+}
+
+// Middleware is the net/http equivalent of AuthMiddleware
 func Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			http.Error(w, "Authorization header is required", http.StatusUnauthorized)
+		principal, err := Authenticate(r.Context(), r)
+		if err != nil {
+			status, message := authErrorStatus(err)
+			http.Error(w, message, status)
 			return
 		}
 
-		// Check if the Authorization header has the correct format
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			http.Error(w, "Authorization header format must be Bearer {token}", http.StatusUnauthorized)
-			return
+		// Add principal and claims to request context
+		ctx := context.WithValue(r.Context(), principalContextKey{}, principal)
+		if principal.Claims != nil {
+			ctx = AddClaimsToContext(ctx, principal.Claims)
 		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
 
-		tokenString := parts[1]
-		claims, err := ValidateToken(tokenString)
-		if err != nil {
-			http.Error(w, "Invalid token", http.StatusUnauthorized)
+// principalContextKey is the context.Context key for the Principal
+type principalContextKey struct{}
+
+// PrincipalFromContext returns the Principal stored by Middleware
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalContextKey{}).(*Principal)
+	return principal, ok
+}
+
+// RecordAudit appends an event for the current request, filling in the
+// client IP and, when not given, the authenticated actor
+func RecordAudit(c *gin.Context, event audit.Event) {
+	event.IP = c.ClientIP()
+	if event.ActorID == "" {
+		event.ActorID, _ = GetUserID(c)
+	}
+	if event.TenantID == "" {
+		event.TenantID = GetTenantID(c)
+	}
+	audit.Record(c.Request.Context(), event)
+}
+
+// SessionOnly rejects requests authenticated with an API key. Use it for
+// account management routes such as creating more keys.
+func SessionOnly() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, ok := GetPrincipal(c)
+		if !ok || principal.Method != AuthMethodJWT {
+			c.JSON(http.StatusForbidden, gin.H{"error": "This endpoint requires an interactive session"})
+			c.Abort()
 			return
 		}
+		c.Next()
+	}
+}
 
-		// Add claims to request context
-		r = r.WithContext(AddClaimsToContext(r.Context(), claims))
-		next.ServeHTTP(w, r)
-	})
-}
\ No newline at end of file
+// RequireScope rejects API keys that were not granted the scope
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, ok := GetPrincipal(c)
+		if !ok || !principal.HasScope(scope) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "API key is missing scope " + scope})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,624 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"Tracker/internal/audit"
+	"Tracker/internal/config"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// ErrOIDCNotConfigured is returned when SSO is used without a provider
+	ErrOIDCNotConfigured = errors.New("single sign-on is not configured")
+	// ErrOIDCInvalidIDToken is returned for ID tokens that fail validation
+	ErrOIDCInvalidIDToken = errors.New("invalid ID token")
+	// ErrOIDCEmailConflict is returned when the provider's email matches an
+	// existing account but the provider has not verified it
+	ErrOIDCEmailConflict = errors.New("email belongs to an existing account")
+)
+
+// oidcStateCookie carries the signed login state between redirect and callback
+const oidcStateCookie = "oidc_state"
+
+// oidcStateTTL is how long a user has to complete the provider login
+const oidcStateTTL = 10 * time.Minute
+
+// oidcJWKSRefreshInterval limits JWKS refetches triggered by unknown kids
+const oidcJWKSRefreshInterval = time.Minute
+
+// TokenUseOIDCState marks the signed login state cookie
+const TokenUseOIDCState = "oidc_state"
+
+// OIDCConfig configures an OpenID Connect relying party
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	// HTTPClient is used for discovery, JWKS and token requests. It
+	// defaults to a client with a 10 second timeout.
+	HTTPClient *http.Client
+}
+
+// OIDCConfigFromEnv returns the provider configuration from the environment
+func OIDCConfigFromEnv() OIDCConfig {
+	return OIDCConfig{
+		Issuer:       config.GetOIDCIssuer(),
+		ClientID:     config.GetOIDCClientID(),
+		ClientSecret: config.GetOIDCClientSecret(),
+		RedirectURL:  config.GetOIDCRedirectURL(),
+		Scopes:       config.GetOIDCScopes(),
+	}
+}
+
+// oidcDiscovery is the subset of the discovery document we use
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// OIDCIdentity is the verified result of an SSO login
+type OIDCIdentity struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	// AMR lists the authentication methods the provider used
+	AMR []string
+}
+
+// UsedMFA reports whether the provider authenticated with more than one factor
+func (i *OIDCIdentity) UsedMFA() bool {
+	for _, method := range i.AMR {
+		if method == "mfa" {
+			return true
+		}
+	}
+	return false
+}
+
+// OIDCProvider is an OpenID Connect issuer this service trusts for logins
+type OIDCProvider struct {
+	cfg       OIDCConfig
+	client    *http.Client
+	discovery oidcDiscovery
+
+	mu            sync.Mutex
+	keys          map[string]crypto.PublicKey
+	keysFetchedAt time.Time
+}
+
+// NewOIDCProvider fetches the issuer's discovery document and JWKS
+func NewOIDCProvider(ctx context.Context, cfg OIDCConfig) (*OIDCProvider, error) {
+	if cfg.Issuer == "" || cfg.ClientID == "" {
+		return nil, errors.New("OIDC issuer and client ID are required")
+	}
+
+	p := &OIDCProvider{cfg: cfg, client: cfg.HTTPClient}
+	if p.client == nil {
+		p.client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	wellKnown := strings.TrimRight(cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := p.getJSON(ctx, wellKnown, &p.discovery); err != nil {
+		return nil, fmt.Errorf("fetching OIDC discovery document: %w", err)
+	}
+	if p.discovery.Issuer != cfg.Issuer {
+		return nil, fmt.Errorf("OIDC discovery issuer %q does not match %q", p.discovery.Issuer, cfg.Issuer)
+	}
+	if p.discovery.AuthorizationEndpoint == "" || p.discovery.TokenEndpoint == "" || p.discovery.JWKSURI == "" {
+		return nil, errors.New("OIDC discovery document is missing endpoints")
+	}
+
+	if err := p.refreshKeys(ctx); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// oidcProvider is the provider used by the SSO handlers
+var oidcProvider *OIDCProvider
+
+// SetOIDCProvider enables SSO logins through the given provider
+func SetOIDCProvider(provider *OIDCProvider) {
+	oidcProvider = provider
+}
+
+// AuthCodeURL returns the provider login URL for an authorization code flow
+// protected by state, nonce and a PKCE S256 challenge derived from verifier
+func (p *OIDCProvider) AuthCodeURL(state, nonce, verifier string) string {
+	challenge := sha256.Sum256([]byte(verifier))
+
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", p.cfg.ClientID)
+	params.Set("redirect_uri", p.cfg.RedirectURL)
+	params.Set("scope", strings.Join(p.cfg.Scopes, " "))
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	params.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(p.discovery.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return p.discovery.AuthorizationEndpoint + sep + params.Encode()
+}
+
+// Exchange redeems an authorization code and returns the verified identity
+func (p *OIDCProvider) Exchange(ctx context.Context, code, verifier, nonce string) (*OIDCIdentity, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.cfg.RedirectURL)
+	form.Set("client_id", p.cfg.ClientID)
+	form.Set("code_verifier", verifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token endpoint returned %d: %s", resp.StatusCode, body)
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.Unmarshal(body, &tokens); err != nil {
+		return nil, err
+	}
+	if tokens.IDToken == "" {
+		return nil, errors.New("token response has no id_token")
+	}
+
+	return p.VerifyIDToken(ctx, tokens.IDToken, nonce)
+}
+
+// VerifyIDToken checks an ID token's signature against the issuer's JWKS
+// and validates its issuer, audience, expiry and nonce
+func (p *OIDCProvider) VerifyIDToken(ctx context.Context, rawIDToken, nonce string) (*OIDCIdentity, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(rawIDToken, claims, func(token *jwt.Token) (interface{}, error) {
+		return p.keyFor(ctx, token)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrOIDCInvalidIDToken, err)
+	}
+
+	if iss, _ := claims["iss"].(string); iss != p.cfg.Issuer {
+		return nil, fmt.Errorf("%w: unexpected issuer %q", ErrOIDCInvalidIDToken, iss)
+	}
+	if !audienceContains(claims["aud"], p.cfg.ClientID) {
+		return nil, fmt.Errorf("%w: audience does not include client", ErrOIDCInvalidIDToken)
+	}
+	if _, ok := claims["exp"]; !ok {
+		return nil, fmt.Errorf("%w: missing exp", ErrOIDCInvalidIDToken)
+	}
+	if got, _ := claims["nonce"].(string); subtle.ConstantTimeCompare([]byte(got), []byte(nonce)) != 1 {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrOIDCInvalidIDToken)
+	}
+
+	identity := &OIDCIdentity{Issuer: p.cfg.Issuer}
+	identity.Subject, _ = claims["sub"].(string)
+	identity.Email, _ = claims["email"].(string)
+	identity.Name, _ = claims["name"].(string)
+	switch verified := claims["email_verified"].(type) {
+	case bool:
+		identity.EmailVerified = verified
+	case string:
+		identity.EmailVerified = verified == "true"
+	}
+	if amr, ok := claims["amr"].([]interface{}); ok {
+		for _, method := range amr {
+			if s, ok := method.(string); ok {
+				identity.AMR = append(identity.AMR, s)
+			}
+		}
+	}
+
+	if identity.Subject == "" {
+		return nil, fmt.Errorf("%w: missing sub", ErrOIDCInvalidIDToken)
+	}
+	return identity, nil
+}
+
+// keyFor resolves the issuer key for an ID token, refetching the JWKS once
+// when the kid is unknown so provider key rotation is picked up
+func (p *OIDCProvider) keyFor(ctx context.Context, token *jwt.Token) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	kid, _ := token.Header["kid"].(string)
+
+	p.mu.Lock()
+	key, ok := p.keys[kid]
+	stale := time.Since(p.keysFetchedAt) > oidcJWKSRefreshInterval
+	p.mu.Unlock()
+
+	if !ok && stale {
+		if err := p.refreshKeys(ctx); err != nil {
+			return nil, err
+		}
+		p.mu.Lock()
+		key, ok = p.keys[kid]
+		p.mu.Unlock()
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	if !keyMatchesMethod(key, token.Method) {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return key, nil
+}
+
+// refreshKeys downloads the issuer's JWKS
+func (p *OIDCProvider) refreshKeys(ctx context.Context) error {
+	var jwks struct {
+		Keys []JWK `json:"keys"`
+	}
+	if err := p.getJSON(ctx, p.discovery.JWKSURI, &jwks); err != nil {
+		return fmt.Errorf("fetching OIDC JWKS: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(jwks.Keys))
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.PublicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.keysFetchedAt = time.Now()
+	p.mu.Unlock()
+	return nil
+}
+
+// getJSON fetches and decodes a JSON document
+func (p *OIDCProvider) getJSON(ctx context.Context, rawURL string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %d", rawURL, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// PublicKey decodes an RSA, EC or Ed25519 JWK
+func (j JWK) PublicKey() (crypto.PublicKey, error) {
+	decode := base64.RawURLEncoding.DecodeString
+
+	switch j.Kty {
+	case "RSA":
+		n, err := decode(j.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(j.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch j.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", j.Crv)
+		}
+		x, err := decode(j.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(j.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case "OKP":
+		if j.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", j.Crv)
+		}
+		x, err := decode(j.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key size")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", j.Kty)
+	}
+}
+
+// keyMatchesMethod stops a token from picking an algorithm its key was not made for
+func keyMatchesMethod(key crypto.PublicKey, method jwt.SigningMethod) bool {
+	switch key.(type) {
+	case *rsa.PublicKey:
+		_, rs := method.(*jwt.SigningMethodRSA)
+		_, ps := method.(*jwt.SigningMethodRSAPSS)
+		return rs || ps
+	case *ecdsa.PublicKey:
+		_, ok := method.(*jwt.SigningMethodECDSA)
+		return ok
+	case ed25519.PublicKey:
+		_, ok := method.(*SigningMethodEdDSA)
+		return ok
+	default:
+		return false
+	}
+}
+
+// audienceContains checks a string or array aud claim for the client ID
+func audienceContains(aud interface{}, clientID string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == clientID
+	case []interface{}:
+		for _, a := range v {
+			if s, ok := a.(string); ok && s == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// oidcStateClaims is the signed login state stored in a cookie during the
+// redirect to the provider
+type oidcStateClaims struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	TokenUse string `json:"token_use"`
+	jwt.StandardClaims
+}
+
+// resolveOIDCUser returns the account for an SSO identity, linking it to an
+// existing account with the same verified email or creating a new one
+func resolveOIDCUser(ctx context.Context, identity *OIDCIdentity) (*User, error) {
+	user, err := userStore.FindByIdentity(ctx, identity.Issuer, identity.Subject)
+	if err == nil || !errors.Is(err, ErrUserNotFound) {
+		return user, err
+	}
+
+	if identity.Email == "" {
+		return nil, fmt.Errorf("%w: no email claim", ErrOIDCInvalidIDToken)
+	}
+
+	user, err = userStore.FindByEmail(ctx, identity.Email)
+	switch {
+	case err == nil:
+		// Only link when the provider vouches for the address, otherwise
+		// anyone could claim an existing account by its email
+		if !identity.EmailVerified {
+			return nil, ErrOIDCEmailConflict
+		}
+	case errors.Is(err, ErrUserNotFound):
+		user = &User{
+			Email:         identity.Email,
+			Role:          RoleUser,
+			EmailVerified: identity.EmailVerified,
+		}
+		if err := createAccount(ctx, user, config.GetOIDCJoinCode(), identity.Email); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	if err := userStore.LinkIdentity(ctx, user.ID, identity.Issuer, identity.Subject); err != nil {
+		return nil, err
+	}
+	if identity.EmailVerified && !user.EmailVerified {
+		if err := userStore.MarkEmailVerified(ctx, user.ID, user.Email); err != nil {
+			return nil, err
+		}
+		user.EmailVerified = true
+	}
+	return user, nil
+}
+
+// OIDCLoginHandler starts an SSO login by redirecting to the provider
+func OIDCLoginHandler(c *gin.Context) {
+	if oidcProvider == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Single sign-on is not configured"})
+		return
+	}
+
+	values := make([]string, 3)
+	for i := range values {
+		v, err := randomToken(32)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+			return
+		}
+		values[i] = v
+	}
+	state, nonce, verifier := values[0], values[1], values[2]
+
+	cookie, err := getKeySet().sign(&oidcStateClaims{
+		State:    state,
+		Nonce:    nonce,
+		Verifier: verifier,
+		TokenUse: TokenUseOIDCState,
+		StandardClaims: jwt.StandardClaims{
+			Issuer:    config.GetJWTIssuer(),
+			ExpiresAt: time.Now().Add(oidcStateTTL).Unix(),
+			IssuedAt:  time.Now().Unix(),
+		},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return
+	}
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, cookie, int(oidcStateTTL.Seconds()), "/auth/oidc", "", c.Request.TLS != nil, true)
+	c.Redirect(http.StatusFound, oidcProvider.AuthCodeURL(state, nonce, verifier))
+}
+
+// OIDCCallbackHandler completes an SSO login and issues the usual token pair
+func OIDCCallbackHandler(c *gin.Context) {
+	if oidcProvider == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Single sign-on is not configured"})
+		return
+	}
+	if userStore == nil || tenantStore == nil || tokenStore == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Auth stores not configured"})
+		return
+	}
+	ctx := c.Request.Context()
+
+	if providerErr := c.Query("error"); providerErr != "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Single sign-on failed: " + providerErr})
+		return
+	}
+
+	// The state cookie ties the callback to the browser that started the login
+	cookie, err := c.Cookie(oidcStateCookie)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Login session expired, start again"})
+		return
+	}
+	c.SetCookie(oidcStateCookie, "", -1, "/auth/oidc", "", c.Request.TLS != nil, true)
+
+	stateClaims := &oidcStateClaims{}
+	token, err := jwt.ParseWithClaims(cookie, stateClaims, getKeySet().keyFunc)
+	if err != nil || !token.Valid || stateClaims.TokenUse != TokenUseOIDCState {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Login session expired, start again"})
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(c.Query("state")), []byte(stateClaims.State)) != 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid login state"})
+		return
+	}
+
+	code := c.Query("code")
+	if code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "code is required"})
+		return
+	}
+
+	identity, err := oidcProvider.Exchange(ctx, code, stateClaims.Verifier, stateClaims.Nonce)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Single sign-on failed"})
+		return
+	}
+
+	user, err := resolveOIDCUser(ctx, identity)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrOIDCEmailConflict), errors.Is(err, ErrEmailTaken), errors.Is(err, ErrIdentityLinked):
+			c.JSON(http.StatusConflict, gin.H{"error": "An account with this email already exists; sign in with your password to continue"})
+		case errors.Is(err, ErrOIDCInvalidIDToken):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Single sign-on failed"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign in"})
+		}
+		return
+	}
+
+	// A second factor at the provider counts as one here. Otherwise an
+	// account with TOTP enrolled still has to complete /auth/login/mfa.
+	if user.MFAEnabled && !identity.UsedMFA() {
+		challenge, expiresAt, err := generateMFAChallenge(user)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate MFA challenge"})
+			return
+		}
+		c.JSON(http.StatusOK, MFAChallengeResponse{
+			MFARequired: true,
+			MFAToken:    challenge,
+			ExpiresAt:   expiresAt,
+		})
+		return
+	}
+
+	tokens, err := issueTokenPair(ctx, user, "", identity.UsedMFA())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	RecordAudit(c, audit.Event{
+		Action:   audit.ActionLoginSuccess,
+		ActorID:  user.ID,
+		TenantID: user.TenantID,
+		TargetID: user.ID,
+		Details:  map[string]string{"method": "oidc", "issuer": identity.Issuer, "mfa": boolString(identity.UsedMFA())},
+	})
+
+	c.JSON(http.StatusOK, LoginResponse{
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		ExpiresAt:    tokens.ExpiresAt,
+		User:         *user,
+	})
+}
@@ -0,0 +1,404 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+)
+
+const mockClientID = "tracker-test"
+
+// mockIssuer is a minimal OpenID Connect provider serving discovery, JWKS
+// and an authorization code token endpoint that enforces PKCE
+type mockIssuer struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	// discoveryIssuer overrides the issuer advertised in discovery
+	discoveryIssuer string
+
+	mu    sync.Mutex
+	codes map[string]mockAuthRequest
+}
+
+// mockAuthRequest is what the issuer remembers about an issued code
+type mockAuthRequest struct {
+	challenge string
+	claims    jwt.MapClaims
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockIssuer{key: key, codes: make(map[string]mockAuthRequest)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		issuer := m.server.URL
+		if m.discoveryIssuer != "" {
+			issuer = m.discoveryIssuer
+		}
+		json.NewEncoder(w).Encode(oidcDiscovery{
+			Issuer:                issuer,
+			AuthorizationEndpoint: m.server.URL + "/authorize",
+			TokenEndpoint:         m.server.URL + "/token",
+			JWKSURI:               m.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string][]JWK{"keys": {{
+			Kty: "RSA",
+			Kid: "mock",
+			Use: "sig",
+			Alg: "RS256",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", m.handleToken)
+
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+// handleToken redeems a code once, checking the PKCE verifier
+func (m *mockIssuer) handleToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	m.mu.Lock()
+	req, ok := m.codes[r.Form.Get("code")]
+	delete(m.codes, r.Form.Get("code"))
+	m.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != req.challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, req.claims)
+	token.Header["kid"] = "mock"
+	idToken, err := token.SignedString(m.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"id_token": idToken, "token_type": "Bearer"})
+}
+
+// authorize plays the user logging in at the provider: it records a code
+// bound to the request's PKCE challenge and returns it. Claims default to
+// a valid ID token for the request's nonce.
+func (m *mockIssuer) authorize(authURL *url.URL, claims jwt.MapClaims) string {
+	query := authURL.Query()
+	defaults := jwt.MapClaims{
+		"iss":   m.server.URL,
+		"aud":   mockClientID,
+		"exp":   time.Now().Add(time.Minute).Unix(),
+		"iat":   time.Now().Unix(),
+		"nonce": query.Get("nonce"),
+	}
+	for k, v := range defaults {
+		if _, ok := claims[k]; !ok {
+			claims[k] = v
+		}
+	}
+
+	code, _ := randomToken(16)
+	m.mu.Lock()
+	m.codes[code] = mockAuthRequest{challenge: query.Get("code_challenge"), claims: claims}
+	m.mu.Unlock()
+	return code
+}
+
+// useMockIssuer configures SSO against a fresh mock issuer
+func useMockIssuer(t *testing.T) *mockIssuer {
+	t.Helper()
+
+	m := newMockIssuer(t)
+	provider, err := NewOIDCProvider(context.Background(), OIDCConfig{
+		Issuer:      m.server.URL,
+		ClientID:    mockClientID,
+		RedirectURL: "http://tracker.test/auth/oidc/callback",
+		Scopes:      []string{"openid", "email"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prev := oidcProvider
+	SetOIDCProvider(provider)
+	t.Cleanup(func() { SetOIDCProvider(prev) })
+	return m
+}
+
+// startOIDCLogin runs the login redirect and returns the provider URL and
+// the state cookie set on the browser
+func startOIDCLogin(t *testing.T) (*url.URL, *http.Cookie) {
+	t.Helper()
+
+	router := gin.New()
+	router.GET("/auth/oidc/login", OIDCLoginHandler)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/auth/oidc/login", nil))
+
+	if rec.Code != http.StatusFound {
+		t.Fatalf("login: status %d, body %s", rec.Code, rec.Body)
+	}
+	authURL, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == oidcStateCookie {
+			return authURL, cookie
+		}
+	}
+	t.Fatal("login did not set the state cookie")
+	return nil, nil
+}
+
+// finishOIDCLogin calls the callback the provider redirects back to
+func finishOIDCLogin(cookie *http.Cookie, state, code string) *httptest.ResponseRecorder {
+	router := gin.New()
+	router.GET("/auth/oidc/callback", OIDCCallbackHandler)
+
+	query := url.Values{"state": {state}, "code": {code}}
+	req := httptest.NewRequest(http.MethodGet, "/auth/oidc/callback?"+query.Encode(), nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestOIDCDiscovery(t *testing.T) {
+	m := newMockIssuer(t)
+
+	provider, err := NewOIDCProvider(context.Background(), OIDCConfig{Issuer: m.server.URL, ClientID: mockClientID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if provider.discovery.TokenEndpoint != m.server.URL+"/token" {
+		t.Errorf("token endpoint = %q", provider.discovery.TokenEndpoint)
+	}
+	if len(provider.keys) != 1 {
+		t.Errorf("loaded %d keys, want 1", len(provider.keys))
+	}
+
+	authURL, err := url.Parse(provider.AuthCodeURL("s", "n", "v"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := authURL.Query().Get("code_challenge_method"); got != "S256" {
+		t.Errorf("code_challenge_method = %q, want S256", got)
+	}
+
+	// A discovery document for another issuer must be refused
+	m.discoveryIssuer = "https://evil.example.com"
+	if _, err := NewOIDCProvider(context.Background(), OIDCConfig{Issuer: m.server.URL, ClientID: mockClientID}); err == nil {
+		t.Error("NewOIDCProvider accepted a mismatched discovery issuer")
+	}
+}
+
+func TestOIDCCallbackRejectsTamperedFlows(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(m *mockIssuer, authURL *url.URL, cookie *http.Cookie) (*http.Cookie, string, string)
+		status int
+	}{
+		{
+			name: "state mismatch",
+			tamper: func(m *mockIssuer, authURL *url.URL, cookie *http.Cookie) (*http.Cookie, string, string) {
+				code := m.authorize(authURL, jwt.MapClaims{"sub": "alice", "email": "alice@example.com"})
+				return cookie, "forged-state", code
+			},
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "missing state cookie",
+			tamper: func(m *mockIssuer, authURL *url.URL, cookie *http.Cookie) (*http.Cookie, string, string) {
+				code := m.authorize(authURL, jwt.MapClaims{"sub": "alice", "email": "alice@example.com"})
+				return nil, authURL.Query().Get("state"), code
+			},
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "PKCE verifier mismatch",
+			tamper: func(m *mockIssuer, authURL *url.URL, cookie *http.Cookie) (*http.Cookie, string, string) {
+				// The code was issued for a different login's challenge
+				other, _ := url.Parse(authURL.String())
+				query := other.Query()
+				query.Set("code_challenge", "not-the-challenge")
+				other.RawQuery = query.Encode()
+				code := m.authorize(other, jwt.MapClaims{"sub": "alice", "email": "alice@example.com"})
+				return cookie, authURL.Query().Get("state"), code
+			},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "nonce mismatch",
+			tamper: func(m *mockIssuer, authURL *url.URL, cookie *http.Cookie) (*http.Cookie, string, string) {
+				code := m.authorize(authURL, jwt.MapClaims{"sub": "alice", "email": "alice@example.com", "nonce": "replayed"})
+				return cookie, authURL.Query().Get("state"), code
+			},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "wrong audience",
+			tamper: func(m *mockIssuer, authURL *url.URL, cookie *http.Cookie) (*http.Cookie, string, string) {
+				code := m.authorize(authURL, jwt.MapClaims{"sub": "alice", "email": "alice@example.com", "aud": "other-client"})
+				return cookie, authURL.Query().Get("state"), code
+			},
+			status: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useMemoryStores(t)
+			m := useMockIssuer(t)
+
+			authURL, cookie := startOIDCLogin(t)
+			cookie, state, code := tt.tamper(m, authURL, cookie)
+
+			rec := finishOIDCLogin(cookie, state, code)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d (body %s)", rec.Code, tt.status, rec.Body)
+			}
+		})
+	}
+}
+
+func TestOIDCCallbackCreatesAndLinksAccounts(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing bool
+		verified bool
+		status   int
+		linked   bool
+	}{
+		{name: "new account", existing: false, verified: true, status: http.StatusOK, linked: true},
+		{name: "links verified email", existing: true, verified: true, status: http.StatusOK, linked: true},
+		{name: "refuses unverified email", existing: true, verified: false, status: http.StatusConflict, linked: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users, _ := useMemoryStores(t)
+			m := useMockIssuer(t)
+
+			var existingID string
+			if tt.existing {
+				user := &User{Email: "bob@example.com", Role: RoleUser}
+				if err := users.Create(context.Background(), user); err != nil {
+					t.Fatal(err)
+				}
+				existingID = user.ID
+			}
+
+			authURL, cookie := startOIDCLogin(t)
+			code := m.authorize(authURL, jwt.MapClaims{
+				"sub":            "bob-sub",
+				"email":          "Bob@Example.com",
+				"email_verified": tt.verified,
+			})
+
+			rec := finishOIDCLogin(cookie, authURL.Query().Get("state"), code)
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d (body %s)", rec.Code, tt.status, rec.Body)
+			}
+
+			linked, err := users.FindByIdentity(context.Background(), m.server.URL, "bob-sub")
+			if tt.linked != (err == nil) {
+				t.Fatalf("linked = %v, want %v", err == nil, tt.linked)
+			}
+			if tt.linked && tt.existing && linked.ID != existingID {
+				t.Errorf("identity linked to %s, want existing account %s", linked.ID, existingID)
+			}
+			if tt.linked && !linked.EmailVerified {
+				t.Error("provider-verified email was not marked verified")
+			}
+		})
+	}
+}
+
+func TestOIDCCallbackRequiresLocalSecondFactor(t *testing.T) {
+	tests := []struct {
+		name    string
+		amr     []interface{}
+		wantMFA bool
+	}{
+		{name: "password only at provider", amr: []interface{}{"pwd"}, wantMFA: true},
+		{name: "no amr claim", amr: nil, wantMFA: true},
+		{name: "mfa at provider", amr: []interface{}{"pwd", "mfa"}, wantMFA: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users, _ := useMemoryStores(t)
+			m := useMockIssuer(t)
+			user, _ := enrolledUser(t, users)
+			if err := users.LinkIdentity(context.Background(), user.ID, m.server.URL, "mfa-sub"); err != nil {
+				t.Fatal(err)
+			}
+
+			claims := jwt.MapClaims{"sub": "mfa-sub", "email": user.Email, "email_verified": true}
+			if tt.amr != nil {
+				claims["amr"] = tt.amr
+			}
+			authURL, cookie := startOIDCLogin(t)
+			code := m.authorize(authURL, claims)
+
+			rec := finishOIDCLogin(cookie, authURL.Query().Get("state"), code)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d, body %s", rec.Code, rec.Body)
+			}
+
+			var body struct {
+				MFARequired bool   `json:"mfaRequired"`
+				MFAToken    string `json:"mfaToken"`
+				Token       string `json:"token"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+
+			if !tt.wantMFA {
+				if body.MFARequired || body.Token == "" {
+					t.Fatalf("expected a session, got %s", rec.Body)
+				}
+				return
+			}
+			if !body.MFARequired || body.Token != "" {
+				t.Fatalf("expected an MFA challenge, got %s", rec.Body)
+			}
+			if _, err := validateTokenUse(body.MFAToken, TokenUseMFAChallenge); err != nil {
+				t.Fatalf("mfaToken is not an MFA challenge: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,362 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"Tracker/internal/audit"
+	"Tracker/internal/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Permission is an action on a resource, written "resource:action"
+type Permission string
+
+// Resources and the permissions defined on them. Analyses are the aggregated
+// summaries derived from activities and events; reading them does not grant
+// access to the raw records (URLs, titles, metadata).
+const (
+	PermActivitiesRead  Permission = "activities:read"
+	PermActivitiesWrite Permission = "activities:write"
+	PermEventsRead      Permission = "events:read"
+	PermEventsWrite     Permission = "events:write"
+	PermAnalysesRead    Permission = "analyses:read"
+	PermUsersRead       Permission = "users:read"
+	PermUsersWrite      Permission = "users:write"
+)
+
+// permissionScopes maps permissions to the API key scope that unlocks them.
+// Permissions without a scope are only available to interactive sessions.
+var permissionScopes = map[Permission]string{
+	PermActivitiesRead:  ScopeActivitiesRead,
+	PermActivitiesWrite: ScopeActivitiesWrite,
+	PermEventsWrite:     ScopeEventsWrite,
+	PermAnalysesRead:    ScopeActivitiesRead,
+}
+
+// Condition restricts whose resources a grant applies to. Conditions are
+// ordered: a broader condition includes every narrower one.
+type Condition int
+
+// Grant conditions, from narrowest to broadest
+const (
+	// ConditionOwn applies to resources owned by the subject
+	ConditionOwn Condition = iota + 1
+	// ConditionTeam applies to resources of users on a team the subject manages
+	ConditionTeam
+	// ConditionTenant applies to any resource in the subject's organization
+	ConditionTenant
+	// ConditionAny applies to every resource
+	ConditionAny
+)
+
+// String returns the condition name used in logs
+func (c Condition) String() string {
+	switch c {
+	case ConditionOwn:
+		return "own"
+	case ConditionTeam:
+		return "team"
+	case ConditionTenant:
+		return "tenant"
+	case ConditionAny:
+		return "any"
+	default:
+		return "none"
+	}
+}
+
+// Grant gives a role a permission under a condition
+type Grant struct {
+	Permission Permission
+	Condition  Condition
+}
+
+// Policy maps role names to their grants. Roles are both account roles
+// (user, admin) and organization roles (owner, manager, member).
+type Policy struct {
+	grants map[string][]Grant
+}
+
+// NewPolicy creates an empty policy that denies everything
+func NewPolicy() *Policy {
+	return &Policy{grants: make(map[string][]Grant)}
+}
+
+// Allow grants a permission to a role under a condition
+func (p *Policy) Allow(role string, perm Permission, cond Condition) *Policy {
+	p.grants[role] = append(p.grants[role], Grant{Permission: perm, Condition: cond})
+	return p
+}
+
+// broadest returns the broadest condition under which any of the roles holds perm
+func (p *Policy) broadest(roles []string, perm Permission) Condition {
+	var best Condition
+	for _, role := range roles {
+		for _, grant := range p.grants[role] {
+			if grant.Permission == perm && grant.Condition > best {
+				best = grant.Condition
+			}
+		}
+	}
+	return best
+}
+
+// DefaultPolicy is the tracker's built-in policy. Users manage their own
+// data, team managers read their members' analyses, organization owners read
+// analyses and users across the organization, and admins can do anything.
+func DefaultPolicy() *Policy {
+	p := NewPolicy()
+	for _, perm := range []Permission{
+		PermActivitiesRead, PermActivitiesWrite,
+		PermEventsRead, PermEventsWrite,
+		PermAnalysesRead,
+		PermUsersRead, PermUsersWrite,
+	} {
+		p.Allow(RoleUser, perm, ConditionOwn)
+		p.Allow(RoleAdmin, perm, ConditionAny)
+	}
+
+	p.Allow(model.MemberRoleManager, PermAnalysesRead, ConditionTeam)
+	p.Allow(model.MemberRoleManager, PermUsersRead, ConditionTeam)
+
+	p.Allow(model.MemberRoleOwner, PermAnalysesRead, ConditionTenant)
+	p.Allow(model.MemberRoleOwner, PermUsersRead, ConditionTenant)
+	return p
+}
+
+// RelationStore answers the organization questions the policy engine needs
+type RelationStore interface {
+	// MemberRoles returns the user's organization role and team roles
+	MemberRoles(ctx context.Context, tenantID, userID string) ([]string, error)
+	// ManagesUser reports whether managerID manages a team userID belongs to
+	ManagesUser(ctx context.Context, tenantID, managerID, userID string) (bool, error)
+}
+
+// Target identifies the owner of the resource being accessed. A zero Target
+// asks whether the subject holds the permission on anything at all.
+type Target struct {
+	OwnerID  string
+	TenantID string
+}
+
+// Decision is the outcome of a policy check
+type Decision struct {
+	Allowed    bool
+	Permission Permission
+	// Condition is the broadest condition the subject holds the permission under
+	Condition Condition
+	Reason    string
+}
+
+// Covers reports whether the decision grants at least the given condition
+func (d *Decision) Covers(cond Condition) bool {
+	return d.Allowed && d.Condition >= cond
+}
+
+// PolicyEngine evaluates permissions for authenticated principals
+type PolicyEngine struct {
+	policy    *Policy
+	relations RelationStore
+}
+
+// NewPolicyEngine creates an engine. relations may be nil, in which case only
+// account roles are considered and team conditions never match.
+func NewPolicyEngine(policy *Policy, relations RelationStore) *PolicyEngine {
+	return &PolicyEngine{
+		policy:    policy,
+		relations: relations,
+	}
+}
+
+// policyEngine is the engine used by RequirePermission and Authorize
+var policyEngine = NewPolicyEngine(DefaultPolicy(), nil)
+
+// SetPolicyEngine configures the engine used by the authorization middleware
+func SetPolicyEngine(engine *PolicyEngine) {
+	policyEngine = engine
+}
+
+// Authorize decides whether the principal holds perm on the target.
+// Denied decisions are logged for audit.
+func (e *PolicyEngine) Authorize(ctx context.Context, principal *Principal, perm Permission, target Target) (*Decision, error) {
+	decision, err := e.evaluate(ctx, principal, perm, target)
+	if err != nil {
+		return nil, err
+	}
+	if !decision.Allowed {
+		logDenied(ctx, principal, decision, target)
+	}
+	return decision, nil
+}
+
+// evaluate runs the policy without logging
+func (e *PolicyEngine) evaluate(ctx context.Context, principal *Principal, perm Permission, target Target) (*Decision, error) {
+	decision := &Decision{Permission: perm}
+
+	// API keys never exceed the scopes they were granted
+	if principal.Method == AuthMethodAPIKey {
+		scope, ok := permissionScopes[perm]
+		if !ok || !principal.HasScope(scope) {
+			decision.Reason = "api key is missing scope"
+			return decision, nil
+		}
+	}
+
+	roles := []string{principal.Role}
+	if e.relations != nil && principal.TenantID != "" {
+		memberRoles, err := e.relations.MemberRoles(ctx, principal.TenantID, principal.UserID)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, memberRoles...)
+	}
+
+	decision.Condition = e.policy.broadest(roles, perm)
+	if decision.Condition == 0 {
+		decision.Reason = "no role grants permission"
+		return decision, nil
+	}
+
+	if target == (Target{}) {
+		decision.Allowed = true
+		return decision, nil
+	}
+
+	allowed, err := e.matches(ctx, principal, decision.Condition, target)
+	if err != nil {
+		return nil, err
+	}
+	decision.Allowed = allowed
+	if !allowed {
+		decision.Reason = "condition " + decision.Condition.String() + " does not cover target"
+	}
+	return decision, nil
+}
+
+// matches checks the target against a condition and every narrower one
+func (e *PolicyEngine) matches(ctx context.Context, principal *Principal, cond Condition, target Target) (bool, error) {
+	if target.OwnerID != "" && target.OwnerID == principal.UserID {
+		return true, nil
+	}
+	if cond == ConditionAny {
+		return true, nil
+	}
+	if target.TenantID != principal.TenantID {
+		return false, nil
+	}
+	if cond == ConditionTenant {
+		return true, nil
+	}
+	if cond == ConditionTeam && e.relations != nil && target.OwnerID != "" {
+		return e.relations.ManagesUser(ctx, principal.TenantID, principal.UserID, target.OwnerID)
+	}
+	return false, nil
+}
+
+// UserTarget returns the target for data owned by a user, looking up the
+// user's tenant
+func UserTarget(ctx context.Context, userID string) (Target, error) {
+	if userStore == nil {
+		return Target{}, errors.New("user store not configured")
+	}
+	user, err := userStore.FindByID(ctx, userID)
+	if err != nil {
+		return Target{}, err
+	}
+	return Target{OwnerID: user.ID, TenantID: user.TenantID}, nil
+}
+
+// Check runs the configured policy engine for callers outside gin handlers,
+// such as the WebSocket handshake
+func Check(ctx context.Context, principal *Principal, perm Permission, target Target) (*Decision, error) {
+	return policyEngine.Authorize(ctx, principal, perm, target)
+}
+
+// logDenied logs a denied decision and records it in the audit log
+func logDenied(ctx context.Context, principal *Principal, decision *Decision, target Target) {
+	log.Printf("policy: denied %s for user=%s role=%s tenant=%s method=%s owner=%s: %s",
+		decision.Permission, principal.UserID, principal.Role, principal.TenantID,
+		principal.Method, target.OwnerID, decision.Reason)
+
+	audit.Record(ctx, audit.Event{
+		Action:   audit.ActionPermissionDenied,
+		Outcome:  audit.OutcomeFailure,
+		ActorID:  principal.UserID,
+		TenantID: principal.TenantID,
+		TargetID: target.OwnerID,
+		Details: map[string]string{
+			"permission": string(decision.Permission),
+			"method":     principal.Method,
+			"reason":     decision.Reason,
+		},
+	})
+}
+
+// RequirePermission rejects callers that do not hold perm under at least the
+// given condition. The decision is stored in the context so handlers can
+// scope their queries to it.
+func RequirePermission(perm Permission, cond Condition) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, ok := GetPrincipal(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+			c.Abort()
+			return
+		}
+
+		decision, err := policyEngine.evaluate(c.Request.Context(), principal, perm, Target{})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check permissions"})
+			c.Abort()
+			return
+		}
+		if decision.Allowed && decision.Condition < cond {
+			decision.Allowed = false
+			decision.Reason = "condition " + decision.Condition.String() + " is narrower than " + cond.String()
+		}
+		if !decision.Allowed {
+			logDenied(c.Request.Context(), principal, decision, Target{})
+			c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+			c.Abort()
+			return
+		}
+
+		c.Set(ContextDecisionKey, decision)
+		c.Next()
+	}
+}
+
+// Authorize checks perm on a specific target from inside a handler and writes
+// a 403 response when denied
+func Authorize(c *gin.Context, perm Permission, target Target) bool {
+	principal, ok := GetPrincipal(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return false
+	}
+
+	decision, err := policyEngine.Authorize(c.Request.Context(), principal, perm, target)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check permissions"})
+		return false
+	}
+	if !decision.Allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return false
+	}
+	return true
+}
+
+// GetDecision returns the decision stored by RequirePermission
+func GetDecision(c *gin.Context) (*Decision, bool) {
+	value, exists := c.Get(ContextDecisionKey)
+	if !exists {
+		return nil, false
+	}
+	decision, ok := value.(*Decision)
+	return decision, ok
+}
@@ -0,0 +1,165 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"Tracker/internal/model"
+)
+
+// fakeRelations is a RelationStore backed by maps. Keys are
+// "tenant/user" for roles and "tenant/manager/user" for managed users.
+type fakeRelations struct {
+	roles   map[string][]string
+	manages map[string]bool
+	err     error
+}
+
+func (r *fakeRelations) MemberRoles(ctx context.Context, tenantID, userID string) ([]string, error) {
+	return r.roles[tenantID+"/"+userID], r.err
+}
+
+func (r *fakeRelations) ManagesUser(ctx context.Context, tenantID, managerID, userID string) (bool, error) {
+	return r.manages[tenantID+"/"+managerID+"/"+userID], r.err
+}
+
+// acme has an owner, a manager of one member, and a member outside that
+// team; dave belongs to another organization
+func acmeRelations() *fakeRelations {
+	return &fakeRelations{
+		roles: map[string][]string{
+			"acme/owner":   {model.MemberRoleOwner},
+			"acme/manager": {model.MemberRoleManager},
+			"acme/carol":   {model.MemberRoleMember},
+			"acme/erin":    {model.MemberRoleMember},
+			"other/dave":   {model.MemberRoleMember},
+		},
+		manages: map[string]bool{"acme/manager/carol": true},
+	}
+}
+
+// sessionUser is a principal logged in with a password
+func sessionUser(userID, tenantID, role string) *Principal {
+	return &Principal{UserID: userID, TenantID: tenantID, Role: role, Method: AuthMethodJWT}
+}
+
+// apiKeyUser is a principal authenticated with an API key
+func apiKeyUser(userID, tenantID string, scopes ...string) *Principal {
+	return &Principal{
+		UserID:   userID,
+		TenantID: tenantID,
+		Role:     RoleUser,
+		Method:   AuthMethodAPIKey,
+		APIKey:   &APIKey{UserID: userID, Scopes: scopes},
+	}
+}
+
+func TestPolicyEngineEvaluate(t *testing.T) {
+	engine := NewPolicyEngine(DefaultPolicy(), acmeRelations())
+
+	carol := sessionUser("carol", "acme", RoleUser)
+	manager := sessionUser("manager", "acme", RoleUser)
+	owner := sessionUser("owner", "acme", RoleUser)
+	admin := sessionUser("admin", "ops", RoleAdmin)
+
+	ofCarol := Target{OwnerID: "carol", TenantID: "acme"}
+	ofErin := Target{OwnerID: "erin", TenantID: "acme"}
+	ofDave := Target{OwnerID: "dave", TenantID: "other"}
+
+	tests := []struct {
+		name      string
+		principal *Principal
+		perm      Permission
+		target    Target
+		allowed   bool
+		condition Condition
+	}{
+		{"own events", carol, PermEventsRead, ofCarol, true, ConditionOwn},
+		{"member reads another member", carol, PermAnalysesRead, ofErin, false, ConditionOwn},
+		{"manager reads own team's analyses", manager, PermAnalysesRead, ofCarol, true, ConditionTeam},
+		{"manager outside the team", manager, PermAnalysesRead, ofErin, false, ConditionTeam},
+		{"manager reads team's raw events", manager, PermEventsRead, ofCarol, false, ConditionOwn},
+		{"owner reads the organization", owner, PermAnalysesRead, ofErin, true, ConditionTenant},
+		{"owner reads another organization", owner, PermAnalysesRead, ofDave, false, ConditionTenant},
+		{"owner writes a member's activities", owner, PermActivitiesWrite, ofErin, false, ConditionOwn},
+		{"admin reads anything", admin, PermEventsRead, ofDave, true, ConditionAny},
+		{"any grant for a zero target", carol, PermAnalysesRead, Target{}, true, ConditionOwn},
+		{"unknown role", sessionUser("x", "acme", "guest"), PermEventsRead, Target{}, false, 0},
+
+		{"api key within scope", apiKeyUser("carol", "acme", ScopeActivitiesRead), PermAnalysesRead, ofCarol, true, ConditionOwn},
+		{"api key missing scope", apiKeyUser("carol", "acme", ScopeActivitiesRead), PermEventsWrite, ofCarol, false, 0},
+		{"api key for a session-only permission", apiKeyUser("carol", "acme", ScopeActivitiesRead), PermUsersRead, ofCarol, false, 0},
+		{"api key of a manager stays in scope", apiKeyUser("manager", "acme", ScopeEventsWrite), PermAnalysesRead, ofCarol, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision, err := engine.evaluate(context.Background(), tt.principal, tt.perm, tt.target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if decision.Allowed != tt.allowed || decision.Condition != tt.condition {
+				t.Errorf("decision = allowed %v under %v, want allowed %v under %v (%s)",
+					decision.Allowed, decision.Condition, tt.allowed, tt.condition, decision.Reason)
+			}
+			if !decision.Allowed && decision.Reason == "" {
+				t.Error("denied without a reason")
+			}
+		})
+	}
+}
+
+func TestPolicyEngineMatches(t *testing.T) {
+	engine := NewPolicyEngine(DefaultPolicy(), acmeRelations())
+	manager := sessionUser("manager", "acme", RoleUser)
+
+	tests := []struct {
+		name   string
+		cond   Condition
+		target Target
+		want   bool
+	}{
+		{"own data under any condition", ConditionOwn, Target{OwnerID: "manager", TenantID: "acme"}, true},
+		{"own condition on others", ConditionOwn, Target{OwnerID: "carol", TenantID: "acme"}, false},
+		{"team member", ConditionTeam, Target{OwnerID: "carol", TenantID: "acme"}, true},
+		{"team without an owner", ConditionTeam, Target{TenantID: "acme"}, false},
+		{"team across tenants", ConditionTeam, Target{OwnerID: "carol", TenantID: "other"}, false},
+		{"tenant", ConditionTenant, Target{OwnerID: "erin", TenantID: "acme"}, true},
+		{"tenant across tenants", ConditionTenant, Target{OwnerID: "dave", TenantID: "other"}, false},
+		{"any across tenants", ConditionAny, Target{OwnerID: "dave", TenantID: "other"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := engine.matches(context.Background(), manager, tt.cond, tt.target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("matches(%v, %+v) = %v, want %v", tt.cond, tt.target, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyEngineWithoutRelations(t *testing.T) {
+	// Without a relation store only account roles count
+	engine := NewPolicyEngine(DefaultPolicy(), nil)
+	decision, err := engine.evaluate(context.Background(), sessionUser("manager", "acme", RoleUser),
+		PermAnalysesRead, Target{OwnerID: "carol", TenantID: "acme"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decision.Allowed || decision.Condition != ConditionOwn {
+		t.Errorf("decision = allowed %v under %v, want denied under own", decision.Allowed, decision.Condition)
+	}
+}
+
+func TestPolicyEngineRelationError(t *testing.T) {
+	relations := acmeRelations()
+	relations.err = errors.New("lookup failed")
+	engine := NewPolicyEngine(DefaultPolicy(), relations)
+
+	if _, err := engine.evaluate(context.Background(), sessionUser("carol", "acme", RoleUser), PermEventsRead, Target{}); !errors.Is(err, relations.err) {
+		t.Errorf("evaluate = %v, want the relation error", err)
+	}
+}
@@ -0,0 +1,319 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"Tracker/internal/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	// ErrUserNotFound is returned when no account matches the lookup
+	ErrUserNotFound = errors.New("user not found")
+	// ErrEmailTaken is returned when registering an email that already has an account
+	ErrEmailTaken = errors.New("email already registered")
+	// ErrIdentityLinked is returned when an OIDC identity belongs to another user
+	ErrIdentityLinked = errors.New("identity already linked to another user")
+)
+
+// UserStore persists user accounts
+type UserStore interface {
+	Create(ctx context.Context, user *User) error
+	FindByEmail(ctx context.Context, email string) (*User, error)
+	FindByID(ctx context.Context, id string) (*User, error)
+	// Delete removes an account
+	Delete(ctx context.Context, id string) error
+
+	// MarkEmailVerified verifies the account's email, provided it is still
+	// the given address
+	MarkEmailVerified(ctx context.Context, id, email string) error
+	// SetPassword replaces the password hash
+	SetPassword(ctx context.Context, id, passwordHash string) error
+	// SetRole changes the user's global role
+	SetRole(ctx context.Context, id, role string) error
+	// FindByIdentity looks up the user linked to an OIDC issuer and subject
+	FindByIdentity(ctx context.Context, issuer, subject string) (*User, error)
+	// LinkIdentity links an OIDC issuer and subject to the user
+	LinkIdentity(ctx context.Context, id, issuer, subject string) error
+
+	// SetPendingTOTPSecret stores a secret awaiting its first valid code
+	SetPendingTOTPSecret(ctx context.Context, id, secret string) error
+	// EnableTOTP activates a secret together with hashed recovery codes
+	EnableTOTP(ctx context.Context, id, secret string, recoveryCodeHashes []string) error
+	// DisableTOTP removes the second factor
+	DisableTOTP(ctx context.Context, id string) error
+	// ConsumeTOTPStep records a used time step. It returns false if the
+	// step, or a later one, was already used.
+	ConsumeTOTPStep(ctx context.Context, id string, step int64) (bool, error)
+	// ConsumeRecoveryCode removes a recovery code. It returns false if the
+	// code was not found.
+	ConsumeRecoveryCode(ctx context.Context, id, codeHash string) (bool, error)
+}
+
+// userStore is the store used by the package-level handlers
+var userStore UserStore
+
+// SetUserStore configures the store used by RegisterHandler and LoginHandler
+func SetUserStore(store UserStore) {
+	userStore = store
+}
+
+// MongoUserStore is a UserStore backed by the users collection
+type MongoUserStore struct {
+	collection *mongo.Collection
+}
+
+// NewMongoUserStore creates a user store on top of the given collection
+func NewMongoUserStore(collection *mongo.Collection) *MongoUserStore {
+	return &MongoUserStore{
+		collection: collection,
+	}
+}
+
+// EnsureIndexes creates the unique email and linked identity indexes
+func (s *MongoUserStore) EnsureIndexes(ctx context.Context) error {
+	_, err := s.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "email", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "identities.issuer", Value: 1}, {Key: "identities.subject", Value: 1}},
+			Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.M{"identities.subject": bson.M{"$exists": true}}),
+		},
+	})
+	return err
+}
+
+// Create inserts a new user and fills in its ID
+func (s *MongoUserStore) Create(ctx context.Context, user *User) error {
+	doc := model.User{
+		Email:    normalizeEmail(user.Email),
+		Password: user.Password,
+		Role:     user.Role,
+		TenantID: user.TenantID,
+		Settings: model.UserSettings{
+			TrackingEnabled: true,
+			Preferences:     make(map[string]string),
+		},
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	if user.EmailVerified {
+		now := time.Now()
+		doc.EmailVerified = true
+		doc.EmailVerifiedAt = &now
+	}
+
+	result, err := s.collection.InsertOne(ctx, doc)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrEmailTaken
+		}
+		return err
+	}
+
+	user.ID = result.InsertedID.(primitive.ObjectID).Hex()
+	user.Email = doc.Email
+	return nil
+}
+
+// FindByEmail looks up a user by email address
+func (s *MongoUserStore) FindByEmail(ctx context.Context, email string) (*User, error) {
+	return s.findOne(ctx, bson.M{"email": normalizeEmail(email)})
+}
+
+// FindByID looks up a user by its hex ObjectID
+func (s *MongoUserStore) FindByID(ctx context.Context, id string) (*User, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+	return s.findOne(ctx, bson.M{"_id": objectID})
+}
+
+// Delete removes a user by its hex ObjectID
+func (s *MongoUserStore) Delete(ctx context.Context, id string) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrUserNotFound
+	}
+
+	result, err := s.collection.DeleteOne(ctx, bson.M{"_id": objectID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// MarkEmailVerified sets the verified flag if the email has not changed
+func (s *MongoUserStore) MarkEmailVerified(ctx context.Context, id, email string) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrUserNotFound
+	}
+
+	now := time.Now()
+	result, err := s.collection.UpdateOne(ctx,
+		bson.M{"_id": objectID, "email": normalizeEmail(email)},
+		bson.M{"$set": bson.M{"emailVerified": true, "emailVerifiedAt": now, "updatedAt": now}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// SetPassword replaces the password hash
+func (s *MongoUserStore) SetPassword(ctx context.Context, id, passwordHash string) error {
+	return s.updateByID(ctx, id, bson.M{"$set": bson.M{"password": passwordHash, "updatedAt": time.Now()}})
+}
+
+// SetRole changes the user's global role
+func (s *MongoUserStore) SetRole(ctx context.Context, id, role string) error {
+	return s.updateByID(ctx, id, bson.M{"$set": bson.M{"role": role, "updatedAt": time.Now()}})
+}
+
+// FindByIdentity looks up the user linked to an OIDC issuer and subject
+func (s *MongoUserStore) FindByIdentity(ctx context.Context, issuer, subject string) (*User, error) {
+	return s.findOne(ctx, bson.M{"identities": bson.M{"$elemMatch": bson.M{"issuer": issuer, "subject": subject}}})
+}
+
+// LinkIdentity adds an OIDC identity to the user
+func (s *MongoUserStore) LinkIdentity(ctx context.Context, id, issuer, subject string) error {
+	err := s.updateByID(ctx, id, bson.M{
+		"$push": bson.M{"identities": model.ExternalIdentity{Issuer: issuer, Subject: subject, LinkedAt: time.Now()}},
+		"$set":  bson.M{"updatedAt": time.Now()},
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrIdentityLinked
+	}
+	return err
+}
+
+// SetPendingTOTPSecret stores a secret awaiting verification
+func (s *MongoUserStore) SetPendingTOTPSecret(ctx context.Context, id, secret string) error {
+	return s.updateByID(ctx, id, bson.M{"$set": bson.M{"mfa.pendingTotpSecret": secret, "updatedAt": time.Now()}})
+}
+
+// EnableTOTP activates TOTP and replaces the recovery codes
+func (s *MongoUserStore) EnableTOTP(ctx context.Context, id, secret string, recoveryCodeHashes []string) error {
+	return s.updateByID(ctx, id, bson.M{
+		"$set": bson.M{
+			"mfa.enabled":       true,
+			"mfa.totpSecret":    secret,
+			"mfa.recoveryCodes": recoveryCodeHashes,
+			"updatedAt":         time.Now(),
+		},
+		"$unset": bson.M{"mfa.pendingTotpSecret": "", "mfa.lastTotpStep": ""},
+	})
+}
+
+// DisableTOTP clears all second-factor state
+func (s *MongoUserStore) DisableTOTP(ctx context.Context, id string) error {
+	return s.updateByID(ctx, id, bson.M{
+		"$set": bson.M{"mfa": model.MFASettings{}, "updatedAt": time.Now()},
+	})
+}
+
+// ConsumeTOTPStep atomically advances the last used step
+func (s *MongoUserStore) ConsumeTOTPStep(ctx context.Context, id string, step int64) (bool, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return false, ErrUserNotFound
+	}
+
+	filter := bson.M{
+		"_id": objectID,
+		"$or": bson.A{
+			bson.M{"mfa.lastTotpStep": bson.M{"$exists": false}},
+			bson.M{"mfa.lastTotpStep": bson.M{"$lt": step}},
+		},
+	}
+	result, err := s.collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"mfa.lastTotpStep": step}})
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
+
+// ConsumeRecoveryCode atomically removes a recovery code hash
+func (s *MongoUserStore) ConsumeRecoveryCode(ctx context.Context, id, codeHash string) (bool, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return false, ErrUserNotFound
+	}
+
+	result, err := s.collection.UpdateOne(ctx,
+		bson.M{"_id": objectID, "mfa.recoveryCodes": codeHash},
+		bson.M{"$pull": bson.M{"mfa.recoveryCodes": codeHash}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
+
+// updateByID applies an update to a single user
+func (s *MongoUserStore) updateByID(ctx context.Context, id string, update bson.M) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrUserNotFound
+	}
+
+	result, err := s.collection.UpdateOne(ctx, bson.M{"_id": objectID}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// findOne decodes a single user document into the auth representation
+func (s *MongoUserStore) findOne(ctx context.Context, filter bson.M) (*User, error) {
+	var doc model.User
+	if err := s.collection.FindOne(ctx, filter).Decode(&doc); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	return userFromModel(&doc), nil
+}
+
+// userFromModel converts a stored user document to an auth User
+func userFromModel(doc *model.User) *User {
+	return &User{
+		ID:       doc.ID.Hex(),
+		Email:    doc.Email,
+		Password: doc.Password,
+		Role:     doc.Role,
+		TenantID: doc.TenantID,
+
+		EmailVerified:     doc.EmailVerified,
+		MFAEnabled:        doc.MFA.Enabled,
+		TOTPSecret:        doc.MFA.TOTPSecret,
+		PendingTOTPSecret: doc.MFA.PendingTOTPSecret,
+	}
+}
+
+// normalizeEmail lower-cases and trims an email so lookups are case-insensitive
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
@@ -0,0 +1,521 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"Tracker/internal/model"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// memoryUserStore is an in-process UserStore for tests
+type memoryUserStore struct {
+	mu            sync.Mutex
+	users         map[string]*User
+	identities    map[string]string
+	lastSteps     map[string]int64
+	recoveryCodes map[string][]string
+}
+
+func newMemoryUserStore() *memoryUserStore {
+	return &memoryUserStore{
+		users:         make(map[string]*User),
+		identities:    make(map[string]string),
+		lastSteps:     make(map[string]int64),
+		recoveryCodes: make(map[string][]string),
+	}
+}
+
+func (s *memoryUserStore) Create(ctx context.Context, user *User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user.Email = normalizeEmail(user.Email)
+	for _, existing := range s.users {
+		if existing.Email == user.Email {
+			return ErrEmailTaken
+		}
+	}
+	if user.ID == "" {
+		user.ID = primitive.NewObjectID().Hex()
+	}
+	stored := *user
+	s.users[user.ID] = &stored
+	return nil
+}
+
+func (s *memoryUserStore) FindByEmail(ctx context.Context, email string) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	email = normalizeEmail(email)
+	for _, user := range s.users {
+		if user.Email == email {
+			found := *user
+			return &found, nil
+		}
+	}
+	return nil, ErrUserNotFound
+}
+
+func (s *memoryUserStore) FindByID(ctx context.Context, id string) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	found := *user
+	return &found, nil
+}
+
+func (s *memoryUserStore) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[id]; !ok {
+		return ErrUserNotFound
+	}
+	delete(s.users, id)
+	return nil
+}
+
+func (s *memoryUserStore) MarkEmailVerified(ctx context.Context, id, email string) error {
+	return s.update(id, func(u *User) { u.EmailVerified = true })
+}
+
+func (s *memoryUserStore) SetPassword(ctx context.Context, id, passwordHash string) error {
+	return s.update(id, func(u *User) { u.Password = passwordHash })
+}
+
+func (s *memoryUserStore) SetRole(ctx context.Context, id, role string) error {
+	return s.update(id, func(u *User) { u.Role = role })
+}
+
+func (s *memoryUserStore) FindByIdentity(ctx context.Context, issuer, subject string) (*User, error) {
+	s.mu.Lock()
+	id, ok := s.identities[issuer+"|"+subject]
+	s.mu.Unlock()
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	return s.FindByID(ctx, id)
+}
+
+func (s *memoryUserStore) LinkIdentity(ctx context.Context, id, issuer, subject string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[id]; !ok {
+		return ErrUserNotFound
+	}
+	key := issuer + "|" + subject
+	if owner, ok := s.identities[key]; ok && owner != id {
+		return ErrIdentityLinked
+	}
+	s.identities[key] = id
+	return nil
+}
+
+func (s *memoryUserStore) SetPendingTOTPSecret(ctx context.Context, id, secret string) error {
+	return s.update(id, func(u *User) { u.PendingTOTPSecret = secret })
+}
+
+func (s *memoryUserStore) EnableTOTP(ctx context.Context, id, secret string, recoveryCodeHashes []string) error {
+	err := s.update(id, func(u *User) {
+		u.MFAEnabled = true
+		u.TOTPSecret = secret
+		u.PendingTOTPSecret = ""
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.recoveryCodes[id] = append([]string(nil), recoveryCodeHashes...)
+	delete(s.lastSteps, id)
+	return nil
+}
+
+func (s *memoryUserStore) DisableTOTP(ctx context.Context, id string) error {
+	err := s.update(id, func(u *User) {
+		u.MFAEnabled = false
+		u.TOTPSecret = ""
+		u.PendingTOTPSecret = ""
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.recoveryCodes, id)
+	delete(s.lastSteps, id)
+	return nil
+}
+
+func (s *memoryUserStore) ConsumeTOTPStep(ctx context.Context, id string, step int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if last, ok := s.lastSteps[id]; ok && last >= step {
+		return false, nil
+	}
+	s.lastSteps[id] = step
+	return true, nil
+}
+
+func (s *memoryUserStore) ConsumeRecoveryCode(ctx context.Context, id, codeHash string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	codes := s.recoveryCodes[id]
+	for i, code := range codes {
+		if code == codeHash {
+			s.recoveryCodes[id] = append(codes[:i:i], codes[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *memoryUserStore) update(id string, fn func(*User)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return ErrUserNotFound
+	}
+	fn(user)
+	return nil
+}
+
+// memoryTokenStore is an in-process TokenStore for tests
+type memoryTokenStore struct {
+	mu      sync.Mutex
+	records map[primitive.ObjectID]*RefreshTokenRecord
+	denied  map[string]time.Time
+}
+
+func newMemoryTokenStore() *memoryTokenStore {
+	return &memoryTokenStore{
+		records: make(map[primitive.ObjectID]*RefreshTokenRecord),
+		denied:  make(map[string]time.Time),
+	}
+}
+
+func (s *memoryTokenStore) SaveRefreshToken(ctx context.Context, record *RefreshTokenRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record.ID = primitive.NewObjectID()
+	stored := *record
+	s.records[record.ID] = &stored
+	return nil
+}
+
+func (s *memoryTokenStore) FindRefreshToken(ctx context.Context, tokenHash string) (*RefreshTokenRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, record := range s.records {
+		if record.TokenHash == tokenHash {
+			found := *record
+			return &found, nil
+		}
+	}
+	return nil, ErrRefreshTokenInvalid
+}
+
+func (s *memoryTokenStore) ConsumeRefreshToken(ctx context.Context, id primitive.ObjectID) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.records[id]
+	if !ok || record.UsedAt != nil {
+		return false, nil
+	}
+	now := time.Now()
+	record.UsedAt = &now
+	return true, nil
+}
+
+func (s *memoryTokenStore) RevokeFamily(ctx context.Context, familyID string) ([]RefreshTokenRecord, error) {
+	return s.revokeMatching(func(r *RefreshTokenRecord) bool { return r.FamilyID == familyID }), nil
+}
+
+func (s *memoryTokenStore) RevokeUser(ctx context.Context, userID string) ([]RefreshTokenRecord, error) {
+	return s.revokeMatching(func(r *RefreshTokenRecord) bool { return r.UserID == userID }), nil
+}
+
+func (s *memoryTokenStore) revokeMatching(match func(*RefreshTokenRecord) bool) []RefreshTokenRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var live []RefreshTokenRecord
+	for _, record := range s.records {
+		if !match(record) {
+			continue
+		}
+		if record.RevokedAt == nil {
+			record.RevokedAt = &now
+		}
+		if record.AccessExpiresAt.After(now) {
+			live = append(live, *record)
+		}
+	}
+	return live
+}
+
+func (s *memoryTokenStore) DenyJTI(ctx context.Context, jti string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.denied[jti] = expiresAt
+	return nil
+}
+
+func (s *memoryTokenStore) IsJTIDenied(ctx context.Context, jti string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.denied[jti]
+	return ok, nil
+}
+
+func (s *memoryTokenStore) ClaimJTI(ctx context.Context, jti string, expiresAt time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.denied[jti]; ok {
+		return false, nil
+	}
+	s.denied[jti] = expiresAt
+	return true, nil
+}
+
+// memoryTenantStore is an in-process TenantStore for tests. membershipErr,
+// when set, fails every AddMembership.
+type memoryTenantStore struct {
+	mu            sync.Mutex
+	organizations map[string]*model.Organization
+	memberships   []model.Membership
+	membershipErr error
+}
+
+func newMemoryTenantStore() *memoryTenantStore {
+	return &memoryTenantStore{organizations: make(map[string]*model.Organization)}
+}
+
+func (s *memoryTenantStore) CreateOrganization(ctx context.Context, name string) (*model.Organization, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	org := &model.Organization{ID: primitive.NewObjectID(), Name: name, JoinCode: primitive.NewObjectID().Hex()}
+	s.organizations[org.ID.Hex()] = org
+	return org, nil
+}
+
+func (s *memoryTenantStore) DeleteOrganization(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.organizations, id)
+	return nil
+}
+
+func (s *memoryTenantStore) FindOrganizationByJoinCode(ctx context.Context, joinCode string) (*model.Organization, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, org := range s.organizations {
+		if org.JoinCode == joinCode {
+			return org, nil
+		}
+	}
+	return nil, ErrInvalidJoinCode
+}
+
+func (s *memoryTenantStore) AddMembership(ctx context.Context, membership *model.Membership) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.membershipErr != nil {
+		return s.membershipErr
+	}
+	membership.ID = primitive.NewObjectID()
+	s.memberships = append(s.memberships, *membership)
+	return nil
+}
+
+// memoryAPIKeyStore is an in-process APIKeyStore for tests. touchErr, when set,
+// fails every TouchLastUsed.
+type memoryAPIKeyStore struct {
+	mu       sync.Mutex
+	keys     map[primitive.ObjectID]*APIKey
+	touchErr error
+}
+
+func newMemoryAPIKeyStore() *memoryAPIKeyStore {
+	return &memoryAPIKeyStore{keys: make(map[primitive.ObjectID]*APIKey)}
+}
+
+func (s *memoryAPIKeyStore) Create(ctx context.Context, key *APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key.ID = primitive.NewObjectID()
+	stored := *key
+	s.keys[key.ID] = &stored
+	return nil
+}
+
+func (s *memoryAPIKeyStore) FindByHash(ctx context.Context, keyHash string) (*APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, key := range s.keys {
+		if key.KeyHash == keyHash && key.RevokedAt == nil {
+			found := *key
+			return &found, nil
+		}
+	}
+	return nil, ErrAPIKeyNotFound
+}
+
+func (s *memoryAPIKeyStore) FindByID(ctx context.Context, id primitive.ObjectID) (*APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.keys[id]
+	if !ok {
+		return nil, ErrAPIKeyNotFound
+	}
+	found := *key
+	return &found, nil
+}
+
+func (s *memoryAPIKeyStore) ListByUser(ctx context.Context, userID string) ([]APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]APIKey, 0)
+	for _, key := range s.keys {
+		if key.UserID == userID {
+			keys = append(keys, *key)
+		}
+	}
+	return keys, nil
+}
+
+func (s *memoryAPIKeyStore) Rename(ctx context.Context, userID string, id primitive.ObjectID, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.keys[id]
+	if !ok || key.UserID != userID {
+		return ErrAPIKeyNotFound
+	}
+	key.Name = name
+	return nil
+}
+
+func (s *memoryAPIKeyStore) Revoke(ctx context.Context, userID string, id primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.keys[id]
+	if !ok || key.UserID != userID || key.RevokedAt != nil {
+		return ErrAPIKeyNotFound
+	}
+	now := time.Now()
+	key.RevokedAt = &now
+	return nil
+}
+
+func (s *memoryAPIKeyStore) TouchLastUsed(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.touchErr != nil {
+		return s.touchErr
+	}
+	if key, ok := s.keys[id]; ok {
+		key.LastUsedAt = &at
+	}
+	return nil
+}
+
+// useMemoryAPIKeys installs a fresh memory API key store for the duration
+// of a test
+func useMemoryAPIKeys(t *testing.T) *memoryAPIKeyStore {
+	keys := newMemoryAPIKeyStore()
+	prev := apiKeyStore
+	SetAPIKeyStore(keys)
+	t.Cleanup(func() { SetAPIKeyStore(prev) })
+	return keys
+}
+
+// useFastHashing hashes new passwords at the lowest bcrypt cost for the
+// duration of a test
+func useFastHashing(t *testing.T) {
+	hashCost = bcrypt.MinCost
+	t.Cleanup(func() { hashCost = passwordHashCost })
+}
+
+// useMemoryStores installs fresh memory stores for the duration of a test
+func useMemoryStores(t *testing.T) (*memoryUserStore, *memoryTokenStore) {
+	users, tokens := newMemoryUserStore(), newMemoryTokenStore()
+
+	prevUsers, prevTokens, prevTenants := userStore, tokenStore, tenantStore
+	SetUserStore(users)
+	SetTokenStore(tokens)
+	SetTenantStore(newMemoryTenantStore())
+
+	// Each test starts with clean lockout counters
+	account, ip := DefaultLockoutPolicies()
+	SetLoginLimiter(NewLoginLimiter(NewMemoryAttemptStore(), account, ip))
+
+	t.Cleanup(func() {
+		userStore, tokenStore, tenantStore = prevUsers, prevTokens, prevTenants
+	})
+	return users, tokens
+}
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// serveJSON runs a handler against a JSON request and returns the recorder
+func serveJSON(handler gin.HandlerFunc, method, path string, body interface{}, setup ...gin.HandlerFunc) *httptest.ResponseRecorder {
+	router := gin.New()
+	router.Handle(method, path, append(setup, handler)...)
+
+	payload, _ := json.Marshal(body)
+	req := httptest.NewRequest(method, path, bytes.NewReader(payload))
+	req.Header.Set("Content-Type", "application/json")
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+// serveAuthed runs a handler behind AuthMiddleware with a bearer credential
+func serveAuthed(handler gin.HandlerFunc, method, path, credential string, body interface{}) *httptest.ResponseRecorder {
+	router := gin.New()
+	router.Handle(method, path, AuthMiddleware(), handler)
+
+	payload, _ := json.Marshal(body)
+	req := httptest.NewRequest(method, path, bytes.NewReader(payload))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+credential)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
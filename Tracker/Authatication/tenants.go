@@ -0,0 +1,211 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"Tracker/internal/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	// ErrInvalidJoinCode is returned when registering with an unknown join code
+	ErrInvalidJoinCode = errors.New("invalid join code")
+)
+
+// TenantStore provisions organizations and memberships for new accounts
+type TenantStore interface {
+	CreateOrganization(ctx context.Context, name string) (*model.Organization, error)
+	DeleteOrganization(ctx context.Context, id string) error
+	FindOrganizationByJoinCode(ctx context.Context, joinCode string) (*model.Organization, error)
+	AddMembership(ctx context.Context, membership *model.Membership) error
+}
+
+// tenantStore is the store used by RegisterHandler to place new users
+var tenantStore TenantStore
+
+// SetTenantStore configures the store used to provision tenants
+func SetTenantStore(store TenantStore) {
+	tenantStore = store
+}
+
+// MongoTenantStore is a TenantStore backed by the organizations and
+// memberships collections
+type MongoTenantStore struct {
+	organizations *mongo.Collection
+	memberships   *mongo.Collection
+}
+
+// NewMongoTenantStore creates a tenant store on top of the given collections
+func NewMongoTenantStore(organizations, memberships *mongo.Collection) *MongoTenantStore {
+	return &MongoTenantStore{
+		organizations: organizations,
+		memberships:   memberships,
+	}
+}
+
+// EnsureIndexes creates the join code index and the unique membership index
+func (s *MongoTenantStore) EnsureIndexes(ctx context.Context) error {
+	_, err := s.organizations.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "joinCode", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.memberships.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "tenantId", Value: 1}, {Key: "teamId", Value: 1}, {Key: "userId", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "userId", Value: 1}}},
+	})
+	return err
+}
+
+// CreateOrganization inserts a new organization with a fresh join code
+func (s *MongoTenantStore) CreateOrganization(ctx context.Context, name string) (*model.Organization, error) {
+	joinCode, err := randomToken(9)
+	if err != nil {
+		return nil, err
+	}
+
+	org := &model.Organization{
+		Name:      name,
+		JoinCode:  joinCode,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	result, err := s.organizations.InsertOne(ctx, org)
+	if err != nil {
+		return nil, err
+	}
+	org.ID = result.InsertedID.(primitive.ObjectID)
+	return org, nil
+}
+
+// DeleteOrganization removes an organization, used to roll back a failed registration
+func (s *MongoTenantStore) DeleteOrganization(ctx context.Context, id string) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	_, err = s.organizations.DeleteOne(ctx, bson.M{"_id": objectID})
+	return err
+}
+
+// FindOrganizationByJoinCode looks up the organization a join code belongs to
+func (s *MongoTenantStore) FindOrganizationByJoinCode(ctx context.Context, joinCode string) (*model.Organization, error) {
+	var org model.Organization
+	if err := s.organizations.FindOne(ctx, bson.M{"joinCode": joinCode}).Decode(&org); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrInvalidJoinCode
+		}
+		return nil, err
+	}
+	return &org, nil
+}
+
+// AddMembership inserts a membership
+func (s *MongoTenantStore) AddMembership(ctx context.Context, membership *model.Membership) error {
+	membership.CreatedAt = time.Now()
+	result, err := s.memberships.InsertOne(ctx, membership)
+	if err != nil {
+		return err
+	}
+	membership.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// MemberRoles returns the user's organization role followed by their team roles
+func (s *MongoTenantStore) MemberRoles(ctx context.Context, tenantID, userID string) ([]string, error) {
+	cursor, err := s.memberships.Find(ctx, bson.M{"tenantId": tenantID, "userId": userID})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var memberships []model.Membership
+	if err := cursor.All(ctx, &memberships); err != nil {
+		return nil, err
+	}
+
+	roles := make([]string, 0, len(memberships))
+	for _, m := range memberships {
+		roles = append(roles, m.Role)
+	}
+	return roles, nil
+}
+
+// ManagesUser reports whether managerID is a manager of a team userID belongs to
+func (s *MongoTenantStore) ManagesUser(ctx context.Context, tenantID, managerID, userID string) (bool, error) {
+	teamIDs, err := s.memberships.Distinct(ctx, "teamId", bson.M{
+		"tenantId": tenantID,
+		"userId":   managerID,
+		"role":     model.MemberRoleManager,
+		"teamId":   bson.M{"$exists": true},
+	})
+	if err != nil || len(teamIDs) == 0 {
+		return false, err
+	}
+
+	count, err := s.memberships.CountDocuments(ctx, bson.M{
+		"tenantId": tenantID,
+		"userId":   userID,
+		"teamId":   bson.M{"$in": teamIDs},
+	}, options.Count().SetLimit(1))
+	return count > 0, err
+}
+
+// provisionTenant resolves the organization for a new account: the one
+// behind joinCode, or a new organization the user will own
+func provisionTenant(ctx context.Context, joinCode, orgName string) (org *model.Organization, role string, created bool, err error) {
+	if joinCode != "" {
+		org, err = tenantStore.FindOrganizationByJoinCode(ctx, joinCode)
+		return org, model.MemberRoleMember, false, err
+	}
+
+	org, err = tenantStore.CreateOrganization(ctx, orgName)
+	return org, model.MemberRoleOwner, true, err
+}
+
+// createAccount provisions the user's organization, persists the user and
+// records their membership. If a step fails, the user and a newly created
+// organization are removed again.
+func createAccount(ctx context.Context, user *User, joinCode, orgName string) error {
+	org, memberRole, createdOrg, err := provisionTenant(ctx, joinCode, orgName)
+	if err != nil {
+		return err
+	}
+	user.TenantID = org.ID.Hex()
+
+	rollback := func() {
+		if createdOrg {
+			_ = tenantStore.DeleteOrganization(ctx, user.TenantID)
+		}
+	}
+
+	if err := userStore.Create(ctx, user); err != nil {
+		rollback()
+		return err
+	}
+
+	err = tenantStore.AddMembership(ctx, &model.Membership{
+		TenantID: user.TenantID,
+		UserID:   user.ID,
+		Role:     memberRole,
+	})
+	if err != nil {
+		// An account without a membership could never use its organization
+		_ = userStore.Delete(ctx, user.ID)
+		rollback()
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"Tracker/internal/model"
+)
+
+func TestCreateAccountRollsBackWithoutMembership(t *testing.T) {
+	errMembership := errors.New("memberships unavailable")
+
+	tests := []struct {
+		name     string
+		joinCode string
+		orgName  string
+	}{
+		{"new organization", "", "Acme"},
+		{"joining an organization", "existing", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users, _ := useMemoryStores(t)
+			tenants := tenantStore.(*memoryTenantStore)
+
+			// Two organizations exist already; the second can be joined
+			tenants.organizations["other"] = &model.Organization{Name: "Other"}
+			tenants.organizations["joined"] = &model.Organization{Name: "Joined", JoinCode: "existing"}
+			tenants.membershipErr = errMembership
+
+			user := &User{Email: "alice@example.com", Role: RoleUser}
+			err := createAccount(context.Background(), user, tt.joinCode, tt.orgName)
+			if !errors.Is(err, errMembership) {
+				t.Fatalf("createAccount = %v, want %v", err, errMembership)
+			}
+
+			if _, err := users.FindByEmail(context.Background(), "alice@example.com"); !errors.Is(err, ErrUserNotFound) {
+				t.Errorf("user left behind: %v", err)
+			}
+			// Only an organization created for the account is removed
+			if len(tenants.organizations) != 2 || tenants.organizations["joined"] == nil {
+				t.Errorf("organizations left: %v", tenants.organizations)
+			}
+		})
+	}
+}
@@ -0,0 +1,304 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"Tracker/internal/config"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	// ErrRefreshTokenInvalid is returned for unknown, expired or revoked refresh tokens
+	ErrRefreshTokenInvalid = errors.New("invalid refresh token")
+	// ErrRefreshTokenReused is returned when an already rotated refresh token is presented again
+	ErrRefreshTokenReused = errors.New("refresh token reuse detected")
+	// ErrTokenStoreNotConfigured is returned when no TokenStore has been set
+	ErrTokenStoreNotConfigured = errors.New("token store not configured")
+)
+
+// RefreshTokenRecord is the server-side state of an opaque refresh token.
+// Only the SHA-256 hash of the token is stored.
+type RefreshTokenRecord struct {
+	ID              primitive.ObjectID `bson:"_id,omitempty"`
+	TokenHash       string             `bson:"tokenHash"`
+	UserID          string             `bson:"userId"`
+	FamilyID        string             `bson:"familyId"`
+	AccessJTI       string             `bson:"accessJti"`
+	AccessExpiresAt time.Time          `bson:"accessExpiresAt"`
+	MFA             bool               `bson:"mfa"`
+	CreatedAt       time.Time          `bson:"createdAt"`
+	ExpiresAt       time.Time          `bson:"expiresAt"`
+	UsedAt          *time.Time         `bson:"usedAt,omitempty"`
+	RevokedAt       *time.Time         `bson:"revokedAt,omitempty"`
+}
+
+// TokenPair is an access token together with its refresh token
+type TokenPair struct {
+	AccessToken  string
+	RefreshToken string
+	ExpiresAt    time.Time
+	UserID       string
+}
+
+// TokenStore persists refresh tokens and the access token jti denylist
+type TokenStore interface {
+	SaveRefreshToken(ctx context.Context, record *RefreshTokenRecord) error
+	FindRefreshToken(ctx context.Context, tokenHash string) (*RefreshTokenRecord, error)
+	// ConsumeRefreshToken marks an unused token as used. It returns false if
+	// the token had already been used.
+	ConsumeRefreshToken(ctx context.Context, id primitive.ObjectID) (bool, error)
+	// RevokeFamily revokes every token in a family and returns the records
+	// whose access tokens may still be live.
+	RevokeFamily(ctx context.Context, familyID string) ([]RefreshTokenRecord, error)
+	// RevokeUser revokes every token of a user and returns the records
+	// whose access tokens may still be live.
+	RevokeUser(ctx context.Context, userID string) ([]RefreshTokenRecord, error)
+	DenyJTI(ctx context.Context, jti string, expiresAt time.Time) error
+	IsJTIDenied(ctx context.Context, jti string) (bool, error)
+	// ClaimJTI atomically adds a jti to the denylist. It returns false if
+	// the jti was already there, making single-use tokens race-free.
+	ClaimJTI(ctx context.Context, jti string, expiresAt time.Time) (bool, error)
+}
+
+// tokenStore is the store used by the package-level handlers and middleware
+var tokenStore TokenStore
+
+// SetTokenStore configures the store used for refresh tokens and revocation
+func SetTokenStore(store TokenStore) {
+	tokenStore = store
+}
+
+// MongoTokenStore is a TokenStore backed by the refresh_tokens and
+// revoked_tokens collections
+type MongoTokenStore struct {
+	refreshTokens *mongo.Collection
+	revokedTokens *mongo.Collection
+}
+
+// NewMongoTokenStore creates a token store on top of the given collections
+func NewMongoTokenStore(refreshTokens, revokedTokens *mongo.Collection) *MongoTokenStore {
+	return &MongoTokenStore{
+		refreshTokens: refreshTokens,
+		revokedTokens: revokedTokens,
+	}
+}
+
+// EnsureIndexes creates lookup indexes and TTL indexes that purge expired entries
+func (s *MongoTokenStore) EnsureIndexes(ctx context.Context) error {
+	_, err := s.refreshTokens.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "tokenHash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "familyId", Value: 1}}},
+		{Keys: bson.D{{Key: "userId", Value: 1}}},
+		{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.revokedTokens.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+// SaveRefreshToken inserts a new refresh token record
+func (s *MongoTokenStore) SaveRefreshToken(ctx context.Context, record *RefreshTokenRecord) error {
+	result, err := s.refreshTokens.InsertOne(ctx, record)
+	if err != nil {
+		return err
+	}
+	record.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// FindRefreshToken looks up a refresh token by its hash
+func (s *MongoTokenStore) FindRefreshToken(ctx context.Context, tokenHash string) (*RefreshTokenRecord, error) {
+	var record RefreshTokenRecord
+	err := s.refreshTokens.FindOne(ctx, bson.M{"tokenHash": tokenHash}).Decode(&record)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrRefreshTokenInvalid
+		}
+		return nil, err
+	}
+	return &record, nil
+}
+
+// ConsumeRefreshToken atomically marks a token as used
+func (s *MongoTokenStore) ConsumeRefreshToken(ctx context.Context, id primitive.ObjectID) (bool, error) {
+	result, err := s.refreshTokens.UpdateOne(ctx,
+		bson.M{"_id": id, "usedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"usedAt": time.Now()}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
+
+// RevokeFamily revokes all tokens descending from the same login
+func (s *MongoTokenStore) RevokeFamily(ctx context.Context, familyID string) ([]RefreshTokenRecord, error) {
+	return s.revokeMatching(ctx, bson.M{"familyId": familyID})
+}
+
+// RevokeUser revokes all tokens belonging to a user
+func (s *MongoTokenStore) RevokeUser(ctx context.Context, userID string) ([]RefreshTokenRecord, error) {
+	return s.revokeMatching(ctx, bson.M{"userId": userID})
+}
+
+// revokeMatching sets revokedAt on matching tokens and returns the ones
+// whose access tokens have not expired yet
+func (s *MongoTokenStore) revokeMatching(ctx context.Context, filter bson.M) ([]RefreshTokenRecord, error) {
+	now := time.Now()
+
+	revokeFilter := bson.M{"revokedAt": bson.M{"$exists": false}}
+	for k, v := range filter {
+		revokeFilter[k] = v
+	}
+	if _, err := s.refreshTokens.UpdateMany(ctx, revokeFilter, bson.M{"$set": bson.M{"revokedAt": now}}); err != nil {
+		return nil, err
+	}
+
+	liveFilter := bson.M{"accessExpiresAt": bson.M{"$gt": now}}
+	for k, v := range filter {
+		liveFilter[k] = v
+	}
+	cursor, err := s.refreshTokens.Find(ctx, liveFilter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var records []RefreshTokenRecord
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// DenyJTI adds an access token ID to the denylist until it expires
+func (s *MongoTokenStore) DenyJTI(ctx context.Context, jti string, expiresAt time.Time) error {
+	_, err := s.revokedTokens.UpdateOne(ctx,
+		bson.M{"_id": jti},
+		bson.M{"$set": bson.M{"expiresAt": expiresAt}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// IsJTIDenied reports whether an access token ID has been revoked
+func (s *MongoTokenStore) IsJTIDenied(ctx context.Context, jti string) (bool, error) {
+	count, err := s.revokedTokens.CountDocuments(ctx, bson.M{"_id": jti}, options.Count().SetLimit(1))
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// ClaimJTI inserts a jti into the denylist unless it is already present
+func (s *MongoTokenStore) ClaimJTI(ctx context.Context, jti string, expiresAt time.Time) (bool, error) {
+	_, err := s.revokedTokens.InsertOne(ctx, bson.M{"_id": jti, "expiresAt": expiresAt})
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// issueTokenPair signs a new access token and stores a new refresh token in
+// the given family. An empty familyID starts a new family (a new login).
+// mfa records whether the login was completed with a second factor.
+func issueTokenPair(ctx context.Context, user *User, familyID string, mfa bool) (*TokenPair, error) {
+	if tokenStore == nil {
+		return nil, ErrTokenStoreNotConfigured
+	}
+
+	accessToken, claims, err := generateAccessToken(user, mfa)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	if familyID == "" {
+		familyID = primitive.NewObjectID().Hex()
+	}
+
+	now := time.Now()
+	record := &RefreshTokenRecord{
+		TokenHash:       hashToken(refreshToken),
+		UserID:          user.ID,
+		FamilyID:        familyID,
+		AccessJTI:       claims.Id,
+		AccessExpiresAt: time.Unix(claims.ExpiresAt, 0),
+		MFA:             mfa,
+		CreatedAt:       now,
+		ExpiresAt:       now.Add(config.GetRefreshTokenTTL()),
+	}
+	if err := tokenStore.SaveRefreshToken(ctx, record); err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresAt:    record.AccessExpiresAt,
+		UserID:       user.ID,
+	}, nil
+}
+
+// revokeRecords denies the access tokens that belong to revoked refresh tokens
+func revokeRecords(ctx context.Context, records []RefreshTokenRecord) error {
+	for _, record := range records {
+		if record.AccessJTI == "" {
+			continue
+		}
+		if err := tokenStore.DenyJTI(ctx, record.AccessJTI, record.AccessExpiresAt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isTokenRevoked reports whether an access token may no longer be used.
+// Tokens without a jti predate revocation support and are rejected.
+func isTokenRevoked(ctx context.Context, claims *Claims) (bool, error) {
+	if claims.Id == "" {
+		return true, nil
+	}
+	if tokenStore == nil {
+		return false, nil
+	}
+	return tokenStore.IsJTIDenied(ctx, claims.Id)
+}
+
+// randomToken returns n random bytes encoded as URL-safe base64
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken returns the hex SHA-256 of an opaque token
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,166 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// loggedInUser creates a user and returns a token pair for them
+func loggedInUser(t *testing.T, users *memoryUserStore) (*User, *TokenPair) {
+	t.Helper()
+
+	user := &User{Email: "ws@example.com", Role: RoleUser, TenantID: "tenant-1"}
+	if err := users.Create(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := issueTokenPair(context.Background(), user, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user, tokens
+}
+
+// isDenied reports whether an access token's jti is on the denylist
+func isDenied(t *testing.T, tokens *memoryTokenStore, accessToken string) bool {
+	t.Helper()
+
+	denied, err := tokens.IsJTIDenied(context.Background(), accessClaims(t, accessToken).Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return denied
+}
+
+func TestRefreshTokenRotationAndReuse(t *testing.T) {
+	users, tokens := useMemoryStores(t)
+	user, first := loggedInUser(t, users)
+	ctx := context.Background()
+
+	// A second login is a separate family
+	other, err := issueTokenPair(ctx, user, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := RefreshToken(ctx, first.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.RefreshToken == first.RefreshToken || second.AccessToken == first.AccessToken {
+		t.Fatal("refresh did not rotate the tokens")
+	}
+
+	// Presenting the rotated token again revokes the whole family
+	if _, err := RefreshToken(ctx, first.RefreshToken); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("reuse = %v, want ErrRefreshTokenReused", err)
+	}
+	if _, err := RefreshToken(ctx, second.RefreshToken); !errors.Is(err, ErrRefreshTokenInvalid) {
+		t.Errorf("refresh with the family's latest token = %v, want ErrRefreshTokenInvalid", err)
+	}
+	for name, pair := range map[string]*TokenPair{"first": first, "second": second} {
+		if !isDenied(t, tokens, pair.AccessToken) {
+			t.Errorf("%s access token of the revoked family is still accepted", name)
+		}
+	}
+
+	// The other login is untouched
+	if isDenied(t, tokens, other.AccessToken) {
+		t.Error("access token of another family was revoked")
+	}
+	if _, err := RefreshToken(ctx, other.RefreshToken); err != nil {
+		t.Errorf("refresh of another family = %v", err)
+	}
+}
+
+func TestRefreshTokenRejected(t *testing.T) {
+	users, tokens := useMemoryStores(t)
+	user, _ := loggedInUser(t, users)
+	ctx := context.Background()
+	past := time.Now().Add(-time.Minute)
+
+	tests := []struct {
+		name string
+		// edit changes the stored record of a fresh login
+		edit func(record *RefreshTokenRecord)
+		want error
+	}{
+		{"expired", func(r *RefreshTokenRecord) { r.ExpiresAt = past }, ErrRefreshTokenInvalid},
+		{"revoked", func(r *RefreshTokenRecord) { r.RevokedAt = &past }, ErrRefreshTokenInvalid},
+		{"used", func(r *RefreshTokenRecord) { r.UsedAt = &past }, ErrRefreshTokenReused},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pair, err := issueTokenPair(ctx, user, "", false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			record, err := tokens.FindRefreshToken(ctx, hashToken(pair.RefreshToken))
+			if err != nil {
+				t.Fatal(err)
+			}
+			tokens.mu.Lock()
+			tt.edit(tokens.records[record.ID])
+			tokens.mu.Unlock()
+
+			if _, err := RefreshToken(ctx, pair.RefreshToken); !errors.Is(err, tt.want) {
+				t.Errorf("RefreshToken = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	if _, err := RefreshToken(ctx, "not-a-token"); !errors.Is(err, ErrRefreshTokenInvalid) {
+		t.Errorf("unknown token = %v, want ErrRefreshTokenInvalid", err)
+	}
+}
+
+func TestRefreshTokenKeepsMFA(t *testing.T) {
+	users, _ := useMemoryStores(t)
+	user, _ := loggedInUser(t, users)
+
+	pair, err := issueTokenPair(context.Background(), user, "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	refreshed, err := RefreshToken(context.Background(), pair.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !accessClaims(t, refreshed.AccessToken).MFA {
+		t.Error("refreshed access token lost the MFA claim")
+	}
+}
+
+func TestConcurrentRefreshSucceedsOnce(t *testing.T) {
+	users, _ := useMemoryStores(t)
+	_, pair := loggedInUser(t, users)
+
+	const attempts = 8
+	var wg sync.WaitGroup
+	results := make(chan error, attempts)
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := RefreshToken(context.Background(), pair.RefreshToken)
+			results <- err
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	succeeded := 0
+	for err := range results {
+		switch {
+		case err == nil:
+			succeeded++
+		case !errors.Is(err, ErrRefreshTokenReused) && !errors.Is(err, ErrRefreshTokenInvalid):
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("%d refreshes succeeded, want 1", succeeded)
+	}
+}
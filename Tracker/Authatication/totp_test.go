@@ -0,0 +1,162 @@
+package auth
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecretSHA1 is the RFC 4226 / RFC 6238 SHA1 test seed
+var rfcSecretSHA1 = []byte("12345678901234567890")
+
+func TestHOTPRFC4226Vectors(t *testing.T) {
+	// RFC 4226 Appendix D
+	want := []string{
+		"755224", "287082", "359152", "969429", "338314",
+		"254676", "287922", "162583", "399871", "520489",
+	}
+	for counter, code := range want {
+		if got := HOTP(rfcSecretSHA1, uint64(counter), 6, TOTPAlgorithmSHA1); got != code {
+			t.Errorf("HOTP(counter=%d) = %s, want %s", counter, got, code)
+		}
+	}
+}
+
+func TestTOTPRFC6238Vectors(t *testing.T) {
+	// RFC 6238 Appendix B, SHA1 mode with 8 digits
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+
+	opts := TOTPOptions{Digits: 8, Period: 30 * time.Second, Algorithm: TOTPAlgorithmSHA1}
+	for _, tt := range tests {
+		if got := TOTP(rfcSecretSHA1, time.Unix(tt.unix, 0), opts); got != tt.code {
+			t.Errorf("TOTP(%d) = %s, want %s", tt.unix, got, tt.code)
+		}
+	}
+}
+
+func TestValidateTOTPSkewWindow(t *testing.T) {
+	opts := TOTPOptions{Digits: 8, Period: 30 * time.Second, Algorithm: TOTPAlgorithmSHA1}
+	now := time.Unix(1111111111, 0)
+	step := TOTPStep(now, opts.Period)
+
+	tests := []struct {
+		name   string
+		offset int64
+		ok     bool
+	}{
+		{"two steps behind", -2, false},
+		{"one step behind", -1, true},
+		{"current step", 0, true},
+		{"one step ahead", 1, true},
+		{"two steps ahead", 2, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code := HOTP(rfcSecretSHA1, uint64(step+tt.offset), opts.Digits, opts.Algorithm)
+			matched, ok := ValidateTOTP(rfcSecretSHA1, code, now, opts)
+			if ok != tt.ok {
+				t.Fatalf("ValidateTOTP ok = %v, want %v", ok, tt.ok)
+			}
+			if ok && matched != step+tt.offset {
+				t.Errorf("matched step = %d, want %d", matched, step+tt.offset)
+			}
+		})
+	}
+}
+
+func TestValidateTOTPRejectsMalformedCodes(t *testing.T) {
+	now := time.Unix(59, 0)
+	for _, code := range []string{"", "12345", "1234567", "abcdef"} {
+		if _, ok := ValidateTOTP(rfcSecretSHA1, code, now, DefaultTOTPOptions); ok {
+			t.Errorf("ValidateTOTP(%q) accepted a malformed code", code)
+		}
+	}
+}
+
+func TestTOTPSecretRoundTrip(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Users type secrets loosely: lower case, grouped with spaces
+	loose := strings.ToLower(secret[:4] + " " + secret[4:])
+	decoded, err := DecodeTOTPSecret(loose)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != 20 {
+		t.Errorf("decoded secret is %d bytes, want 20", len(decoded))
+	}
+}
+
+func TestVerifyTOTPCodeRejectsReplay(t *testing.T) {
+	users, _ := useMemoryStores(t)
+
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &User{Email: "totp@example.com"}
+	if err := users.Create(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.EnableTOTP(context.Background(), user.ID, secret, nil); err != nil {
+		t.Fatal(err)
+	}
+	user, _ = users.FindByID(context.Background(), user.ID)
+
+	raw, _ := DecodeTOTPSecret(secret)
+	code := TOTP(raw, time.Now(), DefaultTOTPOptions)
+
+	if ok, err := verifyTOTPCode(context.Background(), user, code); err != nil || !ok {
+		t.Fatalf("first use = %v, %v; want accepted", ok, err)
+	}
+	if ok, err := verifyTOTPCode(context.Background(), user, code); err != nil || ok {
+		t.Fatalf("replay = %v, %v; want rejected", ok, err)
+	}
+}
+
+func TestRecoveryCodesAreSingleUse(t *testing.T) {
+	users, _ := useMemoryStores(t)
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != recoveryCodeCount || len(hashes) != recoveryCodeCount {
+		t.Fatalf("got %d codes and %d hashes, want %d", len(codes), len(hashes), recoveryCodeCount)
+	}
+
+	user := &User{Email: "recovery@example.com"}
+	if err := users.Create(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.EnableTOTP(context.Background(), user.ID, "JBSWY3DPEHPK3PXP", hashes); err != nil {
+		t.Fatal(err)
+	}
+
+	// Codes are accepted regardless of case and separators
+	typed := strings.ToUpper(strings.ReplaceAll(codes[0], "-", " "))
+	if ok, err := verifySecondFactor(context.Background(), user, "", typed); err != nil || !ok {
+		t.Fatalf("first use = %v, %v; want accepted", ok, err)
+	}
+	if ok, err := verifySecondFactor(context.Background(), user, "", codes[0]); err != nil || ok {
+		t.Fatalf("second use = %v, %v; want rejected", ok, err)
+	}
+	if ok, err := verifySecondFactor(context.Background(), user, "", codes[1]); err != nil || !ok {
+		t.Fatalf("other code = %v, %v; want accepted", ok, err)
+	}
+}
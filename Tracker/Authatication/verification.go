@@ -0,0 +1,317 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"Tracker/internal/audit"
+	"Tracker/internal/config"
+	"Tracker/internal/mail"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+)
+
+// ErrActionTokenInvalid is returned for bad, expired or already used
+// verification and reset tokens
+var ErrActionTokenInvalid = errors.New("invalid or expired token")
+
+// resetMailTimeout bounds a password reset email sent after the response
+const resetMailTimeout = 30 * time.Second
+
+// mailer sends verification and password reset emails
+var mailer mail.Mailer = mail.NewLogMailer(config.GetMailFrom())
+
+// SetMailer configures the mailer used by the account emails
+func SetMailer(m mail.Mailer) {
+	mailer = m
+}
+
+// VerifyEmailRequest represents the email verification request body
+type VerifyEmailRequest struct {
+	Token string `json:"token" form:"token" binding:"required"`
+}
+
+// PasswordResetRequest represents the request to send a reset link
+type PasswordResetRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// ResetPasswordRequest represents the request to set a new password
+type ResetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required,min=6"`
+}
+
+// generateActionToken signs a single-use token for a one-off account action
+func generateActionToken(user *User, use string, ttl time.Duration) (string, error) {
+	jti, err := randomToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	claims := &Claims{
+		UserID:   user.ID,
+		Email:    user.Email,
+		TokenUse: use,
+		StandardClaims: jwt.StandardClaims{
+			Id:        jti,
+			Issuer:    config.GetJWTIssuer(),
+			Subject:   user.ID,
+			ExpiresAt: time.Now().Add(ttl).Unix(),
+			IssuedAt:  time.Now().Unix(),
+		},
+	}
+	return getKeySet().sign(claims)
+}
+
+// consumeActionToken validates a token for the given use and marks it used
+func consumeActionToken(ctx context.Context, token, use string) (*Claims, error) {
+	claims, err := validateTokenUse(token, use)
+	if err != nil {
+		return nil, ErrActionTokenInvalid
+	}
+
+	claimed, err := tokenStore.ClaimJTI(ctx, claims.Id, time.Unix(claims.ExpiresAt, 0))
+	if err != nil {
+		return nil, err
+	}
+	if !claimed {
+		return nil, ErrActionTokenInvalid
+	}
+	return claims, nil
+}
+
+// actionLink builds an emailed link carrying a token
+func actionLink(path, token string) string {
+	return config.GetAppBaseURL() + path + "?token=" + url.QueryEscape(token)
+}
+
+// sendVerificationEmail mails a verification link to the user
+func sendVerificationEmail(ctx context.Context, user *User) error {
+	ttl := config.GetEmailVerifyTTL()
+	token, err := generateActionToken(user, TokenUseEmailVerify, ttl)
+	if err != nil {
+		return err
+	}
+
+	return mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Confirm your email address by opening this link:\n\n%s\n\nThe link expires in %s.\n",
+			actionLink("/auth/verify", token), ttl),
+	})
+}
+
+// sendPasswordResetEmail mails a password reset link to the user
+func sendPasswordResetEmail(ctx context.Context, user *User) error {
+	ttl := config.GetPasswordResetTTL()
+	token, err := generateActionToken(user, TokenUsePasswordReset, ttl)
+	if err != nil {
+		return err
+	}
+
+	return mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Someone asked to reset the password for this account. If it was you, open this link:\n\n%s\n\n"+
+			"The link expires in %s and can only be used once. If you did not ask for a reset, ignore this email.\n",
+			actionLink("/auth/reset", token), ttl),
+	})
+}
+
+// EmailVerified reports whether a user has verified their email address
+func EmailVerified(ctx context.Context, userID string) (bool, error) {
+	if userStore == nil {
+		return false, errors.New("user store not configured")
+	}
+	user, err := userStore.FindByID(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	return user.EmailVerified, nil
+}
+
+// RequireVerifiedEmail rejects users who have not verified their email
+// address, for endpoints that accept tracking data
+func RequireVerifiedEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := GetUserID(c)
+		verified, err := EmailVerified(c.Request.Context(), userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up user"})
+			c.Abort()
+			return
+		}
+		if !verified {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Email address is not verified"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// VerifyEmailHandler marks an email address as verified. It accepts the
+// token as a query parameter, for links opened from the email, or as JSON.
+func VerifyEmailHandler(c *gin.Context) {
+	var req VerifyEmailRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if userStore == nil || tokenStore == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Auth stores not configured"})
+		return
+	}
+	ctx := c.Request.Context()
+
+	claims, err := consumeActionToken(ctx, req.Token, TokenUseEmailVerify)
+	if err != nil {
+		if errors.Is(err, ErrActionTokenInvalid) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired verification link"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email"})
+		return
+	}
+
+	// The address must not have changed since the link was sent
+	if err := userStore.MarkEmailVerified(ctx, claims.UserID, claims.Email); err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired verification link"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email verified successfully"})
+}
+
+// ResendVerificationHandler sends a new verification link to the current user
+func ResendVerificationHandler(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	if user.EmailVerified {
+		c.JSON(http.StatusConflict, gin.H{"error": "Email is already verified"})
+		return
+	}
+
+	if err := sendVerificationEmail(c.Request.Context(), user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send verification email"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "Verification email sent"})
+}
+
+// RequestPasswordResetHandler emails a reset link. It answers the same way
+// whether or not the account exists so it cannot be used to probe emails.
+func RequestPasswordResetHandler(c *gin.Context) {
+	var req PasswordResetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if userStore == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "User store not configured"})
+		return
+	}
+	ctx := c.Request.Context()
+
+	// Locked accounts and IPs cannot request reset links either
+	limiter := getLoginLimiter()
+	if wait, err := limiter.Check(ctx, req.Email, c.ClientIP()); errors.Is(err, ErrLoginLocked) {
+		respondLocked(c, wait)
+		return
+	}
+
+	user, err := userStore.FindByEmail(ctx, req.Email)
+	switch {
+	case err == nil:
+		// Send in the background so the response takes as long whether or
+		// not the account exists
+		go func() {
+			sendCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), resetMailTimeout)
+			defer cancel()
+			if err := sendPasswordResetEmail(sendCtx, user); err != nil {
+				log.Printf("Failed to send password reset email to %s: %v", user.Email, err)
+			}
+		}()
+	case !errors.Is(err, ErrUserNotFound):
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up user"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "If the account exists, a reset link has been sent"})
+}
+
+// ResetPasswordHandler sets a new password from a reset link and signs the
+// user out everywhere
+func ResetPasswordHandler(c *gin.Context) {
+	var req ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if userStore == nil || tokenStore == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Auth stores not configured"})
+		return
+	}
+	ctx := c.Request.Context()
+
+	claims, err := consumeActionToken(ctx, req.Token, TokenUsePasswordReset)
+	if err != nil {
+		if errors.Is(err, ErrActionTokenInvalid) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired reset link"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
+		return
+	}
+
+	hashedPassword, err := hashPasswordBounded(ctx, req.Password)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Server is busy, try again later"})
+		return
+	}
+
+	if err := userStore.SetPassword(ctx, claims.UserID, hashedPassword); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
+		return
+	}
+
+	// Existing sessions may belong to whoever knew the old password
+	revoked, err := tokenStore.RevokeUser(ctx, claims.UserID)
+	if err == nil {
+		err = revokeRecords(ctx, revoked)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sessions"})
+		return
+	}
+
+	RecordAudit(c, audit.Event{
+		Action:   audit.ActionPasswordReset,
+		ActorID:  claims.UserID,
+		TargetID: claims.UserID,
+	})
+
+	// Receiving the link proves control of the mailbox
+	_ = userStore.MarkEmailVerified(ctx, claims.UserID, claims.Email)
+	_ = getLoginLimiter().Success(ctx, claims.Email)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password reset successfully"})
+}
@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"Tracker/internal/mail"
+)
+
+// blockingMailer holds every Send until released
+type blockingMailer struct {
+	release chan struct{}
+	sent    chan mail.Message
+}
+
+func (m *blockingMailer) Send(ctx context.Context, msg mail.Message) error {
+	select {
+	case <-m.release:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	m.sent <- msg
+	return nil
+}
+
+func useBlockingMailer(t *testing.T) *blockingMailer {
+	m := &blockingMailer{release: make(chan struct{}), sent: make(chan mail.Message, 1)}
+	prev := mailer
+	SetMailer(m)
+	t.Cleanup(func() { SetMailer(prev) })
+	return m
+}
+
+func TestRequestPasswordResetDoesNotWaitForMail(t *testing.T) {
+	users, _ := useMemoryStores(t)
+	m := useBlockingMailer(t)
+
+	if err := users.Create(context.Background(), &User{Email: "reset@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The mailer is blocked, so a synchronous send would hang here
+	for _, email := range []string{"reset@example.com", "nobody@example.com"} {
+		rec := serveJSON(RequestPasswordResetHandler, http.MethodPost, "/auth/password/forgot",
+			PasswordResetRequest{Email: email})
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("%s: status %d, body %s", email, rec.Code, rec.Body)
+		}
+	}
+
+	// The existing account's mail is still delivered after the request ended
+	close(m.release)
+	select {
+	case msg := <-m.sent:
+		if msg.To != "reset@example.com" {
+			t.Errorf("mail sent to %s", msg.To)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("reset mail was never sent")
+	}
+
+	select {
+	case msg := <-m.sent:
+		t.Fatalf("unexpected mail to %s", msg.To)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,241 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"Tracker/internal/config"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// WebSocket handshake conventions. Browsers cannot set headers on a
+// WebSocket handshake, so they offer WebSocketProtocol together with
+// WebSocketBearerPrefix+credential in Sec-WebSocket-Protocol, or pass a
+// ticket from WebSocketTicketHandler as the ?ticket= query parameter.
+const (
+	WebSocketProtocol     = "tracker.v1"
+	WebSocketBearerPrefix = "bearer."
+)
+
+// TokenUseWebSocketTicket marks a WebSocket ticket
+const TokenUseWebSocketTicket = "ws_ticket"
+
+// webSocketTicketTTL is how long a client has to open the socket after
+// asking for a ticket
+const webSocketTicketTTL = 30 * time.Second
+
+// ErrInvalidTicket is returned for unknown, expired or already used tickets
+var ErrInvalidTicket = errors.New("invalid WebSocket ticket")
+
+// webSocketTicketClaims carries the principal of the session that asked for
+// a ticket. SessionJTI and SessionExpiresAt identify that session's access
+// token, so a ticket stops working once the session is logged out; both are
+// empty for API keys, which carry APIKeyID instead.
+type webSocketTicketClaims struct {
+	UserID           string   `json:"user_id"`
+	Email            string   `json:"email"`
+	Role             string   `json:"role"`
+	TenantID         string   `json:"tenant_id"`
+	Method           string   `json:"method"`
+	Scopes           []string `json:"scopes,omitempty"`
+	APIKeyID         string   `json:"api_key_id,omitempty"`
+	MFA              bool     `json:"mfa,omitempty"`
+	SessionJTI       string   `json:"session_jti,omitempty"`
+	SessionExpiresAt int64    `json:"session_exp,omitempty"`
+	TokenUse         string   `json:"token_use"`
+	jwt.StandardClaims
+}
+
+// WebSocketSession is an authenticated WebSocket handshake
+type WebSocketSession struct {
+	Principal *Principal
+	// ExpiresAt is when the credential behind the connection expires. It is
+	// zero for API keys, which are valid until revoked; see Revoked.
+	ExpiresAt time.Time
+}
+
+// Revoked reports whether the credential behind the connection has been
+// revoked since the handshake: a revoked API key or a logged out session.
+// Long-lived connections call it periodically.
+func (s *WebSocketSession) Revoked(ctx context.Context) (bool, error) {
+	switch {
+	case s.Principal.APIKey != nil:
+		return isAPIKeyRevoked(ctx, s.Principal.APIKey.ID)
+	case s.Principal.Claims != nil:
+		return isTokenRevoked(ctx, s.Principal.Claims)
+	}
+	return false, nil
+}
+
+// AuthenticateWebSocket validates the credentials of a WebSocket handshake:
+// a ?ticket= query parameter, a bearer.<credential> subprotocol, or the
+// usual Authorization or X-API-Key headers for non-browser clients
+func AuthenticateWebSocket(ctx context.Context, r *http.Request) (*WebSocketSession, error) {
+	if ticket := r.URL.Query().Get("ticket"); ticket != "" {
+		return redeemWebSocketTicket(ctx, ticket)
+	}
+
+	var principal *Principal
+	var err error
+	if credential := webSocketProtocolCredential(r); credential != "" {
+		principal, err = authenticateCredential(ctx, credential)
+	} else {
+		principal, err = Authenticate(ctx, r)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	session := &WebSocketSession{Principal: principal}
+	if principal.Claims != nil {
+		session.ExpiresAt = time.Unix(principal.Claims.ExpiresAt, 0)
+	}
+	return session, nil
+}
+
+// webSocketProtocolCredential extracts the credential offered as a subprotocol
+func webSocketProtocolCredential(r *http.Request) string {
+	for _, header := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, protocol := range strings.Split(header, ",") {
+			protocol = strings.TrimSpace(protocol)
+			if strings.HasPrefix(protocol, WebSocketBearerPrefix) {
+				return strings.TrimPrefix(protocol, WebSocketBearerPrefix)
+			}
+		}
+	}
+	return ""
+}
+
+// redeemWebSocketTicket validates a single-use ticket and rebuilds its principal
+func redeemWebSocketTicket(ctx context.Context, ticket string) (*WebSocketSession, error) {
+	if tokenStore == nil {
+		return nil, ErrTokenStoreNotConfigured
+	}
+
+	claims := &webSocketTicketClaims{}
+	token, err := jwt.ParseWithClaims(ticket, claims, getKeySet().keyFunc)
+	if err != nil || !token.Valid || claims.TokenUse != TokenUseWebSocketTicket {
+		return nil, ErrInvalidTicket
+	}
+
+	claimed, err := tokenStore.ClaimJTI(ctx, claims.Id, time.Unix(claims.ExpiresAt, 0))
+	if err != nil {
+		return nil, err
+	}
+	if !claimed {
+		return nil, ErrInvalidTicket
+	}
+
+	principal := &Principal{
+		UserID:   claims.UserID,
+		Email:    claims.Email,
+		Role:     claims.Role,
+		TenantID: claims.TenantID,
+		Method:   claims.Method,
+	}
+	switch claims.Method {
+	case AuthMethodAPIKey:
+		// The key may have been revoked since the ticket was issued
+		keyID, err := primitive.ObjectIDFromHex(claims.APIKeyID)
+		if err != nil {
+			return nil, ErrInvalidTicket
+		}
+		revoked, err := isAPIKeyRevoked(ctx, keyID)
+		if err != nil {
+			return nil, err
+		}
+		if revoked {
+			return nil, ErrInvalidAPIKey
+		}
+		principal.APIKey = &APIKey{ID: keyID, UserID: claims.UserID, Scopes: claims.Scopes}
+	case AuthMethodJWT:
+		// The session may have been logged out since the ticket was issued
+		sessionClaims := &Claims{
+			UserID:   claims.UserID,
+			Email:    claims.Email,
+			Role:     claims.Role,
+			TenantID: claims.TenantID,
+			TokenUse: TokenUseAccess,
+			MFA:      claims.MFA,
+			StandardClaims: jwt.StandardClaims{
+				Id:        claims.SessionJTI,
+				Issuer:    claims.Issuer,
+				Subject:   claims.UserID,
+				ExpiresAt: claims.SessionExpiresAt,
+			},
+		}
+		revoked, err := isTokenRevoked(ctx, sessionClaims)
+		if err != nil {
+			return nil, err
+		}
+		if revoked {
+			return nil, ErrTokenRevoked
+		}
+		principal.Claims = sessionClaims
+	}
+
+	session := &WebSocketSession{Principal: principal}
+	if claims.SessionExpiresAt != 0 {
+		session.ExpiresAt = time.Unix(claims.SessionExpiresAt, 0)
+	}
+	return session, nil
+}
+
+// WebSocketTicketHandler issues a short-lived, single-use ticket for opening
+// a WebSocket from a browser
+func WebSocketTicketHandler(c *gin.Context) {
+	principal, ok := GetPrincipal(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	jti, err := randomToken(16)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue ticket"})
+		return
+	}
+
+	expiresAt := time.Now().Add(webSocketTicketTTL)
+	claims := &webSocketTicketClaims{
+		UserID:   principal.UserID,
+		Email:    principal.Email,
+		Role:     principal.Role,
+		TenantID: principal.TenantID,
+		Method:   principal.Method,
+		TokenUse: TokenUseWebSocketTicket,
+		StandardClaims: jwt.StandardClaims{
+			Id:        jti,
+			Issuer:    config.GetJWTIssuer(),
+			Subject:   principal.UserID,
+			ExpiresAt: expiresAt.Unix(),
+			IssuedAt:  time.Now().Unix(),
+		},
+	}
+	if principal.Claims != nil {
+		claims.MFA = principal.Claims.MFA
+		claims.SessionJTI = principal.Claims.Id
+		claims.SessionExpiresAt = principal.Claims.ExpiresAt
+	}
+	if principal.APIKey != nil {
+		claims.Scopes = principal.APIKey.Scopes
+		claims.APIKeyID = principal.APIKey.ID.Hex()
+	}
+
+	ticket, err := getKeySet().sign(claims)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue ticket"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"ticket":    ticket,
+		"expiresAt": expiresAt,
+	})
+}
@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// requestTicket asks for a WebSocket ticket with an access token
+func requestTicket(t *testing.T, accessToken string) string {
+	t.Helper()
+
+	rec := serveAuthed(WebSocketTicketHandler, http.MethodPost, "/api/ws/ticket", accessToken, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("ticket: status %d, body %s", rec.Code, rec.Body)
+	}
+	var body struct {
+		Ticket string `json:"ticket"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	return body.Ticket
+}
+
+func TestWebSocketTicketCarriesSession(t *testing.T) {
+	users, _ := useMemoryStores(t)
+	user, tokens := loggedInUser(t, users)
+	access, err := ValidateToken(tokens.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ticket := requestTicket(t, tokens.AccessToken)
+	session, err := redeemWebSocketTicket(context.Background(), ticket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if session.Principal.UserID != user.ID || session.Principal.TenantID != user.TenantID {
+		t.Errorf("principal = %+v, want user %s", session.Principal, user.ID)
+	}
+	if session.Principal.Claims == nil || session.Principal.Claims.Id != access.Id {
+		t.Errorf("principal is not tied to the session's access token %s", access.Id)
+	}
+	if !session.ExpiresAt.Equal(time.Unix(access.ExpiresAt, 0)) {
+		t.Errorf("ExpiresAt = %v, want the session expiry %v", session.ExpiresAt, time.Unix(access.ExpiresAt, 0))
+	}
+
+	// Tickets are single-use
+	if _, err := redeemWebSocketTicket(context.Background(), ticket); !errors.Is(err, ErrInvalidTicket) {
+		t.Errorf("second redeem = %v, want ErrInvalidTicket", err)
+	}
+}
+
+func TestWebSocketTicketRejectedAfterLogout(t *testing.T) {
+	users, _ := useMemoryStores(t)
+	_, tokens := loggedInUser(t, users)
+
+	ticket := requestTicket(t, tokens.AccessToken)
+	session, err := AuthenticateWebSocket(context.Background(), bearerRequest(tokens.AccessToken))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := serveAuthed(LogoutHandler, http.MethodPost, "/auth/logout", tokens.AccessToken,
+		LogoutRequest{RefreshToken: tokens.RefreshToken})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("logout: status %d, body %s", rec.Code, rec.Body)
+	}
+
+	if _, err := redeemWebSocketTicket(context.Background(), ticket); !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("redeem after logout = %v, want ErrTokenRevoked", err)
+	}
+
+	// Connections already open see the logout on their next check
+	if revoked, err := session.Revoked(context.Background()); err != nil || !revoked {
+		t.Errorf("Revoked = %v, %v after logout, want true", revoked, err)
+	}
+}
+
+// bearerRequest builds a handshake request with an Authorization header
+func bearerRequest(credential string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	r.Header.Set("Authorization", "Bearer "+credential)
+	return r
+}
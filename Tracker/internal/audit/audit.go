@@ -0,0 +1,369 @@
+package audit
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Audited actions
+const (
+	ActionRegister         = "auth.register"
+	ActionLoginSuccess     = "auth.login.success"
+	ActionLoginFailure     = "auth.login.failure"
+	ActionTokenRefresh     = "auth.token.refresh"
+	ActionPasswordReset    = "auth.password.reset"
+	ActionMFAEnable        = "auth.mfa.enable"
+	ActionMFADisable       = "auth.mfa.disable"
+	ActionLockoutUnlock    = "auth.lockout.unlock"
+	ActionRoleChange       = "user.role.change"
+	ActionTeamRoleChange   = "team.role.change"
+	ActionAPIKeyCreate     = "apikey.create"
+	ActionAPIKeyRevoke     = "apikey.revoke"
+	ActionAdminRead        = "admin.read"
+	ActionPermissionDenied = "policy.denied"
+)
+
+// Outcomes
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// genesisHash is the previous hash of the first entry
+const genesisHash = "0000000000000000000000000000000000000000000000000000000000000000"
+
+// appendRetries bounds retries when another instance appends concurrently
+const appendRetries = 10
+
+// Event is what callers record
+type Event struct {
+	Action   string
+	Outcome  string
+	ActorID  string
+	TenantID string
+	TargetID string
+	IP       string
+	Details  map[string]string
+}
+
+// Entry is a stored, hash-chained audit record. Hash is an HMAC over every
+// other field, including PrevHash, so editing or removing an entry breaks the
+// chain, and without the key the chain cannot be recomputed to hide it.
+type Entry struct {
+	ID       primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Seq      int64              `bson:"seq" json:"seq"`
+	Time     time.Time          `bson:"time" json:"time"`
+	Action   string             `bson:"action" json:"action"`
+	Outcome  string             `bson:"outcome" json:"outcome"`
+	ActorID  string             `bson:"actorId,omitempty" json:"actorId,omitempty"`
+	TenantID string             `bson:"tenantId,omitempty" json:"tenantId,omitempty"`
+	TargetID string             `bson:"targetId,omitempty" json:"targetId,omitempty"`
+	IP       string             `bson:"ip,omitempty" json:"ip,omitempty"`
+	Details  map[string]string  `bson:"details,omitempty" json:"details,omitempty"`
+	PrevHash string             `bson:"prevHash" json:"prevHash"`
+	Hash     string             `bson:"hash" json:"hash"`
+}
+
+// computeHash MACs the entry's content together with the previous hash
+func (e *Entry) computeHash(key []byte) string {
+	content, _ := json.Marshal(struct {
+		Seq      int64             `json:"seq"`
+		Time     int64             `json:"time"`
+		Action   string            `json:"action"`
+		Outcome  string            `json:"outcome"`
+		ActorID  string            `json:"actorId"`
+		TenantID string            `json:"tenantId"`
+		TargetID string            `json:"targetId"`
+		IP       string            `json:"ip"`
+		Details  map[string]string `json:"details"`
+		PrevHash string            `json:"prevHash"`
+	}{e.Seq, e.Time.UnixMilli(), e.Action, e.Outcome, e.ActorID, e.TenantID, e.TargetID, e.IP, e.Details, e.PrevHash})
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(content)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Filter narrows an audit query. Zero values match everything.
+type Filter struct {
+	Action   string
+	Outcome  string
+	ActorID  string
+	TenantID string
+	TargetID string
+	From     time.Time
+	To       time.Time
+	// BeforeSeq pages backwards from a sequence number
+	BeforeSeq int64
+	Limit     int64
+}
+
+// VerifyResult reports the outcome of a chain verification
+type VerifyResult struct {
+	OK      bool   `json:"ok"`
+	Checked int64  `json:"checked"`
+	LastSeq int64  `json:"lastSeq"`
+	BadSeq  int64  `json:"badSeq,omitempty"`
+	Problem string `json:"problem,omitempty"`
+}
+
+// Log is an append-only, hash-chained audit log in MongoDB
+type Log struct {
+	collection *mongo.Collection
+	// key signs the chain. It must not be stored in the database, or
+	// anyone who can write the collection can forge a valid chain.
+	key []byte
+
+	// mu serializes appends from this instance; the unique seq index
+	// serializes appends across instances
+	mu sync.Mutex
+	// tail is the newest entry this instance knows of, nil until loaded.
+	// It is re-read only when another instance appended in between.
+	tail *Entry
+}
+
+// NewLog creates an audit log on top of the given collection, chained with
+// an HMAC under key
+func NewLog(collection *mongo.Collection, key []byte) *Log {
+	return &Log{collection: collection, key: key}
+}
+
+// EnsureIndexes creates the unique sequence index and the query indexes
+func (l *Log) EnsureIndexes(ctx context.Context) error {
+	_, err := l.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "seq", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "action", Value: 1}, {Key: "seq", Value: -1}}},
+		{Keys: bson.D{{Key: "actorId", Value: 1}, {Key: "seq", Value: -1}}},
+		{Keys: bson.D{{Key: "targetId", Value: 1}, {Key: "seq", Value: -1}}},
+		{Keys: bson.D{{Key: "tenantId", Value: 1}, {Key: "seq", Value: -1}}},
+	})
+	return err
+}
+
+// Append chains an event onto the end of the log
+func (l *Log) Append(ctx context.Context, event Event) (*Entry, error) {
+	// Empty and nil maps must hash the same after a database round trip
+	if len(event.Details) == 0 {
+		event.Details = nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for attempt := 0; attempt < appendRetries; attempt++ {
+		if l.tail == nil {
+			last, err := l.last(ctx)
+			if err != nil {
+				return nil, err
+			}
+			l.tail = last
+		}
+		last := l.tail
+
+		entry := &Entry{
+			Seq:      1,
+			Time:     time.Now().UTC().Truncate(time.Millisecond),
+			Action:   event.Action,
+			Outcome:  event.Outcome,
+			ActorID:  event.ActorID,
+			TenantID: event.TenantID,
+			TargetID: event.TargetID,
+			IP:       event.IP,
+			Details:  event.Details,
+			PrevHash: genesisHash,
+		}
+		if last != nil {
+			entry.Seq = last.Seq + 1
+			entry.PrevHash = last.Hash
+		}
+		entry.Hash = entry.computeHash(l.key)
+
+		result, err := l.collection.InsertOne(ctx, entry)
+		if err != nil {
+			// The insert may or may not have landed, so reload the tail
+			l.tail = nil
+			// Another instance took this sequence number; chain onto it
+			if mongo.IsDuplicateKeyError(err) {
+				continue
+			}
+			return nil, err
+		}
+		entry.ID = result.InsertedID.(primitive.ObjectID)
+		l.tail = entry
+		return entry, nil
+	}
+	return nil, errors.New("audit log append kept conflicting")
+}
+
+// last returns the newest entry, or nil for an empty log
+func (l *Log) last(ctx context.Context) (*Entry, error) {
+	var entry Entry
+	opts := options.FindOne().SetSort(bson.D{{Key: "seq", Value: -1}})
+	if err := l.collection.FindOne(ctx, bson.M{}, opts).Decode(&entry); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// Query returns entries matching the filter, newest first
+func (l *Log) Query(ctx context.Context, f Filter) ([]Entry, error) {
+	filter := bson.M{}
+	for field, value := range map[string]string{
+		"action":   f.Action,
+		"outcome":  f.Outcome,
+		"actorId":  f.ActorID,
+		"tenantId": f.TenantID,
+		"targetId": f.TargetID,
+	} {
+		if value != "" {
+			filter[field] = value
+		}
+	}
+
+	timeRange := bson.M{}
+	if !f.From.IsZero() {
+		timeRange["$gte"] = f.From
+	}
+	if !f.To.IsZero() {
+		timeRange["$lt"] = f.To
+	}
+	if len(timeRange) > 0 {
+		filter["time"] = timeRange
+	}
+	if f.BeforeSeq > 0 {
+		filter["seq"] = bson.M{"$lt": f.BeforeSeq}
+	}
+
+	limit := f.Limit
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "seq", Value: -1}}).SetLimit(limit)
+	cursor, err := l.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	entries := make([]Entry, 0)
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// Verify walks the chain from fromSeq (1 for the whole log) and reports the
+// first gap, broken link or entry whose content no longer matches its hash.
+// Truncating the newest entries leaves a valid chain, so callers should
+// compare LastSeq with a sequence number they saw earlier.
+func (l *Log) Verify(ctx context.Context, fromSeq int64) (*VerifyResult, error) {
+	if fromSeq < 1 {
+		fromSeq = 1
+	}
+
+	result := &VerifyResult{OK: true}
+	prevHash := genesisHash
+	if fromSeq > 1 {
+		var prev Entry
+		if err := l.collection.FindOne(ctx, bson.M{"seq": fromSeq - 1}).Decode(&prev); err != nil {
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return fail(result, fromSeq-1, "entry is missing"), nil
+			}
+			return nil, err
+		}
+		prevHash = prev.Hash
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "seq", Value: 1}})
+	cursor, err := l.collection.Find(ctx, bson.M{"seq": bson.M{"$gte": fromSeq}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	expected := fromSeq
+	for cursor.Next(ctx) {
+		var entry Entry
+		if err := cursor.Decode(&entry); err != nil {
+			return nil, err
+		}
+
+		switch {
+		case entry.Seq != expected:
+			return fail(result, expected, fmt.Sprintf("entry is missing (next entry is %d)", entry.Seq)), nil
+		case entry.PrevHash != prevHash:
+			return fail(result, entry.Seq, "previous hash does not match the chain"), nil
+		case entry.computeHash(l.key) != entry.Hash:
+			return fail(result, entry.Seq, "entry content does not match its hash"), nil
+		}
+
+		prevHash = entry.Hash
+		result.Checked++
+		result.LastSeq = entry.Seq
+		expected++
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// fail marks a verification result as failed at seq
+func fail(result *VerifyResult, seq int64, problem string) *VerifyResult {
+	result.OK = false
+	result.BadSeq = seq
+	result.Problem = problem
+	return result
+}
+
+// defaultLog is the log used by Record
+var defaultLog *Log
+
+// SetLog configures the log used by Record
+func SetLog(l *Log) {
+	defaultLog = l
+}
+
+// Default returns the configured log, or nil when auditing is disabled
+func Default() *Log {
+	return defaultLog
+}
+
+// Record appends an event to the configured log. Audit failures are logged
+// rather than failing the action being audited.
+func Record(ctx context.Context, event Event) {
+	if defaultLog == nil {
+		return
+	}
+	if event.Outcome == "" {
+		event.Outcome = OutcomeSuccess
+	}
+
+	// Record even when the request that triggered the event was cancelled
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+
+	if _, err := defaultLog.Append(ctx, event); err != nil {
+		log.Printf("audit: failed to record %s for actor=%s: %v", event.Action, event.ActorID, err)
+	}
+}
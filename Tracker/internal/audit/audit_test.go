@@ -0,0 +1,64 @@
+package audit
+
+import (
+	"testing"
+	"time"
+)
+
+func sampleEntry() *Entry {
+	return &Entry{
+		Seq:      7,
+		Time:     time.Date(2026, 1, 2, 3, 4, 5, 6_000_000, time.UTC),
+		Action:   ActionLoginSuccess,
+		Outcome:  OutcomeSuccess,
+		ActorID:  "user-1",
+		TenantID: "tenant-1",
+		TargetID: "user-1",
+		IP:       "10.0.0.1",
+		Details:  map[string]string{"method": "password"},
+		PrevHash: genesisHash,
+	}
+}
+
+func TestComputeHashIsStable(t *testing.T) {
+	key := []byte("audit-key")
+	if sampleEntry().computeHash(key) != sampleEntry().computeHash(key) {
+		t.Fatal("the same entry hashed differently")
+	}
+}
+
+func TestComputeHashDetectsEdits(t *testing.T) {
+	key := []byte("audit-key")
+	original := sampleEntry().computeHash(key)
+
+	edits := map[string]func(e *Entry){
+		"seq":      func(e *Entry) { e.Seq++ },
+		"time":     func(e *Entry) { e.Time = e.Time.Add(time.Millisecond) },
+		"action":   func(e *Entry) { e.Action = ActionLoginFailure },
+		"outcome":  func(e *Entry) { e.Outcome = OutcomeFailure },
+		"actor":    func(e *Entry) { e.ActorID = "user-2" },
+		"tenant":   func(e *Entry) { e.TenantID = "tenant-2" },
+		"target":   func(e *Entry) { e.TargetID = "user-2" },
+		"ip":       func(e *Entry) { e.IP = "10.0.0.2" },
+		"details":  func(e *Entry) { e.Details["method"] = "oidc" },
+		"prevHash": func(e *Entry) { e.PrevHash = original },
+	}
+	for field, edit := range edits {
+		entry := sampleEntry()
+		edit(entry)
+		if entry.computeHash(key) == original {
+			t.Errorf("editing %s did not change the hash", field)
+		}
+	}
+}
+
+func TestComputeHashDependsOnKey(t *testing.T) {
+	// Without the key, a recomputed chain does not match
+	entry := sampleEntry()
+	if entry.computeHash([]byte("server-key")) == entry.computeHash([]byte("attacker-guess")) {
+		t.Fatal("hash does not depend on the key")
+	}
+	if entry.computeHash(nil) == entry.computeHash([]byte("server-key")) {
+		t.Fatal("keyed hash matches the unkeyed one")
+	}
+}
@@ -4,7 +4,10 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -159,6 +162,28 @@ func getEnvOrDefault(key, defaultValue string) string {
 	return defaultValue
 }
 
+// getDurationOrDefault parses a duration environment variable, falling back
+// to the default when it is unset or malformed
+func getDurationOrDefault(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if d, err := time.ParseDuration(value); err == nil && d > 0 {
+			return d
+		}
+	}
+	return defaultValue
+}
+
+// getIntOrDefault parses a positive integer environment variable, falling
+// back to the default when it is unset or malformed
+func getIntOrDefault(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		if n, err := strconv.Atoi(value); err == nil && n > 0 {
+			return n
+		}
+	}
+	return defaultValue
+}
+
 // isValidEnvironment checks if the environment value is valid
 func isValidEnvironment(env string) bool {
 	validEnvs := map[string]bool{
@@ -195,6 +220,280 @@ func GetMongoCollectionName() string {
 	return os.Getenv("MONGODB_COLLECTION")
 }
 
+// GetMongoUsersCollectionName returns the MongoDB collection holding user accounts
+func GetMongoUsersCollectionName() string {
+	return getEnvOrDefault("MONGODB_USERS_COLLECTION", "users")
+}
+
+// GetAccessTokenTTL returns how long issued access tokens stay valid
+func GetAccessTokenTTL() time.Duration {
+	return getDurationOrDefault("ACCESS_TOKEN_TTL", 15*time.Minute)
+}
+
+// GetRefreshTokenTTL returns how long issued refresh tokens stay valid
+func GetRefreshTokenTTL() time.Duration {
+	return getDurationOrDefault("REFRESH_TOKEN_TTL", 30*24*time.Hour)
+}
+
+// GetJWTKeysDir returns the directory holding <kid>.pem JWT signing keys.
+// When empty, tokens are signed with the HMAC JWT_SECRET.
+func GetJWTKeysDir() string {
+	return os.Getenv("JWT_KEYS_DIR")
+}
+
+// GetJWTSigningKID returns the kid of the key used to sign new tokens
+func GetJWTSigningKID() string {
+	return os.Getenv("JWT_SIGNING_KID")
+}
+
+// GetJWTIssuer returns the iss claim placed in issued tokens
+func GetJWTIssuer() string {
+	return getEnvOrDefault("JWT_ISSUER", "activity-tracker")
+}
+
+// GetAuditHMACKey returns the key that chains audit log entries. It must not
+// be stored in the database.
+func GetAuditHMACKey() string {
+	return os.Getenv("AUDIT_HMAC_KEY")
+}
+
+// GetTOTPIssuer returns the issuer name shown in authenticator apps
+func GetTOTPIssuer() string {
+	return getEnvOrDefault("TOTP_ISSUER", "Activity Tracker")
+}
+
+// GetLoginAccountMaxFailures returns how many failed logins an account
+// gets before it is locked
+func GetLoginAccountMaxFailures() int {
+	return getIntOrDefault("LOGIN_ACCOUNT_MAX_FAILURES", 5)
+}
+
+// GetLoginIPMaxFailures returns how many failed logins a client IP gets
+// before it is locked
+func GetLoginIPMaxFailures() int {
+	return getIntOrDefault("LOGIN_IP_MAX_FAILURES", 20)
+}
+
+// GetLoginLockoutBase returns the first lockout duration, doubled for every
+// further failure
+func GetLoginLockoutBase() time.Duration {
+	return getDurationOrDefault("LOGIN_LOCKOUT_BASE", 30*time.Second)
+}
+
+// GetLoginLockoutMax returns the longest lockout duration
+func GetLoginLockoutMax() time.Duration {
+	return getDurationOrDefault("LOGIN_LOCKOUT_MAX", time.Hour)
+}
+
+// GetLoginFailureWindow returns how long failures are remembered
+func GetLoginFailureWindow() time.Duration {
+	return getDurationOrDefault("LOGIN_FAILURE_WINDOW", 15*time.Minute)
+}
+
+// GetMaxConcurrentPasswordChecks returns how many bcrypt operations may run
+// at once
+func GetMaxConcurrentPasswordChecks() int {
+	return getIntOrDefault("MAX_CONCURRENT_PASSWORD_CHECKS", runtime.NumCPU())
+}
+
+// GetAppBaseURL returns the public URL used in links sent by email
+func GetAppBaseURL() string {
+	return strings.TrimRight(getEnvOrDefault("APP_BASE_URL", "http://localhost:8000"), "/")
+}
+
+// GetEmailVerifyTTL returns how long email verification links stay valid
+func GetEmailVerifyTTL() time.Duration {
+	return getDurationOrDefault("EMAIL_VERIFY_TTL", 24*time.Hour)
+}
+
+// GetPasswordResetTTL returns how long password reset links stay valid
+func GetPasswordResetTTL() time.Duration {
+	return getDurationOrDefault("PASSWORD_RESET_TTL", 30*time.Minute)
+}
+
+// GetMailDriver returns the mail driver: smtp, file or log
+func GetMailDriver() string {
+	return getEnvOrDefault("MAIL_DRIVER", "log")
+}
+
+// GetMailFrom returns the sender address for outgoing mail
+func GetMailFrom() string {
+	return getEnvOrDefault("MAIL_FROM", "no-reply@localhost")
+}
+
+// GetMailDir returns the directory the file mail driver writes to
+func GetMailDir() string {
+	return getEnvOrDefault("MAIL_DIR", "mail")
+}
+
+// GetSMTPHost returns the SMTP server host
+func GetSMTPHost() string {
+	return os.Getenv("SMTP_HOST")
+}
+
+// GetSMTPPort returns the SMTP server port
+func GetSMTPPort() string {
+	return getEnvOrDefault("SMTP_PORT", "587")
+}
+
+// GetSMTPUsername returns the SMTP username
+func GetSMTPUsername() string {
+	return os.Getenv("SMTP_USERNAME")
+}
+
+// GetSMTPPassword returns the SMTP password
+func GetSMTPPassword() string {
+	return os.Getenv("SMTP_PASSWORD")
+}
+
+// GetOIDCIssuer returns the OpenID Connect issuer URL. SSO is disabled when empty.
+func GetOIDCIssuer() string {
+	return os.Getenv("OIDC_ISSUER")
+}
+
+// GetOIDCClientID returns the OAuth client ID registered with the issuer
+func GetOIDCClientID() string {
+	return os.Getenv("OIDC_CLIENT_ID")
+}
+
+// GetOIDCClientSecret returns the OAuth client secret, empty for public clients
+func GetOIDCClientSecret() string {
+	return os.Getenv("OIDC_CLIENT_SECRET")
+}
+
+// GetOIDCRedirectURL returns the callback URL registered with the issuer
+func GetOIDCRedirectURL() string {
+	return getEnvOrDefault("OIDC_REDIRECT_URL", GetAppBaseURL()+"/auth/oidc/callback")
+}
+
+// GetOIDCScopes returns the scopes requested at login
+func GetOIDCScopes() []string {
+	return strings.Fields(getEnvOrDefault("OIDC_SCOPES", "openid email profile"))
+}
+
+// GetOIDCJoinCode returns the join code of the organization new SSO users
+// are placed in. When empty, each new SSO user gets their own organization.
+func GetOIDCJoinCode() string {
+	return os.Getenv("OIDC_JOIN_CODE")
+}
+
+// GetEventQueueSize returns how many WebSocket events may wait to be
+// written before new ones are dropped
+func GetEventQueueSize() int {
+	return getIntOrDefault("EVENT_QUEUE_SIZE", 10000)
+}
+
+// GetEventBatchSize returns the most events written in one insert
+func GetEventBatchSize() int {
+	return getIntOrDefault("EVENT_BATCH_SIZE", 500)
+}
+
+// GetEventFlushInterval returns how long a partial batch waits before it is
+// written
+func GetEventFlushInterval() time.Duration {
+	return getDurationOrDefault("EVENT_FLUSH_INTERVAL", time.Second)
+}
+
+// GetEventMaxClientAge returns how far in the past a WebSocket event's
+// client timestamp may lie before it is clamped
+func GetEventMaxClientAge() time.Duration {
+	return getDurationOrDefault("EVENT_MAX_CLIENT_AGE", 24*time.Hour)
+}
+
+// GetEventBatchMaxEvents returns the most events accepted in one REST
+// batch upload
+func GetEventBatchMaxEvents() int {
+	return getIntOrDefault("EVENT_BATCH_MAX_EVENTS", 500)
+}
+
+// GetEventDedupeWindow returns how long client event IDs are remembered.
+// Uploaded events older than this are rejected.
+func GetEventDedupeWindow() time.Duration {
+	return getDurationOrDefault("EVENT_DEDUPE_WINDOW", 7*24*time.Hour)
+}
+
+// GetWSPingInterval returns how often the server pings WebSocket clients
+func GetWSPingInterval() time.Duration {
+	return getDurationOrDefault("WS_PING_INTERVAL", 54*time.Second)
+}
+
+// GetWSPongWait returns how long a WebSocket connection may stay silent,
+// pongs included, before it is considered dead
+func GetWSPongWait() time.Duration {
+	return getDurationOrDefault("WS_PONG_WAIT", 60*time.Second)
+}
+
+// GetWSWriteTimeout returns how long a single WebSocket write may take
+func GetWSWriteTimeout() time.Duration {
+	return getDurationOrDefault("WS_WRITE_TIMEOUT", 10*time.Second)
+}
+
+// GetWSMaxMessageSize returns the largest message in bytes a WebSocket
+// client may send
+func GetWSMaxMessageSize() int {
+	return getIntOrDefault("WS_MAX_MESSAGE_SIZE", 64<<10)
+}
+
+// GetWSResumeGrace returns how long a WebSocket session is kept after its
+// connection drops, waiting for the client to resume it
+func GetWSResumeGrace() time.Duration {
+	return getDurationOrDefault("WS_RESUME_GRACE", 2*time.Minute)
+}
+
+// GetWSResumeBuffer returns the most unacknowledged messages kept per
+// WebSocket session
+func GetWSResumeBuffer() int {
+	return getIntOrDefault("WS_RESUME_BUFFER", 1000)
+}
+
+// GetWSSendBuffer returns how many messages may wait to be written to a
+// WebSocket client
+func GetWSSendBuffer() int {
+	return getIntOrDefault("WS_SEND_BUFFER", 256)
+}
+
+// GetWSSlowConsumerPolicy returns what happens when a WebSocket client's
+// send buffer is full: drop_oldest or disconnect
+func GetWSSlowConsumerPolicy() string {
+	return getEnvOrDefault("WS_SLOW_CONSUMER_POLICY", "drop_oldest")
+}
+
+// GetWSConnectionRate returns how many messages per second one WebSocket
+// connection may send, and the burst it may send at once
+func GetWSConnectionRate() (rate, burst int) {
+	return getIntOrDefault("WS_CONNECTION_RATE", 50), getIntOrDefault("WS_CONNECTION_BURST", 100)
+}
+
+// GetWSUserRate returns how many messages per second a user may send over
+// all their WebSocket connections, and the burst they may send at once
+func GetWSUserRate() (rate, burst int) {
+	return getIntOrDefault("WS_USER_RATE", 100), getIntOrDefault("WS_USER_BURST", 200)
+}
+
+// GetWSEventTypeRates returns per-user quotas in events per second for
+// single event types, from a list like "mouse_move=60,scroll=30". Malformed
+// entries are skipped.
+func GetWSEventTypeRates() map[string]int {
+	rates := make(map[string]int)
+	value := getEnvOrDefault("WS_EVENT_TYPE_RATES", "mouse_move=60,scroll=30,key_press=30,click=20")
+	for _, entry := range strings.Split(value, ",") {
+		eventType, rate, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			continue
+		}
+		if n, err := strconv.Atoi(rate); err == nil && n > 0 && eventType != "" {
+			rates[eventType] = n
+		}
+	}
+	return rates
+}
+
+// GetWSMouseMoveCoalesce returns the window in which a WebSocket
+// connection's mouse_move events are merged into the latest one
+func GetWSMouseMoveCoalesce() time.Duration {
+	return getDurationOrDefault("WS_MOUSE_MOVE_COALESCE", 100*time.Millisecond)
+}
+
 // GetGeminiApiKey returns the Gemini API key
 func GetGeminiApiKey() string {
 	return os.Getenv("GEMINI_API_KEY")
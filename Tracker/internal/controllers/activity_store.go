@@ -0,0 +1,198 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"Tracker/internal/database"
+	"Tracker/internal/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrActivityNotFound is returned when no activity in scope matches
+var ErrActivityNotFound = errors.New("activity not found")
+
+// ActivityScope selects the activities a query may see. An empty UserID
+// matches every owner. Unless AllTenants is set only activities of
+// TenantID match, see database.TenantFilter; with AllTenants a non-empty
+// TenantID still narrows the view to that tenant.
+type ActivityScope struct {
+	UserID     string
+	TenantID   string
+	AllTenants bool
+}
+
+// filter builds the Mongo filter of the scope
+func (s ActivityScope) filter() bson.M {
+	filter := bson.M{}
+	if s.UserID != "" {
+		filter["userId"] = s.UserID
+	}
+	if !s.AllTenants {
+		return database.TenantFilter(s.TenantID, filter)
+	}
+	if s.TenantID != "" {
+		filter["tenantId"] = s.TenantID
+	}
+	return filter
+}
+
+// ActivityUpdate holds the fields a user can change on an activity
+type ActivityUpdate struct {
+	Title       string
+	Description string
+	Category    string
+	Duration    float64
+	Date        time.Time
+}
+
+// ActivitySummary holds one user's activity totals, without the
+// activities themselves
+type ActivitySummary struct {
+	UserID          string   `bson:"_id" json:"_id"`
+	TotalActivities int      `bson:"totalActivities" json:"totalActivities"`
+	TotalDuration   float64  `bson:"totalDuration" json:"totalDuration"`
+	Categories      []string `bson:"categories" json:"categories"`
+}
+
+// ActivityStore persists activities. Every lookup takes the scope it is
+// allowed to see, so handlers cannot leave out the owner or the tenant.
+type ActivityStore interface {
+	Create(ctx context.Context, activity *model.Activity) error
+	List(ctx context.Context, scope ActivityScope, skip, limit int64) ([]model.Activity, error)
+	Find(ctx context.Context, scope ActivityScope, id primitive.ObjectID) (*model.Activity, error)
+	Update(ctx context.Context, scope ActivityScope, id primitive.ObjectID, update ActivityUpdate) error
+	Delete(ctx context.Context, scope ActivityScope, id primitive.ObjectID) error
+	// Summaries aggregates the activities of the given users created since
+	// a time, one summary per user with activities, ordered by user ID
+	Summaries(ctx context.Context, tenantID string, userIDs []string, since time.Time) ([]ActivitySummary, error)
+}
+
+// MongoActivityStore is an ActivityStore backed by the activities collection
+type MongoActivityStore struct {
+	collection *mongo.Collection
+}
+
+// NewMongoActivityStore creates an activity store on top of the given collection
+func NewMongoActivityStore(collection *mongo.Collection) *MongoActivityStore {
+	return &MongoActivityStore{collection: collection}
+}
+
+// Create inserts an activity and sets its ID
+func (s *MongoActivityStore) Create(ctx context.Context, activity *model.Activity) error {
+	result, err := s.collection.InsertOne(ctx, activity)
+	if err != nil {
+		return err
+	}
+	activity.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// List returns a page of the activities in scope
+func (s *MongoActivityStore) List(ctx context.Context, scope ActivityScope, skip, limit int64) ([]model.Activity, error) {
+	opts := options.Find().SetSkip(skip).SetLimit(limit)
+	cursor, err := s.collection.Find(ctx, scope.filter(), opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var activities []model.Activity
+	if err := cursor.All(ctx, &activities); err != nil {
+		return nil, err
+	}
+	return activities, nil
+}
+
+// Find returns an activity in scope
+func (s *MongoActivityStore) Find(ctx context.Context, scope ActivityScope, id primitive.ObjectID) (*model.Activity, error) {
+	filter := scope.filter()
+	filter["_id"] = id
+
+	var activity model.Activity
+	if err := s.collection.FindOne(ctx, filter).Decode(&activity); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrActivityNotFound
+		}
+		return nil, err
+	}
+	return &activity, nil
+}
+
+// Update changes an activity in scope
+func (s *MongoActivityStore) Update(ctx context.Context, scope ActivityScope, id primitive.ObjectID, update ActivityUpdate) error {
+	filter := scope.filter()
+	filter["_id"] = id
+
+	result, err := s.collection.UpdateOne(ctx, filter, bson.M{
+		"$set": bson.M{
+			"title":       update.Title,
+			"description": update.Description,
+			"category":    update.Category,
+			"duration":    update.Duration,
+			"date":        update.Date,
+			"updatedAt":   time.Now(),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrActivityNotFound
+	}
+	return nil
+}
+
+// Delete removes an activity in scope
+func (s *MongoActivityStore) Delete(ctx context.Context, scope ActivityScope, id primitive.ObjectID) error {
+	filter := scope.filter()
+	filter["_id"] = id
+
+	result, err := s.collection.DeleteOne(ctx, filter)
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrActivityNotFound
+	}
+	return nil
+}
+
+// Summaries aggregates per-user totals in the database
+func (s *MongoActivityStore) Summaries(ctx context.Context, tenantID string, userIDs []string, since time.Time) ([]ActivitySummary, error) {
+	pipeline := []bson.M{
+		{
+			"$match": database.TenantFilter(tenantID, bson.M{
+				"userId":    bson.M{"$in": userIDs},
+				"createdAt": bson.M{"$gte": since},
+			}),
+		},
+		{
+			"$group": bson.M{
+				"_id":             "$userId",
+				"totalActivities": bson.M{"$sum": 1},
+				"totalDuration":   bson.M{"$sum": "$duration"},
+				"categories":      bson.M{"$addToSet": "$category"},
+			},
+		},
+		{"$sort": bson.M{"_id": 1}},
+	}
+
+	opts := options.Aggregate().SetMaxTime(2 * time.Second)
+	cursor, err := s.collection.Aggregate(ctx, pipeline, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	summaries := make([]ActivitySummary, 0)
+	if err := cursor.All(ctx, &summaries); err != nil {
+		return nil, err
+	}
+	return summaries, nil
+}
@@ -0,0 +1,95 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"Tracker/internal/audit"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AuditController exposes the security audit log to platform admins
+type AuditController struct{}
+
+// NewAuditController creates a new audit controller
+func NewAuditController() *AuditController {
+	return &AuditController{}
+}
+
+// QueryAuditLog returns audit entries, newest first. Results can be narrowed
+// by action, outcome, actorId, tenantId, targetId and an RFC 3339 from/to
+// range, and paged with beforeSeq and limit.
+func (c *AuditController) QueryAuditLog(ctx *gin.Context) {
+	log := audit.Default()
+	if log == nil {
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{"error": "Audit log not configured"})
+		return
+	}
+
+	filter := audit.Filter{
+		Action:   ctx.Query("action"),
+		Outcome:  ctx.Query("outcome"),
+		ActorID:  ctx.Query("actorId"),
+		TenantID: ctx.Query("tenantId"),
+		TargetID: ctx.Query("targetId"),
+	}
+
+	var err error
+	if from := ctx.Query("from"); from != "" {
+		if filter.From, err = time.Parse(time.RFC3339, from); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from time, expected RFC 3339"})
+			return
+		}
+	}
+	if to := ctx.Query("to"); to != "" {
+		if filter.To, err = time.Parse(time.RFC3339, to); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to time, expected RFC 3339"})
+			return
+		}
+	}
+	if beforeSeq := ctx.Query("beforeSeq"); beforeSeq != "" {
+		if filter.BeforeSeq, err = strconv.ParseInt(beforeSeq, 10, 64); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid beforeSeq"})
+			return
+		}
+	}
+	if limit := ctx.Query("limit"); limit != "" {
+		if filter.Limit, err = strconv.ParseInt(limit, 10, 64); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+	}
+
+	entries, err := log.Query(ctx, filter)
+	if err != nil {
+		HandleError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, entries)
+}
+
+// VerifyAuditLog checks the hash chain, optionally starting at fromSeq
+func (c *AuditController) VerifyAuditLog(ctx *gin.Context) {
+	log := audit.Default()
+	if log == nil {
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{"error": "Audit log not configured"})
+		return
+	}
+
+	fromSeq, err := strconv.ParseInt(ctx.DefaultQuery("fromSeq", "1"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid fromSeq"})
+		return
+	}
+
+	result, err := log.Verify(ctx, fromSeq)
+	if err != nil {
+		HandleError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, result)
+}
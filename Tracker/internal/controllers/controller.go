@@ -8,44 +8,40 @@ import (
 	"strconv"
 	"time"
 
+	auth "Tracker/Authatication"
+	"Tracker/internal/audit"
 	"Tracker/internal/database"
 	"Tracker/internal/model"
 	"Tracker/internal/services"
 
 	"github.com/gin-gonic/gin"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // ActivityController handles activity-related operations
 type ActivityController struct {
 	aiService    *services.AIService
 	eventService *services.EventProcessor
+	activities   ActivityStore
 }
 
-// NewActivityController creates a new activity controller
-func NewActivityController() (*ActivityController, error) {
-	aiService, err := services.NewAIService()
-	if err != nil {
-		return nil, err
-	}
-
-	analyzer := services.NewActivityAnalyzer(aiService)
-	eventProcessor := services.NewEventProcessor(analyzer)
-
+// NewActivityController creates a new activity controller. The event
+// processor is shared with the WebSocket manager so on-demand analyses see
+// the live events.
+func NewActivityController(aiService *services.AIService, eventProcessor *services.EventProcessor) *ActivityController {
 	return &ActivityController{
 		aiService:    aiService,
 		eventService: eventProcessor,
-	}, nil
+		activities:   NewMongoActivityStore(database.GetCollection()),
+	}
 }
 
 // AnalyzeActivity analyzes user activity patterns
 func (c *ActivityController) AnalyzeActivity(ctx *gin.Context) {
-	userID := ctx.Param("userID")
-	if userID == "" {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "User ID is required"})
+	userID, ok := auth.GetUserID(ctx)
+	if !ok {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
 		return
 	}
 
@@ -82,49 +78,19 @@ func (c *ActivityController) AnalyzeActivity(ctx *gin.Context) {
 
 // GetActivitySummary retrieves activity summary for a user
 func (c *ActivityController) GetActivitySummary(ctx *gin.Context) {
-	userID := ctx.Param("userID")
-	if userID == "" {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "User ID is required"})
+	userID, ok := auth.GetUserID(ctx)
+	if !ok {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
 		return
 	}
 
-	// Set up options for aggregation
-	opts := options.Aggregate().SetMaxTime(2 * time.Second)
-
-	// Create pipeline for activity summary
-	pipeline := []bson.M{
-		{
-			"$match": bson.M{
-				"user_id": userID,
-				"created_at": bson.M{
-					"$gte": time.Now().Add(-24 * time.Hour),
-				},
-			},
-		},
-		{
-			"$group": bson.M{
-				"_id":             nil,
-				"totalActivities": bson.M{"$sum": 1},
-				"totalDuration":   bson.M{"$sum": "$duration"},
-				"categories": bson.M{
-					"$addToSet": "$category",
-				},
-			},
-		},
-	}
-
-	cursor, err := database.GetCollection().Aggregate(ctx, pipeline, opts)
+	// Summarize the last 24 hours
+	since := time.Now().Add(-24 * time.Hour)
+	summaries, err := c.activities.Summaries(ctx, auth.GetTenantID(ctx), []string{userID}, since)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to aggregate activities"})
 		return
 	}
-	defer cursor.Close(ctx)
-
-	var summaries []bson.M
-	if err = cursor.All(ctx, &summaries); err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode summary"})
-		return
-	}
 
 	if len(summaries) == 0 {
 		ctx.JSON(http.StatusNotFound, gin.H{"error": "No activities found"})
@@ -136,6 +102,12 @@ func (c *ActivityController) GetActivitySummary(ctx *gin.Context) {
 
 // CreateActivity creates a new activity
 func (c *ActivityController) CreateActivity(ctx *gin.Context) {
+	userID, ok := auth.GetUserID(ctx)
+	if !ok {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
 	var req model.ActivityRequest
 	if err := ctx.ShouldBindJSON(&req); err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{
@@ -162,36 +134,87 @@ func (c *ActivityController) CreateActivity(ctx *gin.Context) {
 		Category:    req.Category,
 		Duration:    req.Duration,
 		Date:        date,
+		UserID:      userID,
+		TenantID:    auth.GetTenantID(ctx),
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
 	}
 
-	result, err := database.GetCollection().InsertOne(context.Background(), activity)
-	if err != nil {
+	if err := c.activities.Create(ctx, &activity); err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	activity.ID = result.InsertedID.(primitive.ObjectID)
 	ctx.JSON(http.StatusCreated, activity)
 }
 
-// GetActivities retrieves all activities
+// GetActivities retrieves the authenticated user's activities
 func (c *ActivityController) GetActivities(ctx *gin.Context) {
-	page, _ := strconv.Atoi(ctx.DefaultQuery("page", "1"))
-	limit, _ := strconv.Atoi(ctx.DefaultQuery("limit", "10"))
-	skip := (page - 1) * limit
+	userID, ok := auth.GetUserID(ctx)
+	if !ok {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	c.listActivities(ctx, ActivityScope{UserID: userID, TenantID: auth.GetTenantID(ctx)})
+}
 
-	opts := options.Find().SetSkip(int64(skip)).SetLimit(int64(limit))
-	cursor, err := database.GetCollection().Find(context.Background(), bson.M{}, opts)
+// GetAllActivities retrieves activities across all users and tenants
+// (platform admin only). Optional userId and tenantId query parameters
+// narrow the view.
+func (c *ActivityController) GetAllActivities(ctx *gin.Context) {
+	scope := ActivityScope{
+		UserID:     ctx.Query("userId"),
+		TenantID:   ctx.Query("tenantId"),
+		AllTenants: true,
+	}
+
+	auth.RecordAudit(ctx, audit.Event{
+		Action:   audit.ActionAdminRead,
+		TargetID: ctx.Query("userId"),
+		Details:  map[string]string{"resource": "activities", "tenantId": ctx.Query("tenantId")},
+	})
+
+	c.listActivities(ctx, scope)
+}
+
+// GetAnyActivity retrieves a specific activity regardless of owner (admin only)
+func (c *ActivityController) GetAnyActivity(ctx *gin.Context) {
+	objectID, err := primitive.ObjectIDFromHex(ctx.Param("id"))
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
 		return
 	}
-	defer cursor.Close(context.Background())
 
-	var activities []model.Activity
-	if err := cursor.All(context.Background(), &activities); err != nil {
+	activity, err := c.activities.Find(ctx, ActivityScope{AllTenants: true}, objectID)
+	if err != nil {
+		activityError(ctx, err)
+		return
+	}
+
+	auth.RecordAudit(ctx, audit.Event{
+		Action:   audit.ActionAdminRead,
+		TargetID: activity.UserID,
+		Details:  map[string]string{"resource": "activity", "activityId": activity.ID.Hex()},
+	})
+
+	ctx.JSON(http.StatusOK, activity)
+}
+
+// listActivities returns a page of the activities in scope
+func (c *ActivityController) listActivities(ctx *gin.Context, scope ActivityScope) {
+	page, _ := strconv.Atoi(ctx.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(ctx.DefaultQuery("limit", "10"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 {
+		limit = 10
+	}
+	skip := (page - 1) * limit
+
+	activities, err := c.activities.List(ctx, scope, int64(skip), int64(limit))
+	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -199,31 +222,26 @@ func (c *ActivityController) GetActivities(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, activities)
 }
 
-// GetActivity retrieves a specific activity
+// GetActivity retrieves a specific activity owned by the authenticated user
 func (c *ActivityController) GetActivity(ctx *gin.Context) {
-	id := ctx.Param("id")
-	objectID, err := primitive.ObjectIDFromHex(id)
-	if err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+	scope, id, ok := ownedActivity(ctx)
+	if !ok {
 		return
 	}
 
-	var activity model.Activity
-	err = database.GetCollection().FindOne(context.Background(), bson.M{"_id": objectID}).Decode(&activity)
+	activity, err := c.activities.Find(ctx, scope, id)
 	if err != nil {
-		ctx.JSON(http.StatusNotFound, gin.H{"error": "Activity not found"})
+		activityError(ctx, err)
 		return
 	}
 
 	ctx.JSON(http.StatusOK, activity)
 }
 
-// UpdateActivity updates an existing activity
+// UpdateActivity updates an activity owned by the authenticated user
 func (c *ActivityController) UpdateActivity(ctx *gin.Context) {
-	id := ctx.Param("id")
-	objectID, err := primitive.ObjectIDFromHex(id)
-	if err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+	scope, id, ok := ownedActivity(ctx)
+	if !ok {
 		return
 	}
 
@@ -239,52 +257,30 @@ func (c *ActivityController) UpdateActivity(ctx *gin.Context) {
 		return
 	}
 
-	update := bson.M{
-		"$set": bson.M{
-			"title":       req.Title,
-			"description": req.Description,
-			"category":    req.Category,
-			"duration":    req.Duration,
-			"date":        date,
-			"updated_at":  time.Now(),
-		},
-	}
-
-	result, err := database.GetCollection().UpdateOne(
-		context.Background(),
-		bson.M{"_id": objectID},
-		update,
-	)
+	err = c.activities.Update(ctx, scope, id, ActivityUpdate{
+		Title:       req.Title,
+		Description: req.Description,
+		Category:    req.Category,
+		Duration:    req.Duration,
+		Date:        date,
+	})
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-
-	if result.MatchedCount == 0 {
-		ctx.JSON(http.StatusNotFound, gin.H{"error": "Activity not found"})
+		activityError(ctx, err)
 		return
 	}
 
 	ctx.JSON(http.StatusOK, gin.H{"message": "Activity updated successfully"})
 }
 
-// DeleteActivity deletes an activity
+// DeleteActivity deletes an activity owned by the authenticated user
 func (c *ActivityController) DeleteActivity(ctx *gin.Context) {
-	id := ctx.Param("id")
-	objectID, err := primitive.ObjectIDFromHex(id)
-	if err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+	scope, id, ok := ownedActivity(ctx)
+	if !ok {
 		return
 	}
 
-	result, err := database.GetCollection().DeleteOne(context.Background(), bson.M{"_id": objectID})
-	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-
-	if result.DeletedCount == 0 {
-		ctx.JSON(http.StatusNotFound, gin.H{"error": "Activity not found"})
+	if err := c.activities.Delete(ctx, scope, id); err != nil {
+		activityError(ctx, err)
 		return
 	}
 
@@ -330,6 +326,36 @@ func (c *ActivityController) GetSuggestions(ctx *gin.Context) {
 	})
 }
 
+// ownedActivity returns the scope of the authenticated user's activities
+// and the :id activity. It writes the error response and returns false when
+// the caller is unauthenticated or the ID is malformed.
+func ownedActivity(ctx *gin.Context) (ActivityScope, primitive.ObjectID, bool) {
+	userID, ok := auth.GetUserID(ctx)
+	if !ok {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return ActivityScope{}, primitive.NilObjectID, false
+	}
+
+	objectID, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return ActivityScope{}, primitive.NilObjectID, false
+	}
+
+	return ActivityScope{UserID: userID, TenantID: auth.GetTenantID(ctx)}, objectID, true
+}
+
+// activityError answers 404 for activities out of scope and 500 otherwise.
+// Activities of other users are not found rather than forbidden, so their
+// IDs cannot be probed.
+func activityError(ctx *gin.Context, err error) {
+	if errors.Is(err, ErrActivityNotFound) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Activity not found"})
+		return
+	}
+	ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
+
 // HandleError is a helper function to handle common errors
 func HandleError(ctx *gin.Context, err error) {
 	switch {
@@ -0,0 +1,154 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	auth "Tracker/Authatication"
+	"Tracker/internal/model"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// updateRequest is a valid activity update body
+var updateRequest = model.ActivityRequest{Title: "renamed", Category: "focus", Duration: 30, Date: "2026-01-02"}
+
+// activityIDs decodes a list of activities and returns their IDs
+func activityIDs(t *testing.T, body []byte) map[string]bool {
+	t.Helper()
+
+	var activities []model.Activity
+	if err := json.Unmarshal(body, &activities); err != nil {
+		t.Fatal(err)
+	}
+	ids := make(map[string]bool)
+	for _, activity := range activities {
+		ids[activity.ID.Hex()] = true
+	}
+	return ids
+}
+
+func TestActivityOwnership(t *testing.T) {
+	store := newMemoryActivityStore()
+	controller := &ActivityController{activities: store}
+
+	own := store.add("alice", "acme", "focus", 10)
+	colleagues := store.add("bob", "acme", "focus", 20)
+	// The same user ID in another organization is someone else
+	otherTenant := store.add("alice", "globex", "focus", 30)
+
+	handlers := map[string]gin.HandlerFunc{
+		http.MethodGet:    controller.GetActivity,
+		http.MethodPut:    controller.UpdateActivity,
+		http.MethodDelete: controller.DeleteActivity,
+	}
+	tests := []struct {
+		name   string
+		method string
+		id     string
+		body   interface{}
+		want   int
+	}{
+		{"get own", http.MethodGet, own.Hex(), nil, http.StatusOK},
+		{"get another user's", http.MethodGet, colleagues.Hex(), nil, http.StatusNotFound},
+		{"get another tenant's", http.MethodGet, otherTenant.Hex(), nil, http.StatusNotFound},
+		{"update another user's", http.MethodPut, colleagues.Hex(), updateRequest, http.StatusNotFound},
+		{"update another tenant's", http.MethodPut, otherTenant.Hex(), updateRequest, http.StatusNotFound},
+		{"delete another user's", http.MethodDelete, colleagues.Hex(), nil, http.StatusNotFound},
+		{"delete another tenant's", http.MethodDelete, otherTenant.Hex(), nil, http.StatusNotFound},
+		{"malformed ID", http.MethodGet, "not-an-id", nil, http.StatusBadRequest},
+		{"update own", http.MethodPut, own.Hex(), updateRequest, http.StatusOK},
+		{"delete own", http.MethodDelete, own.Hex(), nil, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(sessionUser("alice", "acme"), tt.method, "/activities/:id", "/activities/"+tt.id, tt.body, handlers[tt.method])
+			if rec.Code != tt.want {
+				t.Errorf("status %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+
+	// Only the caller's own activity was changed
+	for name, id := range map[string]primitive.ObjectID{"another user's": colleagues, "another tenant's": otherTenant} {
+		activity, ok := store.get(id)
+		if !ok || activity.Title != "focus work" {
+			t.Errorf("%s activity was changed: %+v", name, activity)
+		}
+	}
+	if _, ok := store.get(own); ok {
+		t.Error("own activity was not deleted")
+	}
+}
+
+func TestGetActivitiesListsOwnOnly(t *testing.T) {
+	store := newMemoryActivityStore()
+	controller := &ActivityController{activities: store}
+
+	own := store.add("alice", "acme", "focus", 10)
+	store.add("bob", "acme", "focus", 20)
+	store.add("alice", "globex", "focus", 30)
+
+	rec := serve(sessionUser("alice", "acme"), http.MethodGet, "/activities", "/activities", nil, controller.GetActivities)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if ids := activityIDs(t, rec.Body.Bytes()); len(ids) != 1 || !ids[own.Hex()] {
+		t.Errorf("listed %v, want only %s", ids, own.Hex())
+	}
+}
+
+func TestAdminActivities(t *testing.T) {
+	store := newMemoryActivityStore()
+	controller := &ActivityController{activities: store}
+	canReadAny := auth.RequirePermission(auth.PermActivitiesRead, auth.ConditionAny)
+
+	alice := store.add("alice", "acme", "focus", 10)
+	bob := store.add("bob", "acme", "focus", 20)
+	carol := store.add("carol", "globex", "focus", 30)
+
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{"every user and tenant", "", []string{alice.Hex(), bob.Hex(), carol.Hex()}},
+		{"one user", "?userId=bob", []string{bob.Hex()}},
+		{"one tenant", "?tenantId=globex", []string{carol.Hex()}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(sessionAdmin("root", "ops"), http.MethodGet, "/admin/activities", "/admin/activities"+tt.query, nil,
+				canReadAny, controller.GetAllActivities)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body)
+			}
+			ids := activityIDs(t, rec.Body.Bytes())
+			if len(ids) != len(tt.want) {
+				t.Fatalf("listed %v, want %v", ids, tt.want)
+			}
+			for _, id := range tt.want {
+				if !ids[id] {
+					t.Errorf("%s not listed", id)
+				}
+			}
+		})
+	}
+
+	rec := serve(sessionAdmin("root", "ops"), http.MethodGet, "/admin/activities/:id", "/admin/activities/"+carol.Hex(), nil,
+		canReadAny, controller.GetAnyActivity)
+	if rec.Code != http.StatusOK {
+		t.Errorf("admin reading another tenant's activity: status %d", rec.Code)
+	}
+
+	// The cross-user view is closed to everyone else
+	for _, handler := range []gin.HandlerFunc{controller.GetAllActivities, controller.GetAnyActivity} {
+		rec := serve(sessionUser("alice", "acme"), http.MethodGet, "/admin/activities/:id", "/admin/activities/"+bob.Hex(), nil,
+			canReadAny, handler)
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("user on the admin view: status %d, want %d", rec.Code, http.StatusForbidden)
+		}
+	}
+}
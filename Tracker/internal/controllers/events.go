@@ -0,0 +1,302 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	auth "Tracker/Authatication"
+	"Tracker/internal/audit"
+	"Tracker/internal/config"
+	"Tracker/internal/database"
+	"Tracker/internal/events"
+	"Tracker/internal/model"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// maxBatchBodyBytes bounds the size of a batch upload
+const maxBatchBodyBytes = 4 << 20
+
+// maxClientEventIDLength bounds client-generated event IDs
+const maxClientEventIDLength = 128
+
+// Per-item statuses of a batch upload
+const (
+	BatchStatusAccepted  = "accepted"
+	BatchStatusDuplicate = "duplicate"
+	BatchStatusInvalid   = "invalid"
+	// BatchStatusRejected means the server could not take the event right
+	// now; it was not stored and can be retried
+	BatchStatusRejected = "rejected"
+)
+
+// EventPipeline takes events accepted from clients, the same way the
+// WebSocket manager does for events received over a socket
+type EventPipeline interface {
+	ProcessEvent(event *model.Event) error
+}
+
+// EventController serves the raw event timeline and accepts batch uploads
+// from clients that cannot keep a WebSocket open
+type EventController struct {
+	store    *events.MongoStore
+	dedupe   *events.MongoDedupeStore
+	pipeline EventPipeline
+}
+
+// NewEventController creates a new event controller feeding uploads into
+// the given pipeline
+func NewEventController(pipeline EventPipeline) *EventController {
+	return &EventController{
+		store:    events.NewMongoStore(database.GetEventsCollection()),
+		dedupe:   events.NewMongoDedupeStore(database.GetEventIDsCollection()),
+		pipeline: pipeline,
+	}
+}
+
+// BatchEventsRequest represents a batch upload. Events are decoded one by
+// one so a malformed event does not fail the whole batch. SentAt is the
+// client's clock when it sent the batch, used to correct event timestamps
+// for clock skew.
+type BatchEventsRequest struct {
+	SentAt time.Time         `json:"sentAt"`
+	Events []json.RawMessage `json:"events" binding:"required"`
+}
+
+// BatchEventResult is the outcome for one uploaded event. Invalid events
+// list every problem found in Errors.
+type BatchEventResult struct {
+	Index         int                 `json:"index"`
+	ClientEventID string              `json:"clientEventId,omitempty"`
+	Status        string              `json:"status"`
+	Error         string              `json:"error,omitempty"`
+	Errors        []events.FieldError `json:"errors,omitempty"`
+}
+
+// PostEventsAction serves POST /api/events:<action>. gin reads a colon as
+// the start of a path parameter, so the custom method arrives as one.
+func (c *EventController) PostEventsAction(ctx *gin.Context) {
+	switch ctx.Param("action") {
+	case ":batch":
+		c.BatchEvents(ctx)
+	default:
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Unknown events action"})
+	}
+}
+
+// BatchEvents serves POST /api/events:batch. It accepts up to
+// EVENT_BATCH_MAX_EVENTS events with client timestamps and client event
+// IDs. Each event is validated and deduplicated on its own, and the
+// response reports a status per event. Accepted events enter the same
+// pipeline as WebSocket events.
+func (c *EventController) BatchEvents(ctx *gin.Context) {
+	ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, maxBatchBodyBytes)
+
+	var req BatchEventsRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.Events) == 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "No events in batch"})
+		return
+	}
+	if max := config.GetEventBatchMaxEvents(); len(req.Events) > max {
+		ctx.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("A batch can hold at most %d events", max)})
+		return
+	}
+
+	principal, _ := auth.GetPrincipal(ctx)
+	receivedAt := time.Now()
+	window := config.GetEventDedupeWindow()
+
+	// Without a round trip the upload latency counts as skew, which only
+	// moves timestamps slightly earlier
+	var skew time.Duration
+	if !req.SentAt.IsZero() {
+		skew = req.SentAt.Sub(receivedAt)
+	}
+
+	results := make([]BatchEventResult, len(req.Events))
+	batch := make([]*model.Event, len(req.Events))
+	seen := make(map[string]bool, len(req.Events))
+	ids := make([]string, 0, len(req.Events))
+
+	for i, raw := range req.Events {
+		results[i] = BatchEventResult{Index: i, Status: BatchStatusInvalid}
+
+		var event model.Event
+		if err := json.Unmarshal(raw, &event); err != nil {
+			results[i].Error = "malformed event: " + err.Error()
+			results[i].Errors = []events.FieldError{{Code: events.CodeMalformed, Message: err.Error()}}
+			continue
+		}
+		results[i].ClientEventID = event.ClientEventID
+
+		events.ApplyClientTime(&event, skew, receivedAt, 0)
+		if err := validateUploadedEvent(&event, window); err != nil {
+			results[i].Error = err.Error()
+			results[i].Errors = err.Errors
+			continue
+		}
+		if seen[event.ClientEventID] {
+			results[i].Status = BatchStatusDuplicate
+			continue
+		}
+		seen[event.ClientEventID] = true
+
+		event.ID = primitive.NilObjectID
+		event.UserID = principal.UserID
+		event.TenantID = principal.TenantID
+		batch[i] = &event
+		ids = append(ids, event.ClientEventID)
+	}
+
+	claimed, err := c.dedupe.Claim(ctx, principal.UserID, ids, window)
+	if err != nil {
+		HandleError(ctx, err)
+		return
+	}
+
+	var released []string
+	counts := map[string]int{}
+	for i, event := range batch {
+		if event != nil {
+			switch {
+			case !claimed[event.ClientEventID]:
+				results[i].Status = BatchStatusDuplicate
+			case c.pipeline.ProcessEvent(event) != nil:
+				results[i].Status = BatchStatusRejected
+				results[i].Error = "server is busy, retry later"
+				released = append(released, event.ClientEventID)
+			default:
+				results[i].Status = BatchStatusAccepted
+			}
+		}
+		counts[results[i].Status]++
+	}
+
+	if len(released) > 0 {
+		// Forget the IDs so the retry is not taken for a duplicate
+		if err := c.dedupe.Release(ctx, principal.UserID, released); err != nil {
+			HandleError(ctx, err)
+			return
+		}
+		ctx.Header("Retry-After", "1")
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"accepted":   counts[BatchStatusAccepted],
+		"duplicates": counts[BatchStatusDuplicate],
+		"invalid":    counts[BatchStatusInvalid],
+		"rejected":   counts[BatchStatusRejected],
+		"results":    results,
+	})
+}
+
+// validateUploadedEvent checks an uploaded event's ID and corrected
+// timestamp, and upgrades and validates its metadata, collecting every
+// problem. Timestamps ahead of the server were already clamped.
+func validateUploadedEvent(event *model.Event, window time.Duration) *events.ValidationError {
+	var problems []events.FieldError
+	switch {
+	case event.ClientEventID == "":
+		problems = append(problems, events.FieldError{Field: "clientEventId", Code: events.CodeRequired, Message: "clientEventId is required"})
+	case len(event.ClientEventID) > maxClientEventIDLength:
+		problems = append(problems, events.FieldError{Field: "clientEventId", Code: events.CodeTooLong,
+			Message: fmt.Sprintf("clientEventId must be at most %d bytes", maxClientEventIDLength)})
+	}
+	switch {
+	case event.ClientTimestamp.IsZero():
+		problems = append(problems, events.FieldError{Field: "timestamp", Code: events.CodeRequired, Message: "timestamp is required"})
+	case event.Timestamp.Before(event.ReceivedAt.Add(-window)):
+		// Older IDs may already be forgotten, so duplicates could slip in
+		problems = append(problems, events.FieldError{Field: "timestamp", Code: events.CodeOutOfRange, Message: "timestamp is too old"})
+	}
+
+	var invalid *events.ValidationError
+	if err := events.Normalize(event); errors.As(err, &invalid) {
+		problems = append(problems, invalid.Errors...)
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return &events.ValidationError{EventType: event.Type, Errors: problems}
+}
+
+// GetTimeline returns a page of the caller's events, oldest first. Optional
+// query parameters: type (comma separated), from and to (RFC 3339), cursor
+// from a previous page, and limit. Callers allowed to read other users'
+// events can pass userId.
+func (c *EventController) GetTimeline(ctx *gin.Context) {
+	userID, tenantID := currentUserID(ctx), auth.GetTenantID(ctx)
+	if requested := ctx.Query("userId"); requested != "" && requested != userID {
+		// The events are in the requested user's tenant
+		target, err := auth.UserTarget(ctx, requested)
+		if err != nil {
+			if errors.Is(err, auth.ErrUserNotFound) {
+				ctx.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+				return
+			}
+			HandleError(ctx, err)
+			return
+		}
+		if !auth.Authorize(ctx, auth.PermEventsRead, target) {
+			return
+		}
+		auth.RecordAudit(ctx, audit.Event{
+			Action:   audit.ActionAdminRead,
+			TargetID: requested,
+			Details:  map[string]string{"resource": "events"},
+		})
+		userID, tenantID = requested, target.TenantID
+	}
+
+	query := events.TimelineQuery{
+		UserID:   userID,
+		TenantID: tenantID,
+		Cursor:   ctx.Query("cursor"),
+	}
+	if types := ctx.Query("type"); types != "" {
+		query.Types = strings.Split(types, ",")
+	}
+
+	var err error
+	if from := ctx.Query("from"); from != "" {
+		if query.From, err = time.Parse(time.RFC3339, from); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from time, expected RFC 3339"})
+			return
+		}
+	}
+	if to := ctx.Query("to"); to != "" {
+		if query.To, err = time.Parse(time.RFC3339, to); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to time, expected RFC 3339"})
+			return
+		}
+	}
+	if limit := ctx.Query("limit"); limit != "" {
+		if query.Limit, err = strconv.ParseInt(limit, 10, 64); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+	}
+
+	page, err := c.store.Timeline(ctx, query)
+	if err != nil {
+		if errors.Is(err, events.ErrInvalidCursor) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+			return
+		}
+		HandleError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, page)
+}
@@ -0,0 +1,430 @@
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	auth "Tracker/Authatication"
+	"Tracker/internal/audit"
+	"Tracker/internal/database"
+	"Tracker/internal/model"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// OrganizationController handles organizations, teams and memberships
+// within the caller's tenant
+type OrganizationController struct {
+	store      OrganizationStore
+	activities ActivityStore
+}
+
+// NewOrganizationController creates a new organization controller
+func NewOrganizationController() *OrganizationController {
+	return &OrganizationController{
+		store: NewMongoOrganizationStore(
+			database.GetOrganizationsCollection(),
+			database.GetTeamsCollection(),
+			database.GetMembershipsCollection(),
+		),
+		activities: NewMongoActivityStore(database.GetCollection()),
+	}
+}
+
+// CreateTeamRequest represents the team creation request body
+type CreateTeamRequest struct {
+	Name string `json:"name" binding:"required,max=100"`
+}
+
+// AddTeamMemberRequest represents the team membership request body
+type AddTeamMemberRequest struct {
+	UserID string `json:"userId" binding:"required"`
+	Role   string `json:"role" binding:"required,oneof=manager member"`
+}
+
+// GetOrganization returns the caller's organization. The join code is only
+// included for owners.
+func (c *OrganizationController) GetOrganization(ctx *gin.Context) {
+	tenantID := auth.GetTenantID(ctx)
+	org, err := c.store.FindOrganization(ctx, tenantID)
+	if err != nil {
+		if errors.Is(err, ErrOrganizationNotFound) {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "Organization not found"})
+			return
+		}
+		HandleError(ctx, err)
+		return
+	}
+
+	role, err := c.store.MembershipRole(ctx, tenantID, "", currentUserID(ctx))
+	if err != nil {
+		HandleError(ctx, err)
+		return
+	}
+	if role != model.MemberRoleOwner {
+		org.JoinCode = ""
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"organization": org,
+		"role":         role,
+	})
+}
+
+// RotateJoinCode replaces the organization's join code (owner only)
+func (c *OrganizationController) RotateJoinCode(ctx *gin.Context) {
+	tenantID, ok := c.requireOwner(ctx)
+	if !ok {
+		return
+	}
+
+	joinCode, err := newJoinCode()
+	if err != nil {
+		HandleError(ctx, err)
+		return
+	}
+
+	if err := c.store.SetJoinCode(ctx, tenantID, joinCode); err != nil {
+		HandleError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"joinCode": joinCode})
+}
+
+// ListMembers lists organization-level memberships (owner only)
+func (c *OrganizationController) ListMembers(ctx *gin.Context) {
+	tenantID, ok := c.requireOwner(ctx)
+	if !ok {
+		return
+	}
+
+	memberships, err := c.store.Memberships(ctx, tenantID, "")
+	if err != nil {
+		HandleError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, memberships)
+}
+
+// CreateTeam creates a team in the caller's organization (owner only)
+func (c *OrganizationController) CreateTeam(ctx *gin.Context) {
+	tenantID, ok := c.requireOwner(ctx)
+	if !ok {
+		return
+	}
+
+	var req CreateTeamRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	team := model.Team{
+		TenantID:  tenantID,
+		Name:      req.Name,
+		CreatedAt: time.Now(),
+	}
+	if err := c.store.CreateTeam(ctx, &team); err != nil {
+		HandleError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, team)
+}
+
+// ListTeams lists the teams in the caller's organization
+func (c *OrganizationController) ListTeams(ctx *gin.Context) {
+	teams, err := c.store.ListTeams(ctx, auth.GetTenantID(ctx))
+	if err != nil {
+		HandleError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, teams)
+}
+
+// AddTeamMember adds a user of the same organization to a team or changes
+// their role there. Owners can add managers and members; team managers can
+// only add members and cannot change the membership of another manager.
+func (c *OrganizationController) AddTeamMember(ctx *gin.Context) {
+	tenantID := auth.GetTenantID(ctx)
+	team, ok := c.loadTeam(ctx, tenantID)
+	if !ok {
+		return
+	}
+
+	var req AddTeamMemberRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	callerRole, err := c.effectiveTeamRole(ctx, tenantID, team.ID.Hex(), currentUserID(ctx))
+	if err != nil {
+		HandleError(ctx, err)
+		return
+	}
+	if callerRole != model.MemberRoleOwner && !(callerRole == model.MemberRoleManager && req.Role == model.MemberRoleMember) {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return
+	}
+
+	// Only users of this organization can join its teams
+	if role, err := c.store.MembershipRole(ctx, tenantID, "", req.UserID); err != nil || role == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "User is not a member of this organization"})
+		return
+	}
+
+	if !c.canChangeMembership(ctx, tenantID, team.ID.Hex(), req.UserID, callerRole) {
+		return
+	}
+
+	previous, err := c.store.SetTeamRole(ctx, tenantID, team.ID.Hex(), req.UserID, req.Role)
+	if err != nil {
+		HandleError(ctx, err)
+		return
+	}
+
+	if previous != req.Role {
+		auth.RecordAudit(ctx, audit.Event{
+			Action:   audit.ActionTeamRoleChange,
+			TargetID: req.UserID,
+			Details:  map[string]string{"teamId": team.ID.Hex(), "from": previous, "to": req.Role},
+		})
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Team member saved successfully"})
+}
+
+// RemoveTeamMember removes a user from a team. Owners can remove anyone;
+// team managers can only remove members.
+func (c *OrganizationController) RemoveTeamMember(ctx *gin.Context) {
+	tenantID := auth.GetTenantID(ctx)
+	team, ok := c.loadTeam(ctx, tenantID)
+	if !ok {
+		return
+	}
+	userID := ctx.Param("userId")
+
+	callerRole, err := c.effectiveTeamRole(ctx, tenantID, team.ID.Hex(), currentUserID(ctx))
+	if err != nil {
+		HandleError(ctx, err)
+		return
+	}
+	if callerRole != model.MemberRoleOwner && callerRole != model.MemberRoleManager {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return
+	}
+
+	role, err := c.store.MembershipRole(ctx, tenantID, team.ID.Hex(), userID)
+	if err != nil {
+		HandleError(ctx, err)
+		return
+	}
+	if role == "" {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Team member not found"})
+		return
+	}
+	if !c.canChangeMembership(ctx, tenantID, team.ID.Hex(), userID, callerRole) {
+		return
+	}
+
+	if err := c.store.RemoveTeamMember(ctx, tenantID, team.ID.Hex(), userID); err != nil {
+		if errors.Is(err, ErrMembershipNotFound) {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "Team member not found"})
+			return
+		}
+		HandleError(ctx, err)
+		return
+	}
+
+	auth.RecordAudit(ctx, audit.Event{
+		Action:   audit.ActionTeamRoleChange,
+		TargetID: userID,
+		Details:  map[string]string{"teamId": team.ID.Hex(), "from": role, "to": ""},
+	})
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Team member removed successfully"})
+}
+
+// GetTeamSummary returns per-member activity aggregates for a team.
+// Managers of the team, organization owners and admins see totals only,
+// never the underlying activities or events.
+func (c *OrganizationController) GetTeamSummary(ctx *gin.Context) {
+	tenantID := auth.GetTenantID(ctx)
+	team, ok := c.loadTeam(ctx, tenantID)
+	if !ok {
+		return
+	}
+
+	// The route lets through anyone managing some team; admins may read
+	// every team, everyone else only a team they manage
+	if decision, ok := auth.GetDecision(ctx); !ok || decision.Condition != auth.ConditionAny {
+		callerRole, err := c.effectiveTeamRole(ctx, tenantID, team.ID.Hex(), currentUserID(ctx))
+		if err != nil {
+			HandleError(ctx, err)
+			return
+		}
+		if callerRole != model.MemberRoleOwner && callerRole != model.MemberRoleManager {
+			ctx.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+			return
+		}
+	}
+
+	members, err := c.store.Memberships(ctx, tenantID, team.ID.Hex())
+	if err != nil {
+		HandleError(ctx, err)
+		return
+	}
+	memberIDs := make([]string, 0, len(members))
+	for _, m := range members {
+		memberIDs = append(memberIDs, m.UserID)
+	}
+
+	since := summaryWindow(ctx)
+	summaries, err := c.activities.Summaries(ctx, tenantID, memberIDs, since)
+	if err != nil {
+		HandleError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"team":    team,
+		"since":   since,
+		"members": summaries,
+	})
+}
+
+// GetMemberSummary returns activity aggregates for one user of the
+// organization. The policy decides who may see them: the user themselves,
+// managers of one of their teams, and organization owners.
+func (c *OrganizationController) GetMemberSummary(ctx *gin.Context) {
+	userID := ctx.Param("userId")
+
+	// The target's tenant is the member's, not the caller's, so the tenant
+	// check in the policy engine means something
+	target, err := auth.UserTarget(ctx, userID)
+	if err != nil {
+		if errors.Is(err, auth.ErrUserNotFound) {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+			return
+		}
+		HandleError(ctx, err)
+		return
+	}
+	if !auth.Authorize(ctx, auth.PermAnalysesRead, target) {
+		return
+	}
+
+	since := summaryWindow(ctx)
+	summaries, err := c.activities.Summaries(ctx, target.TenantID, []string{userID}, since)
+	if err != nil {
+		HandleError(ctx, err)
+		return
+	}
+
+	summary := ActivitySummary{UserID: userID, Categories: []string{}}
+	if len(summaries) > 0 {
+		summary = summaries[0]
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"since":   since,
+		"summary": summary,
+	})
+}
+
+// summaryWindow returns the start of the ?days window (default 7, max 90)
+func summaryWindow(ctx *gin.Context) time.Time {
+	days, _ := strconv.Atoi(ctx.DefaultQuery("days", "7"))
+	if days < 1 || days > 90 {
+		days = 7
+	}
+	return time.Now().AddDate(0, 0, -days)
+}
+
+// currentUserID returns the authenticated user's ID
+func currentUserID(ctx *gin.Context) string {
+	userID, _ := auth.GetUserID(ctx)
+	return userID
+}
+
+// requireOwner checks the caller owns their organization and returns its tenant ID
+func (c *OrganizationController) requireOwner(ctx *gin.Context) (string, bool) {
+	tenantID := auth.GetTenantID(ctx)
+	role, err := c.store.MembershipRole(ctx, tenantID, "", currentUserID(ctx))
+	if err != nil {
+		HandleError(ctx, err)
+		return "", false
+	}
+	if role != model.MemberRoleOwner {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "Only organization owners can do this"})
+		return "", false
+	}
+	return tenantID, true
+}
+
+// loadTeam loads the :id team of the tenant, writing a response on failure
+func (c *OrganizationController) loadTeam(ctx *gin.Context, tenantID string) (*model.Team, bool) {
+	if _, err := primitive.ObjectIDFromHex(ctx.Param("id")); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
+		return nil, false
+	}
+
+	team, err := c.store.FindTeam(ctx, tenantID, ctx.Param("id"))
+	if err != nil {
+		if errors.Is(err, ErrTeamNotFound) {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "Team not found"})
+			return nil, false
+		}
+		HandleError(ctx, err)
+		return nil, false
+	}
+	return team, true
+}
+
+// effectiveTeamRole returns owner for organization owners, otherwise the
+// user's role in the team ("" if not a member)
+func (c *OrganizationController) effectiveTeamRole(ctx context.Context, tenantID, teamID, userID string) (string, error) {
+	role, err := c.store.MembershipRole(ctx, tenantID, "", userID)
+	if err != nil || role == model.MemberRoleOwner {
+		return role, err
+	}
+	return c.store.MembershipRole(ctx, tenantID, teamID, userID)
+}
+
+// canChangeMembership checks the caller may change a user's membership of
+// a team. Only owners can touch the membership of a manager, so managers
+// cannot demote or remove each other. It writes the response when not.
+func (c *OrganizationController) canChangeMembership(ctx *gin.Context, tenantID, teamID, userID, callerRole string) bool {
+	if callerRole == model.MemberRoleOwner {
+		return true
+	}
+	role, err := c.store.MembershipRole(ctx, tenantID, teamID, userID)
+	if err != nil {
+		HandleError(ctx, err)
+		return false
+	}
+	if role == model.MemberRoleManager {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "Only organization owners can change a manager's membership"})
+		return false
+	}
+	return true
+}
+
+// newJoinCode returns a random URL-safe join code
+func newJoinCode() (string, error) {
+	b := make([]byte, 9)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
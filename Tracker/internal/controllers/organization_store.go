@@ -0,0 +1,217 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"Tracker/internal/database"
+	"Tracker/internal/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	// ErrOrganizationNotFound is returned when the tenant has no organization
+	ErrOrganizationNotFound = errors.New("organization not found")
+	// ErrTeamNotFound is returned when the tenant has no team with the ID
+	ErrTeamNotFound = errors.New("team not found")
+	// ErrMembershipNotFound is returned when the user is not in the team
+	ErrMembershipNotFound = errors.New("membership not found")
+)
+
+// OrganizationStore persists organizations, their teams and memberships.
+// Every lookup is scoped to one tenant. Organization-level memberships
+// have no team ID.
+type OrganizationStore interface {
+	FindOrganization(ctx context.Context, tenantID string) (*model.Organization, error)
+	SetJoinCode(ctx context.Context, tenantID, joinCode string) error
+
+	CreateTeam(ctx context.Context, team *model.Team) error
+	ListTeams(ctx context.Context, tenantID string) ([]model.Team, error)
+	FindTeam(ctx context.Context, tenantID, teamID string) (*model.Team, error)
+
+	// Memberships lists the members of a team, or the organization-level
+	// memberships when teamID is empty
+	Memberships(ctx context.Context, tenantID, teamID string) ([]model.Membership, error)
+	// MembershipRole returns the user's role in a team, or in the
+	// organization when teamID is empty. It returns "" for non-members.
+	MembershipRole(ctx context.Context, tenantID, teamID, userID string) (string, error)
+	// SetTeamRole adds a user to a team or changes their role there and
+	// returns the previous role, "" if they were not a member
+	SetTeamRole(ctx context.Context, tenantID, teamID, userID, role string) (string, error)
+	RemoveTeamMember(ctx context.Context, tenantID, teamID, userID string) error
+}
+
+// MongoOrganizationStore is an OrganizationStore backed by the
+// organizations, teams and memberships collections
+type MongoOrganizationStore struct {
+	organizations *mongo.Collection
+	teams         *mongo.Collection
+	memberships   *mongo.Collection
+}
+
+// NewMongoOrganizationStore creates an organization store on top of the
+// given collections
+func NewMongoOrganizationStore(organizations, teams, memberships *mongo.Collection) *MongoOrganizationStore {
+	return &MongoOrganizationStore{
+		organizations: organizations,
+		teams:         teams,
+		memberships:   memberships,
+	}
+}
+
+// FindOrganization returns the organization of a tenant
+func (s *MongoOrganizationStore) FindOrganization(ctx context.Context, tenantID string) (*model.Organization, error) {
+	objectID, err := primitive.ObjectIDFromHex(tenantID)
+	if err != nil {
+		return nil, ErrOrganizationNotFound
+	}
+
+	var org model.Organization
+	if err := s.organizations.FindOne(ctx, bson.M{"_id": objectID}).Decode(&org); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrOrganizationNotFound
+		}
+		return nil, err
+	}
+	return &org, nil
+}
+
+// SetJoinCode replaces the organization's join code
+func (s *MongoOrganizationStore) SetJoinCode(ctx context.Context, tenantID, joinCode string) error {
+	objectID, err := primitive.ObjectIDFromHex(tenantID)
+	if err != nil {
+		return ErrOrganizationNotFound
+	}
+
+	result, err := s.organizations.UpdateOne(ctx,
+		bson.M{"_id": objectID},
+		bson.M{"$set": bson.M{"joinCode": joinCode, "updatedAt": time.Now()}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrOrganizationNotFound
+	}
+	return nil
+}
+
+// CreateTeam inserts a team and sets its ID
+func (s *MongoOrganizationStore) CreateTeam(ctx context.Context, team *model.Team) error {
+	result, err := s.teams.InsertOne(ctx, team)
+	if err != nil {
+		return err
+	}
+	team.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// ListTeams returns the teams of a tenant
+func (s *MongoOrganizationStore) ListTeams(ctx context.Context, tenantID string) ([]model.Team, error) {
+	cursor, err := s.teams.Find(ctx, database.TenantFilter(tenantID, bson.M{}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	teams := make([]model.Team, 0)
+	if err := cursor.All(ctx, &teams); err != nil {
+		return nil, err
+	}
+	return teams, nil
+}
+
+// FindTeam returns a team of a tenant
+func (s *MongoOrganizationStore) FindTeam(ctx context.Context, tenantID, teamID string) (*model.Team, error) {
+	objectID, err := primitive.ObjectIDFromHex(teamID)
+	if err != nil {
+		return nil, ErrTeamNotFound
+	}
+
+	var team model.Team
+	err = s.teams.FindOne(ctx, database.TenantFilter(tenantID, bson.M{"_id": objectID})).Decode(&team)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrTeamNotFound
+		}
+		return nil, err
+	}
+	return &team, nil
+}
+
+// membershipFilter matches the memberships of a team, or the
+// organization-level ones when teamID is empty
+func membershipFilter(tenantID, teamID string) bson.M {
+	if teamID == "" {
+		return bson.M{"tenantId": tenantID, "teamId": bson.M{"$exists": false}}
+	}
+	return bson.M{"tenantId": tenantID, "teamId": teamID}
+}
+
+// Memberships lists the memberships of a team or of the organization
+func (s *MongoOrganizationStore) Memberships(ctx context.Context, tenantID, teamID string) ([]model.Membership, error) {
+	cursor, err := s.memberships.Find(ctx, membershipFilter(tenantID, teamID))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	memberships := make([]model.Membership, 0)
+	if err := cursor.All(ctx, &memberships); err != nil {
+		return nil, err
+	}
+	return memberships, nil
+}
+
+// MembershipRole returns the user's role in a team or in the organization
+func (s *MongoOrganizationStore) MembershipRole(ctx context.Context, tenantID, teamID, userID string) (string, error) {
+	filter := membershipFilter(tenantID, teamID)
+	filter["userId"] = userID
+
+	var membership model.Membership
+	if err := s.memberships.FindOne(ctx, filter).Decode(&membership); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return "", nil
+		}
+		return "", err
+	}
+	return membership.Role, nil
+}
+
+// SetTeamRole upserts a team membership
+func (s *MongoOrganizationStore) SetTeamRole(ctx context.Context, tenantID, teamID, userID, role string) (string, error) {
+	var previous model.Membership
+	err := s.memberships.FindOneAndUpdate(ctx,
+		bson.M{"tenantId": tenantID, "teamId": teamID, "userId": userID},
+		bson.M{
+			"$set":         bson.M{"role": role},
+			"$setOnInsert": bson.M{"createdAt": time.Now()},
+		},
+		options.FindOneAndUpdate().SetUpsert(true),
+	).Decode(&previous)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return "", err
+	}
+	return previous.Role, nil
+}
+
+// RemoveTeamMember deletes a team membership
+func (s *MongoOrganizationStore) RemoveTeamMember(ctx context.Context, tenantID, teamID, userID string) error {
+	result, err := s.memberships.DeleteOne(ctx, bson.M{
+		"tenantId": tenantID,
+		"teamId":   teamID,
+		"userId":   userID,
+	})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrMembershipNotFound
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	auth "Tracker/Authatication"
+	"Tracker/internal/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+// testOrganization creates the acme organization: olivia owns it, alice and
+// mike manage the core team, bob is on the team and carol is not
+func testOrganization() (*OrganizationController, *memoryOrganizationStore, *memoryActivityStore, string) {
+	store := newMemoryOrganizationStore()
+	activities := newMemoryActivityStore()
+
+	store.organizations["acme"] = model.Organization{Name: "Acme", JoinCode: "initial-code"}
+	for _, userID := range []string{"alice", "mike", "bob", "carol"} {
+		store.join("acme", "", userID, model.MemberRoleMember)
+	}
+	store.join("acme", "", "olivia", model.MemberRoleOwner)
+
+	teamID := store.addTeam("acme", "core")
+	store.join("acme", teamID, "alice", model.MemberRoleManager)
+	store.join("acme", teamID, "mike", model.MemberRoleManager)
+	store.join("acme", teamID, "bob", model.MemberRoleMember)
+
+	controller := &OrganizationController{store: store, activities: activities}
+	return controller, store, activities, teamID
+}
+
+func TestJoinCode(t *testing.T) {
+	controller, store, _, _ := testOrganization()
+
+	tests := []struct {
+		name   string
+		caller string
+		want   string
+	}{
+		{"owner", "olivia", "initial-code"},
+		{"member", "bob", ""},
+	}
+	for _, tt := range tests {
+		rec := serve(sessionUser(tt.caller, "acme"), http.MethodGet, "/organization", "/organization", nil, controller.GetOrganization)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", tt.name, rec.Code, rec.Body)
+		}
+		var body struct {
+			Organization model.Organization `json:"organization"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		if body.Organization.JoinCode != tt.want {
+			t.Errorf("%s: join code %q, want %q", tt.name, body.Organization.JoinCode, tt.want)
+		}
+	}
+
+	rec := serve(sessionUser("bob", "acme"), http.MethodPost, "/organization/join-code", "/organization/join-code", nil, controller.RotateJoinCode)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("member rotating the join code: status %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if store.organizations["acme"].JoinCode != "initial-code" {
+		t.Fatal("a member rotated the join code")
+	}
+
+	rec = serve(sessionUser("olivia", "acme"), http.MethodPost, "/organization/join-code", "/organization/join-code", nil, controller.RotateJoinCode)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("owner rotating the join code: status %d: %s", rec.Code, rec.Body)
+	}
+	var body struct {
+		JoinCode string `json:"joinCode"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.JoinCode == "" || body.JoinCode == "initial-code" || store.organizations["acme"].JoinCode != body.JoinCode {
+		t.Errorf("join code %q returned, %q stored", body.JoinCode, store.organizations["acme"].JoinCode)
+	}
+}
+
+func TestAddTeamMember(t *testing.T) {
+	tests := []struct {
+		name     string
+		caller   string
+		userID   string
+		role     string
+		want     int
+		wantRole string
+	}{
+		{"owner adds a manager", "olivia", "carol", model.MemberRoleManager, http.StatusOK, model.MemberRoleManager},
+		{"owner demotes a manager", "olivia", "mike", model.MemberRoleMember, http.StatusOK, model.MemberRoleMember},
+		{"manager adds a member", "alice", "carol", model.MemberRoleMember, http.StatusOK, model.MemberRoleMember},
+		{"manager adds a manager", "alice", "carol", model.MemberRoleManager, http.StatusForbidden, ""},
+		{"manager demotes a manager", "alice", "mike", model.MemberRoleMember, http.StatusForbidden, model.MemberRoleManager},
+		{"member adds a member", "bob", "carol", model.MemberRoleMember, http.StatusForbidden, ""},
+		{"user outside the organization", "olivia", "erin", model.MemberRoleMember, http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller, store, _, teamID := testOrganization()
+
+			rec := serve(sessionUser(tt.caller, "acme"), http.MethodPost, "/teams/:id/members", "/teams/"+teamID+"/members",
+				AddTeamMemberRequest{UserID: tt.userID, Role: tt.role}, controller.AddTeamMember)
+			if rec.Code != tt.want {
+				t.Errorf("status %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			if got := store.role("acme", teamID, tt.userID); got != tt.wantRole {
+				t.Errorf("team role %q, want %q", got, tt.wantRole)
+			}
+		})
+	}
+}
+
+func TestRemoveTeamMember(t *testing.T) {
+	tests := []struct {
+		name     string
+		caller   string
+		userID   string
+		want     int
+		wantRole string
+	}{
+		{"owner removes a manager", "olivia", "mike", http.StatusOK, ""},
+		{"manager removes a member", "alice", "bob", http.StatusOK, ""},
+		{"manager removes a manager", "alice", "mike", http.StatusForbidden, model.MemberRoleManager},
+		{"member removes a member", "bob", "bob", http.StatusForbidden, model.MemberRoleMember},
+		{"not on the team", "olivia", "carol", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller, store, _, teamID := testOrganization()
+
+			rec := serve(sessionUser(tt.caller, "acme"), http.MethodDelete, "/teams/:id/members/:userId", "/teams/"+teamID+"/members/"+tt.userID,
+				nil, controller.RemoveTeamMember)
+			if rec.Code != tt.want {
+				t.Errorf("status %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			if got := store.role("acme", teamID, tt.userID); got != tt.wantRole {
+				t.Errorf("team role %q, want %q", got, tt.wantRole)
+			}
+		})
+	}
+}
+
+func TestGetTeamSummary(t *testing.T) {
+	controller, _, activities, teamID := testOrganization()
+	activities.add("bob", "acme", "focus", 10)
+	activities.add("bob", "acme", "meeting", 20)
+	activities.add("carol", "acme", "focus", 30)
+	canReadTeams := auth.RequirePermission(auth.PermAnalysesRead, auth.ConditionTeam)
+
+	tests := []struct {
+		name     string
+		caller   *auth.Principal
+		handlers []gin.HandlerFunc
+		want     int
+	}{
+		{"manager", sessionUser("alice", "acme"), []gin.HandlerFunc{controller.GetTeamSummary}, http.StatusOK},
+		{"owner", sessionUser("olivia", "acme"), []gin.HandlerFunc{controller.GetTeamSummary}, http.StatusOK},
+		{"admin through the route check", sessionAdmin("root", "acme"), []gin.HandlerFunc{canReadTeams, controller.GetTeamSummary}, http.StatusOK},
+		{"member", sessionUser("bob", "acme"), []gin.HandlerFunc{controller.GetTeamSummary}, http.StatusForbidden},
+		{"another tenant", sessionUser("alice", "globex"), []gin.HandlerFunc{controller.GetTeamSummary}, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(tt.caller, http.MethodGet, "/teams/:id/summary", "/teams/"+teamID+"/summary", nil, tt.handlers...)
+			if rec.Code != tt.want {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+
+			var body struct {
+				Members []map[string]json.RawMessage `json:"members"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			// Only bob has activities in the window, and only totals are exposed
+			if len(body.Members) != 1 {
+				t.Fatalf("members %d, want 1", len(body.Members))
+			}
+			for key := range body.Members[0] {
+				switch key {
+				case "_id", "totalActivities", "totalDuration", "categories":
+				default:
+					t.Errorf("summary exposes %q", key)
+				}
+			}
+			var summary ActivitySummary
+			raw, _ := json.Marshal(body.Members[0])
+			if err := json.Unmarshal(raw, &summary); err != nil {
+				t.Fatal(err)
+			}
+			if summary.UserID != "bob" || summary.TotalActivities != 2 || summary.TotalDuration != 30 {
+				t.Errorf("summary = %+v", summary)
+			}
+		})
+	}
+}
@@ -0,0 +1,361 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"time"
+
+	auth "Tracker/Authatication"
+	"Tracker/internal/model"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// memoryActivityStore is an ActivityStore kept in memory
+type memoryActivityStore struct {
+	mu         sync.Mutex
+	activities map[primitive.ObjectID]model.Activity
+}
+
+func newMemoryActivityStore() *memoryActivityStore {
+	return &memoryActivityStore{activities: make(map[primitive.ObjectID]model.Activity)}
+}
+
+// inScope reports whether an activity is visible in a scope, following the
+// filter MongoActivityStore builds
+func inScope(scope ActivityScope, activity model.Activity) bool {
+	if scope.UserID != "" && activity.UserID != scope.UserID {
+		return false
+	}
+	if scope.AllTenants && scope.TenantID == "" {
+		return true
+	}
+	return activity.TenantID == scope.TenantID
+}
+
+// add stores an activity of a user and returns its ID
+func (s *memoryActivityStore) add(userID, tenantID, category string, duration float64) primitive.ObjectID {
+	activity := model.Activity{
+		Title:     category + " work",
+		Category:  category,
+		Duration:  duration,
+		UserID:    userID,
+		TenantID:  tenantID,
+		CreatedAt: time.Now(),
+	}
+	_ = s.Create(context.Background(), &activity)
+	return activity.ID
+}
+
+// get returns a stored activity regardless of scope
+func (s *memoryActivityStore) get(id primitive.ObjectID) (model.Activity, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	activity, ok := s.activities[id]
+	return activity, ok
+}
+
+func (s *memoryActivityStore) Create(ctx context.Context, activity *model.Activity) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	activity.ID = primitive.NewObjectID()
+	s.activities[activity.ID] = *activity
+	return nil
+}
+
+func (s *memoryActivityStore) List(ctx context.Context, scope ActivityScope, skip, limit int64) ([]model.Activity, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var matched []model.Activity
+	for _, activity := range s.activities {
+		if inScope(scope, activity) {
+			matched = append(matched, activity)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool { return matched[i].ID.Hex() < matched[j].ID.Hex() })
+
+	if skip >= int64(len(matched)) {
+		return nil, nil
+	}
+	matched = matched[skip:]
+	if limit < int64(len(matched)) {
+		matched = matched[:limit]
+	}
+	return matched, nil
+}
+
+func (s *memoryActivityStore) Find(ctx context.Context, scope ActivityScope, id primitive.ObjectID) (*model.Activity, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	activity, ok := s.activities[id]
+	if !ok || !inScope(scope, activity) {
+		return nil, ErrActivityNotFound
+	}
+	return &activity, nil
+}
+
+func (s *memoryActivityStore) Update(ctx context.Context, scope ActivityScope, id primitive.ObjectID, update ActivityUpdate) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	activity, ok := s.activities[id]
+	if !ok || !inScope(scope, activity) {
+		return ErrActivityNotFound
+	}
+	activity.Title = update.Title
+	activity.Description = update.Description
+	activity.Category = update.Category
+	activity.Duration = update.Duration
+	activity.Date = update.Date
+	activity.UpdatedAt = time.Now()
+	s.activities[id] = activity
+	return nil
+}
+
+func (s *memoryActivityStore) Delete(ctx context.Context, scope ActivityScope, id primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	activity, ok := s.activities[id]
+	if !ok || !inScope(scope, activity) {
+		return ErrActivityNotFound
+	}
+	delete(s.activities, id)
+	return nil
+}
+
+func (s *memoryActivityStore) Summaries(ctx context.Context, tenantID string, userIDs []string, since time.Time) ([]ActivitySummary, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	byUser := make(map[string]*ActivitySummary)
+	for _, activity := range s.activities {
+		if activity.TenantID != tenantID || activity.CreatedAt.Before(since) {
+			continue
+		}
+		for _, userID := range userIDs {
+			if activity.UserID != userID {
+				continue
+			}
+			summary, ok := byUser[userID]
+			if !ok {
+				summary = &ActivitySummary{UserID: userID, Categories: []string{}}
+				byUser[userID] = summary
+			}
+			summary.TotalActivities++
+			summary.TotalDuration += activity.Duration
+			if !containsString(summary.Categories, activity.Category) {
+				summary.Categories = append(summary.Categories, activity.Category)
+			}
+		}
+	}
+
+	summaries := make([]ActivitySummary, 0, len(byUser))
+	for _, summary := range byUser {
+		summaries = append(summaries, *summary)
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].UserID < summaries[j].UserID })
+	return summaries, nil
+}
+
+// containsString reports whether values holds value
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// sessionUser is a principal logged in with a password
+func sessionUser(userID, tenantID string) *auth.Principal {
+	return &auth.Principal{UserID: userID, TenantID: tenantID, Role: auth.RoleUser, Method: auth.AuthMethodJWT}
+}
+
+// sessionAdmin is a platform admin logged in with a password
+func sessionAdmin(userID, tenantID string) *auth.Principal {
+	return &auth.Principal{UserID: userID, TenantID: tenantID, Role: auth.RoleAdmin, Method: auth.AuthMethodJWT}
+}
+
+// serve runs handlers on a route pattern for a request to path, with the
+// caller set in the context the way AuthMiddleware sets it
+func serve(caller *auth.Principal, method, pattern, path string, body interface{}, handlers ...gin.HandlerFunc) *httptest.ResponseRecorder {
+	identify := func(c *gin.Context) {
+		c.Set(auth.ContextPrincipalKey, caller)
+		c.Set(auth.ContextUserIDKey, caller.UserID)
+		c.Set(auth.ContextRoleKey, caller.Role)
+		c.Set(auth.ContextTenantIDKey, caller.TenantID)
+	}
+	router := gin.New()
+	router.Handle(method, pattern, append([]gin.HandlerFunc{identify}, handlers...)...)
+
+	var reader io.Reader
+	if body != nil {
+		payload, _ := json.Marshal(body)
+		reader = bytes.NewReader(payload)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+// memoryOrganizationStore is an OrganizationStore kept in memory. Tenant
+// IDs are used as organization keys as they are.
+type memoryOrganizationStore struct {
+	mu            sync.Mutex
+	organizations map[string]model.Organization
+	teams         map[primitive.ObjectID]model.Team
+	memberships   []model.Membership
+}
+
+func newMemoryOrganizationStore() *memoryOrganizationStore {
+	return &memoryOrganizationStore{
+		organizations: make(map[string]model.Organization),
+		teams:         make(map[primitive.ObjectID]model.Team),
+	}
+}
+
+// addTeam stores a team of a tenant and returns its ID
+func (s *memoryOrganizationStore) addTeam(tenantID, name string) string {
+	team := model.Team{TenantID: tenantID, Name: name}
+	_ = s.CreateTeam(context.Background(), &team)
+	return team.ID.Hex()
+}
+
+// join adds a membership of a team, or of the organization when teamID is empty
+func (s *memoryOrganizationStore) join(tenantID, teamID, userID, role string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.memberships = append(s.memberships, model.Membership{TenantID: tenantID, TeamID: teamID, UserID: userID, Role: role})
+}
+
+// role returns a membership role without going through the interface
+func (s *memoryOrganizationStore) role(tenantID, teamID, userID string) string {
+	role, _ := s.MembershipRole(context.Background(), tenantID, teamID, userID)
+	return role
+}
+
+func (s *memoryOrganizationStore) FindOrganization(ctx context.Context, tenantID string) (*model.Organization, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	org, ok := s.organizations[tenantID]
+	if !ok {
+		return nil, ErrOrganizationNotFound
+	}
+	return &org, nil
+}
+
+func (s *memoryOrganizationStore) SetJoinCode(ctx context.Context, tenantID, joinCode string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	org, ok := s.organizations[tenantID]
+	if !ok {
+		return ErrOrganizationNotFound
+	}
+	org.JoinCode = joinCode
+	s.organizations[tenantID] = org
+	return nil
+}
+
+func (s *memoryOrganizationStore) CreateTeam(ctx context.Context, team *model.Team) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	team.ID = primitive.NewObjectID()
+	s.teams[team.ID] = *team
+	return nil
+}
+
+func (s *memoryOrganizationStore) ListTeams(ctx context.Context, tenantID string) ([]model.Team, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	teams := make([]model.Team, 0)
+	for _, team := range s.teams {
+		if team.TenantID == tenantID {
+			teams = append(teams, team)
+		}
+	}
+	sort.Slice(teams, func(i, j int) bool { return teams[i].ID.Hex() < teams[j].ID.Hex() })
+	return teams, nil
+}
+
+func (s *memoryOrganizationStore) FindTeam(ctx context.Context, tenantID, teamID string) (*model.Team, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	objectID, err := primitive.ObjectIDFromHex(teamID)
+	if err != nil {
+		return nil, ErrTeamNotFound
+	}
+	team, ok := s.teams[objectID]
+	if !ok || team.TenantID != tenantID {
+		return nil, ErrTeamNotFound
+	}
+	return &team, nil
+}
+
+func (s *memoryOrganizationStore) Memberships(ctx context.Context, tenantID, teamID string) ([]model.Membership, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	memberships := make([]model.Membership, 0)
+	for _, membership := range s.memberships {
+		if membership.TenantID == tenantID && membership.TeamID == teamID {
+			memberships = append(memberships, membership)
+		}
+	}
+	return memberships, nil
+}
+
+func (s *memoryOrganizationStore) MembershipRole(ctx context.Context, tenantID, teamID, userID string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i := s.find(tenantID, teamID, userID); i >= 0 {
+		return s.memberships[i].Role, nil
+	}
+	return "", nil
+}
+
+func (s *memoryOrganizationStore) SetTeamRole(ctx context.Context, tenantID, teamID, userID, role string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.find(tenantID, teamID, userID)
+	if i < 0 {
+		s.memberships = append(s.memberships, model.Membership{TenantID: tenantID, TeamID: teamID, UserID: userID, Role: role})
+		return "", nil
+	}
+	previous := s.memberships[i].Role
+	s.memberships[i].Role = role
+	return previous, nil
+}
+
+func (s *memoryOrganizationStore) RemoveTeamMember(ctx context.Context, tenantID, teamID, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.find(tenantID, teamID, userID)
+	if i < 0 || teamID == "" {
+		return ErrMembershipNotFound
+	}
+	s.memberships = append(s.memberships[:i], s.memberships[i+1:]...)
+	return nil
+}
+
+// find returns the index of a membership or -1; the caller holds mu
+func (s *memoryOrganizationStore) find(tenantID, teamID, userID string) int {
+	for i, membership := range s.memberships {
+		if membership.TenantID == tenantID && membership.TeamID == teamID && membership.UserID == userID {
+			return i
+		}
+	}
+	return -1
+}
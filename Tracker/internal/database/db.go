@@ -7,10 +7,25 @@ import (
 
 	"Tracker/internal/config"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// Auxiliary collection names
+const (
+	RefreshTokensCollection = "refresh_tokens"
+	RevokedTokensCollection = "revoked_tokens"
+	APIKeysCollection       = "api_keys"
+	OrganizationsCollection = "organizations"
+	TeamsCollection         = "teams"
+	MembershipsCollection   = "memberships"
+	LoginAttemptsCollection = "login_attempts"
+	AuditLogCollection      = "audit_log"
+	EventsCollection        = "events"
+	EventIDsCollection      = "event_ids"
+)
+
 var (
 	client     *mongo.Client
 	database   *mongo.Database
@@ -61,6 +76,82 @@ func GetCollection() *mongo.Collection {
 	return collection
 }
 
+// GetCollectionByName returns a named collection from the database
+func GetCollectionByName(name string) *mongo.Collection {
+	return GetDatabase().Collection(name)
+}
+
+// GetUsersCollection returns the users collection
+func GetUsersCollection() *mongo.Collection {
+	return GetCollectionByName(config.GetMongoUsersCollectionName())
+}
+
+// GetRefreshTokensCollection returns the collection of issued refresh tokens
+func GetRefreshTokensCollection() *mongo.Collection {
+	return GetCollectionByName(RefreshTokensCollection)
+}
+
+// GetRevokedTokensCollection returns the access token jti denylist collection
+func GetRevokedTokensCollection() *mongo.Collection {
+	return GetCollectionByName(RevokedTokensCollection)
+}
+
+// GetAPIKeysCollection returns the personal API keys collection
+func GetAPIKeysCollection() *mongo.Collection {
+	return GetCollectionByName(APIKeysCollection)
+}
+
+// GetOrganizationsCollection returns the organizations (tenants) collection
+func GetOrganizationsCollection() *mongo.Collection {
+	return GetCollectionByName(OrganizationsCollection)
+}
+
+// GetTeamsCollection returns the teams collection
+func GetTeamsCollection() *mongo.Collection {
+	return GetCollectionByName(TeamsCollection)
+}
+
+// GetMembershipsCollection returns the organization and team memberships collection
+func GetMembershipsCollection() *mongo.Collection {
+	return GetCollectionByName(MembershipsCollection)
+}
+
+// GetAuditLogCollection returns the append-only audit log collection
+func GetAuditLogCollection() *mongo.Collection {
+	return GetCollectionByName(AuditLogCollection)
+}
+
+// GetEventsCollection returns the raw tracking events collection
+func GetEventsCollection() *mongo.Collection {
+	return GetCollectionByName(EventsCollection)
+}
+
+// GetEventIDsCollection returns the client event IDs used to drop
+// duplicate uploads
+func GetEventIDsCollection() *mongo.Collection {
+	return GetCollectionByName(EventIDsCollection)
+}
+
+// GetLoginAttemptsCollection returns the login failure counters collection
+func GetLoginAttemptsCollection() *mongo.Collection {
+	return GetCollectionByName(LoginAttemptsCollection)
+}
+
+// TenantFilter adds the tenant to a query filter. Documents written before
+// tenants existed have no tenantId and only match the empty tenant.
+func TenantFilter(tenantID string, filter bson.M) bson.M {
+	scoped := bson.M{}
+	for k, v := range filter {
+		scoped[k] = v
+	}
+	if tenantID == "" {
+		scoped["tenantId"] = bson.M{"$in": bson.A{nil, ""}}
+	} else {
+		scoped["tenantId"] = tenantID
+	}
+	return scoped
+}
+
 // GetDatabase returns the database instance
 func GetDatabase() *mongo.Database {
 	if database == nil {
@@ -0,0 +1,92 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// duplicateKeyCode is MongoDB's error code for a unique index violation
+const duplicateKeyCode = 11000
+
+// MongoDedupeStore remembers client event IDs so a retried upload is only
+// stored once. IDs are scoped to the user and expire with a TTL index.
+type MongoDedupeStore struct {
+	collection *mongo.Collection
+}
+
+// NewMongoDedupeStore creates a dedupe store on top of the given collection
+func NewMongoDedupeStore(collection *mongo.Collection) *MongoDedupeStore {
+	return &MongoDedupeStore{collection: collection}
+}
+
+// EnsureIndexes creates the TTL index that forgets old IDs
+func (s *MongoDedupeStore) EnsureIndexes(ctx context.Context) error {
+	_, err := s.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+// Claim records the user's client event IDs and reports which of them were
+// new. IDs claimed by an earlier call map to false; an ID repeated within
+// ids is claimed once.
+func (s *MongoDedupeStore) Claim(ctx context.Context, userID string, ids []string, ttl time.Duration) (map[string]bool, error) {
+	claimed := make(map[string]bool, len(ids))
+	if len(ids) == 0 {
+		return claimed, nil
+	}
+
+	expiresAt := time.Now().Add(ttl)
+	docs := make([]interface{}, 0, len(ids))
+	keys := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if _, seen := claimed[id]; seen {
+			continue
+		}
+		claimed[id] = true
+		docs = append(docs, bson.M{"_id": dedupeKey(userID, id), "expiresAt": expiresAt})
+		keys = append(keys, id)
+	}
+
+	_, err := s.collection.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	if err == nil {
+		return claimed, nil
+	}
+
+	var bulkErr mongo.BulkWriteException
+	if !errors.As(err, &bulkErr) || bulkErr.WriteConcernError != nil {
+		return nil, err
+	}
+	for _, writeErr := range bulkErr.WriteErrors {
+		if writeErr.Code != duplicateKeyCode {
+			return nil, err
+		}
+		claimed[keys[writeErr.Index]] = false
+	}
+	return claimed, nil
+}
+
+// Release forgets IDs whose events could not be accepted after all, so the
+// client can retry them
+func (s *MongoDedupeStore) Release(ctx context.Context, userID string, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	keys := make(bson.A, len(ids))
+	for i, id := range ids {
+		keys[i] = dedupeKey(userID, id)
+	}
+	_, err := s.collection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": keys}})
+	return err
+}
+
+// dedupeKey scopes a client event ID to its user
+func dedupeKey(userID, id string) string {
+	return userID + "/" + id
+}
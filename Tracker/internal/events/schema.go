@@ -0,0 +1,347 @@
+package events
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"Tracker/internal/eventschema"
+	"Tracker/internal/model"
+)
+
+// FieldType is the JSON type a metadata field must have
+type FieldType int
+
+// Metadata field types
+const (
+	FieldNumber FieldType = iota + 1
+	FieldString
+	FieldBool
+)
+
+// String returns the JSON name of the type
+func (t FieldType) String() string {
+	switch t {
+	case FieldNumber:
+		return "number"
+	case FieldString:
+		return "string"
+	case FieldBool:
+		return "boolean"
+	default:
+		return "unknown"
+	}
+}
+
+// Range bounds a number field, inclusive
+type Range struct {
+	Min float64
+	Max float64
+}
+
+// Field describes one metadata field
+type Field struct {
+	Type     FieldType
+	Required bool
+	// Range bounds number fields. Nil means any number.
+	Range *Range
+	// MaxLength bounds string fields. Zero means any length.
+	MaxLength int
+}
+
+// Schema describes the metadata of one event type
+type Schema struct {
+	Fields map[string]Field
+	// Strict rejects metadata fields the schema does not list
+	Strict bool
+}
+
+// Field error codes
+const (
+	CodeUnknownType  = "unknown_type"
+	CodeRequired     = "required"
+	CodeWrongType    = "wrong_type"
+	CodeOutOfRange   = "out_of_range"
+	CodeTooLong      = "too_long"
+	CodeUnknownField = "unknown_field"
+	// CodeMalformed is used for events that are not valid JSON objects
+	CodeMalformed = "malformed"
+	// CodeUnsupportedVersion is used for unknown metadata schema versions
+	CodeUnsupportedVersion = "unsupported_version"
+)
+
+// FieldError is one problem with an event
+type FieldError struct {
+	Field   string `json:"field,omitempty"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every problem found with an event
+type ValidationError struct {
+	EventType string       `json:"eventType"`
+	Errors    []FieldError `json:"errors"`
+}
+
+// Error summarizes the problems in one line
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, fieldErr := range e.Errors {
+		messages[i] = fieldErr.Message
+	}
+	return fmt.Sprintf("invalid %q event: %s", e.EventType, strings.Join(messages, "; "))
+}
+
+// Registry maps event types to their metadata schemas
+type Registry struct {
+	mu      sync.RWMutex
+	schemas map[string]Schema
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{schemas: make(map[string]Schema)}
+}
+
+// Register adds the schema for an event type. Types cannot be registered
+// twice, so a custom type cannot silently replace a built-in one.
+func (r *Registry) Register(eventType string, schema Schema) error {
+	if eventType == "" {
+		return errors.New("event type is required")
+	}
+	for name, field := range schema.Fields {
+		if field.Type < FieldNumber || field.Type > FieldBool {
+			return fmt.Errorf("field %q of event type %q has no type", name, eventType)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.schemas[eventType]; exists {
+		return fmt.Errorf("event type %q is already registered", eventType)
+	}
+	r.schemas[eventType] = schema
+	return nil
+}
+
+// Lookup returns the schema registered for an event type
+func (r *Registry) Lookup(eventType string) (Schema, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	schema, ok := r.schemas[eventType]
+	return schema, ok
+}
+
+// Types returns the registered event types in order
+func (r *Registry) Types() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	types := make([]string, 0, len(r.schemas))
+	for eventType := range r.schemas {
+		types = append(types, eventType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// Validate checks the event's metadata against the schema of its type. It
+// returns a *ValidationError listing every problem.
+func (r *Registry) Validate(event *model.Event) error {
+	schema, ok := r.Lookup(event.Type)
+	if !ok {
+		return &ValidationError{
+			EventType: event.Type,
+			Errors: []FieldError{{
+				Field:   "type",
+				Code:    CodeUnknownType,
+				Message: fmt.Sprintf("unknown event type %q", event.Type),
+			}},
+		}
+	}
+
+	var problems []FieldError
+	names := make([]string, 0, len(schema.Fields))
+	for name := range schema.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		field := schema.Fields[name]
+		value, present := event.Metadata[name]
+		if !present || value == nil {
+			if field.Required {
+				problems = append(problems, fieldError(name, CodeRequired, "is required"))
+			}
+			continue
+		}
+		if problem := field.check(name, value); problem != nil {
+			problems = append(problems, *problem)
+		}
+	}
+
+	if schema.Strict {
+		unknown := make([]string, 0)
+		for name := range event.Metadata {
+			if _, ok := schema.Fields[name]; !ok {
+				unknown = append(unknown, name)
+			}
+		}
+		sort.Strings(unknown)
+		for _, name := range unknown {
+			problems = append(problems, fieldError(name, CodeUnknownField, "is not allowed"))
+		}
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{EventType: event.Type, Errors: problems}
+	}
+	return nil
+}
+
+// check validates a present value against the field
+func (f Field) check(name string, value interface{}) *FieldError {
+	switch f.Type {
+	case FieldNumber:
+		number, ok := value.(float64)
+		if !ok {
+			return wrongType(name, f.Type)
+		}
+		if f.Range != nil && (number < f.Range.Min || number > f.Range.Max) {
+			problem := fieldError(name, CodeOutOfRange, fmt.Sprintf("must be between %g and %g", f.Range.Min, f.Range.Max))
+			return &problem
+		}
+	case FieldString:
+		text, ok := value.(string)
+		if !ok {
+			return wrongType(name, f.Type)
+		}
+		if f.Required && text == "" {
+			problem := fieldError(name, CodeRequired, "must not be empty")
+			return &problem
+		}
+		if f.MaxLength > 0 && len(text) > f.MaxLength {
+			problem := fieldError(name, CodeTooLong, fmt.Sprintf("must be at most %d bytes", f.MaxLength))
+			return &problem
+		}
+	case FieldBool:
+		if _, ok := value.(bool); !ok {
+			return wrongType(name, f.Type)
+		}
+	}
+	return nil
+}
+
+// fieldError builds an error for a metadata field
+func fieldError(name, code, message string) FieldError {
+	return FieldError{Field: "metadata." + name, Code: code, Message: "metadata." + name + " " + message}
+}
+
+// wrongType builds a type mismatch error
+func wrongType(name string, want FieldType) *FieldError {
+	problem := fieldError(name, CodeWrongType, "must be a "+want.String())
+	return &problem
+}
+
+// Limits shared by the built-in schemas
+var (
+	coordinateRange  = &Range{Min: -100000, Max: 100000}
+	scrollDeltaRange = &Range{Min: -1000000, Max: 1000000}
+)
+
+const (
+	maxURLLength   = 2048
+	maxTitleLength = 1024
+	maxTabIDLength = 128
+	maxKeyLength   = 32
+)
+
+// builtinSchemas are the schemas of the model.Event* types
+func builtinSchemas() map[string]Schema {
+	pointer := Schema{Fields: map[string]Field{
+		"x":   {Type: FieldNumber, Required: true, Range: coordinateRange},
+		"y":   {Type: FieldNumber, Required: true, Range: coordinateRange},
+		"url": {Type: FieldString, MaxLength: maxURLLength},
+	}}
+	tab := Schema{Fields: map[string]Field{
+		"tabId":     {Type: FieldString, Required: true, MaxLength: maxTabIDLength},
+		"url":       {Type: FieldString, MaxLength: maxURLLength},
+		"pageTitle": {Type: FieldString, MaxLength: maxTitleLength},
+	}}
+	page := Schema{Fields: map[string]Field{
+		"url":       {Type: FieldString, Required: true, MaxLength: maxURLLength},
+		"pageTitle": {Type: FieldString, MaxLength: maxTitleLength},
+		"tabId":     {Type: FieldString, MaxLength: maxTabIDLength},
+	}}
+
+	return map[string]Schema{
+		model.EventMouseMove: pointer,
+		model.EventClick:     pointer,
+		model.EventKeyPress: {Fields: map[string]Field{
+			"keyCode": {Type: FieldString, Required: true, MaxLength: maxKeyLength},
+			"url":     {Type: FieldString, MaxLength: maxURLLength},
+		}},
+		model.EventScroll: {Fields: map[string]Field{
+			"scrollDelta": {Type: FieldNumber, Required: true, Range: scrollDeltaRange},
+			"url":         {Type: FieldString, MaxLength: maxURLLength},
+		}},
+		model.EventTabFocus:   tab,
+		model.EventTabBlur:    tab,
+		model.EventPageLoad:   page,
+		model.EventPageUnload: page,
+	}
+}
+
+// defaultRegistry holds the built-in event types and any registered with
+// RegisterType
+var defaultRegistry = newDefaultRegistry()
+
+// newDefaultRegistry creates a registry with the built-in event types
+func newDefaultRegistry() *Registry {
+	r := NewRegistry()
+	for eventType, schema := range builtinSchemas() {
+		if err := r.Register(eventType, schema); err != nil {
+			panic(err)
+		}
+	}
+	return r
+}
+
+// RegisterType adds a custom event type to the default registry
+func RegisterType(eventType string, schema Schema) error {
+	return defaultRegistry.Register(eventType, schema)
+}
+
+// Validate checks an event against the default registry
+func Validate(event *model.Event) error {
+	return defaultRegistry.Validate(event)
+}
+
+// Upgrade brings the event's metadata to the current schema version
+func Upgrade(event *model.Event) error {
+	version, err := eventschema.Upgrade(event.SchemaVersion, event.Metadata)
+	if err != nil {
+		return err
+	}
+	event.SchemaVersion = version
+	return nil
+}
+
+// Normalize upgrades an incoming event to the current schema version and
+// validates it against the default registry
+func Normalize(event *model.Event) error {
+	if err := Upgrade(event); err != nil {
+		return &ValidationError{
+			EventType: event.Type,
+			Errors:    []FieldError{{Field: "schemaVersion", Code: CodeUnsupportedVersion, Message: err.Error()}},
+		}
+	}
+	return Validate(event)
+}
+
+// Types returns the event types of the default registry
+func Types() []string {
+	return defaultRegistry.Types()
+}
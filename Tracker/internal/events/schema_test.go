@@ -0,0 +1,185 @@
+package events
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"Tracker/internal/eventschema"
+	"Tracker/internal/model"
+)
+
+// testRegistry holds one schema using every field feature
+func testRegistry(t *testing.T) *Registry {
+	t.Helper()
+
+	r := NewRegistry()
+	err := r.Register("purchase", Schema{
+		Strict: true,
+		Fields: map[string]Field{
+			"amount":   {Type: FieldNumber, Required: true, Range: &Range{Min: 0, Max: 100}},
+			"currency": {Type: FieldString, Required: true, MaxLength: 3},
+			"note":     {Type: FieldString, MaxLength: 10},
+			"gift":     {Type: FieldBool},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestRegistryRegister(t *testing.T) {
+	r := testRegistry(t)
+
+	tests := []struct {
+		name      string
+		eventType string
+		schema    Schema
+	}{
+		{"empty type", "", Schema{}},
+		{"field without type", "refund", Schema{Fields: map[string]Field{"amount": {Required: true}}}},
+		{"duplicate type", "purchase", Schema{}},
+	}
+	for _, tt := range tests {
+		if err := r.Register(tt.eventType, tt.schema); err == nil {
+			t.Errorf("%s: Register succeeded", tt.name)
+		}
+	}
+
+	if err := r.Register("refund", Schema{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := r.Types(); !reflect.DeepEqual(got, []string{"purchase", "refund"}) {
+		t.Errorf("Types = %v", got)
+	}
+}
+
+func TestRegistryValidate(t *testing.T) {
+	r := testRegistry(t)
+
+	tests := []struct {
+		name     string
+		metadata map[string]interface{}
+		// want lists the expected error codes by field, in order
+		want []string
+	}{
+		{"valid", map[string]interface{}{"amount": 10.0, "currency": "EUR", "gift": true}, nil},
+		{"bounds are inclusive", map[string]interface{}{"amount": 100.0, "currency": "EUR"}, nil},
+		{"missing required", map[string]interface{}{}, []string{"metadata.amount:required", "metadata.currency:required"}},
+		{"null counts as missing", map[string]interface{}{"amount": nil, "currency": "EUR"}, []string{"metadata.amount:required"}},
+		{"empty required string", map[string]interface{}{"amount": 1.0, "currency": ""}, []string{"metadata.currency:required"}},
+		{"wrong types", map[string]interface{}{"amount": "10", "currency": 978.0, "gift": "yes"},
+			[]string{"metadata.amount:wrong_type", "metadata.currency:wrong_type", "metadata.gift:wrong_type"}},
+		{"out of range", map[string]interface{}{"amount": -1.0, "currency": "EUR"}, []string{"metadata.amount:out_of_range"}},
+		{"too long", map[string]interface{}{"amount": 1.0, "currency": "EURO", "note": "much too long"},
+			[]string{"metadata.currency:too_long", "metadata.note:too_long"}},
+		{"unknown fields in a strict schema", map[string]interface{}{"amount": 1.0, "currency": "EUR", "z": 1.0, "a": 1.0},
+			[]string{"metadata.a:unknown_field", "metadata.z:unknown_field"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := r.Validate(&model.Event{Type: "purchase", Metadata: tt.metadata})
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("Validate = %v", err)
+				}
+				return
+			}
+
+			var invalid *ValidationError
+			if !errors.As(err, &invalid) {
+				t.Fatalf("Validate = %v, want a *ValidationError", err)
+			}
+			got := make([]string, len(invalid.Errors))
+			for i, problem := range invalid.Errors {
+				got[i] = problem.Field + ":" + problem.Code
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("errors = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegistryValidateUnknownType(t *testing.T) {
+	err := testRegistry(t).Validate(&model.Event{Type: "teleport"})
+
+	var invalid *ValidationError
+	if !errors.As(err, &invalid) || len(invalid.Errors) != 1 || invalid.Errors[0].Code != CodeUnknownType {
+		t.Fatalf("Validate = %v, want one unknown_type error", err)
+	}
+	if !strings.Contains(err.Error(), `"teleport"`) {
+		t.Errorf("message %q does not name the type", err.Error())
+	}
+}
+
+func TestBuiltinTypes(t *testing.T) {
+	want := []string{
+		model.EventClick, model.EventKeyPress, model.EventMouseMove, model.EventPageLoad,
+		model.EventPageUnload, model.EventScroll, model.EventTabBlur, model.EventTabFocus,
+	}
+	for _, eventType := range want {
+		if _, ok := defaultRegistry.Lookup(eventType); !ok {
+			t.Errorf("built-in type %q is not registered", eventType)
+		}
+	}
+	if err := RegisterType(model.EventClick, Schema{}); err == nil {
+		t.Error("a custom type replaced a built-in one")
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name    string
+		event   model.Event
+		wantErr string
+	}{
+		{
+			name: "current version",
+			event: model.Event{Type: model.EventClick, SchemaVersion: eventschema.CurrentVersion,
+				Metadata: map[string]interface{}{"x": 1.0, "y": 2.0}},
+		},
+		{
+			name: "version 1 key press is upgraded before validation",
+			event: model.Event{Type: model.EventKeyPress,
+				Metadata: map[string]interface{}{"keyPressed": "Enter"}},
+		},
+		{
+			name: "version 1 numbers sent as strings",
+			event: model.Event{Type: model.EventScroll, SchemaVersion: eventschema.Version1,
+				Metadata: map[string]interface{}{"scrollDelta": "-120"}},
+		},
+		{
+			name:    "unsupported version",
+			event:   model.Event{Type: model.EventClick, SchemaVersion: 99, Metadata: map[string]interface{}{"x": 1.0, "y": 2.0}},
+			wantErr: CodeUnsupportedVersion,
+		},
+		{
+			name:    "invalid after upgrade",
+			event:   model.Event{Type: model.EventScroll, Metadata: map[string]interface{}{"scrollDelta": "fast"}},
+			wantErr: CodeWrongType,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := tt.event
+			err := Normalize(&event)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Normalize = %v", err)
+				}
+				if event.SchemaVersion != eventschema.CurrentVersion {
+					t.Errorf("schema version = %d, want %d", event.SchemaVersion, eventschema.CurrentVersion)
+				}
+				return
+			}
+
+			var invalid *ValidationError
+			if !errors.As(err, &invalid) || len(invalid.Errors) == 0 || invalid.Errors[0].Code != tt.wantErr {
+				t.Errorf("Normalize = %v, want a %s error", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+package events
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"Tracker/internal/database"
+	"Tracker/internal/eventschema"
+	"Tracker/internal/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrInvalidCursor is returned for timeline cursors that were not issued by
+// Timeline
+var ErrInvalidCursor = errors.New("invalid timeline cursor")
+
+// Timeline page size limits
+const (
+	DefaultTimelineLimit = 100
+	MaxTimelineLimit     = 1000
+)
+
+// TimelineQuery selects a page of one user's events in one tenant, see
+// database.TenantFilter. Other zero values match everything.
+type TimelineQuery struct {
+	UserID   string
+	TenantID string
+	Types    []string
+	From     time.Time
+	To       time.Time
+	// Cursor continues after the last event of a previous page
+	Cursor string
+	Limit  int64
+}
+
+// TimelinePage is a page of events in chronological order. NextCursor is
+// empty on the last page.
+type TimelinePage struct {
+	Events     []model.Event `json:"events"`
+	NextCursor string        `json:"nextCursor,omitempty"`
+}
+
+// MongoStore stores raw events in the events collection
+type MongoStore struct {
+	collection *mongo.Collection
+}
+
+// NewMongoStore creates an event store on top of the given collection
+func NewMongoStore(collection *mongo.Collection) *MongoStore {
+	return &MongoStore{collection: collection}
+}
+
+// EnsureIndexes creates the index used by timeline queries
+func (s *MongoStore) EnsureIndexes(ctx context.Context) error {
+	_, err := s.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "userId", Value: 1}, {Key: "timestamp", Value: 1}, {Key: "_id", Value: 1}},
+	})
+	return err
+}
+
+// InsertMany writes a batch of events. The insert is unordered so one bad
+// document does not stop the rest of the batch.
+func (s *MongoStore) InsertMany(ctx context.Context, events []*model.Event) error {
+	docs := make([]interface{}, len(events))
+	for i, event := range events {
+		if event.ID.IsZero() {
+			event.ID = primitive.NewObjectID()
+		}
+		docs[i] = event
+	}
+	_, err := s.collection.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	return err
+}
+
+// UpgradeStored rewrites events stored under an older schema version to the
+// current one and returns how many were rewritten. Events that cannot be
+// upgraded are left as they are.
+func (s *MongoStore) UpgradeStored(ctx context.Context) (int64, error) {
+	filter := bson.M{"$or": bson.A{
+		bson.M{"schemaVersion": bson.M{"$exists": false}},
+		bson.M{"schemaVersion": bson.M{"$lt": eventschema.CurrentVersion}},
+	}}
+	cursor, err := s.collection.Find(ctx, filter, options.Find().SetBatchSize(500))
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var upgraded int64
+	for cursor.Next(ctx) {
+		var event model.Event
+		if err := cursor.Decode(&event); err != nil {
+			return upgraded, err
+		}
+
+		// Only rewrite the version the event was read at, in case it
+		// was upgraded concurrently
+		readVersion := interface{}(event.SchemaVersion)
+		if event.SchemaVersion == 0 {
+			readVersion = bson.M{"$exists": false}
+		}
+		if err := Upgrade(&event); err != nil {
+			continue
+		}
+
+		_, err := s.collection.UpdateOne(ctx,
+			bson.M{"_id": event.ID, "schemaVersion": readVersion},
+			bson.M{"$set": bson.M{"metadata": event.Metadata, "schemaVersion": event.SchemaVersion}},
+		)
+		if err != nil {
+			return upgraded, err
+		}
+		upgraded++
+	}
+	return upgraded, cursor.Err()
+}
+
+// Timeline returns a page of a user's events, oldest first
+func (s *MongoStore) Timeline(ctx context.Context, q TimelineQuery) (*TimelinePage, error) {
+	filter := database.TenantFilter(q.TenantID, bson.M{"userId": q.UserID})
+	if len(q.Types) > 0 {
+		filter["type"] = bson.M{"$in": q.Types}
+	}
+
+	timeRange := bson.M{}
+	if !q.From.IsZero() {
+		timeRange["$gte"] = q.From
+	}
+	if !q.To.IsZero() {
+		timeRange["$lt"] = q.To
+	}
+	if len(timeRange) > 0 {
+		filter["timestamp"] = timeRange
+	}
+
+	if q.Cursor != "" {
+		after, afterID, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		filter["$or"] = bson.A{
+			bson.M{"timestamp": bson.M{"$gt": after}},
+			bson.M{"timestamp": after, "_id": bson.M{"$gt": afterID}},
+		}
+	}
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultTimelineLimit
+	}
+	if limit > MaxTimelineLimit {
+		limit = MaxTimelineLimit
+	}
+
+	// Fetch one extra event to know whether there is another page
+	opts := options.Find().
+		SetSort(bson.D{{Key: "timestamp", Value: 1}, {Key: "_id", Value: 1}}).
+		SetLimit(limit + 1)
+	cursor, err := s.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	page := &TimelinePage{Events: make([]model.Event, 0)}
+	if err := cursor.All(ctx, &page.Events); err != nil {
+		return nil, err
+	}
+
+	// Serve events not yet rewritten by UpgradeStored in the current shape
+	for i := range page.Events {
+		_ = Upgrade(&page.Events[i])
+	}
+	if int64(len(page.Events)) > limit {
+		page.Events = page.Events[:limit]
+		last := page.Events[limit-1]
+		page.NextCursor = encodeCursor(last.Timestamp, last.ID)
+	}
+	return page, nil
+}
+
+// encodeCursor makes an opaque cursor from an event's sort key
+func encodeCursor(t time.Time, id primitive.ObjectID) string {
+	raw := strconv.FormatInt(t.UnixMilli(), 10) + ":" + id.Hex()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeCursor reverses encodeCursor
+func decodeCursor(cursor string) (time.Time, primitive.ObjectID, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, primitive.NilObjectID, ErrInvalidCursor
+	}
+	millis, hexID, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return time.Time{}, primitive.NilObjectID, ErrInvalidCursor
+	}
+	ms, err := strconv.ParseInt(millis, 10, 64)
+	if err != nil {
+		return time.Time{}, primitive.NilObjectID, ErrInvalidCursor
+	}
+	id, err := primitive.ObjectIDFromHex(hexID)
+	if err != nil {
+		return time.Time{}, primitive.NilObjectID, ErrInvalidCursor
+	}
+	return time.UnixMilli(ms).UTC(), id, nil
+}
@@ -0,0 +1,30 @@
+package events
+
+import (
+	"time"
+
+	"Tracker/internal/model"
+)
+
+// ApplyClientTime keeps the client's timestamp and corrects it for clock
+// skew, the amount the client's clock runs ahead of the server's. The
+// corrected time is clamped so an event never happens after it was
+// received, nor more than maxAge before. Events without a client timestamp
+// get the receive time.
+func ApplyClientTime(event *model.Event, skew time.Duration, receivedAt time.Time, maxAge time.Duration) {
+	event.ReceivedAt = receivedAt
+	event.ClientTimestamp = event.Timestamp
+	if event.ClientTimestamp.IsZero() {
+		event.Timestamp = receivedAt
+		return
+	}
+
+	corrected := event.ClientTimestamp.Add(-skew)
+	switch {
+	case corrected.After(receivedAt):
+		corrected = receivedAt
+	case maxAge > 0 && corrected.Before(receivedAt.Add(-maxAge)):
+		corrected = receivedAt.Add(-maxAge)
+	}
+	event.Timestamp = corrected
+}
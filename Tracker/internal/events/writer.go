@@ -0,0 +1,175 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"Tracker/internal/model"
+)
+
+// ErrQueueFull is returned when the writer is too far behind to accept
+// another event
+var ErrQueueFull = errors.New("event queue is full")
+
+// ErrWriterClosed is returned for events enqueued after Close
+var ErrWriterClosed = errors.New("event writer is closed")
+
+// writeTimeout bounds a single batch insert
+const writeTimeout = 10 * time.Second
+
+// BatchInserter writes a batch of events
+type BatchInserter interface {
+	InsertMany(ctx context.Context, events []*model.Event) error
+}
+
+// WriterStats counts what happened to enqueued events
+type WriterStats struct {
+	Written int64 `json:"written"`
+	Dropped int64 `json:"dropped"`
+	Failed  int64 `json:"failed"`
+	Queued  int   `json:"queued"`
+}
+
+// Writer persists events in the background. Enqueue never blocks: events
+// wait in a bounded queue and are inserted in batches of up to batchSize,
+// at least every flushInterval. When the queue is full new events are
+// dropped, so a slow database cannot stall the WebSocket read pumps.
+type Writer struct {
+	store         BatchInserter
+	queue         chan *model.Event
+	batchSize     int
+	flushInterval time.Duration
+
+	closeOnce sync.Once
+	closing   chan struct{}
+	done      chan struct{}
+	mu        sync.RWMutex
+	closed    bool
+
+	written atomic.Int64
+	dropped atomic.Int64
+	failed  atomic.Int64
+}
+
+// NewWriter creates a writer with room for queueSize waiting events
+func NewWriter(store BatchInserter, queueSize, batchSize int, flushInterval time.Duration) *Writer {
+	if queueSize < 1 {
+		queueSize = 1
+	}
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	if flushInterval <= 0 {
+		flushInterval = time.Second
+	}
+	return &Writer{
+		store:         store,
+		queue:         make(chan *model.Event, queueSize),
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		closing:       make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+}
+
+// Enqueue adds an event to the queue without blocking
+func (w *Writer) Enqueue(event *model.Event) error {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		return ErrWriterClosed
+	}
+
+	select {
+	case w.queue <- event:
+		return nil
+	default:
+		w.dropped.Add(1)
+		return ErrQueueFull
+	}
+}
+
+// Run writes queued events until Close is called
+func (w *Writer) Run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*model.Event, 0, w.batchSize)
+	var reportedDrops int64
+	for {
+		select {
+		case event := <-w.queue:
+			batch = append(batch, event)
+			if len(batch) >= w.batchSize {
+				batch = w.flush(batch)
+			}
+		case <-ticker.C:
+			batch = w.flush(batch)
+			if dropped := w.dropped.Load(); dropped > reportedDrops {
+				log.Printf("events: queue full, dropped %d events", dropped-reportedDrops)
+				reportedDrops = dropped
+			}
+		case <-w.closing:
+			// Enqueue can no longer add events, so the queue only drains
+			for {
+				select {
+				case event := <-w.queue:
+					batch = append(batch, event)
+					if len(batch) >= w.batchSize {
+						batch = w.flush(batch)
+					}
+				default:
+					w.flush(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+// flush inserts a batch and returns the emptied slice for reuse
+func (w *Writer) flush(batch []*model.Event) []*model.Event {
+	if len(batch) == 0 {
+		return batch
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), writeTimeout)
+	defer cancel()
+
+	if err := w.store.InsertMany(ctx, batch); err != nil {
+		w.failed.Add(int64(len(batch)))
+		log.Printf("events: failed to write %d events: %v", len(batch), err)
+	} else {
+		w.written.Add(int64(len(batch)))
+	}
+
+	clear(batch)
+	return batch[:0]
+}
+
+// Close stops accepting events and waits until the queue has been written
+func (w *Writer) Close() {
+	w.closeOnce.Do(func() {
+		w.mu.Lock()
+		w.closed = true
+		w.mu.Unlock()
+		close(w.closing)
+	})
+	<-w.done
+}
+
+// Stats returns the writer's counters
+func (w *Writer) Stats() WriterStats {
+	return WriterStats{
+		Written: w.written.Load(),
+		Dropped: w.dropped.Load(),
+		Failed:  w.failed.Load(),
+		Queued:  len(w.queue),
+	}
+}
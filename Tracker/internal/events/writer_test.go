@@ -0,0 +1,145 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"Tracker/internal/model"
+)
+
+// fakeInserter hands every batch it is asked to insert to the test. err,
+// when set, fails every insert.
+type fakeInserter struct {
+	batches chan []string
+	err     error
+}
+
+func newFakeInserter() *fakeInserter {
+	return &fakeInserter{batches: make(chan []string, 16)}
+}
+
+// InsertMany records the client event IDs of a batch; the writer reuses
+// the slice once it returns
+func (f *fakeInserter) InsertMany(ctx context.Context, events []*model.Event) error {
+	ids := make([]string, len(events))
+	for i, event := range events {
+		ids[i] = event.ClientEventID
+	}
+	f.batches <- ids
+	return f.err
+}
+
+// next waits for the next batch
+func (f *fakeInserter) next(t *testing.T) []string {
+	t.Helper()
+
+	select {
+	case batch := <-f.batches:
+		return batch
+	case <-time.After(5 * time.Second):
+		t.Fatal("no batch written")
+		return nil
+	}
+}
+
+// none checks no batch is written for a while
+func (f *fakeInserter) none(t *testing.T) {
+	t.Helper()
+
+	select {
+	case batch := <-f.batches:
+		t.Fatalf("unexpected batch %v", batch)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// enqueue adds n events named from the given index and fails on errors
+func enqueue(t *testing.T, w *Writer, from, n int) {
+	t.Helper()
+
+	for i := from; i < from+n; i++ {
+		if err := w.Enqueue(&model.Event{ClientEventID: "e" + strconv.Itoa(i)}); err != nil {
+			t.Fatalf("enqueue e%d: %v", i, err)
+		}
+	}
+}
+
+func TestWriterBatchSize(t *testing.T) {
+	store := newFakeInserter()
+	w := NewWriter(store, 10, 3, time.Hour)
+	enqueue(t, w, 0, 7)
+	go w.Run()
+
+	for _, want := range []string{"e0 e1 e2", "e3 e4 e5"} {
+		if got := strings.Join(store.next(t), " "); got != want {
+			t.Fatalf("batch %q, want %q", got, want)
+		}
+	}
+	// A partial batch waits for the interval
+	store.none(t)
+
+	w.Close()
+	if got := strings.Join(store.next(t), " "); got != "e6" {
+		t.Errorf("batch on close %q, want e6", got)
+	}
+	if stats := w.Stats(); stats.Written != 7 || stats.Queued != 0 {
+		t.Errorf("stats = %+v, want 7 written", stats)
+	}
+}
+
+func TestWriterFlushInterval(t *testing.T) {
+	store := newFakeInserter()
+	w := NewWriter(store, 10, 100, 20*time.Millisecond)
+	go w.Run()
+	defer w.Close()
+
+	enqueue(t, w, 0, 2)
+	if got := strings.Join(store.next(t), " "); got != "e0 e1" {
+		t.Errorf("batch %q, want e0 e1", got)
+	}
+}
+
+func TestWriterQueueFull(t *testing.T) {
+	store := newFakeInserter()
+	w := NewWriter(store, 2, 10, time.Hour)
+
+	// Nothing drains the queue until Run starts
+	enqueue(t, w, 0, 2)
+	if err := w.Enqueue(&model.Event{ClientEventID: "e2"}); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("enqueue on a full queue = %v, want %v", err, ErrQueueFull)
+	}
+	if stats := w.Stats(); stats.Dropped != 1 || stats.Queued != 2 {
+		t.Errorf("stats = %+v, want 1 dropped and 2 queued", stats)
+	}
+
+	// Close drains what was queued and refuses anything after
+	go w.Run()
+	w.Close()
+	if got := strings.Join(store.next(t), " "); got != "e0 e1" {
+		t.Errorf("batch on close %q, want e0 e1", got)
+	}
+	if err := w.Enqueue(&model.Event{ClientEventID: "e3"}); !errors.Is(err, ErrWriterClosed) {
+		t.Errorf("enqueue after close = %v, want %v", err, ErrWriterClosed)
+	}
+	if stats := w.Stats(); stats.Written != 2 || stats.Dropped != 1 {
+		t.Errorf("stats = %+v, want 2 written and 1 dropped", stats)
+	}
+}
+
+func TestWriterFailedInsert(t *testing.T) {
+	store := newFakeInserter()
+	store.err = errors.New("database unavailable")
+	w := NewWriter(store, 10, 2, time.Hour)
+	go w.Run()
+
+	enqueue(t, w, 0, 2)
+	store.next(t)
+	w.Close()
+	if stats := w.Stats(); stats.Failed != 2 || stats.Written != 0 {
+		t.Errorf("stats = %+v, want 2 failed", stats)
+	}
+}
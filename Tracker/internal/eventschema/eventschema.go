@@ -0,0 +1,101 @@
+package eventschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// Schema versions. Version 1 is the untyped metadata accepted before
+// versioning, where clients sent either keyCode or keyPressed and numbers
+// could arrive as strings. Events without a version are version 1.
+const (
+	Version1       = 1
+	Version2       = 2
+	CurrentVersion = Version2
+)
+
+// Metadata is the canonical, typed view of an event's metadata. Field names
+// match the keys of the stored metadata map.
+type Metadata struct {
+	URL         string  `json:"url,omitempty" bson:"url,omitempty"`
+	PageTitle   string  `json:"pageTitle,omitempty" bson:"pageTitle,omitempty"`
+	TabID       string  `json:"tabId,omitempty" bson:"tabId,omitempty"`
+	X           float64 `json:"x,omitempty" bson:"x,omitempty"`
+	Y           float64 `json:"y,omitempty" bson:"y,omitempty"`
+	ScrollDelta float64 `json:"scrollDelta,omitempty" bson:"scrollDelta,omitempty"`
+	KeyCode     string  `json:"keyCode,omitempty" bson:"keyCode,omitempty"`
+}
+
+// FromMap decodes a current-version metadata map. Keys that are not part
+// of Metadata, such as those of custom event types, are ignored.
+func FromMap(metadata map[string]interface{}) (Metadata, error) {
+	var m Metadata
+	raw, err := json.Marshal(metadata)
+	if err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return m, err
+	}
+	return m, nil
+}
+
+// UpgradeFunc rewrites metadata in place from one version to the next
+type UpgradeFunc func(metadata map[string]interface{}) error
+
+// upgrades maps a version to the function upgrading it to the next one
+var upgrades = map[int]UpgradeFunc{
+	Version1: upgradeV1,
+}
+
+// Upgrade rewrites metadata written under version to the current version
+// and returns the new version. Version 0 means the event predates
+// versioning and is treated as version 1.
+func Upgrade(version int, metadata map[string]interface{}) (int, error) {
+	if version == 0 {
+		version = Version1
+	}
+	if version > CurrentVersion {
+		return version, fmt.Errorf("unsupported schema version %d", version)
+	}
+
+	for version < CurrentVersion {
+		upgrade, ok := upgrades[version]
+		if !ok {
+			return version, fmt.Errorf("no upgrade from schema version %d", version)
+		}
+		if metadata != nil {
+			if err := upgrade(metadata); err != nil {
+				return version, fmt.Errorf("upgrading from schema version %d: %w", version, err)
+			}
+		}
+		version++
+	}
+	return version, nil
+}
+
+// numericFields are the canonical fields holding numbers
+var numericFields = []string{"x", "y", "scrollDelta"}
+
+// upgradeV1 renames keyPressed to keyCode and parses numbers sent as
+// strings. Values that cannot be parsed are left for validation to reject.
+func upgradeV1(metadata map[string]interface{}) error {
+	if key, ok := metadata["keyPressed"]; ok {
+		if _, exists := metadata["keyCode"]; !exists {
+			metadata["keyCode"] = key
+		}
+		delete(metadata, "keyPressed")
+	}
+
+	for _, name := range numericFields {
+		text, ok := metadata[name].(string)
+		if !ok {
+			continue
+		}
+		if number, err := strconv.ParseFloat(text, 64); err == nil {
+			metadata[name] = number
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package eventschema
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUpgrade(t *testing.T) {
+	tests := []struct {
+		name     string
+		version  int
+		metadata map[string]interface{}
+		want     map[string]interface{}
+	}{
+		{
+			name:     "unversioned key press",
+			version:  0,
+			metadata: map[string]interface{}{"keyPressed": "Enter"},
+			want:     map[string]interface{}{"keyCode": "Enter"},
+		},
+		{
+			name:     "keyCode wins over keyPressed",
+			version:  Version1,
+			metadata: map[string]interface{}{"keyPressed": "a", "keyCode": "KeyA"},
+			want:     map[string]interface{}{"keyCode": "KeyA"},
+		},
+		{
+			name:     "numbers sent as strings",
+			version:  Version1,
+			metadata: map[string]interface{}{"x": "12.5", "y": "-3", "scrollDelta": "120", "tabId": "7"},
+			want:     map[string]interface{}{"x": 12.5, "y": float64(-3), "scrollDelta": float64(120), "tabId": "7"},
+		},
+		{
+			name:     "unparsable numbers are left for validation",
+			version:  Version1,
+			metadata: map[string]interface{}{"x": "left", "y": float64(4)},
+			want:     map[string]interface{}{"x": "left", "y": float64(4)},
+		},
+		{
+			name:     "current version is untouched",
+			version:  Version2,
+			metadata: map[string]interface{}{"keyPressed": "Enter", "x": "1"},
+			want:     map[string]interface{}{"keyPressed": "Enter", "x": "1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			version, err := Upgrade(tt.version, tt.metadata)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if version != CurrentVersion {
+				t.Errorf("version = %d, want %d", version, CurrentVersion)
+			}
+			if !reflect.DeepEqual(tt.metadata, tt.want) {
+				t.Errorf("metadata = %#v, want %#v", tt.metadata, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpgradeNilMetadata(t *testing.T) {
+	version, err := Upgrade(Version1, nil)
+	if err != nil || version != CurrentVersion {
+		t.Errorf("Upgrade(nil) = %d, %v", version, err)
+	}
+}
+
+func TestUpgradeFutureVersion(t *testing.T) {
+	version, err := Upgrade(CurrentVersion+1, map[string]interface{}{})
+	if err == nil {
+		t.Fatal("upgraded a version newer than the server")
+	}
+	if version != CurrentVersion+1 {
+		t.Errorf("version = %d, want it unchanged", version)
+	}
+}
+
+func TestFromMap(t *testing.T) {
+	m, err := FromMap(map[string]interface{}{
+		"url":     "https://example.com",
+		"x":       float64(3),
+		"keyCode": "Enter",
+		"button":  "left",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Metadata{URL: "https://example.com", X: 3, KeyCode: "Enter"}
+	if m != want {
+		t.Errorf("FromMap = %+v, want %+v", m, want)
+	}
+
+	if _, err := FromMap(map[string]interface{}{"x": "3"}); err == nil {
+		t.Error("decoded a string as a number")
+	}
+}
@@ -0,0 +1,136 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"Tracker/internal/config"
+)
+
+// Message is a plain-text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends email
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// Mail drivers selectable with MAIL_DRIVER
+const (
+	DriverSMTP = "smtp"
+	DriverFile = "file"
+	DriverLog  = "log"
+)
+
+// NewMailerFromConfig creates the mailer selected by MAIL_DRIVER
+func NewMailerFromConfig() (Mailer, error) {
+	switch driver := config.GetMailDriver(); driver {
+	case DriverSMTP:
+		return NewSMTPMailer(config.GetSMTPHost(), config.GetSMTPPort(), config.GetSMTPUsername(), config.GetSMTPPassword(), config.GetMailFrom()), nil
+	case DriverFile:
+		return NewFileMailer(config.GetMailDir(), config.GetMailFrom())
+	case DriverLog:
+		return NewLogMailer(config.GetMailFrom()), nil
+	default:
+		return nil, fmt.Errorf("unknown mail driver %q", driver)
+	}
+}
+
+// SMTPMailer sends mail through an SMTP server using PLAIN auth when a
+// username is configured
+type SMTPMailer struct {
+	addr string
+	host string
+	auth smtp.Auth
+	from string
+}
+
+// NewSMTPMailer creates a mailer for the given server
+func NewSMTPMailer(host, port, username, password, from string) *SMTPMailer {
+	m := &SMTPMailer{
+		addr: net.JoinHostPort(host, port),
+		host: host,
+		from: from,
+	}
+	if username != "" {
+		m.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return m
+}
+
+// Send delivers the message. net/smtp has no context support, so ctx is
+// only checked before dialing.
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return smtp.SendMail(m.addr, m.auth, m.from, []string{msg.To}, format(m.from, msg))
+}
+
+// FileMailer writes each message to a .eml file, for local testing
+type FileMailer struct {
+	dir  string
+	from string
+	mu   sync.Mutex
+	seq  int
+}
+
+// NewFileMailer creates a mailer writing into dir, creating it if needed
+func NewFileMailer(dir, from string) (*FileMailer, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &FileMailer{dir: dir, from: from}, nil
+}
+
+// Send writes the message to a new file
+func (m *FileMailer) Send(ctx context.Context, msg Message) error {
+	m.mu.Lock()
+	m.seq++
+	name := fmt.Sprintf("%s-%04d.eml", time.Now().Format("20060102T150405"), m.seq)
+	m.mu.Unlock()
+
+	return os.WriteFile(filepath.Join(m.dir, name), format(m.from, msg), 0o600)
+}
+
+// LogMailer prints messages to the application log, for development
+type LogMailer struct {
+	from string
+}
+
+// NewLogMailer creates a mailer that logs instead of sending
+func NewLogMailer(from string) *LogMailer {
+	return &LogMailer{from: from}
+}
+
+// Send logs the message
+func (m *LogMailer) Send(ctx context.Context, msg Message) error {
+	log.Printf("mail: to=%s subject=%q\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+// format renders a message as RFC 5322 text
+func format(from string, msg Message) []byte {
+	var b strings.Builder
+	b.WriteString("From: " + from + "\r\n")
+	b.WriteString("To: " + msg.To + "\r\n")
+	b.WriteString("Subject: " + msg.Subject + "\r\n")
+	b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
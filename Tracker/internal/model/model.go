@@ -10,9 +10,21 @@ import (
 type Event struct {
 	ID        primitive.ObjectID     `bson:"_id,omitempty" json:"id"`
 	UserID    string                 `bson:"userId" json:"userId"`
+	TenantID  string                 `bson:"tenantId,omitempty" json:"tenantId,omitempty"`
 	Type      string                 `bson:"type" json:"type"`
 	Timestamp time.Time              `bson:"timestamp" json:"timestamp"`
 	Metadata  map[string]interface{} `bson:"metadata" json:"metadata"`
+	// ClientEventID is generated by the client so retried uploads can be
+	// recognized
+	ClientEventID string `bson:"clientEventId,omitempty" json:"clientEventId,omitempty"`
+	// ClientTimestamp is the time the client reported, before clock skew
+	// correction. Timestamp holds the corrected time.
+	ClientTimestamp time.Time `bson:"clientTimestamp,omitempty" json:"clientTimestamp,omitempty"`
+	// ReceivedAt is when the server received the event
+	ReceivedAt time.Time `bson:"receivedAt,omitempty" json:"receivedAt,omitempty"`
+	// SchemaVersion is the eventschema version of Metadata. Events stored
+	// before versioning have none and are treated as version 1.
+	SchemaVersion int `bson:"schemaVersion,omitempty" json:"schemaVersion,omitempty"`
 }
 
 // Activity represents a collection of events with AI analysis
@@ -25,6 +37,7 @@ type Activity struct {
     Duration    float64           `bson:"duration" json:"duration"`
     Date        time.Time         `bson:"date" json:"date"`
     UserID      string            `bson:"userId" json:"userId"`
+    TenantID    string            `bson:"tenantId" json:"tenantId"`
     CreatedAt   time.Time         `bson:"createdAt" json:"createdAt"`
     UpdatedAt   time.Time         `bson:"updatedAt" json:"updatedAt"`
 }
@@ -47,7 +60,6 @@ type ActivityRequest struct {
     Category    string  `json:"category" binding:"required"`
     Duration    float64 `json:"duration" binding:"required,gt=0"`
     Date        string  `json:"date" binding:"required"`
-    UserID      string  `json:"userId" binding:"required"`
 }
 
 
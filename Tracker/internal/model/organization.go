@@ -0,0 +1,42 @@
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Organization is a tenant; every user and activity belongs to exactly one
+type Organization struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name      string             `bson:"name" json:"name"`
+	JoinCode  string             `bson:"joinCode" json:"joinCode,omitempty"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+	UpdatedAt time.Time          `bson:"updatedAt" json:"updatedAt"`
+}
+
+// Team is a group of users inside an organization
+type Team struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	TenantID  string             `bson:"tenantId" json:"tenantId"`
+	Name      string             `bson:"name" json:"name"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+// Membership links a user to an organization (TeamID empty) or to a team
+type Membership struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	TenantID  string             `bson:"tenantId" json:"tenantId"`
+	TeamID    string             `bson:"teamId,omitempty" json:"teamId,omitempty"`
+	UserID    string             `bson:"userId" json:"userId"`
+	Role      string             `bson:"role" json:"role"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+// Membership role constants. Organizations have owners and members; teams
+// have managers and members.
+const (
+	MemberRoleOwner   = "owner"
+	MemberRoleManager = "manager"
+	MemberRoleMember  = "member"
+)
@@ -11,9 +11,27 @@ type User struct {
     ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
     Username  string            `bson:"username" json:"username"`
     Email     string            `bson:"email" json:"email"`
+    Password  string            `bson:"password" json:"-"`
+    Role      string            `bson:"role" json:"role"`
+    TenantID  string            `bson:"tenantId" json:"tenantId"`
     Settings  UserSettings      `bson:"settings" json:"settings"`
+    MFA       MFASettings       `bson:"mfa" json:"mfa"`
     CreatedAt time.Time         `bson:"createdAt" json:"createdAt"`
     UpdatedAt time.Time         `bson:"updatedAt" json:"updatedAt"`
+
+    // EmailVerified is set once the user follows the verification link
+    EmailVerified   bool       `bson:"emailVerified" json:"emailVerified"`
+    EmailVerifiedAt *time.Time `bson:"emailVerifiedAt,omitempty" json:"emailVerifiedAt,omitempty"`
+
+    // Identities are the single sign-on accounts linked to this user
+    Identities []ExternalIdentity `bson:"identities,omitempty" json:"-"`
+}
+
+// ExternalIdentity links a user to an account at an OpenID Connect issuer
+type ExternalIdentity struct {
+    Issuer   string    `bson:"issuer" json:"issuer"`
+    Subject  string    `bson:"subject" json:"subject"`
+    LinkedAt time.Time `bson:"linkedAt" json:"linkedAt"`
 }
 
 // UserSettings contains user-specific settings
@@ -22,4 +40,14 @@ type UserSettings struct {
     Preferences     map[string]string `bson:"preferences" json:"preferences"`
     NotifyEmail     bool              `bson:"notifyEmail" json:"notifyEmail"`
     TimeZone        string            `bson:"timeZone" json:"timeZone"`
-}
\ No newline at end of file
+}
+
+// MFASettings contains a user's second-factor state. Secrets and recovery
+// code hashes are never serialized to clients.
+type MFASettings struct {
+    Enabled           bool     `bson:"enabled" json:"enabled"`
+    TOTPSecret        string   `bson:"totpSecret,omitempty" json:"-"`
+    PendingTOTPSecret string   `bson:"pendingTotpSecret,omitempty" json:"-"`
+    RecoveryCodes     []string `bson:"recoveryCodes,omitempty" json:"-"`
+    LastTOTPStep      int64    `bson:"lastTotpStep,omitempty" json:"-"`
+}
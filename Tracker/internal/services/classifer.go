@@ -3,22 +3,15 @@ package services
 import (
 	"math"
 	"time"
+
+	"Tracker/internal/eventschema"
 )
 
 // UserEvent represents a single user activity event
 type UserEvent struct {
-	Type      string        `json:"type"`
-	Timestamp time.Time     `json:"timestamp"`
-	Metadata  EventMetadata `json:"metadata"`
-}
-
-// EventMetadata contains event-specific details
-type EventMetadata struct {
-	URL        string  `json:"url,omitempty"`
-	X          float64 `json:"x,omitempty"`
-	Y          float64 `json:"y,omitempty"`
-	KeyPressed string  `json:"keyPressed,omitempty"`
-	TabID      string  `json:"tabId,omitempty"`
+	Type      string               `json:"type"`
+	Timestamp time.Time            `json:"timestamp"`
+	Metadata  eventschema.Metadata `json:"metadata"`
 }
 
 // classifyBehavior analyzes events to determine user behavior
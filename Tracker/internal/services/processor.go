@@ -4,18 +4,37 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log"
 	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-// EventProcessor handles real-time event processing
+// analysisTimeout bounds a background analysis, including the AI call
+const analysisTimeout = 30 * time.Second
+
+// maxConcurrentAnalyses bounds background analyses across all users
+const maxConcurrentAnalyses = 4
+
+// AnalysisHandler receives analyses produced from live events
+type AnalysisHandler func(analysis *ActivityAnalysis)
+
+// EventProcessor handles real-time event processing. Events are buffered
+// per user; every batchSize events the batch is analyzed in the background
+// and the result handed to the analysis handler. A user has at most one
+// analysis in flight, and events arriving meanwhile join the next batch,
+// which keeps only its latest batchSize events.
 type EventProcessor struct {
-	events    map[string][]UserEvent
-	mutex     sync.RWMutex
-	batchSize int
-	analyzer  *ActivityAnalyzer
+	events     map[string][]UserEvent
+	recent     map[string][]UserEvent
+	analyzing  map[string]bool
+	mutex      sync.RWMutex
+	batchSize  int
+	maxRecent  int
+	analyzer   *ActivityAnalyzer
+	slots      chan struct{}
+	onAnalysis AnalysisHandler
 }
 
 func (p *EventProcessor) ProcessBatchEvents(ctx *gin.Context, userID string, events []UserEvent) (*ActivityAnalysis, error) {
@@ -42,47 +61,101 @@ func (p *EventProcessor) ProcessBatchEvents(ctx *gin.Context, userID string, eve
 func NewEventProcessor(analyzer *ActivityAnalyzer) *EventProcessor {
 	return &EventProcessor{
 		events:    make(map[string][]UserEvent),
+		recent:    make(map[string][]UserEvent),
+		analyzing: make(map[string]bool),
 		batchSize: 100,
+		maxRecent: 1000,
 		analyzer:  analyzer,
+		slots:     make(chan struct{}, maxConcurrentAnalyses),
 	}
 }
 
-// ProcessEvent handles a new incoming event
+// SetAnalysisHandler configures where analyses of live events are sent
+func (p *EventProcessor) SetAnalysisHandler(handler AnalysisHandler) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.onAnalysis = handler
+}
+
+// ProcessEvent handles a new incoming event. It never waits for an
+// analysis, so it is safe to call from a connection's read loop.
 func (p *EventProcessor) ProcessEvent(ctx context.Context, userID string, event UserEvent) error {
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
 
-	// Add event to user's event list
+	// Keep a bounded window of recent events for on-demand analysis,
+	// trimming it back to maxRecent once it has doubled
+	recent := append(p.recent[userID], event)
+	if len(recent) > 2*p.maxRecent {
+		recent = append(make([]UserEvent, 0, 2*p.maxRecent), recent[len(recent)-p.maxRecent:]...)
+	}
+	p.recent[userID] = recent
+
+	// Add event to user's event list. While an analysis is running the
+	// oldest pending event makes room for the new one.
 	if _, exists := p.events[userID]; !exists {
 		p.events[userID] = make([]UserEvent, 0, p.batchSize)
 	}
 
-	p.events[userID] = append(p.events[userID], event)
+	pending := append(p.events[userID], event)
+	if len(pending) > p.batchSize {
+		pending = append(pending[:0], pending[len(pending)-p.batchSize:]...)
+	}
+	p.events[userID] = pending
 
 	// Process batch if threshold reached
-	if len(p.events[userID]) >= p.batchSize {
-		return p.processBatch(ctx, userID)
+	if len(p.events[userID]) >= p.batchSize && !p.analyzing[userID] {
+		events := p.events[userID]
+
+		// Clear events before processing to prevent duplicates
+		p.events[userID] = make([]UserEvent, 0, p.batchSize)
+		p.analyzing[userID] = true
+
+		go p.processBatch(userID, events)
 	}
 
 	return nil
 }
 
-// processBatch handles a batch of events for analysis
-func (p *EventProcessor) processBatch(ctx context.Context, userID string) error {
-	events := p.events[userID]
+// processBatch analyzes a batch of events and hands the result to the
+// analysis handler
+func (p *EventProcessor) processBatch(userID string, events []UserEvent) {
+	defer func() {
+		p.mutex.Lock()
+		delete(p.analyzing, userID)
+		p.mutex.Unlock()
+	}()
+
+	p.slots <- struct{}{}
+	defer func() { <-p.slots }()
 
-	// Clear events before processing to prevent duplicates
-	p.events[userID] = make([]UserEvent, 0, p.batchSize)
+	ctx, cancel := context.WithTimeout(context.Background(), analysisTimeout)
+	defer cancel()
 
 	// Analyze the batch
-	_, err := p.analyzer.AnalyzeActivity(ctx, userID, events)
+	analysis, err := p.analyzer.AnalyzeActivity(ctx, userID, events)
 	if err != nil {
-		return err
+		log.Printf("event processor: analysis for user %s failed: %v", userID, err)
+		return
 	}
 
 	// TODO: Store analysis results in database
 
-	return nil
+	p.mutex.RLock()
+	handler := p.onAnalysis
+	p.mutex.RUnlock()
+	if handler != nil {
+		handler(analysis)
+	}
+}
+
+// ForgetUser drops the events kept for a user, once the user has no live
+// session left. An analysis already running still completes.
+func (p *EventProcessor) ForgetUser(userID string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	delete(p.events, userID)
+	delete(p.recent, userID)
 }
 
 // GetRecentEvents retrieves recent events for a user
@@ -90,7 +163,7 @@ func (p *EventProcessor) GetRecentEvents(userID string, duration time.Duration)
 	p.mutex.RLock()
 	defer p.mutex.RUnlock()
 
-	if events, exists := p.events[userID]; exists {
+	if events, exists := p.recent[userID]; exists {
 		cutoff := time.Now().Add(-duration)
 		recent := make([]UserEvent, 0)
 
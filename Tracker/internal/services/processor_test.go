@@ -0,0 +1,41 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPendingEventsAreCappedDuringAnalysis(t *testing.T) {
+	p := NewEventProcessor(nil)
+	p.batchSize = 4
+	// An analysis is in flight, so no new batch starts
+	p.analyzing["user-1"] = true
+
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		event := UserEvent{Type: "click", Timestamp: start.Add(time.Duration(i) * time.Second)}
+		if err := p.ProcessEvent(context.Background(), "user-1", event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pending := p.events["user-1"]
+	if len(pending) != 4 {
+		t.Fatalf("%d pending events, want 4", len(pending))
+	}
+	// The oldest events made room for the latest
+	for i, event := range pending {
+		if want := start.Add(time.Duration(6+i) * time.Second); !event.Timestamp.Equal(want) {
+			t.Errorf("pending event %d at %v, want %v", i, event.Timestamp, want)
+		}
+	}
+
+	p.ForgetUser("user-1")
+	if _, ok := p.events["user-1"]; ok {
+		t.Error("pending events kept after ForgetUser")
+	}
+	if p.GetRecentEvents("user-1", time.Hour) != nil {
+		t.Error("recent events kept after ForgetUser")
+	}
+}
@@ -0,0 +1,80 @@
+package ws
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// clockSyncTimeout is how long a clock sync ping waits for its reply
+const clockSyncTimeout = 30 * time.Second
+
+// ClockSyncPayload is the server half of a clock sync ping. The client
+// answers with a clock_sync event carrying its current time as timestamp
+// and the ping ID as metadata.id.
+type ClockSyncPayload struct {
+	ID         string    `json:"id"`
+	ServerTime time.Time `json:"serverTime"`
+}
+
+// skewEstimator estimates how far a client's clock runs ahead of the
+// server's from clock sync round trips. It assumes the reply was written
+// halfway through the round trip and keeps the sample with the shortest
+// round trip, whose midpoint guess is the most precise.
+type skewEstimator struct {
+	mu      sync.Mutex
+	pending map[string]time.Time
+	nextID  int
+	skew    time.Duration
+	rtt     time.Duration
+	known   bool
+}
+
+// newSkewEstimator creates an estimator with no samples
+func newSkewEstimator() *skewEstimator {
+	return &skewEstimator{pending: make(map[string]time.Time)}
+}
+
+// ping records a ping sent at sentAt and returns its ID
+func (e *skewEstimator) ping(sentAt time.Time) string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for id, at := range e.pending {
+		if sentAt.Sub(at) > clockSyncTimeout {
+			delete(e.pending, id)
+		}
+	}
+	e.nextID++
+	id := strconv.Itoa(e.nextID)
+	e.pending[id] = sentAt
+	return id
+}
+
+// sample completes the ping with the client's reply. It reports false for
+// unknown or expired pings and replies without a client time.
+func (e *skewEstimator) sample(id string, clientTime, receivedAt time.Time) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	sentAt, ok := e.pending[id]
+	delete(e.pending, id)
+	rtt := receivedAt.Sub(sentAt)
+	if !ok || clientTime.IsZero() || rtt < 0 || rtt > clockSyncTimeout {
+		return false
+	}
+
+	if !e.known || rtt < e.rtt {
+		e.skew = clientTime.Sub(sentAt.Add(rtt / 2))
+		e.rtt = rtt
+		e.known = true
+	}
+	return true
+}
+
+// Skew returns the estimated skew, zero until a ping has been answered
+func (e *skewEstimator) Skew() time.Duration {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.skew
+}
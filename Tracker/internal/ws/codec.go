@@ -0,0 +1,132 @@
+package ws
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	auth "Tracker/Authatication"
+	"Tracker/internal/model"
+
+	"github.com/gorilla/websocket"
+)
+
+// Subprotocols selecting the framing of messages. WebSocketProtocol is the
+// original name of the JSON framing and stays an alias of it.
+const (
+	SubprotocolJSON     = auth.WebSocketProtocol + ".json"
+	SubprotocolMsgpack  = auth.WebSocketProtocol + ".msgpack"
+	SubprotocolProtobuf = auth.WebSocketProtocol + ".protobuf"
+)
+
+// frameCodec frames the messages of one subprotocol
+type frameCodec interface {
+	// frameType is the WebSocket message type of encoded messages
+	frameType() int
+	// encode frames one server message
+	encode(event WebSocketEvent) ([]byte, error)
+	// decode splits a client frame into its messages. A frame holds one
+	// message or a batch of them.
+	decode(frame []byte) ([]inbound, error)
+}
+
+// codecs maps each supported subprotocol to its codec
+var codecs = map[string]frameCodec{
+	auth.WebSocketProtocol: jsonCodec{},
+	SubprotocolJSON:        jsonCodec{},
+	SubprotocolMsgpack:     msgpackCodec{},
+	SubprotocolProtobuf:    protobufCodec{},
+}
+
+// negotiateCodec picks the first framing the client offers, in the client's
+// order of preference. Clients offering none get JSON and no subprotocol.
+func negotiateCodec(r *http.Request) (string, frameCodec) {
+	for _, header := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, protocol := range strings.Split(header, ",") {
+			protocol = strings.TrimSpace(protocol)
+			if c, ok := codecs[protocol]; ok {
+				return protocol, c
+			}
+		}
+	}
+	return "", jsonCodec{}
+}
+
+// inbound is one decoded client message. Envelope errors make the whole
+// message unusable; event errors only matter if it is a tracking event.
+type inbound struct {
+	controlMessage
+	event       model.Event
+	envelopeErr error
+	eventErr    error
+}
+
+// wireMessage is a client message in the binary framings
+type wireMessage struct {
+	Type          string                 `codec:"type"`
+	Seq           uint64                 `codec:"seq"`
+	Ack           uint64                 `codec:"ack"`
+	Topic         string                 `codec:"topic"`
+	ClientEventID string                 `codec:"clientEventId"`
+	Timestamp     time.Time              `codec:"timestamp"`
+	SchemaVersion int                    `codec:"schemaVersion"`
+	Metadata      map[string]interface{} `codec:"metadata"`
+}
+
+// toInbound converts a binary client message
+func (w *wireMessage) toInbound() inbound {
+	return inbound{
+		controlMessage: controlMessage{Type: w.Type, Seq: w.Seq, Ack: w.Ack, Topic: w.Topic},
+		event: model.Event{
+			Type:          w.Type,
+			Timestamp:     w.Timestamp,
+			Metadata:      w.Metadata,
+			ClientEventID: w.ClientEventID,
+			SchemaVersion: w.SchemaVersion,
+		},
+	}
+}
+
+// jsonCodec frames messages as JSON text. A batch is a JSON array.
+type jsonCodec struct{}
+
+// frameType returns the text message type
+func (jsonCodec) frameType() int {
+	return websocket.TextMessage
+}
+
+// encode marshals a server message as JSON
+func (jsonCodec) encode(event WebSocketEvent) ([]byte, error) {
+	return json.Marshal(event)
+}
+
+// decode reads a JSON object or an array of them
+func (jsonCodec) decode(frame []byte) ([]inbound, error) {
+	frame = bytes.TrimSpace(frame)
+	if len(frame) == 0 || frame[0] != '[' {
+		return []inbound{decodeJSONMessage(frame)}, nil
+	}
+
+	var batch []json.RawMessage
+	if err := json.Unmarshal(frame, &batch); err != nil {
+		return nil, err
+	}
+	messages := make([]inbound, len(batch))
+	for i, raw := range batch {
+		messages[i] = decodeJSONMessage(raw)
+	}
+	return messages, nil
+}
+
+// decodeJSONMessage decodes one JSON client message
+func decodeJSONMessage(raw []byte) inbound {
+	var msg inbound
+	if err := json.Unmarshal(raw, &msg.controlMessage); err != nil {
+		msg.envelopeErr = err
+		return msg
+	}
+	msg.eventErr = json.Unmarshal(raw, &msg.event)
+	return msg
+}
@@ -1,14 +1,92 @@
 package ws
 
+import (
+	"Tracker/internal/events"
+	"Tracker/internal/model"
+	"Tracker/internal/services"
+)
+
 // EventType constants for WebSocket events
 const (
 	EventTypeActivity = "activity"
 	EventTypeMessage  = "message"
 	EventTypeAlert    = "alert"
+	EventTypeError    = "error"
+	// EventTypeClockSync is sent by the server after the handshake and
+	// answered by the client, to estimate the client's clock skew
+	EventTypeClockSync = "clock_sync"
+	// EventTypeSubscribe and EventTypeUnsubscribe are sent by the client to
+	// follow or stop following a topic, and are confirmed with
+	// EventTypeSubscribed and EventTypeUnsubscribed
+	EventTypeSubscribe    = "subscribe"
+	EventTypeUnsubscribe  = "unsubscribe"
+	EventTypeSubscribed   = "subscribed"
+	EventTypeUnsubscribed = "unsubscribed"
+	// EventTypeSession opens every connection with the session's resume
+	// token
+	EventTypeSession = "session"
+	// EventTypeAck acknowledges messages up to a sequence number, in either
+	// direction
+	EventTypeAck = "ack"
+	// EventTypeSlowDown tells the client its messages exceed a rate limit
+	// or the server cannot keep up
+	EventTypeSlowDown = "slow_down"
+)
+
+// Error codes sent in ErrorPayload
+const (
+	ErrorCodeMalformed    = "malformed_event"
+	ErrorCodeInvalidEvent = "invalid_event"
+	ErrorCodeInvalidTopic = "invalid_topic"
+	ErrorCodeForbidden    = "forbidden"
+	ErrorCodeTooMany      = "too_many_subscriptions"
+	ErrorCodeInternal     = "internal_error"
 )
 
+// ErrorPayload tells a client why one of its messages was rejected
+type ErrorPayload struct {
+	Code          string              `json:"code"`
+	Message       string              `json:"message"`
+	ClientEventID string              `json:"clientEventId,omitempty"`
+	EventType     string              `json:"eventType,omitempty"`
+	Topic         string              `json:"topic,omitempty"`
+	Errors        []events.FieldError `json:"errors,omitempty"`
+}
+
+// WebSocketEvent is the envelope of a message sent to a client. Messages
+// published to a topic carry the topic, a message ID and the session's next
+// sequence number, and are kept until the client acknowledges them. Ack is
+// the sequence number of the last client message processed.
 type WebSocketEvent struct {
 	Type    string      `json:"type"`
-	Payload interface{} `json:"payload"`
+	ID      string      `json:"id,omitempty"`
+	Seq     uint64      `json:"seq,omitempty"`
+	Ack     uint64      `json:"ack,omitempty"`
+	Topic   string      `json:"topic,omitempty"`
+	Payload interface{} `json:"payload,omitempty"`
 }
 
+// controlMessage is the envelope of a client message. Clients that want
+// their messages deduplicated on replay number them with Seq, and events
+// carry their clientEventId as message ID. Ack acknowledges the server's
+// messages up to that sequence number.
+type controlMessage struct {
+	Type  string `json:"type"`
+	Seq   uint64 `json:"seq"`
+	Ack   uint64 `json:"ack"`
+	Topic string `json:"topic"`
+}
+
+// toUserEvent converts an event received over the WebSocket into the form
+// the event processor analyzes. Both share the canonical eventschema
+// metadata, so nothing is lost beyond the keys of custom event types.
+func toUserEvent(event *model.Event) services.UserEvent {
+	userEvent := services.UserEvent{
+		Type:      event.Type,
+		Timestamp: event.Timestamp,
+	}
+	if metadata, err := event.GetMetadata(); err == nil {
+		userEvent.Metadata = *metadata
+	}
+	return userEvent
+}
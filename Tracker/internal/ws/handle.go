@@ -1,14 +1,22 @@
 package ws
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"log"
+	"net"
 	"net/http"
+	"strconv"
+	"sync"
 	"time"
 
+	auth "Tracker/Authatication"
+	"Tracker/internal/config"
+	"Tracker/internal/events"
 	"Tracker/internal/model"
 
 	"github.com/gorilla/websocket"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 var upgrader = websocket.Upgrader{
@@ -17,6 +25,8 @@ var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool {
 		return true // In production, implement proper origin checking
 	},
+	// Per-message deflate, used when the client offers it
+	EnableCompression: true,
 }
 
 // Handler handles WebSocket connections and events
@@ -31,17 +41,61 @@ func NewHandler(manager *Manager) *Handler {
 	}
 }
 
-// HandleWebSocket upgrades HTTP connection to WebSocket and handles events
+// HandleWebSocket authenticates the handshake, upgrades the HTTP connection
+// to WebSocket and handles events. The connection belongs to the user in the
+// validated credentials and is closed when those credentials expire or are
+// revoked. A client reconnecting after a drop passes its resume token as
+// resume and the last sequence number it received as ack. The framing is
+// negotiated with Sec-WebSocket-Protocol; JSON is the default.
 func (h *Handler) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
-	// Get user ID from query parameters
-	userID := r.URL.Query().Get("userId")
-	if userID == "" {
-		http.Error(w, "userId is required", http.StatusBadRequest)
+	creds, err := auth.AuthenticateWebSocket(r.Context(), r)
+	if err != nil {
+		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		return
+	}
+	principal := creds.Principal
+	userID := principal.UserID
+
+	decision, err := auth.Check(r.Context(), principal, auth.PermEventsWrite, auth.Target{OwnerID: userID, TenantID: principal.TenantID})
+	if err != nil {
+		http.Error(w, "Failed to check permissions", http.StatusInternalServerError)
+		return
+	}
+	if !decision.Allowed {
+		http.Error(w, "Insufficient permissions", http.StatusForbidden)
+		return
+	}
+
+	// Only verified accounts may stream events
+	verified, err := auth.EmailVerified(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "Unknown user", http.StatusUnauthorized)
 		return
 	}
+	if !verified {
+		http.Error(w, "Email address is not verified", http.StatusForbidden)
+		return
+	}
+
+	resumeToken := r.URL.Query().Get("resume")
+	var ack uint64
+	if value := r.URL.Query().Get("ack"); value != "" {
+		if ack, err = strconv.ParseUint(value, 10, 64); err != nil {
+			http.Error(w, "Invalid ack", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Echo the chosen framing, as browsers passing a bearer credential in
+	// Sec-WebSocket-Protocol expect one of their protocols back
+	protocol, framing := negotiateCodec(r)
+	var responseHeader http.Header
+	if protocol != "" {
+		responseHeader = http.Header{"Sec-WebSocket-Protocol": {protocol}}
+	}
 
 	// Upgrade HTTP connection to WebSocket
-	conn, err := upgrader.Upgrade(w, r, nil)
+	conn, err := upgrader.Upgrade(w, r, responseHeader)
 	if err != nil {
 		log.Printf("Failed to upgrade connection: %v", err)
 		return
@@ -49,19 +103,68 @@ func (h *Handler) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 
 	// Create new client
 	client := NewClient(conn, userID, h.manager)
-	h.manager.RegisterClient(client)
+	client.principal = principal
+	client.codec = framing
+	if resumeToken != "" {
+		err = h.manager.ResumeClient(client, resumeToken, ack)
+	} else {
+		err = h.manager.RegisterClient(client)
+	}
+	if err != nil {
+		log.Printf("ws: failed to start session for user %s: %v", userID, err)
+		client.writeClose(websocket.CloseInternalServerErr, "")
+		conn.Close()
+		return
+	}
+	if !creds.ExpiresAt.IsZero() {
+		client.closeAt(creds.ExpiresAt)
+	}
+	client.watchRevocation(creds, h.manager.limits.PingInterval)
+
+	// Measure the client's clock skew before its events arrive
+	client.sendClockSync()
 
 	// Start goroutines for reading and writing
 	go client.ReadPump()
 	go client.WritePump()
 }
 
-// Client represents a WebSocket client
+// Client represents one WebSocket connection of a session
 type Client struct {
-	conn    *websocket.Conn
-	userID  string
-	manager *Manager
-	send    chan []byte
+	conn      *websocket.Conn
+	userID    string
+	principal *auth.Principal
+	manager   *Manager
+	send      chan []byte
+	expiry    *time.Timer
+	clock     *skewEstimator
+	// revocation re-checks the credentials every ping interval
+	revocation *time.Timer
+	// codec frames messages in the negotiated subprotocol
+	codec frameCodec
+	// reapOnce makes sure a closed connection is counted for one cause
+	reapOnce sync.Once
+
+	// session outlives the connection, see Manager.ResumeClient
+	session *session
+
+	// mu guards closed, kicked and sends on the send channel
+	mu     sync.Mutex
+	closed bool
+	// kicked is set once the connection is being closed for falling behind
+	kicked bool
+
+	// limit, slowDownUntil and pendingMove, the mouse_move event held for
+	// coalescing, are only used by the read pump
+	limit         *tokenBucket
+	slowDownUntil time.Time
+	pendingMove   *model.Event
+}
+
+// frame is one read from the connection
+type frame struct {
+	message []byte
+	err     error
 }
 
 // NewClient creates a new WebSocket client
@@ -70,63 +173,450 @@ func NewClient(conn *websocket.Conn, userID string, manager *Manager) *Client {
 		conn:    conn,
 		userID:  userID,
 		manager: manager,
-		send:    make(chan []byte, 256),
+		send:    make(chan []byte, manager.limits.SendBuffer),
+		clock:   newSkewEstimator(),
+		codec:   jsonCodec{},
+		limit:   newTokenBucket(manager.rateLimits.ConnectionRate, manager.rateLimits.ConnectionBurst),
 	}
 }
 
-// ReadPump pumps messages from the WebSocket connection
+// ReadPump pumps messages from the WebSocket connection. Connections that
+// stay silent for longer than the pong wait, pongs included, or send a
+// message over the size limit are closed. Messages over the connection's or
+// the user's rate limits are dropped and answered with slow_down.
 func (c *Client) ReadPump() {
 	defer func() {
+		if c.expiry != nil {
+			c.expiry.Stop()
+		}
+		if c.revocation != nil {
+			c.revocation.Stop()
+		}
+		c.flushMove()
+		c.reap("")
 		c.manager.UnregisterClient(c)
 		c.conn.Close()
 	}()
 
+	limits := c.manager.limits
+	c.conn.SetReadLimit(limits.MaxMessageSize)
+	_ = c.conn.SetReadDeadline(time.Now().Add(limits.PongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(limits.PongWait))
+	})
+
+	frames := make(chan frame)
+	go c.readFrames(frames)
+
+	// Held mouse_move events are flushed from this loop rather than a timer
+	// goroutine, so they keep their place among the client's other events
+	var flush <-chan time.Time
+	if window := c.manager.rateLimits.CoalesceWindow; window > 0 {
+		ticker := time.NewTicker(window)
+		defer ticker.Stop()
+		flush = ticker.C
+	}
+
+	for {
+		select {
+		case <-flush:
+			c.flushMove()
+		case f := <-frames:
+			if f.err != nil {
+				c.readFailed(f.err)
+				return
+			}
+			c.readMessage(f.message)
+		}
+	}
+}
+
+// readFrames reads from the connection until it fails, extending the read
+// deadline after every message. The failure is the last frame sent.
+func (c *Client) readFrames(frames chan<- frame) {
 	for {
 		_, message, err := c.conn.ReadMessage()
+		if err == nil {
+			_ = c.conn.SetReadDeadline(time.Now().Add(c.manager.limits.PongWait))
+		}
+		frames <- frame{message: message, err: err}
 		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("error: %v", err)
-			}
-			break
+			return
 		}
+	}
+}
+
+// readMessage decodes a message, which may batch several client messages,
+// and handles each of them
+func (c *Client) readMessage(message []byte) {
+	receivedAt := time.Now()
 
-		// Parse event
-		var event model.Event
-		if err := json.Unmarshal(message, &event); err != nil {
-			log.Printf("error parsing event: %v", err)
-			continue
+	messages, err := c.codec.decode(message)
+	if err != nil {
+		if c.allow("", receivedAt) {
+			c.sendError(ErrorPayload{Code: ErrorCodeMalformed, Message: err.Error()})
 		}
+		return
+	}
 
-		// Set event metadata
-		event.UserID = c.userID
-		event.Timestamp = time.Now()
+	// A batched frame is acknowledged once
+	sequenced := false
+	for _, msg := range messages {
+		sequenced = c.receive(msg, receivedAt) || sequenced
+	}
+	if sequenced {
+		c.sendEvent(WebSocketEvent{Type: EventTypeAck, Ack: c.session.lastInbound()})
+	}
+}
 
-		// Process event
-		c.manager.ProcessEvent(&event)
+// receive applies a client message's ack and processes the message unless
+// it is a replay, follows a gap or is over a rate limit. A sequenced message
+// only counts as received once it was handled. It reports whether the
+// message was sequenced and needs an ack, which tells the client where to
+// continue.
+func (c *Client) receive(msg inbound, receivedAt time.Time) bool {
+	if msg.envelopeErr != nil {
+		if c.allow("", receivedAt) {
+			c.sendError(ErrorPayload{Code: ErrorCodeMalformed, Message: msg.envelopeErr.Error()})
+		}
+		return false
 	}
+	if msg.Ack > 0 {
+		c.session.ack(msg.Ack)
+	}
+	if msg.Type == EventTypeAck {
+		return false
+	}
+
+	if c.session.isNextInbound(msg.Seq) && c.allow(msg.Type, receivedAt) && c.handleMessage(msg, receivedAt) {
+		c.session.acceptInbound(msg.Seq)
+	}
+	return msg.Seq > 0
 }
 
-// WritePump pumps messages to the WebSocket connection
+// handleMessage acts on one client message: a subscription change, a clock
+// sync reply or a tracking event. It returns false when a tracking event
+// could not be queued, so the client sends it again; messages that are
+// rejected as invalid count as handled.
+func (c *Client) handleMessage(msg inbound, receivedAt time.Time) bool {
+	switch msg.Type {
+	case EventTypeSubscribe:
+		c.subscribe(msg.Topic)
+		return true
+	case EventTypeUnsubscribe:
+		c.manager.Unsubscribe(c, msg.Topic)
+		c.sendEvent(WebSocketEvent{Type: EventTypeUnsubscribed, Topic: msg.Topic})
+		return true
+	}
+
+	if msg.eventErr != nil {
+		c.sendError(ErrorPayload{Code: ErrorCodeMalformed, Message: msg.eventErr.Error()})
+		return true
+	}
+	event := msg.event
+
+	// Clock sync replies are not tracking events
+	if event.Type == EventTypeClockSync {
+		id, _ := event.Metadata["id"].(string)
+		c.clock.sample(id, event.Timestamp, receivedAt)
+		return true
+	}
+
+	// Upgrade events from older clients and reject those that do not
+	// match their type's schema
+	if err := events.Normalize(&event); err != nil {
+		payload := ErrorPayload{
+			Code:          ErrorCodeInvalidEvent,
+			Message:       err.Error(),
+			ClientEventID: event.ClientEventID,
+			EventType:     event.Type,
+		}
+		var invalid *events.ValidationError
+		if errors.As(err, &invalid) {
+			payload.Errors = invalid.Errors
+		}
+		c.sendError(payload)
+		return true
+	}
+
+	// Set event metadata. The client's timestamp is kept, corrected
+	// for the connection's clock skew.
+	event.ID = primitive.NilObjectID
+	event.UserID = c.userID
+	event.TenantID = c.principal.TenantID
+	events.ApplyClientTime(&event, c.clock.Skew(), receivedAt, config.GetEventMaxClientAge())
+
+	if event.Type == model.EventMouseMove && c.manager.rateLimits.CoalesceWindow > 0 {
+		c.coalesceMove(&event)
+		return true
+	}
+
+	// Process event, after a held mouse_move so the order is kept. When the
+	// event sink is behind the event is not acknowledged and the client is
+	// told to slow down; the sink logs dropped events itself.
+	c.flushMove()
+	if err := c.manager.ProcessEvent(&event); err != nil {
+		c.slowDown(SlowDownPayload{Scope: RateScopeServer}, backlogRetry, receivedAt)
+		return false
+	}
+	return true
+}
+
+// allow applies the connection's and the user's rate limits to a message of
+// the given type. When the message is dropped the client is told to slow
+// down, at most once per retry period.
+func (c *Client) allow(messageType string, now time.Time) bool {
+	scope, wait := RateScopeConnection, c.limit.wait(now)
+	if wait == 0 {
+		if scope, wait = c.session.limiter.allow(messageType, now); wait == 0 {
+			c.limit.take()
+			return true
+		}
+	}
+
+	c.manager.rateLimited.Add(1)
+	payload := SlowDownPayload{Scope: scope}
+	if scope == RateScopeEventType {
+		payload.EventType = messageType
+	}
+	c.slowDown(payload, wait, now)
+	return false
+}
+
+// slowDown tells the client to wait before sending again, at most once per
+// retry period
+func (c *Client) slowDown(payload SlowDownPayload, wait time.Duration, now time.Time) {
+	if now.Before(c.slowDownUntil) {
+		return
+	}
+	c.slowDownUntil = now.Add(wait)
+	payload.RetryAfterMs = int64((wait + time.Millisecond - 1) / time.Millisecond)
+	c.sendEvent(WebSocketEvent{Type: EventTypeSlowDown, Payload: payload})
+}
+
+// coalesceMove holds a mouse_move event until the read pump's next flush,
+// replacing the one already held, so a burst of moves is processed as its
+// latest
+func (c *Client) coalesceMove(event *model.Event) {
+	if c.pendingMove != nil {
+		c.manager.coalesced.Add(1)
+	}
+	c.pendingMove = event
+}
+
+// flushMove processes the held mouse_move event, if any
+func (c *Client) flushMove() {
+	event := c.pendingMove
+	c.pendingMove = nil
+	if event != nil {
+		_ = c.manager.ProcessEvent(event)
+	}
+}
+
+// sendEvent queues a message for the client. It is dropped when the client
+// is not reading its messages.
+func (c *Client) sendEvent(event WebSocketEvent) bool {
+	message, err := c.codec.encode(event)
+	if err != nil {
+		return false
+	}
+	return c.deliver(message)
+}
+
+// deliver queues an encoded message without blocking. When the client's
+// buffer is full the slow consumer policy either drops the oldest waiting
+// message or disconnects the client. It reports false when the message was
+// not queued.
+func (c *Client) deliver(message []byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed || c.kicked {
+		return false
+	}
+	select {
+	case c.send <- message:
+		return true
+	default:
+	}
+
+	c.manager.dropped.Add(1)
+	if c.manager.limits.SlowConsumer == SlowConsumerDisconnect {
+		c.kicked = true
+		// Writing the close frame may block, so not under the lock
+		go c.disconnect(ReapSlowConsumer, websocket.CloseTryAgainLater, "slow consumer")
+		return false
+	}
+
+	select {
+	case <-c.send:
+	default:
+	}
+	select {
+	case c.send <- message:
+		return true
+	default:
+		return false
+	}
+}
+
+// shutdown closes the send channel. It reports false if the client was
+// already shut down.
+func (c *Client) shutdown() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return false
+	}
+	c.closed = true
+	close(c.send)
+	return true
+}
+
+// subscribe handles a subscribe request and tells the client the outcome
+func (c *Client) subscribe(topic string) {
+	err := c.manager.Subscribe(c, topic)
+	if err == nil {
+		c.sendEvent(WebSocketEvent{Type: EventTypeSubscribed, Topic: topic})
+		return
+	}
+
+	payload := ErrorPayload{Code: ErrorCodeInternal, Message: "failed to subscribe", Topic: topic}
+	switch {
+	case errors.Is(err, ErrInvalidTopic):
+		payload.Code, payload.Message = ErrorCodeInvalidTopic, err.Error()
+	case errors.Is(err, ErrTopicForbidden):
+		payload.Code, payload.Message = ErrorCodeForbidden, err.Error()
+	case errors.Is(err, ErrTooManySubscriptions):
+		payload.Code, payload.Message = ErrorCodeTooMany, err.Error()
+	default:
+		log.Printf("ws: failed to subscribe user %s to %s: %v", c.userID, topic, err)
+	}
+	c.sendError(payload)
+}
+
+// sendError tells the client one of its messages was rejected
+func (c *Client) sendError(payload ErrorPayload) {
+	c.sendEvent(WebSocketEvent{Type: EventTypeError, Payload: payload})
+}
+
+// sendClockSync sends a clock sync ping
+func (c *Client) sendClockSync() {
+	sentAt := time.Now()
+	c.sendEvent(WebSocketEvent{
+		Type:    EventTypeClockSync,
+		Payload: ClockSyncPayload{ID: c.clock.ping(sentAt), ServerTime: sentAt},
+	})
+}
+
+// readFailed closes the connection after a failed read, with a close code
+// telling the client why
+func (c *Client) readFailed(err error) {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, websocket.ErrReadLimit):
+		// The connection already sent CloseMessageTooBig
+		c.reap(ReapMessageTooLarge)
+	case errors.As(err, &netErr) && netErr.Timeout():
+		c.reap(ReapPongTimeout)
+		c.writeClose(websocket.CloseGoingAway, "pong timeout")
+	case websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway, websocket.CloseAbnormalClosure):
+		log.Printf("ws: connection of user %s failed: %v", c.userID, err)
+	}
+}
+
+// reap records why the server closed the connection. Only the first cause
+// counts; an empty cause marks a connection that ended on its own.
+func (c *Client) reap(cause string) {
+	c.reapOnce.Do(func() {
+		if cause != "" {
+			c.manager.reaped.add(cause)
+		}
+	})
+}
+
+// writeClose sends a close frame without waiting longer than the write
+// timeout
+func (c *Client) writeClose(code int, text string) {
+	msg := websocket.FormatCloseMessage(code, text)
+	_ = c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(c.manager.limits.WriteTimeout))
+}
+
+// disconnect closes the connection with a close code and counts it for
+// cause
+func (c *Client) disconnect(cause string, code int, text string) {
+	c.reap(cause)
+	c.writeClose(code, text)
+	c.conn.Close()
+}
+
+// closeAt closes the connection with a policy violation once the
+// credentials it was opened with expire
+func (c *Client) closeAt(expiresAt time.Time) {
+	c.expiry = time.AfterFunc(time.Until(expiresAt), func() {
+		c.disconnect(ReapTokenExpired, websocket.ClosePolicyViolation, "token expired")
+	})
+}
+
+// watchRevocation re-checks the credentials the connection was opened with
+// every interval and closes it with a policy violation once they are
+// revoked. API keys never expire, so this is what ends their connections.
+func (c *Client) watchRevocation(creds *auth.WebSocketSession, interval time.Duration) {
+	c.revocation = time.AfterFunc(interval, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), c.manager.limits.WriteTimeout)
+		revoked, err := creds.Revoked(ctx)
+		cancel()
+		if err != nil {
+			// Keep the connection on a lookup failure and try again later
+			log.Printf("ws: failed to check credentials of user %s: %v", c.userID, err)
+		}
+		if revoked {
+			c.disconnect(ReapRevoked, websocket.ClosePolicyViolation, "credentials revoked")
+			return
+		}
+
+		c.mu.Lock()
+		closed := c.closed
+		c.mu.Unlock()
+		if !closed {
+			c.revocation.Reset(interval)
+		}
+	})
+}
+
+// WritePump pumps messages to the WebSocket connection and pings the client
+// every ping interval. A write that does not finish within the write
+// timeout closes the connection.
 func (c *Client) WritePump() {
+	limits := c.manager.limits
+	ticker := time.NewTicker(limits.PingInterval)
 	defer func() {
+		ticker.Stop()
 		c.conn.Close()
 	}()
-		for {
-			select {
-			case message, ok := <-c.send:
-				if !ok {
-					// The channel was closed, so close the WebSocket connection
-					_ = c.conn.WriteMessage(websocket.CloseMessage, []byte{})
-					return
-				}
-	
-				// Send the message to the WebSocket connection
-				if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
-					return
-				}
+
+	for {
+		select {
+		case message, ok := <-c.send:
+			if !ok {
+				// The client was unregistered, so close the connection
+				c.writeClose(websocket.CloseNormalClosure, "")
+				return
+			}
+
+			// Send the message to the WebSocket connection
+			_ = c.conn.SetWriteDeadline(time.Now().Add(limits.WriteTimeout))
+			if err := c.conn.WriteMessage(c.codec.frameType(), message); err != nil {
+				c.reap(ReapWriteFailed)
+				return
+			}
+		case <-ticker.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(limits.WriteTimeout)); err != nil {
+				c.reap(ReapWriteFailed)
+				return
 			}
 		}
 	}
+}
 
 func handleWSError(conn *websocket.Conn, err error) {
     errMsg := struct {
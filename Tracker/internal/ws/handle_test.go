@@ -0,0 +1,265 @@
+package ws
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	auth "Tracker/Authatication"
+	"Tracker/internal/model"
+
+	"github.com/gorilla/websocket"
+)
+
+// recordingSink keeps the events handed to it in order
+type recordingSink struct {
+	mu     sync.Mutex
+	events []model.Event
+	// err, when set, is returned instead of recording
+	err error
+}
+
+func (s *recordingSink) Enqueue(event *model.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	s.events = append(s.events, *event)
+	return nil
+}
+
+// fail makes every following Enqueue return err, or record again if nil
+func (s *recordingSink) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
+
+// snapshot returns the events received so far
+func (s *recordingSink) snapshot() []model.Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]model.Event(nil), s.events...)
+}
+
+// newTestManager creates a manager with generous limits and the given
+// mouse_move coalesce window
+func newTestManager(sink EventSink, coalesce time.Duration) *Manager {
+	m := NewManager(sink, nil)
+	m.SetLimits(Limits{
+		PingInterval:   time.Minute,
+		PongWait:       2 * time.Minute,
+		WriteTimeout:   time.Second,
+		MaxMessageSize: 64 << 10,
+		ResumeGrace:    time.Minute,
+		ResumeBuffer:   16,
+		SendBuffer:     64,
+		SlowConsumer:   SlowConsumerDropOldest,
+	})
+	m.SetRateLimits(RateLimits{
+		ConnectionRate:  1000,
+		ConnectionBurst: 1000,
+		UserRate:        1000,
+		UserBurst:       1000,
+		CoalesceWindow:  coalesce,
+	})
+	return m
+}
+
+// newTestServer serves WebSocket connections for user-1 without the
+// handshake authentication of HandleWebSocket
+func newTestServer(t *testing.T, m *Manager) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		client := NewClient(conn, "user-1", m)
+		client.principal = &auth.Principal{UserID: "user-1", TenantID: "tenant-1"}
+
+		if token := r.URL.Query().Get("resume"); token != "" {
+			ack, _ := strconv.ParseUint(r.URL.Query().Get("ack"), 10, 64)
+			err = m.ResumeClient(client, token, ack)
+		} else {
+			err = m.RegisterClient(client)
+		}
+		if err != nil {
+			conn.Close()
+			return
+		}
+		go client.ReadPump()
+		go client.WritePump()
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// dial opens a connection to the test server with an optional query
+func dial(t *testing.T, server *httptest.Server, query string) *websocket.Conn {
+	t.Helper()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+	if query != "" {
+		url += "?" + query
+	}
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// readUntil reads server messages until one of the given type arrives
+func readUntil(t *testing.T, conn *websocket.Conn, messageType string) WebSocketEvent {
+	t.Helper()
+
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var event WebSocketEvent
+		if err := conn.ReadJSON(&event); err != nil {
+			t.Fatalf("waiting for %s: %v", messageType, err)
+		}
+		if event.Type == messageType {
+			return event
+		}
+	}
+}
+
+// pointerEvent builds a sequenced mouse_move or click client message
+func pointerEvent(seq uint64, eventType string, x int) map[string]interface{} {
+	return map[string]interface{}{
+		"type":          eventType,
+		"seq":           seq,
+		"clientEventId": eventType + "-" + strconv.Itoa(x),
+		"timestamp":     time.Now().UTC(),
+		"metadata":      map[string]interface{}{"x": x, "y": 0},
+	}
+}
+
+// eventIDs lists the client event IDs of events in order
+func eventIDs(events []model.Event) []string {
+	ids := make([]string, len(events))
+	for i, event := range events {
+		ids[i] = event.ClientEventID
+	}
+	return ids
+}
+
+func TestHeldMouseMoveKeepsItsPlace(t *testing.T) {
+	sink := &recordingSink{}
+	m := newTestManager(sink, 20*time.Millisecond)
+	conn := dial(t, newTestServer(t, m), "")
+	readUntil(t, conn, EventTypeSession)
+
+	// A burst of moves is processed as its latest, before the click
+	batch := []interface{}{
+		pointerEvent(1, model.EventMouseMove, 1),
+		pointerEvent(2, model.EventMouseMove, 2),
+		pointerEvent(3, model.EventClick, 3),
+	}
+	if err := conn.WriteJSON(batch); err != nil {
+		t.Fatal(err)
+	}
+	if ack := readUntil(t, conn, EventTypeAck); ack.Ack != 3 {
+		t.Fatalf("ack = %d, want 3", ack.Ack)
+	}
+
+	// A move on its own is flushed by the read pump's ticker
+	if err := conn.WriteJSON(pointerEvent(4, model.EventMouseMove, 4)); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(sink.snapshot()) < 3 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	got := eventIDs(sink.snapshot())
+	want := []string{"mouse_move-2", "click-3", "mouse_move-4"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("processed %v, want %v", got, want)
+	}
+	if coalesced := m.coalesced.Load(); coalesced != 1 {
+		t.Errorf("coalesced = %d, want 1", coalesced)
+	}
+}
+
+// closeCode reads until the connection fails and returns the close code
+// the server sent, or 0 if it sent none
+func closeCode(t *testing.T, conn *websocket.Conn) int {
+	t.Helper()
+
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			var closeErr *websocket.CloseError
+			if errors.As(err, &closeErr) {
+				return closeErr.Code
+			}
+			return 0
+		}
+	}
+}
+
+func TestHeartbeatReaps(t *testing.T) {
+	tests := []struct {
+		name  string
+		edit  func(limits *Limits)
+		send  func(conn *websocket.Conn) error
+		cause string
+		code  int
+	}{
+		{
+			name: "pong timeout",
+			edit: func(limits *Limits) {
+				limits.PingInterval = 20 * time.Millisecond
+				limits.PongWait = 100 * time.Millisecond
+			},
+			// The client leaves pings unanswered
+			send: func(conn *websocket.Conn) error {
+				conn.SetPingHandler(func(string) error { return nil })
+				return nil
+			},
+			cause: ReapPongTimeout,
+			code:  websocket.CloseGoingAway,
+		},
+		{
+			name: "message too large",
+			edit: func(limits *Limits) { limits.MaxMessageSize = 64 },
+			send: func(conn *websocket.Conn) error {
+				return conn.WriteJSON(map[string]string{"type": EventTypeAck, "padding": strings.Repeat("x", 128)})
+			},
+			cause: ReapMessageTooLarge,
+			code:  websocket.CloseMessageTooBig,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestManager(&recordingSink{}, 0)
+			limits := m.limits
+			tt.edit(&limits)
+			m.SetLimits(limits)
+
+			conn := dial(t, newTestServer(t, m), "")
+			if err := tt.send(conn); err != nil {
+				t.Fatal(err)
+			}
+			waitFor(t, func() bool { return m.Stats().Reaped[tt.cause] == 1 })
+
+			if code := closeCode(t, conn); code != tt.code {
+				t.Errorf("close code %d, want %d", code, tt.code)
+			}
+			for cause, count := range m.Stats().Reaped {
+				if cause != tt.cause && count != 0 {
+					t.Errorf("also reaped %d for %s", count, cause)
+				}
+			}
+		})
+	}
+}
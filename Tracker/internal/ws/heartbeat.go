@@ -0,0 +1,122 @@
+package ws
+
+import (
+	"sync/atomic"
+	"time"
+
+	"Tracker/internal/config"
+)
+
+// Causes for which the server closes a connection
+const (
+	ReapPongTimeout     = "pong_timeout"
+	ReapMessageTooLarge = "message_too_large"
+	ReapWriteFailed     = "write_failed"
+	ReapTokenExpired    = "token_expired"
+	ReapSlowConsumer    = "slow_consumer"
+	ReapRevoked         = "credentials_revoked"
+)
+
+// reapCauses lists every cause counted in ConnectionStats
+var reapCauses = []string{ReapPongTimeout, ReapMessageTooLarge, ReapWriteFailed, ReapTokenExpired, ReapSlowConsumer, ReapRevoked}
+
+// Policies for clients whose send buffer is full. Dropped messages stay in
+// the session until acknowledged, so the client can get them back by
+// resuming after it sees a gap in the sequence numbers.
+const (
+	SlowConsumerDropOldest = "drop_oldest"
+	SlowConsumerDisconnect = "disconnect"
+)
+
+// Limits are the heartbeat, size and resume limits applied to every
+// connection
+type Limits struct {
+	// PingInterval is how often the server pings the client
+	PingInterval time.Duration
+	// PongWait is how long the connection may stay silent before it is
+	// closed. Any message, pongs included, resets it.
+	PongWait time.Duration
+	// WriteTimeout bounds a single write to the client
+	WriteTimeout time.Duration
+	// MaxMessageSize is the largest message in bytes the client may send
+	MaxMessageSize int64
+	// ResumeGrace is how long a session waits for its client to reconnect
+	ResumeGrace time.Duration
+	// ResumeBuffer is the most unacknowledged messages a session keeps
+	ResumeBuffer int
+	// SendBuffer is how many messages may wait to be written
+	SendBuffer int
+	// SlowConsumer is the policy applied when the send buffer is full
+	SlowConsumer string
+}
+
+// LimitsFromConfig reads the limits from the WS_* settings. A ping interval
+// that would not fit in the pong wait is shortened.
+func LimitsFromConfig() Limits {
+	limits := Limits{
+		PingInterval:   config.GetWSPingInterval(),
+		PongWait:       config.GetWSPongWait(),
+		WriteTimeout:   config.GetWSWriteTimeout(),
+		MaxMessageSize: int64(config.GetWSMaxMessageSize()),
+		ResumeGrace:    config.GetWSResumeGrace(),
+		ResumeBuffer:   config.GetWSResumeBuffer(),
+		SendBuffer:     config.GetWSSendBuffer(),
+		SlowConsumer:   config.GetWSSlowConsumerPolicy(),
+	}
+	if limits.ResumeBuffer < 1 {
+		limits.ResumeBuffer = 1
+	}
+	if limits.SendBuffer < 1 {
+		limits.SendBuffer = 1
+	}
+	if limits.SlowConsumer != SlowConsumerDisconnect {
+		limits.SlowConsumer = SlowConsumerDropOldest
+	}
+	if limits.PingInterval <= 0 || limits.PingInterval >= limits.PongWait {
+		limits.PingInterval = limits.PongWait * 9 / 10
+	}
+	return limits
+}
+
+// ConnectionStats counts open connections, sessions including those
+// waiting for a reconnect, and connections the server closed, by cause.
+// RateLimited counts client messages dropped by a rate limit, Coalesced the
+// mouse_move events merged into a later one and Dropped the messages a
+// slow client missed.
+type ConnectionStats struct {
+	Connected   int64            `json:"connected"`
+	Sessions    int              `json:"sessions"`
+	Reaped      map[string]int64 `json:"reaped"`
+	RateLimited int64            `json:"rateLimited"`
+	Coalesced   int64            `json:"coalesced"`
+	Dropped     int64            `json:"dropped"`
+}
+
+// reapCounters counts closed connections by cause. The map is filled once
+// and only read afterwards.
+type reapCounters map[string]*atomic.Int64
+
+// newReapCounters creates a counter for every cause
+func newReapCounters() reapCounters {
+	counters := make(reapCounters, len(reapCauses))
+	for _, cause := range reapCauses {
+		counters[cause] = new(atomic.Int64)
+	}
+	return counters
+}
+
+// add counts a closed connection
+func (r reapCounters) add(cause string) {
+	if counter, ok := r[cause]; ok {
+		counter.Add(1)
+	}
+}
+
+// snapshot returns the current counts
+func (r reapCounters) snapshot() map[string]int64 {
+	counts := make(map[string]int64, len(r))
+	for cause, counter := range r {
+		counts[cause] = counter.Load()
+	}
+	return counts
+}
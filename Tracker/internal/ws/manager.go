@@ -1,69 +1,345 @@
 package ws
 
 import (
-	"Tracker/internal/model"
+	"context"
+	"encoding/json"
+	"errors"
+	"hash/fnv"
+	"log"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"Tracker/internal/model"
+	"Tracker/internal/services"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// topicShards is the number of independently locked topic tables
+const topicShards = 32
+
+// maxSubscriptionsPerSession bounds the topics one session can follow
+const maxSubscriptionsPerSession = 64
+
+// subscribeTimeout bounds the permission lookups of a subscription
+const subscribeTimeout = 5 * time.Second
+
+// ErrTooManySubscriptions is returned when a client follows too many topics
+var ErrTooManySubscriptions = errors.New("too many subscriptions")
+
+// EventSink persists events received from clients. Enqueue must not block.
+type EventSink interface {
+	Enqueue(event *model.Event) error
+}
+
+// topicShard holds the subscribers of the topics hashed to it
+type topicShard struct {
+	mu          sync.RWMutex
+	subscribers map[string]map[*session]struct{}
+}
+
+// Manager tracks client sessions and routes messages to the sessions
+// subscribed to a topic. Topics are spread over shards so publishing to one
+// topic does not contend with subscriptions to others. A session survives
+// its connection for the resume grace period, so a client that reconnects
+// keeps its subscriptions and gets the messages it missed.
 type Manager struct {
-	clients    map[*Client]bool
-	register   chan *Client
-	unregister chan *Client
-	broadcast  chan []byte
-	mu         sync.Mutex
-}
-
-func NewManager() *Manager {
-	return &Manager{
-		clients:    make(map[*Client]bool),
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
-		broadcast:  make(chan []byte),
-	}
-}
-
-func (m *Manager) Run() {
-	for {
-		select {
-		case client := <-m.register:
-			m.mu.Lock()
-			m.clients[client] = true
-			m.mu.Unlock()
-		case client := <-m.unregister:
-			m.mu.Lock()
-			if _, ok := m.clients[client]; ok {
-				delete(m.clients, client)
-				close(client.send)
-			}
-			m.mu.Unlock()
-		case message := <-m.broadcast:
-			m.mu.Lock()
-			for client := range m.clients {
-				select {
-				case client.send <- message:
-				default:
-					close(client.send)
-					delete(m.clients, client)
-				}
-			}
-			m.mu.Unlock()
-		}
+	shards     [topicShards]topicShard
+	sessionsMu sync.Mutex
+	sessions   map[string]*session
+	clients    atomic.Int64
+	events     EventSink
+	processor  *services.EventProcessor
+	authorize  TopicAuthorizer
+	limits     Limits
+	reaped     reapCounters
+
+	rateLimits RateLimits
+	limitersMu sync.Mutex
+	limiters   map[string]*userLimiter
+
+	rateLimited atomic.Int64
+	coalesced   atomic.Int64
+	dropped     atomic.Int64
+}
+
+// NewManager creates a manager that hands client events to sink for storage
+// and to processor for analysis. Either may be nil.
+func NewManager(sink EventSink, processor *services.EventProcessor) *Manager {
+	m := &Manager{
+		events:    sink,
+		processor: processor,
+		authorize: AuthorizeTopic,
+		limits:    LimitsFromConfig(),
+		reaped:    newReapCounters(),
+		sessions:  make(map[string]*session),
+
+		rateLimits: RateLimitsFromConfig(),
+		limiters:   make(map[string]*userLimiter),
 	}
+	for i := range m.shards {
+		m.shards[i].subscribers = make(map[string]map[*session]struct{})
+	}
+	return m
+}
+
+// SetTopicAuthorizer replaces the permission check for subscriptions
+func (m *Manager) SetTopicAuthorizer(authorize TopicAuthorizer) {
+	m.authorize = authorize
+}
+
+// SetLimits replaces the heartbeat, size and resume limits for new
+// connections
+func (m *Manager) SetLimits(limits Limits) {
+	m.limits = limits
 }
 
-func (m *Manager) RegisterClient(client *Client) {
-	m.register <- client
+// SetRateLimits replaces the rate limits for new connections
+func (m *Manager) SetRateLimits(limits RateLimits) {
+	m.rateLimits = limits
+}
+
+// shard returns the shard holding a topic
+func (m *Manager) shard(topic string) *topicShard {
+	h := fnv.New32a()
+	h.Write([]byte(topic))
+	return &m.shards[h.Sum32()%topicShards]
+}
+
+// RegisterClient attaches a client to a new session. Every session follows
+// its user's own analyses without asking.
+func (m *Manager) RegisterClient(client *Client) error {
+	s, err := newSession(client.userID)
+	if err != nil {
+		return err
+	}
+	s.limiter = m.acquireUserLimiter(client.userID)
+	m.sessionsMu.Lock()
+	m.sessions[s.token] = s
+	m.sessionsMu.Unlock()
+
+	client.manager = m
+	m.clients.Add(1)
+	s.attach(client, 0, false, m.limits.ResumeGrace)
+	if err := m.addSubscription(s, UserAnalysisTopic(client.userID)); err != nil {
+		log.Printf("ws: failed to subscribe user %s to own analyses: %v", client.userID, err)
+	}
+	return nil
+}
+
+// ResumeClient attaches a client to the session of a resume token and
+// replays the messages after ack. A connection still attached to the
+// session is closed. When the session cannot be resumed the client gets a
+// new one, and learns so from the session message.
+func (m *Manager) ResumeClient(client *Client, token string, ack uint64) error {
+	m.sessionsMu.Lock()
+	s := m.sessions[token]
+	m.sessionsMu.Unlock()
+	if s == nil {
+		return m.RegisterClient(client)
+	}
+
 	client.manager = m
+	previous, ok := s.attach(client, ack, true, m.limits.ResumeGrace)
+	if !ok {
+		return m.RegisterClient(client)
+	}
+	m.clients.Add(1)
+	if previous != nil {
+		m.closeClient(previous)
+	}
+	return nil
 }
 
+// UnregisterClient detaches a client from its session and closes its send
+// channel. The session waits for a reconnect for the resume grace period.
+// It is safe to call more than once.
 func (m *Manager) UnregisterClient(client *Client) {
-	m.unregister <- client
+	if s := client.session; s != nil {
+		s.detach(client, m.limits.ResumeGrace, func() { m.endSession(s) })
+	}
+	m.closeClient(client)
+}
+
+// closeClient closes a client's send channel, which makes its write pump
+// close the connection
+func (m *Manager) closeClient(client *Client) {
+	if client.shutdown() {
+		m.clients.Add(-1)
+	}
+}
+
+// endSession drops a session nobody resumed in time. After a user's last
+// session the processor forgets their buffered events.
+func (m *Manager) endSession(s *session) {
+	topics, ok := s.close()
+	if !ok {
+		return
+	}
+	for _, topic := range topics {
+		m.removeSubscriber(topic, s)
+	}
+	m.sessionsMu.Lock()
+	delete(m.sessions, s.token)
+	m.sessionsMu.Unlock()
+	if m.releaseUserLimiter(s.userID) && m.processor != nil {
+		m.processor.ForgetUser(s.userID)
+	}
+}
+
+// Clients returns the number of connected clients
+func (m *Manager) Clients() int64 {
+	return m.clients.Load()
+}
+
+// Stats returns the number of connected clients and how many connections
+// the server closed, by cause
+func (m *Manager) Stats() ConnectionStats {
+	return ConnectionStats{
+		Connected: m.clients.Load(),
+		Sessions:  m.sessionCount(),
+		Reaped:    m.reaped.snapshot(),
+
+		RateLimited: m.rateLimited.Load(),
+		Coalesced:   m.coalesced.Load(),
+		Dropped:     m.dropped.Load(),
+	}
+}
+
+// sessionCount returns the number of sessions, attached or not
+func (m *Manager) sessionCount() int {
+	m.sessionsMu.Lock()
+	defer m.sessionsMu.Unlock()
+	return len(m.sessions)
 }
 
-func (m *Manager) Broadcast(event *model.Event) {
-	// Marshal event to JSON and broadcast (implementation can be added)
+// Subscribe adds a client's session to a topic after checking the client
+// may see the topic
+func (m *Manager) Subscribe(client *Client, topic string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), subscribeTimeout)
+	defer cancel()
+
+	if err := m.authorize(ctx, client.principal, topic); err != nil {
+		return err
+	}
+	return m.addSubscription(client.session, topic)
+}
+
+// addSubscription adds a session to a topic without a permission check
+func (m *Manager) addSubscription(s *session, topic string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	if _, ok := s.topics[topic]; ok {
+		return nil
+	}
+	if len(s.topics) >= maxSubscriptionsPerSession {
+		return ErrTooManySubscriptions
+	}
+	s.topics[topic] = struct{}{}
+
+	shard := m.shard(topic)
+	shard.mu.Lock()
+	subscribers, ok := shard.subscribers[topic]
+	if !ok {
+		subscribers = make(map[*session]struct{})
+		shard.subscribers[topic] = subscribers
+	}
+	subscribers[s] = struct{}{}
+	shard.mu.Unlock()
+	return nil
+}
+
+// Unsubscribe removes a client's session from a topic
+func (m *Manager) Unsubscribe(client *Client, topic string) {
+	s := client.session
+	s.mu.Lock()
+	_, ok := s.topics[topic]
+	delete(s.topics, topic)
+	s.mu.Unlock()
+
+	if ok {
+		m.removeSubscriber(topic, s)
+	}
 }
 
-func (m *Manager) ProcessEvent(event *model.Event) {
-	// Placeholder for event processing logic (see processor.go)
+// removeSubscriber drops a session from a topic's subscribers
+func (m *Manager) removeSubscriber(topic string, s *session) {
+	shard := m.shard(topic)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if subscribers, ok := shard.subscribers[topic]; ok {
+		delete(subscribers, s)
+		if len(subscribers) == 0 {
+			delete(shard.subscribers, topic)
+		}
+	}
+}
+
+// Publish sends a message to every session subscribed to a topic and
+// returns how many connected clients it was queued for. Each session gives
+// the message its own sequence number and keeps it until acknowledged, so
+// clients that are away or too slow get it when they resume.
+func (m *Manager) Publish(topic string, event WebSocketEvent) (int, error) {
+	// Encode the payload once for all subscribers
+	payload, err := json.Marshal(event.Payload)
+	if err != nil {
+		return 0, err
+	}
+	event.Payload = json.RawMessage(payload)
+	event.ID = primitive.NewObjectID().Hex()
+	event.Topic = topic
+
+	shard := m.shard(topic)
+	shard.mu.RLock()
+	subscribers := make([]*session, 0, len(shard.subscribers[topic]))
+	for s := range shard.subscribers[topic] {
+		subscribers = append(subscribers, s)
+	}
+	shard.mu.RUnlock()
+
+	delivered := 0
+	for _, s := range subscribers {
+		if s.deliver(event, m.limits.ResumeBuffer) {
+			delivered++
+		}
+	}
+	return delivered, nil
+}
+
+// Broadcast publishes an event to the live activity topic of its user
+func (m *Manager) Broadcast(event *model.Event) error {
+	_, err := m.Publish(ActivityTopic(event.UserID), WebSocketEvent{Type: EventTypeActivity, Payload: event})
+	return err
+}
+
+// ProcessEvent queues a client event for persistence, forwards it to the
+// event processor and publishes it to its activity topic. Events the sink
+// cannot take go no further, so a client retrying them is not counted twice.
+func (m *Manager) ProcessEvent(event *model.Event) error {
+	if m.events != nil {
+		if err := m.events.Enqueue(event); err != nil {
+			return err
+		}
+	}
+	if err := m.Broadcast(event); err != nil {
+		log.Printf("ws: failed to publish event for user %s: %v", event.UserID, err)
+	}
+	if m.processor != nil {
+		return m.processor.ProcessEvent(context.Background(), event.UserID, toUserEvent(event))
+	}
+	return nil
+}
+
+// PushAnalysis publishes an analysis to the analyzed user's analysis topic.
+// It is meant to be the event processor's analysis handler.
+func (m *Manager) PushAnalysis(analysis *services.ActivityAnalysis) {
+	if _, err := m.Publish(UserAnalysisTopic(analysis.UserID), WebSocketEvent{Type: EventTypeActivity, Payload: analysis}); err != nil {
+		log.Printf("ws: failed to push analysis to user %s: %v", analysis.UserID, err)
+	}
 }
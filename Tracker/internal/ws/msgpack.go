@@ -0,0 +1,108 @@
+package ws
+
+import (
+	"encoding/json"
+	"reflect"
+
+	"github.com/gorilla/websocket"
+	"github.com/ugorji/go/codec"
+)
+
+// msgpackHandle encodes times with the MessagePack timestamp extension and
+// decodes maps with string keys, as the event metadata expects
+var msgpackHandle = func() *codec.MsgpackHandle {
+	h := &codec.MsgpackHandle{}
+	h.WriteExt = true
+	h.RawToString = true
+	h.MapType = reflect.TypeOf(map[string]interface{}(nil))
+	return h
+}()
+
+// msgpackCodec frames messages as MessagePack maps with the same keys as
+// the JSON framing. A batch is an array of maps.
+type msgpackCodec struct{}
+
+// frameType returns the binary message type
+func (msgpackCodec) frameType() int {
+	return websocket.BinaryMessage
+}
+
+// encode marshals a server message. Payloads go through their JSON form so
+// IDs and times look the same as in the JSON framing.
+func (msgpackCodec) encode(event WebSocketEvent) ([]byte, error) {
+	if event.Payload != nil {
+		raw, err := json.Marshal(event.Payload)
+		if err != nil {
+			return nil, err
+		}
+		var payload interface{}
+		if err := json.Unmarshal(raw, &payload); err != nil {
+			return nil, err
+		}
+		event.Payload = payload
+	}
+
+	var frame []byte
+	err := codec.NewEncoderBytes(&frame, msgpackHandle).Encode(event)
+	return frame, err
+}
+
+// decode reads a map or an array of maps
+func (msgpackCodec) decode(frame []byte) ([]inbound, error) {
+	if isMsgpackArray(frame) {
+		var batch []wireMessage
+		if err := codec.NewDecoderBytes(frame, msgpackHandle).Decode(&batch); err != nil {
+			return nil, err
+		}
+		messages := make([]inbound, len(batch))
+		for i := range batch {
+			jsonNumbers(batch[i].Metadata)
+			messages[i] = batch[i].toInbound()
+		}
+		return messages, nil
+	}
+
+	var msg wireMessage
+	if err := codec.NewDecoderBytes(frame, msgpackHandle).Decode(&msg); err != nil {
+		return nil, err
+	}
+	jsonNumbers(msg.Metadata)
+	return []inbound{msg.toInbound()}, nil
+}
+
+// jsonNumbers turns the integers MessagePack decodes into float64, the
+// number type of decoded JSON that the event schemas check for, in nested
+// maps and arrays too
+func jsonNumbers(values map[string]interface{}) {
+	for key, value := range values {
+		values[key] = jsonNumber(value)
+	}
+}
+
+// jsonNumber converts one decoded value for jsonNumbers
+func jsonNumber(value interface{}) interface{} {
+	switch v := value.(type) {
+	case int64:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	case float32:
+		return float64(v)
+	case map[string]interface{}:
+		jsonNumbers(v)
+	case []interface{}:
+		for i := range v {
+			v[i] = jsonNumber(v[i])
+		}
+	}
+	return value
+}
+
+// isMsgpackArray reports whether a frame starts with an array header
+func isMsgpackArray(frame []byte) bool {
+	if len(frame) == 0 {
+		return false
+	}
+	b := frame[0]
+	return b&0xf0 == 0x90 || b == 0xdc || b == 0xdd
+}
@@ -0,0 +1,109 @@
+package ws
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ugorji/go/codec"
+)
+
+// encodeMsgpack marshals a client message the way a client would
+func encodeMsgpack(t *testing.T, v interface{}) []byte {
+	t.Helper()
+
+	var frame []byte
+	if err := codec.NewEncoderBytes(&frame, msgpackHandle).Encode(v); err != nil {
+		t.Fatal(err)
+	}
+	return frame
+}
+
+func TestMsgpackDecode(t *testing.T) {
+	timestamp := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	message := map[string]interface{}{
+		"type":          "click",
+		"seq":           uint64(3),
+		"ack":           uint64(2),
+		"clientEventId": "evt-1",
+		"timestamp":     timestamp,
+		"schemaVersion": 2,
+		"metadata": map[string]interface{}{
+			"x":    12,
+			"y":    -4,
+			"url":  "https://example.com",
+			"path": []interface{}{1, map[string]interface{}{"depth": uint64(2)}, []interface{}{int8(3)}},
+		},
+	}
+
+	tests := []struct {
+		name  string
+		frame []byte
+		count int
+	}{
+		{"single", encodeMsgpack(t, message), 1},
+		{"batch", encodeMsgpack(t, []interface{}{message, message}), 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messages, err := msgpackCodec{}.decode(tt.frame)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(messages) != tt.count {
+				t.Fatalf("decoded %d messages, want %d", len(messages), tt.count)
+			}
+
+			msg := messages[0]
+			if msg.Type != "click" || msg.Seq != 3 || msg.Ack != 2 {
+				t.Errorf("envelope = %+v", msg.controlMessage)
+			}
+			if msg.event.ClientEventID != "evt-1" || msg.event.SchemaVersion != 2 || !msg.event.Timestamp.Equal(timestamp) {
+				t.Errorf("event = %+v", msg.event)
+			}
+
+			// Integers come out as float64 however deeply they are nested
+			want := map[string]interface{}{
+				"x":    float64(12),
+				"y":    float64(-4),
+				"url":  "https://example.com",
+				"path": []interface{}{float64(1), map[string]interface{}{"depth": float64(2)}, []interface{}{float64(3)}},
+			}
+			if !reflect.DeepEqual(msg.event.Metadata, want) {
+				t.Errorf("metadata = %#v, want %#v", msg.event.Metadata, want)
+			}
+		})
+	}
+}
+
+func TestMsgpackDecodeMalformed(t *testing.T) {
+	if _, err := (msgpackCodec{}).decode([]byte{0x92, 0xc1}); err == nil {
+		t.Error("decoded a truncated batch")
+	}
+}
+
+func TestMsgpackEncodeUsesJSONPayloads(t *testing.T) {
+	frame, err := msgpackCodec{}.encode(WebSocketEvent{
+		Type:    EventTypeSession,
+		Seq:     4,
+		Payload: SessionPayload{ResumeToken: "token", LastSeq: 9, GraceSeconds: 30},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded map[string]interface{}
+	if err := codec.NewDecoderBytes(frame, msgpackHandle).Decode(&decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded["type"] != EventTypeSession {
+		t.Errorf("type = %v", decoded["type"])
+	}
+	payload, _ := decoded["payload"].(map[string]interface{})
+	if payload["resumeToken"] != "token" || payload["graceSeconds"] == nil {
+		t.Errorf("payload = %#v, want the JSON field names", payload)
+	}
+	if _, ok := payload["ResumeToken"]; ok {
+		t.Error("payload uses Go field names")
+	}
+}
@@ -0,0 +1,380 @@
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"time"
+
+	"Tracker/internal/model"
+	"Tracker/internal/services"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// errProtobufType is returned for a field sent with the wrong wire type
+var errProtobufType = errors.New("protobuf: unexpected wire type")
+
+// Field numbers of the messages in tracker.proto
+const (
+	pbFrameMessages = 1
+
+	pbClientType          = 1
+	pbClientSeq           = 2
+	pbClientAck           = 3
+	pbClientTopic         = 4
+	pbClientEventID       = 5
+	pbClientTimestampMs   = 6
+	pbClientSchemaVersion = 7
+	pbClientMetadata      = 8
+	pbClientExtraMetadata = 9
+
+	pbServerType     = 1
+	pbServerID       = 2
+	pbServerSeq      = 3
+	pbServerAck      = 4
+	pbServerTopic    = 5
+	pbServerPayload  = 6
+	pbServerEvent    = 7
+	pbServerAnalysis = 8
+
+	pbEventID            = 1
+	pbEventUserID        = 2
+	pbEventTenantID      = 3
+	pbEventType          = 4
+	pbEventTimestampMs   = 5
+	pbEventMetadata      = 6
+	pbEventExtraMetadata = 7
+	pbEventClientEventID = 8
+	pbEventClientTimeMs  = 9
+	pbEventReceivedAtMs  = 10
+	pbEventSchemaVersion = 11
+
+	pbAnalysisUserID          = 1
+	pbAnalysisStartMs         = 2
+	pbAnalysisEndMs           = 3
+	pbAnalysisBehavior        = 4
+	pbAnalysisConfidence      = 5
+	pbAnalysisRecommendations = 6
+	pbAnalysisAnalyzedAtMs    = 7
+)
+
+// pbMetadataFields maps Metadata field numbers to metadata keys. Numbers
+// 4 to 6 are doubles, the others strings.
+var pbMetadataFields = map[protowire.Number]string{
+	1: "url",
+	2: "pageTitle",
+	3: "tabId",
+	4: "x",
+	5: "y",
+	6: "scrollDelta",
+	7: "keyCode",
+}
+
+// protobufCodec frames messages as described in tracker.proto. Client
+// frames are always a ClientFrame, which batches one or more messages;
+// server frames are a single ServerMessage and never batched.
+type protobufCodec struct{}
+
+// frameType returns the binary message type
+func (protobufCodec) frameType() int {
+	return websocket.BinaryMessage
+}
+
+// encode marshals a ServerMessage. Events and analyses, the payloads of
+// activity messages, are encoded as messages of their own; any other
+// payload is carried as JSON since its shape depends on the message type.
+func (protobufCodec) encode(event WebSocketEvent) ([]byte, error) {
+	var frame []byte
+	frame = appendString(frame, pbServerType, event.Type)
+	frame = appendString(frame, pbServerID, event.ID)
+	frame = appendVarint(frame, pbServerSeq, event.Seq)
+	frame = appendVarint(frame, pbServerAck, event.Ack)
+	frame = appendString(frame, pbServerTopic, event.Topic)
+
+	switch payload := event.Payload.(type) {
+	case nil:
+	case *model.Event:
+		encoded, err := encodeProtobufEvent(payload)
+		if err != nil {
+			return nil, err
+		}
+		frame = protowire.AppendTag(frame, pbServerEvent, protowire.BytesType)
+		frame = protowire.AppendBytes(frame, encoded)
+	case *services.ActivityAnalysis:
+		frame = protowire.AppendTag(frame, pbServerAnalysis, protowire.BytesType)
+		frame = protowire.AppendBytes(frame, encodeProtobufAnalysis(payload))
+	default:
+		encoded, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		frame = protowire.AppendTag(frame, pbServerPayload, protowire.BytesType)
+		frame = protowire.AppendBytes(frame, encoded)
+	}
+	return frame, nil
+}
+
+// encodeProtobufEvent marshals an Event
+func encodeProtobufEvent(event *model.Event) ([]byte, error) {
+	var b []byte
+	if !event.ID.IsZero() {
+		b = appendString(b, pbEventID, event.ID.Hex())
+	}
+	b = appendString(b, pbEventUserID, event.UserID)
+	b = appendString(b, pbEventTenantID, event.TenantID)
+	b = appendString(b, pbEventType, event.Type)
+	b = appendTime(b, pbEventTimestampMs, event.Timestamp)
+
+	metadata, extra, err := encodeProtobufMetadata(event.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	if len(metadata) > 0 {
+		b = protowire.AppendTag(b, pbEventMetadata, protowire.BytesType)
+		b = protowire.AppendBytes(b, metadata)
+	}
+	if len(extra) > 0 {
+		b = protowire.AppendTag(b, pbEventExtraMetadata, protowire.BytesType)
+		b = protowire.AppendBytes(b, extra)
+	}
+
+	b = appendString(b, pbEventClientEventID, event.ClientEventID)
+	b = appendTime(b, pbEventClientTimeMs, event.ClientTimestamp)
+	b = appendTime(b, pbEventReceivedAtMs, event.ReceivedAt)
+	b = appendVarint(b, pbEventSchemaVersion, uint64(int64(event.SchemaVersion)))
+	return b, nil
+}
+
+// encodeProtobufMetadata splits metadata into a Metadata message and the
+// JSON object of the keys it has no field for, or whose value does not fit
+// the field's type
+func encodeProtobufMetadata(metadata map[string]interface{}) ([]byte, []byte, error) {
+	var b []byte
+	rest := make(map[string]interface{})
+	for key, value := range metadata {
+		rest[key] = value
+	}
+
+	for num := protowire.Number(1); num <= protowire.Number(len(pbMetadataFields)); num++ {
+		key := pbMetadataFields[num]
+		value, ok := rest[key]
+		if !ok {
+			continue
+		}
+		if num >= 4 && num <= 6 {
+			f, ok := value.(float64)
+			if !ok {
+				continue
+			}
+			b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+			b = protowire.AppendFixed64(b, math.Float64bits(f))
+		} else {
+			s, ok := value.(string)
+			if !ok {
+				continue
+			}
+			b = protowire.AppendTag(b, num, protowire.BytesType)
+			b = protowire.AppendString(b, s)
+		}
+		delete(rest, key)
+	}
+
+	if len(rest) == 0 {
+		return b, nil, nil
+	}
+	extra, err := json.Marshal(rest)
+	return b, extra, err
+}
+
+// encodeProtobufAnalysis marshals an ActivityAnalysis
+func encodeProtobufAnalysis(analysis *services.ActivityAnalysis) []byte {
+	var b []byte
+	b = appendString(b, pbAnalysisUserID, analysis.UserID)
+	b = appendTime(b, pbAnalysisStartMs, analysis.TimeFrame.Start)
+	b = appendTime(b, pbAnalysisEndMs, analysis.TimeFrame.End)
+	b = appendString(b, pbAnalysisBehavior, analysis.Behavior)
+	if analysis.Confidence != 0 {
+		b = protowire.AppendTag(b, pbAnalysisConfidence, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(analysis.Confidence))
+	}
+	for _, recommendation := range analysis.Recommendations {
+		b = protowire.AppendTag(b, pbAnalysisRecommendations, protowire.BytesType)
+		b = protowire.AppendString(b, recommendation)
+	}
+	b = appendTime(b, pbAnalysisAnalyzedAtMs, analysis.AnalyzedAt)
+	return b
+}
+
+// decode reads a ClientFrame
+func (protobufCodec) decode(frame []byte) ([]inbound, error) {
+	var messages []inbound
+	err := readFields(frame, func(num protowire.Number, typ protowire.Type, value []byte, _ uint64) error {
+		if num != pbFrameMessages {
+			return nil
+		}
+		if typ != protowire.BytesType {
+			return errProtobufType
+		}
+		msg, err := decodeProtobufMessage(value)
+		if err != nil {
+			return err
+		}
+		messages = append(messages, msg.toInbound())
+		return nil
+	})
+	return messages, err
+}
+
+// decodeProtobufMessage reads a ClientMessage
+func decodeProtobufMessage(data []byte) (*wireMessage, error) {
+	msg := &wireMessage{}
+	var extra []byte
+	err := readFields(data, func(num protowire.Number, typ protowire.Type, value []byte, n uint64) error {
+		wantBytes := num == pbClientType || num == pbClientTopic || num == pbClientEventID ||
+			num == pbClientMetadata || num == pbClientExtraMetadata
+		wantVarint := num == pbClientSeq || num == pbClientAck || num == pbClientTimestampMs || num == pbClientSchemaVersion
+		if (wantBytes && typ != protowire.BytesType) || (wantVarint && typ != protowire.VarintType) {
+			return errProtobufType
+		}
+
+		switch num {
+		case pbClientType:
+			msg.Type = string(value)
+		case pbClientSeq:
+			msg.Seq = n
+		case pbClientAck:
+			msg.Ack = n
+		case pbClientTopic:
+			msg.Topic = string(value)
+		case pbClientEventID:
+			msg.ClientEventID = string(value)
+		case pbClientTimestampMs:
+			msg.Timestamp = time.UnixMilli(int64(n)).UTC()
+		case pbClientSchemaVersion:
+			msg.SchemaVersion = int(int32(n))
+		case pbClientMetadata:
+			metadata, err := decodeProtobufMetadata(value)
+			if err != nil {
+				return err
+			}
+			msg.Metadata = metadata
+		case pbClientExtraMetadata:
+			extra = value
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Keys outside the canonical metadata arrive as a JSON object
+	if len(extra) > 0 {
+		var fields map[string]interface{}
+		if err := json.Unmarshal(extra, &fields); err != nil {
+			return nil, err
+		}
+		if msg.Metadata == nil {
+			msg.Metadata = make(map[string]interface{}, len(fields))
+		}
+		for key, value := range fields {
+			if _, ok := msg.Metadata[key]; !ok {
+				msg.Metadata[key] = value
+			}
+		}
+	}
+	return msg, nil
+}
+
+// decodeProtobufMetadata reads a Metadata message. Only fields present on
+// the wire are set, so a zero coordinate is told apart from a missing one.
+func decodeProtobufMetadata(data []byte) (map[string]interface{}, error) {
+	metadata := make(map[string]interface{})
+	err := readFields(data, func(num protowire.Number, typ protowire.Type, value []byte, n uint64) error {
+		key, ok := pbMetadataFields[num]
+		if !ok {
+			return nil
+		}
+		switch {
+		case num >= 4 && num <= 6 && typ == protowire.Fixed64Type:
+			metadata[key] = math.Float64frombits(n)
+		case (num < 4 || num > 6) && typ == protowire.BytesType:
+			metadata[key] = string(value)
+		default:
+			return errProtobufType
+		}
+		return nil
+	})
+	return metadata, err
+}
+
+// readFields calls fn for every field of a message. Length-delimited fields
+// pass their bytes as value, numeric fields their bits as n; unknown wire
+// types are skipped.
+func readFields(data []byte, fn func(num protowire.Number, typ protowire.Type, value []byte, n uint64) error) error {
+	for len(data) > 0 {
+		num, typ, length := protowire.ConsumeTag(data)
+		if length < 0 {
+			return protowire.ParseError(length)
+		}
+		data = data[length:]
+
+		var value []byte
+		var n uint64
+		switch typ {
+		case protowire.VarintType:
+			n, length = protowire.ConsumeVarint(data)
+		case protowire.Fixed64Type:
+			n, length = protowire.ConsumeFixed64(data)
+		case protowire.Fixed32Type:
+			var v uint32
+			v, length = protowire.ConsumeFixed32(data)
+			n = uint64(v)
+		case protowire.BytesType:
+			value, length = protowire.ConsumeBytes(data)
+		default:
+			length = protowire.ConsumeFieldValue(num, typ, data)
+			if length < 0 {
+				return protowire.ParseError(length)
+			}
+			data = data[length:]
+			continue
+		}
+		if length < 0 {
+			return protowire.ParseError(length)
+		}
+		data = data[length:]
+
+		if err := fn(num, typ, value, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendString appends a string field unless it is empty
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+// appendTime appends a time as Unix milliseconds unless it is zero
+func appendTime(b []byte, num protowire.Number, t time.Time) []byte {
+	if t.IsZero() {
+		return b
+	}
+	return appendVarint(b, num, uint64(t.UnixMilli()))
+}
+
+// appendVarint appends a varint field unless it is zero
+func appendVarint(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
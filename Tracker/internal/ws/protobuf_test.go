@@ -0,0 +1,258 @@
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"Tracker/internal/model"
+	"Tracker/internal/services"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// appendMessage appends a length-delimited field
+func appendMessage(b []byte, num protowire.Number, message []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, message)
+}
+
+// appendDouble appends a double field
+func appendDouble(b []byte, num protowire.Number, f float64) []byte {
+	b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, math.Float64bits(f))
+}
+
+// field is the value of one field as passed by readFields
+type field struct {
+	bytes []byte
+	n     uint64
+}
+
+// fields holds the fields of a message by number. A repeated field keeps
+// its last value.
+type fields map[protowire.Number]field
+
+// readAll reads the fields of a message for inspection
+func readAll(t *testing.T, data []byte) fields {
+	t.Helper()
+
+	all := make(fields)
+	err := readFields(data, func(num protowire.Number, _ protowire.Type, value []byte, n uint64) error {
+		all[num] = field{bytes: value, n: n}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return all
+}
+
+func TestProtobufDecode(t *testing.T) {
+	timestamp := time.Date(2026, 3, 4, 5, 6, 7, 8_000_000, time.UTC)
+
+	var metadata []byte
+	metadata = appendString(metadata, 1, "https://example.com")
+	metadata = appendDouble(metadata, 4, 0)
+	metadata = appendDouble(metadata, 5, -7.5)
+
+	var click []byte
+	click = appendString(click, pbClientType, model.EventClick)
+	click = appendVarint(click, pbClientSeq, 5)
+	click = appendVarint(click, pbClientAck, 3)
+	click = appendString(click, pbClientEventID, "evt-1")
+	click = appendVarint(click, pbClientTimestampMs, uint64(timestamp.UnixMilli()))
+	click = appendVarint(click, pbClientSchemaVersion, 2)
+	click = appendMessage(click, pbClientMetadata, metadata)
+	click = appendMessage(click, pbClientExtraMetadata, []byte(`{"button":"left","url":"ignored"}`))
+
+	var subscribe []byte
+	subscribe = appendString(subscribe, pbClientType, EventTypeSubscribe)
+	subscribe = appendString(subscribe, pbClientTopic, "activity:user-1")
+
+	var frame []byte
+	frame = appendMessage(frame, pbFrameMessages, click)
+	frame = appendMessage(frame, pbFrameMessages, subscribe)
+
+	messages, err := protobufCodec{}.decode(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 {
+		t.Fatalf("decoded %d messages, want 2", len(messages))
+	}
+
+	msg := messages[0]
+	if msg.Type != model.EventClick || msg.Seq != 5 || msg.Ack != 3 {
+		t.Errorf("envelope = %+v", msg.controlMessage)
+	}
+	if msg.event.ClientEventID != "evt-1" || msg.event.SchemaVersion != 2 || !msg.event.Timestamp.Equal(timestamp) {
+		t.Errorf("event = %+v", msg.event)
+	}
+
+	// A zero x is present, a missing scroll delta is not, and the typed
+	// fields win over the extra JSON
+	want := map[string]interface{}{
+		"url":    "https://example.com",
+		"x":      float64(0),
+		"y":      -7.5,
+		"button": "left",
+	}
+	if !reflect.DeepEqual(msg.event.Metadata, want) {
+		t.Errorf("metadata = %#v, want %#v", msg.event.Metadata, want)
+	}
+
+	if messages[1].Type != EventTypeSubscribe || messages[1].Topic != "activity:user-1" {
+		t.Errorf("second message = %+v", messages[1].controlMessage)
+	}
+}
+
+func TestProtobufDecodeWrongWireType(t *testing.T) {
+	tests := map[string][]byte{
+		"frame":    protowire.AppendVarint(protowire.AppendTag(nil, pbFrameMessages, protowire.VarintType), 1),
+		"seq":      appendMessage(nil, pbFrameMessages, appendString(nil, pbClientSeq, "1")),
+		"metadata": appendMessage(nil, pbFrameMessages, appendMessage(nil, pbClientMetadata, appendString(nil, 4, "12"))),
+	}
+	for name, frame := range tests {
+		if _, err := (protobufCodec{}).decode(frame); !errors.Is(err, errProtobufType) {
+			t.Errorf("%s: decode = %v, want errProtobufType", name, err)
+		}
+	}
+
+	if _, err := (protobufCodec{}).decode([]byte{0x0a, 0x05, 0x01}); err == nil {
+		t.Error("decoded a truncated frame")
+	}
+}
+
+func TestProtobufEncodeEvent(t *testing.T) {
+	event := &model.Event{
+		ID:              primitive.NewObjectID(),
+		UserID:          "user-1",
+		TenantID:        "tenant-1",
+		Type:            model.EventMouseMove,
+		Timestamp:       time.UnixMilli(1_700_000_000_123).UTC(),
+		ClientTimestamp: time.UnixMilli(1_700_000_000_100).UTC(),
+		ClientEventID:   "evt-9",
+		SchemaVersion:   2,
+		Metadata: map[string]interface{}{
+			"x":      float64(10),
+			"y":      float64(0),
+			"url":    "https://example.com",
+			"button": "left",
+		},
+	}
+
+	frame, err := protobufCodec{}.encode(WebSocketEvent{Type: EventTypeActivity, Seq: 7, Topic: "activity:user-1", Payload: event})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := readAll(t, frame)
+	if string(server[pbServerType].bytes) != EventTypeActivity || server[pbServerSeq].n != 7 {
+		t.Errorf("envelope = %+v", server)
+	}
+	if _, ok := server[pbServerPayload]; ok {
+		t.Error("event sent as JSON")
+	}
+
+	encoded := readAll(t, server[pbServerEvent].bytes)
+	wantStrings := map[protowire.Number]string{
+		pbEventID:            event.ID.Hex(),
+		pbEventUserID:        "user-1",
+		pbEventTenantID:      "tenant-1",
+		pbEventType:          model.EventMouseMove,
+		pbEventClientEventID: "evt-9",
+	}
+	for num, want := range wantStrings {
+		if got := string(encoded[num].bytes); got != want {
+			t.Errorf("field %d = %q, want %q", num, got, want)
+		}
+	}
+	if encoded[pbEventTimestampMs].n != 1_700_000_000_123 || encoded[pbEventClientTimeMs].n != 1_700_000_000_100 {
+		t.Errorf("times = %d, %d", encoded[pbEventTimestampMs].n, encoded[pbEventClientTimeMs].n)
+	}
+	if _, ok := encoded[pbEventReceivedAtMs]; ok {
+		t.Error("zero ReceivedAt was encoded")
+	}
+	if encoded[pbEventSchemaVersion].n != 2 {
+		t.Errorf("schema version = %d", encoded[pbEventSchemaVersion].n)
+	}
+
+	metadata, err := decodeProtobufMetadata(encoded[pbEventMetadata].bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantMetadata := map[string]interface{}{"x": float64(10), "y": float64(0), "url": "https://example.com"}
+	if !reflect.DeepEqual(metadata, wantMetadata) {
+		t.Errorf("metadata = %#v, want %#v", metadata, wantMetadata)
+	}
+	var extra map[string]interface{}
+	if err := json.Unmarshal(encoded[pbEventExtraMetadata].bytes, &extra); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(extra, map[string]interface{}{"button": "left"}) {
+		t.Errorf("extra metadata = %#v", extra)
+	}
+}
+
+func TestProtobufEncodeAnalysis(t *testing.T) {
+	analysis := &services.ActivityAnalysis{
+		UserID:          "user-1",
+		TimeFrame:       services.TimeFrame{Start: time.UnixMilli(1000), End: time.UnixMilli(2000)},
+		Behavior:        "focused",
+		Confidence:      0.75,
+		Recommendations: []string{"take a break", "close tabs"},
+		AnalyzedAt:      time.UnixMilli(3000),
+	}
+
+	frame, err := protobufCodec{}.encode(WebSocketEvent{Type: EventTypeActivity, Payload: analysis})
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded := readAll(t, readAll(t, frame)[pbServerAnalysis].bytes)
+
+	if string(encoded[pbAnalysisUserID].bytes) != "user-1" || string(encoded[pbAnalysisBehavior].bytes) != "focused" {
+		t.Errorf("analysis = %+v", encoded)
+	}
+	if encoded[pbAnalysisStartMs].n != 1000 || encoded[pbAnalysisEndMs].n != 2000 || encoded[pbAnalysisAnalyzedAtMs].n != 3000 {
+		t.Error("times not encoded as Unix milliseconds")
+	}
+	if math.Float64frombits(encoded[pbAnalysisConfidence].n) != 0.75 {
+		t.Errorf("confidence = %v", math.Float64frombits(encoded[pbAnalysisConfidence].n))
+	}
+
+	var recommendations []string
+	err = readFields(readAll(t, frame)[pbServerAnalysis].bytes, func(num protowire.Number, _ protowire.Type, value []byte, _ uint64) error {
+		if num == pbAnalysisRecommendations {
+			recommendations = append(recommendations, string(value))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(recommendations, analysis.Recommendations) {
+		t.Errorf("recommendations = %v", recommendations)
+	}
+}
+
+func TestProtobufEncodeOtherPayloadsAsJSON(t *testing.T) {
+	frame, err := protobufCodec{}.encode(WebSocketEvent{
+		Type:    EventTypeError,
+		Payload: ErrorPayload{Code: ErrorCodeMalformed, Message: "bad"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var payload ErrorPayload
+	if err := json.Unmarshal(readAll(t, frame)[pbServerPayload].bytes, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Code != ErrorCodeMalformed || payload.Message != "bad" {
+		t.Errorf("payload = %+v", payload)
+	}
+}
@@ -0,0 +1,169 @@
+package ws
+
+import (
+	"sync"
+	"time"
+
+	"Tracker/internal/config"
+)
+
+// Scopes reported in SlowDownPayload
+const (
+	RateScopeConnection = "connection"
+	RateScopeUser       = "user"
+	RateScopeEventType  = "event_type"
+	// RateScopeServer means the server could not queue the message
+	RateScopeServer = "server"
+)
+
+// backlogRetry is how long a client waits before sending again when the
+// server could not queue its events
+const backlogRetry = time.Second
+
+// SlowDownPayload tells a client that messages were dropped for exceeding a
+// rate limit, or because the server is behind. Sequenced messages from the dropped one on must be sent again
+// after RetryAfterMs.
+type SlowDownPayload struct {
+	Scope        string `json:"scope"`
+	EventType    string `json:"eventType,omitempty"`
+	RetryAfterMs int64  `json:"retryAfterMs"`
+}
+
+// RateLimits are the token bucket limits on client messages, in messages
+// per second with a burst
+type RateLimits struct {
+	ConnectionRate  int
+	ConnectionBurst int
+	UserRate        int
+	UserBurst       int
+	// TypeRates are per-user quotas for single event types, with a burst of
+	// twice the rate
+	TypeRates map[string]int
+	// CoalesceWindow is how long mouse_move events are merged
+	CoalesceWindow time.Duration
+}
+
+// RateLimitsFromConfig reads the limits from the WS_* settings
+func RateLimitsFromConfig() RateLimits {
+	limits := RateLimits{
+		TypeRates:      config.GetWSEventTypeRates(),
+		CoalesceWindow: config.GetWSMouseMoveCoalesce(),
+	}
+	limits.ConnectionRate, limits.ConnectionBurst = config.GetWSConnectionRate()
+	limits.UserRate, limits.UserBurst = config.GetWSUserRate()
+	return limits
+}
+
+// tokenBucket refills rate tokens per second up to burst. It is not safe
+// for concurrent use.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket creates a full bucket
+func newTokenBucket(rate, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: float64(rate), burst: float64(burst), tokens: float64(burst)}
+}
+
+// wait refills the bucket and returns how long until a token is available,
+// zero if one is available now
+func (b *tokenBucket) wait(now time.Time) time.Duration {
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+	if b.tokens >= 1 {
+		return 0
+	}
+	if b.rate <= 0 {
+		return time.Second
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// take uses a token made available by wait
+func (b *tokenBucket) take() {
+	b.tokens--
+}
+
+// userLimiter holds the buckets a user's connections share
+type userLimiter struct {
+	mu    sync.Mutex
+	all   *tokenBucket
+	types map[string]*tokenBucket
+	// refs counts the user's sessions
+	refs int
+}
+
+// newUserLimiter creates the buckets for one user
+func newUserLimiter(limits RateLimits) *userLimiter {
+	l := &userLimiter{
+		all:   newTokenBucket(limits.UserRate, limits.UserBurst),
+		types: make(map[string]*tokenBucket, len(limits.TypeRates)),
+	}
+	for eventType, rate := range limits.TypeRates {
+		l.types[eventType] = newTokenBucket(rate, 2*rate)
+	}
+	return l
+}
+
+// allow takes a token for a message of the given type from the user's
+// buckets, or reports the scope that is exhausted and when to retry
+func (l *userLimiter) allow(eventType string, now time.Time) (string, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	typeBucket := l.types[eventType]
+	if typeBucket != nil {
+		if wait := typeBucket.wait(now); wait > 0 {
+			return RateScopeEventType, wait
+		}
+	}
+	if wait := l.all.wait(now); wait > 0 {
+		return RateScopeUser, wait
+	}
+	if typeBucket != nil {
+		typeBucket.take()
+	}
+	l.all.take()
+	return "", 0
+}
+
+// acquireUserLimiter returns the shared limiter of a user, creating it for
+// the user's first session
+func (m *Manager) acquireUserLimiter(userID string) *userLimiter {
+	m.limitersMu.Lock()
+	defer m.limitersMu.Unlock()
+
+	l, ok := m.limiters[userID]
+	if !ok {
+		l = newUserLimiter(m.rateLimits)
+		m.limiters[userID] = l
+	}
+	l.refs++
+	return l
+}
+
+// releaseUserLimiter forgets a user's limiter after their last session. It
+// reports whether that session was the user's last.
+func (m *Manager) releaseUserLimiter(userID string) bool {
+	m.limitersMu.Lock()
+	defer m.limitersMu.Unlock()
+
+	if l, ok := m.limiters[userID]; ok {
+		if l.refs--; l.refs <= 0 {
+			delete(m.limiters, userID)
+			return true
+		}
+	}
+	return false
+}
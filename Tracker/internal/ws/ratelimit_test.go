@@ -0,0 +1,153 @@
+package ws
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestTokenBucket(t *testing.T) {
+	start := time.Now()
+	b := newTokenBucket(10, 2)
+
+	steps := []struct {
+		name  string
+		after time.Duration
+		want  time.Duration
+		take  bool
+	}{
+		{"first of the burst", 0, 0, true},
+		{"second of the burst", 0, 0, true},
+		{"burst used up", 0, 100 * time.Millisecond, false},
+		{"half refilled", 50 * time.Millisecond, 50 * time.Millisecond, false},
+		{"refilled", 100 * time.Millisecond, 0, true},
+		// A long pause refills no more than the burst
+		{"after a pause", 10 * time.Second, 0, true},
+		{"rest of the burst", 10 * time.Second, 0, true},
+		{"burst used up again", 10 * time.Second, 100 * time.Millisecond, false},
+	}
+	for _, step := range steps {
+		got := b.wait(start.Add(step.after))
+		if diff := got - step.want; diff < -time.Millisecond || diff > time.Millisecond {
+			t.Fatalf("%s: wait = %v, want %v", step.name, got, step.want)
+		}
+		if step.take {
+			b.take()
+		}
+	}
+}
+
+func TestUserLimiter(t *testing.T) {
+	now := time.Now()
+	// mouse_move has a quota of 1 per second with a burst of 2, on top of
+	// the user's 3 messages
+	l := newUserLimiter(RateLimits{UserRate: 1, UserBurst: 3, TypeRates: map[string]int{"mouse_move": 1}})
+
+	steps := []struct {
+		eventType string
+		scope     string
+	}{
+		{"mouse_move", ""},
+		{"mouse_move", ""},
+		{"mouse_move", RateScopeEventType},
+		// A message refused by its type's quota does not count for the user
+		{"click", ""},
+		{"click", RateScopeUser},
+		{"mouse_move", RateScopeEventType},
+	}
+	for i, step := range steps {
+		scope, wait := l.allow(step.eventType, now)
+		if scope != step.scope {
+			t.Fatalf("message %d (%s): scope %q, want %q", i, step.eventType, scope, step.scope)
+		}
+		if (wait > 0) != (scope != "") {
+			t.Errorf("message %d (%s): wait %v with scope %q", i, step.eventType, wait, scope)
+		}
+	}
+
+	// Types without a quota only count for the user
+	later := now.Add(time.Second)
+	if scope, _ := l.allow("scroll", later); scope != "" {
+		t.Errorf("scroll after a second: scope %q", scope)
+	}
+}
+
+// serverConn returns the server side of a WebSocket connection and the
+// client side connected to it
+func serverConn(t *testing.T) (*websocket.Conn, *websocket.Conn) {
+	t.Helper()
+
+	conns := make(chan *websocket.Conn, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		conns <- conn
+	}))
+	t.Cleanup(server.Close)
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	conn := <-conns
+	t.Cleanup(func() { conn.Close() })
+	return conn, client
+}
+
+func TestDeliverDropOldest(t *testing.T) {
+	m := newTestManager(&recordingSink{}, 0)
+	m.limits.SendBuffer = 2
+	// The client has no write pump, so nothing leaves its buffer
+	client := NewClient(nil, "user-1", m)
+
+	for _, message := range []string{"a", "b", "c"} {
+		if !client.deliver([]byte(message)) {
+			t.Fatalf("message %s not queued", message)
+		}
+	}
+
+	var queued []string
+	for len(client.send) > 0 {
+		queued = append(queued, string(<-client.send))
+	}
+	if strings.Join(queued, "") != "bc" {
+		t.Errorf("queued %v, want b c", queued)
+	}
+	if stats := m.Stats(); stats.Dropped != 1 || stats.Reaped[ReapSlowConsumer] != 0 {
+		t.Errorf("stats = %+v, want 1 dropped and no reap", stats)
+	}
+}
+
+func TestDeliverDisconnect(t *testing.T) {
+	m := newTestManager(&recordingSink{}, 0)
+	m.limits.SendBuffer = 2
+	m.limits.SlowConsumer = SlowConsumerDisconnect
+	conn, peer := serverConn(t)
+	client := NewClient(conn, "user-1", m)
+
+	for _, message := range []string{"a", "b"} {
+		if !client.deliver([]byte(message)) {
+			t.Fatalf("message %s not queued", message)
+		}
+	}
+	if client.deliver([]byte("c")) {
+		t.Fatal("message queued for a full buffer")
+	}
+	if client.deliver([]byte("d")) {
+		t.Fatal("message queued after the client was disconnected")
+	}
+
+	if code := closeCode(t, peer); code != websocket.CloseTryAgainLater {
+		t.Errorf("close code %d, want %d", code, websocket.CloseTryAgainLater)
+	}
+	if stats := m.Stats(); stats.Dropped != 1 || stats.Reaped[ReapSlowConsumer] != 1 {
+		t.Errorf("stats = %+v, want 1 dropped and 1 slow consumer reap", stats)
+	}
+}
@@ -0,0 +1,204 @@
+package ws
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"sync"
+	"time"
+)
+
+// SessionPayload is sent first on every connection. The client passes
+// ResumeToken and the last sequence number it received when reconnecting,
+// and replays the events it sent after LastSeq.
+type SessionPayload struct {
+	ResumeToken string `json:"resumeToken"`
+	Resumed     bool   `json:"resumed"`
+	// LastSeq is the sequence number of the last client message processed
+	LastSeq uint64 `json:"lastSeq"`
+	// GraceSeconds is how long the session waits for a reconnect
+	GraceSeconds int `json:"graceSeconds"`
+}
+
+// session is the part of a client's stream that survives reconnects: its
+// topic subscriptions, the messages it has not acknowledged yet and the
+// sequence numbers in both directions. A session outlives its connection
+// for the resume grace period.
+type session struct {
+	token  string
+	userID string
+	// limiter holds the rate limits shared by the user's sessions
+	limiter *userLimiter
+
+	// mu guards every field below
+	mu sync.Mutex
+	// client is the attached connection, nil while detached
+	client *Client
+	closed bool
+	topics map[string]struct{}
+	// outbox holds sequenced messages until the client acknowledges them
+	outbox []WebSocketEvent
+	// lost is set once an unacknowledged message was evicted from a full
+	// outbox; such a session cannot be resumed without a gap
+	lost    bool
+	nextSeq uint64
+	// inboundSeq is the sequence number of the last client message
+	// processed
+	inboundSeq uint64
+	expiry     *time.Timer
+}
+
+// newSession creates a session with a fresh resume token
+func newSession(userID string) (*session, error) {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
+	}
+	return &session{
+		token:   base64.RawURLEncoding.EncodeToString(token),
+		userID:  userID,
+		topics:  make(map[string]struct{}),
+		nextSeq: 1,
+	}, nil
+}
+
+// deliver sequences a published message, keeps it until it is acknowledged
+// and passes it to the attached connection, if any. maxOutbox bounds the
+// unacknowledged messages kept.
+func (s *session) deliver(event WebSocketEvent, maxOutbox int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+
+	event.Seq = s.nextSeq
+	s.nextSeq++
+	if len(s.outbox) >= maxOutbox {
+		s.outbox = s.outbox[1:]
+		s.lost = true
+	}
+	s.outbox = append(s.outbox, event)
+
+	if s.client == nil {
+		return false
+	}
+	event.Ack = s.inboundSeq
+	return s.client.sendEvent(event)
+}
+
+// ack drops the messages up to seq from the outbox
+func (s *session) ack(seq uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := 0
+	for i < len(s.outbox) && s.outbox[i].Seq <= seq {
+		i++
+	}
+	clear(s.outbox[:i])
+	s.outbox = s.outbox[i:]
+}
+
+// isNextInbound reports whether a client message with the given sequence
+// number is the next one expected. Clients number their messages from 1
+// without gaps, so a replayed message is a duplicate and a message after a
+// gap must wait for the missing one. Messages without a sequence number are
+// always processed.
+func (s *session) isNextInbound(seq uint64) bool {
+	if seq == 0 {
+		return true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return seq == s.inboundSeq+1
+}
+
+// acceptInbound records a client message as processed. It reports false if
+// the message is not the next one expected.
+func (s *session) acceptInbound(seq uint64) bool {
+	if seq == 0 {
+		return true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if seq != s.inboundSeq+1 {
+		return false
+	}
+	s.inboundSeq = seq
+	return true
+}
+
+// lastInbound returns the sequence number of the last client message
+// processed
+func (s *session) lastInbound() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.inboundSeq
+}
+
+// attach makes client the session's connection and replays every message
+// after ack. A resume fails if the session ended, lost messages or belongs
+// to another user. It returns the connection it replaced, if any.
+func (s *session) attach(client *Client, ack uint64, resume bool, grace time.Duration) (*Client, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if resume && (s.closed || s.lost || s.userID != client.userID) {
+		return nil, false
+	}
+
+	if s.expiry != nil {
+		s.expiry.Stop()
+		s.expiry = nil
+	}
+	previous := s.client
+	s.client = client
+	client.session = s
+
+	// The session message goes first so the client knows what to replay
+	client.sendEvent(WebSocketEvent{Type: EventTypeSession, Payload: SessionPayload{
+		ResumeToken:  s.token,
+		Resumed:      resume,
+		LastSeq:      s.inboundSeq,
+		GraceSeconds: int(grace / time.Second),
+	}})
+	for _, event := range s.outbox {
+		if event.Seq > ack {
+			event.Ack = s.inboundSeq
+			client.sendEvent(event)
+		}
+	}
+	return previous, true
+}
+
+// detach forgets client if it is still the attached connection and calls
+// expire once the session was left detached for grace. It reports whether
+// the client was attached.
+func (s *session) detach(client *Client, grace time.Duration, expire func()) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client != client || s.closed {
+		return false
+	}
+	s.client = nil
+	s.expiry = time.AfterFunc(grace, expire)
+	return true
+}
+
+// close ends the session if it is still detached and returns its topics.
+// It reports false if a client reattached or the session already ended.
+func (s *session) close() ([]string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed || s.client != nil {
+		return nil, false
+	}
+	s.closed = true
+	s.outbox = nil
+
+	topics := make([]string, 0, len(s.topics))
+	for topic := range s.topics {
+		topics = append(topics, topic)
+	}
+	s.topics = nil
+	return topics, true
+}
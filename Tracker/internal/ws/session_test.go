@@ -0,0 +1,356 @@
+package ws
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"Tracker/internal/events"
+	"Tracker/internal/model"
+	"Tracker/internal/services"
+
+	"github.com/gorilla/websocket"
+)
+
+// testSession creates a detached session of user-1
+func testSession(t *testing.T) *session {
+	t.Helper()
+
+	s, err := newSession("user-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// outboxSeqs lists the sequence numbers waiting for an ack
+func outboxSeqs(s *session) []uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seqs := make([]uint64, len(s.outbox))
+	for i, event := range s.outbox {
+		seqs[i] = event.Seq
+	}
+	return seqs
+}
+
+// equalSeqs reports whether got holds exactly want
+func equalSeqs(got []uint64, want ...uint64) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// sent decodes the messages queued for a client that has no write pump
+func sent(t *testing.T, client *Client) []WebSocketEvent {
+	t.Helper()
+
+	var events []WebSocketEvent
+	for {
+		select {
+		case message := <-client.send:
+			var event WebSocketEvent
+			if err := json.Unmarshal(message, &event); err != nil {
+				t.Fatal(err)
+			}
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+// sessionPayload decodes the payload of a session message
+func sessionPayload(t *testing.T, event WebSocketEvent) SessionPayload {
+	t.Helper()
+
+	raw, err := json.Marshal(event.Payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var payload SessionPayload
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		t.Fatal(err)
+	}
+	return payload
+}
+
+func TestSessionOutbox(t *testing.T) {
+	s := testSession(t)
+
+	for i := 0; i < 3; i++ {
+		if s.deliver(WebSocketEvent{Type: EventTypeActivity}, 4) {
+			t.Fatal("delivered to a detached session")
+		}
+	}
+	if got := outboxSeqs(s); !equalSeqs(got, 1, 2, 3) {
+		t.Fatalf("outbox = %v, want 1 2 3", got)
+	}
+
+	s.ack(2)
+	if got := outboxSeqs(s); !equalSeqs(got, 3) {
+		t.Fatalf("outbox after ack 2 = %v, want 3", got)
+	}
+	s.ack(1)
+	if got := outboxSeqs(s); !equalSeqs(got, 3) {
+		t.Fatalf("outbox after a stale ack = %v, want 3", got)
+	}
+	if s.lost {
+		t.Fatal("session lost messages before its outbox was full")
+	}
+
+	// Filling the outbox evicts the oldest message and rules out a resume
+	for i := 0; i < 4; i++ {
+		s.deliver(WebSocketEvent{Type: EventTypeActivity}, 4)
+	}
+	if got := outboxSeqs(s); !equalSeqs(got, 4, 5, 6, 7) {
+		t.Fatalf("outbox = %v, want 4 5 6 7", got)
+	}
+	if !s.lost {
+		t.Error("evicting an unacknowledged message did not mark the session lost")
+	}
+}
+
+func TestSessionInbound(t *testing.T) {
+	s := testSession(t)
+
+	tests := []struct {
+		name string
+		seq  uint64
+		want bool
+		last uint64
+	}{
+		{"unsequenced", 0, true, 0},
+		{"first", 1, true, 1},
+		{"replay", 1, false, 1},
+		{"gap", 3, false, 1},
+		{"next", 2, true, 2},
+		{"unsequenced after sequenced", 0, true, 2},
+	}
+	for _, tt := range tests {
+		if got := s.isNextInbound(tt.seq); got != tt.want {
+			t.Errorf("%s: isNextInbound(%d) = %v, want %v", tt.name, tt.seq, got, tt.want)
+		}
+		if got := s.acceptInbound(tt.seq); got != tt.want {
+			t.Errorf("%s: acceptInbound(%d) = %v, want %v", tt.name, tt.seq, got, tt.want)
+		}
+		if got := s.lastInbound(); got != tt.last {
+			t.Errorf("%s: lastInbound = %d, want %d", tt.name, got, tt.last)
+		}
+	}
+}
+
+func TestSessionAttach(t *testing.T) {
+	m := newTestManager(&recordingSink{}, 0)
+	s := testSession(t)
+	for i := 0; i < 3; i++ {
+		s.deliver(WebSocketEvent{Type: EventTypeActivity}, 4)
+	}
+	s.acceptInbound(1)
+
+	client := NewClient(nil, "user-1", m)
+	if _, ok := s.attach(client, 1, true, time.Minute); !ok {
+		t.Fatal("resume refused")
+	}
+
+	// The session message comes first, then the messages after the ack
+	events := sent(t, client)
+	if len(events) != 3 || events[0].Type != EventTypeSession {
+		t.Fatalf("sent %+v, want a session message and two replays", events)
+	}
+	payload := sessionPayload(t, events[0])
+	if !payload.Resumed || payload.LastSeq != 1 || payload.ResumeToken != s.token || payload.GraceSeconds != 60 {
+		t.Errorf("session payload = %+v", payload)
+	}
+	for i, want := range []uint64{2, 3} {
+		if events[i+1].Seq != want || events[i+1].Ack != 1 {
+			t.Errorf("replay %d = seq %d ack %d, want seq %d ack 1", i, events[i+1].Seq, events[i+1].Ack, want)
+		}
+	}
+
+	// A second connection replaces the first
+	second := NewClient(nil, "user-1", m)
+	if previous, ok := s.attach(second, 3, true, time.Minute); !ok || previous != client {
+		t.Errorf("attach = %p, %v, want the replaced client", previous, ok)
+	}
+	if events := sent(t, second); len(events) != 1 {
+		t.Errorf("replayed %d acknowledged messages", len(events)-1)
+	}
+}
+
+func TestSessionResumeRefused(t *testing.T) {
+	m := newTestManager(&recordingSink{}, 0)
+
+	tests := []struct {
+		name   string
+		userID string
+		edit   func(s *session)
+	}{
+		{"another user", "user-2", func(s *session) {}},
+		{"lost messages", "user-1", func(s *session) { s.lost = true }},
+		{"ended", "user-1", func(s *session) { s.close() }},
+	}
+	for _, tt := range tests {
+		s := testSession(t)
+		tt.edit(s)
+		if _, ok := s.attach(NewClient(nil, tt.userID, m), 0, true, time.Minute); ok {
+			t.Errorf("%s: resume accepted", tt.name)
+		}
+	}
+}
+
+func TestResumeAfterReconnect(t *testing.T) {
+	sink := &recordingSink{}
+	m := newTestManager(sink, 0)
+	server := newTestServer(t, m)
+	topic := UserAnalysisTopic("user-1")
+
+	conn := dial(t, server, "")
+	first := sessionPayload(t, readUntil(t, conn, EventTypeSession))
+	if first.Resumed {
+		t.Fatal("new connection reported as resumed")
+	}
+
+	// The client sends one event and reads two messages but acknowledges
+	// only the first
+	if err := conn.WriteJSON(pointerEvent(1, model.EventClick, 1)); err != nil {
+		t.Fatal(err)
+	}
+	readUntil(t, conn, EventTypeAck)
+	for i := 0; i < 2; i++ {
+		if _, err := m.Publish(topic, WebSocketEvent{Type: EventTypeActivity, Payload: i}); err != nil {
+			t.Fatal(err)
+		}
+		readUntil(t, conn, EventTypeActivity)
+	}
+	if err := conn.WriteJSON(map[string]interface{}{"type": EventTypeAck, "ack": 1}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return equalSeqs(sessionOutbox(m, first.ResumeToken), 2) })
+
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	conn.Close()
+	waitFor(t, func() bool { return m.Clients() == 0 })
+
+	// Published while away
+	if _, err := m.Publish(topic, WebSocketEvent{Type: EventTypeActivity, Payload: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	resumed := dial(t, server, "resume="+first.ResumeToken+"&ack=1")
+	payload := sessionPayload(t, readUntil(t, resumed, EventTypeSession))
+	if !payload.Resumed || payload.LastSeq != 1 {
+		t.Fatalf("session payload = %+v, want resumed after client message 1", payload)
+	}
+	for _, want := range []uint64{2, 3} {
+		if event := readUntil(t, resumed, EventTypeActivity); event.Seq != want {
+			t.Fatalf("replayed seq %d, want %d", event.Seq, want)
+		}
+	}
+
+	// An event the client sends again after reconnecting is not processed
+	// twice
+	batch := []interface{}{pointerEvent(1, model.EventClick, 1), pointerEvent(2, model.EventClick, 2)}
+	if err := resumed.WriteJSON(batch); err != nil {
+		t.Fatal(err)
+	}
+	if ack := readUntil(t, resumed, EventTypeAck); ack.Ack != 2 {
+		t.Fatalf("ack = %d, want 2", ack.Ack)
+	}
+	if got := strings.Join(eventIDs(sink.snapshot()), ","); got != "click-1,click-2" {
+		t.Errorf("processed %s, want click-1,click-2", got)
+	}
+}
+
+func TestQueueFullIsNotAcknowledged(t *testing.T) {
+	sink := &recordingSink{}
+	conn := dial(t, newTestServer(t, newTestManager(sink, 0)), "")
+	readUntil(t, conn, EventTypeSession)
+
+	sink.fail(events.ErrQueueFull)
+	batch := []interface{}{pointerEvent(1, model.EventClick, 1), pointerEvent(2, model.EventClick, 2)}
+	if err := conn.WriteJSON(batch); err != nil {
+		t.Fatal(err)
+	}
+	slowDown := readUntil(t, conn, EventTypeSlowDown)
+	if payload, ok := slowDown.Payload.(map[string]interface{}); !ok || payload["scope"] != RateScopeServer {
+		t.Errorf("slow_down payload = %v, want scope %s", slowDown.Payload, RateScopeServer)
+	}
+	if ack := readUntil(t, conn, EventTypeAck); ack.Ack != 0 {
+		t.Fatalf("ack = %d for events that were not queued", ack.Ack)
+	}
+
+	// The client replays from the last acknowledged message
+	sink.fail(nil)
+	if err := conn.WriteJSON(batch); err != nil {
+		t.Fatal(err)
+	}
+	if ack := readUntil(t, conn, EventTypeAck); ack.Ack != 2 {
+		t.Fatalf("ack = %d, want 2", ack.Ack)
+	}
+	if got := strings.Join(eventIDs(sink.snapshot()), ","); got != "click-1,click-2" {
+		t.Errorf("processed %s, want click-1,click-2", got)
+	}
+}
+
+func TestResumeUnknownToken(t *testing.T) {
+	conn := dial(t, newTestServer(t, newTestManager(&recordingSink{}, 0)), "resume=unknown&ack=4")
+	payload := sessionPayload(t, readUntil(t, conn, EventTypeSession))
+	if payload.Resumed || payload.ResumeToken == "unknown" || payload.LastSeq != 0 {
+		t.Errorf("session payload = %+v, want a new session", payload)
+	}
+}
+
+func TestLastSessionEndForgetsProcessorState(t *testing.T) {
+	processor := services.NewEventProcessor(nil)
+	m := NewManager(&recordingSink{}, processor)
+	m.SetLimits(Limits{ResumeGrace: 10 * time.Millisecond, ResumeBuffer: 16, SendBuffer: 16})
+
+	clients := []*Client{NewClient(nil, "user-1", m), NewClient(nil, "user-1", m)}
+	for _, client := range clients {
+		if err := m.RegisterClient(client); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.ProcessEvent(&model.Event{UserID: "user-1", Type: model.EventClick, Timestamp: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The user still has a session, so the processor keeps their events
+	m.UnregisterClient(clients[0])
+	waitFor(t, func() bool { return m.sessionCount() == 1 })
+	if got := len(processor.GetRecentEvents("user-1", time.Hour)); got != 1 {
+		t.Fatalf("%d recent events after the first session ended, want 1", got)
+	}
+
+	m.UnregisterClient(clients[1])
+	waitFor(t, func() bool { return processor.GetRecentEvents("user-1", time.Hour) == nil })
+}
+
+// sessionOutbox returns the outbox of the session with a resume token
+func sessionOutbox(m *Manager, token string) []uint64 {
+	m.sessionsMu.Lock()
+	s := m.sessions[token]
+	m.sessionsMu.Unlock()
+	return outboxSeqs(s)
+}
+
+// waitFor polls cond until it holds or five seconds passed
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
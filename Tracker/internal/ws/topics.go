@@ -0,0 +1,158 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	auth "Tracker/Authatication"
+	"Tracker/internal/database"
+	"Tracker/internal/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	// ErrInvalidTopic is returned for topics that do not follow a known form
+	ErrInvalidTopic = errors.New("invalid topic")
+	// ErrTopicForbidden is returned when the client may not see a topic
+	ErrTopicForbidden = errors.New("not allowed to subscribe to topic")
+)
+
+// UserAnalysisTopic carries the analyses of a user's activity
+func UserAnalysisTopic(userID string) string {
+	return "user:" + userID + ":analysis"
+}
+
+// TeamAlertsTopic carries alerts shared with a team
+func TeamAlertsTopic(teamID string) string {
+	return "team:" + teamID + ":alerts"
+}
+
+// ActivityTopic carries a user's events live as they arrive
+func ActivityTopic(userID string) string {
+	return "activity:" + userID
+}
+
+// TopicAuthorizer decides whether a principal may subscribe to a topic. It
+// returns ErrInvalidTopic, ErrTopicForbidden or a lookup error.
+type TopicAuthorizer func(ctx context.Context, principal *auth.Principal, topic string) error
+
+// TeamAccess answers who may follow a team's alerts
+type TeamAccess interface {
+	// CanSeeTeam reports whether the team belongs to the tenant and the
+	// user is on it or owns the organization
+	CanSeeTeam(ctx context.Context, tenantID, teamID, userID string) (bool, error)
+}
+
+// AuthorizeTopic is the topic authorizer backed by the teams and
+// memberships collections, see NewTopicAuthorizer
+func AuthorizeTopic(ctx context.Context, principal *auth.Principal, topic string) error {
+	return authorizeTopic(ctx, principal, topic, MongoTeamAccess{})
+}
+
+// NewTopicAuthorizer applies the policy engine to a topic. Analyses follow
+// analyses:read and live activity follows events:read on the topic's user;
+// team alerts are open to the team's members, the organization's owners
+// and admins.
+func NewTopicAuthorizer(teams TeamAccess) TopicAuthorizer {
+	return func(ctx context.Context, principal *auth.Principal, topic string) error {
+		return authorizeTopic(ctx, principal, topic, teams)
+	}
+}
+
+// authorizeTopic dispatches on the form of the topic
+func authorizeTopic(ctx context.Context, principal *auth.Principal, topic string, teams TeamAccess) error {
+	parts := strings.Split(topic, ":")
+	switch {
+	case len(parts) == 3 && parts[0] == "user" && parts[2] == "analysis" && parts[1] != "":
+		return authorizeUserTopic(ctx, principal, auth.PermAnalysesRead, parts[1])
+	case len(parts) == 2 && parts[0] == "activity" && parts[1] != "":
+		return authorizeUserTopic(ctx, principal, auth.PermEventsRead, parts[1])
+	case len(parts) == 3 && parts[0] == "team" && parts[2] == "alerts" && parts[1] != "":
+		return authorizeTeamTopic(ctx, principal, teams, parts[1])
+	default:
+		return ErrInvalidTopic
+	}
+}
+
+// authorizeUserTopic checks perm on data owned by userID
+func authorizeUserTopic(ctx context.Context, principal *auth.Principal, perm auth.Permission, userID string) error {
+	target := auth.Target{OwnerID: userID, TenantID: principal.TenantID}
+	if userID != principal.UserID {
+		var err error
+		if target, err = auth.UserTarget(ctx, userID); err != nil {
+			if errors.Is(err, auth.ErrUserNotFound) {
+				return ErrTopicForbidden
+			}
+			return err
+		}
+	}
+
+	decision, err := auth.Check(ctx, principal, perm, target)
+	if err != nil {
+		return err
+	}
+	if !decision.Allowed {
+		return ErrTopicForbidden
+	}
+	return nil
+}
+
+// authorizeTeamTopic checks the principal belongs to the team or owns its
+// organization
+func authorizeTeamTopic(ctx context.Context, principal *auth.Principal, teams TeamAccess, teamID string) error {
+	// API keys still need a scope covering analyses
+	decision, err := auth.Check(ctx, principal, auth.PermAnalysesRead, auth.Target{})
+	if err != nil {
+		return err
+	}
+	if !decision.Allowed {
+		return ErrTopicForbidden
+	}
+	if principal.Role == auth.RoleAdmin {
+		return nil
+	}
+
+	allowed, err := teams.CanSeeTeam(ctx, principal.TenantID, teamID, principal.UserID)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return ErrTopicForbidden
+	}
+	return nil
+}
+
+// MongoTeamAccess is the TeamAccess backed by the teams and memberships
+// collections
+type MongoTeamAccess struct{}
+
+// CanSeeTeam looks up the team in the tenant, then a membership of the team
+// or an ownership of the organization
+func (MongoTeamAccess) CanSeeTeam(ctx context.Context, tenantID, teamID, userID string) (bool, error) {
+	objectID, err := primitive.ObjectIDFromHex(teamID)
+	if err != nil {
+		return false, nil
+	}
+	teams, err := database.GetTeamsCollection().CountDocuments(ctx,
+		bson.M{"_id": objectID, "tenantId": tenantID}, options.Count().SetLimit(1))
+	if err != nil || teams == 0 {
+		return false, err
+	}
+
+	memberships, err := database.GetMembershipsCollection().CountDocuments(ctx, bson.M{
+		"tenantId": tenantID,
+		"userId":   userID,
+		"$or": bson.A{
+			bson.M{"teamId": teamID},
+			bson.M{"teamId": bson.M{"$exists": false}, "role": model.MemberRoleOwner},
+		},
+	}, options.Count().SetLimit(1))
+	if err != nil {
+		return false, err
+	}
+	return memberships > 0, nil
+}
@@ -0,0 +1,91 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	auth "Tracker/Authatication"
+)
+
+// knownUsers is a user store answering FindByID only, which is all topic
+// checks need
+type knownUsers struct {
+	auth.UserStore
+	users map[string]*auth.User
+}
+
+func (s knownUsers) FindByID(ctx context.Context, id string) (*auth.User, error) {
+	user, ok := s.users[id]
+	if !ok {
+		return nil, auth.ErrUserNotFound
+	}
+	return user, nil
+}
+
+// teamRoster is a TeamAccess over fixed teams. members holds tenant, team
+// and user; owners holds tenant and user.
+type teamRoster struct {
+	teams   map[string]string
+	members map[[3]string]bool
+	owners  map[[2]string]bool
+}
+
+func (r teamRoster) CanSeeTeam(ctx context.Context, tenantID, teamID, userID string) (bool, error) {
+	if r.teams[teamID] != tenantID {
+		return false, nil
+	}
+	return r.members[[3]string{tenantID, teamID, userID}] || r.owners[[2]string{tenantID, userID}], nil
+}
+
+func TestAuthorizeTopic(t *testing.T) {
+	auth.SetUserStore(knownUsers{users: map[string]*auth.User{
+		"alice": {ID: "alice", TenantID: "acme"},
+		"bob":   {ID: "bob", TenantID: "acme"},
+		"erin":  {ID: "erin", TenantID: "globex"},
+	}})
+	t.Cleanup(func() { auth.SetUserStore(nil) })
+
+	authorize := NewTopicAuthorizer(teamRoster{
+		teams:   map[string]string{"core": "acme", "sales": "globex"},
+		members: map[[3]string]bool{{"acme", "core", "alice"}: true},
+		owners:  map[[2]string]bool{{"acme", "olivia"}: true},
+	})
+
+	alice := &auth.Principal{UserID: "alice", TenantID: "acme", Role: auth.RoleUser, Method: auth.AuthMethodJWT}
+	bob := &auth.Principal{UserID: "bob", TenantID: "acme", Role: auth.RoleUser, Method: auth.AuthMethodJWT}
+	olivia := &auth.Principal{UserID: "olivia", TenantID: "acme", Role: auth.RoleUser, Method: auth.AuthMethodJWT}
+	admin := &auth.Principal{UserID: "root", TenantID: "ops", Role: auth.RoleAdmin, Method: auth.AuthMethodJWT}
+	aliceKey := &auth.Principal{UserID: "alice", TenantID: "acme", Role: auth.RoleUser, Method: auth.AuthMethodAPIKey,
+		APIKey: &auth.APIKey{Scopes: []string{auth.ScopeEventsWrite}}}
+
+	tests := []struct {
+		name      string
+		principal *auth.Principal
+		topic     string
+		want      error
+	}{
+		{"own analyses", alice, UserAnalysisTopic("alice"), nil},
+		{"own activity", alice, ActivityTopic("alice"), nil},
+		{"another user's analyses", bob, UserAnalysisTopic("alice"), ErrTopicForbidden},
+		{"another user's activity", bob, ActivityTopic("alice"), ErrTopicForbidden},
+		{"unknown user", alice, UserAnalysisTopic("nobody"), ErrTopicForbidden},
+		{"admin on another tenant's user", admin, ActivityTopic("erin"), nil},
+		{"team member", alice, TeamAlertsTopic("core"), nil},
+		{"not on the team", bob, TeamAlertsTopic("core"), ErrTopicForbidden},
+		{"organization owner", olivia, TeamAlertsTopic("core"), nil},
+		{"team of another tenant", olivia, TeamAlertsTopic("sales"), ErrTopicForbidden},
+		{"admin on any team", admin, TeamAlertsTopic("sales"), nil},
+		{"api key without analyses scope", aliceKey, TeamAlertsTopic("core"), ErrTopicForbidden},
+		{"unknown form", alice, "news", ErrInvalidTopic},
+		{"empty user", alice, "user::analysis", ErrInvalidTopic},
+		{"wrong suffix", alice, "user:alice:events", ErrInvalidTopic},
+		{"extra part", alice, "activity:alice:1", ErrInvalidTopic},
+		{"empty team", alice, "team::alerts", ErrInvalidTopic},
+	}
+	for _, tt := range tests {
+		if err := authorize(context.Background(), tt.principal, tt.topic); !errors.Is(err, tt.want) {
+			t.Errorf("%s: AuthorizeTopic(%s) = %v, want %v", tt.name, tt.topic, err, tt.want)
+		}
+	}
+}
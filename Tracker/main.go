@@ -1,43 +1,136 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"os"
+	"time"
 
+	auth "Tracker/Authatication"
+	"Tracker/internal/audit"
 	"Tracker/internal/config"
 	"Tracker/internal/database"
+	"Tracker/internal/events"
+	"Tracker/internal/mail"
+	"Tracker/internal/services"
 	ws "Tracker/internal/ws"
 	routes "Tracker/router"
-
-	"github.com/gin-gonic/gin"
 )
 
 func main() {
 	// Load configuration
-	_, err := config.LoadConfig()
+	cfg, err := config.LoadConfig()
 	if err != nil {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
+	// Load JWT signing keys
+	if err := auth.InitKeys(cfg.Env); err != nil {
+		log.Fatalf("Failed to load JWT keys: %v", err)
+	}
+
 	// Initialize database
 	if err := database.InitDB(); err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
 
-	// Initialize WebSocket manager and start it
-	manager := ws.NewManager()
-	go manager.Run()
+	// Initialize auth stores
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	userStore := auth.NewMongoUserStore(database.GetUsersCollection())
+	if err := userStore.EnsureIndexes(ctx); err != nil {
+		log.Fatalf("Failed to create user indexes: %v", err)
+	}
+	tokenStore := auth.NewMongoTokenStore(database.GetRefreshTokensCollection(), database.GetRevokedTokensCollection())
+	if err := tokenStore.EnsureIndexes(ctx); err != nil {
+		log.Fatalf("Failed to create token indexes: %v", err)
+	}
+	apiKeyStore := auth.NewMongoAPIKeyStore(database.GetAPIKeysCollection())
+	if err := apiKeyStore.EnsureIndexes(ctx); err != nil {
+		log.Fatalf("Failed to create API key indexes: %v", err)
+	}
+	tenantStore := auth.NewMongoTenantStore(database.GetOrganizationsCollection(), database.GetMembershipsCollection())
+	if err := tenantStore.EnsureIndexes(ctx); err != nil {
+		log.Fatalf("Failed to create tenant indexes: %v", err)
+	}
+	attemptStore := auth.NewMongoAttemptStore(database.GetLoginAttemptsCollection())
+	if err := attemptStore.EnsureIndexes(ctx); err != nil {
+		log.Fatalf("Failed to create login attempt indexes: %v", err)
+	}
+	auditKey := config.GetAuditHMACKey()
+	if auditKey == "" {
+		if cfg.Env == "production" {
+			log.Fatalf("AUDIT_HMAC_KEY is required in production")
+		}
+		log.Printf("AUDIT_HMAC_KEY is not set, using the development audit key")
+		auditKey = "development-audit-key"
+	}
+	auditLog := audit.NewLog(database.GetAuditLogCollection(), []byte(auditKey))
+	if err := auditLog.EnsureIndexes(ctx); err != nil {
+		log.Fatalf("Failed to create audit log indexes: %v", err)
+	}
+	eventStore := events.NewMongoStore(database.GetEventsCollection())
+	if err := eventStore.EnsureIndexes(ctx); err != nil {
+		log.Fatalf("Failed to create event indexes: %v", err)
+	}
+	dedupeStore := events.NewMongoDedupeStore(database.GetEventIDsCollection())
+	if err := dedupeStore.EnsureIndexes(ctx); err != nil {
+		log.Fatalf("Failed to create event ID indexes: %v", err)
+	}
+	cancel()
+	audit.SetLog(auditLog)
+	auth.SetUserStore(userStore)
+	auth.SetTenantStore(tenantStore)
+	auth.SetPolicyEngine(auth.NewPolicyEngine(auth.DefaultPolicy(), tenantStore))
+	auth.SetTokenStore(tokenStore)
+	auth.SetAPIKeyStore(apiKeyStore)
+	mailer, err := mail.NewMailerFromConfig()
+	if err != nil {
+		log.Fatalf("Failed to configure mailer: %v", err)
+	}
+	auth.SetMailer(mailer)
+	if oidcConfig := auth.OIDCConfigFromEnv(); oidcConfig.Issuer != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		provider, err := auth.NewOIDCProvider(ctx, oidcConfig)
+		cancel()
+		if err != nil {
+			log.Fatalf("Failed to configure single sign-on: %v", err)
+		}
+		auth.SetOIDCProvider(provider)
+	}
+	accountPolicy, ipPolicy := auth.DefaultLockoutPolicies()
+	auth.SetLoginLimiter(auth.NewLoginLimiter(attemptStore, accountPolicy, ipPolicy))
+
+	// Bring events stored under older schema versions up to date
+	go func() {
+		upgraded, err := eventStore.UpgradeStored(context.Background())
+		if err != nil {
+			log.Printf("Failed to upgrade stored events: %v", err)
+			return
+		}
+		if upgraded > 0 {
+			log.Printf("Upgraded %d stored events to the current schema", upgraded)
+		}
+	}()
+
+	// Start the background event writer
+	eventWriter := events.NewWriter(eventStore, config.GetEventQueueSize(), config.GetEventBatchSize(), config.GetEventFlushInterval())
+	go eventWriter.Run()
+
+	// Shared event processor, fed by the WebSocket manager
+	aiService, err := services.NewAIService()
+	if err != nil {
+		log.Fatalf("Failed to initialize AI service: %v", err)
+	}
+	processor := services.NewEventProcessor(services.NewActivityAnalyzer(aiService))
 
-	// Initialize router
-	router := routes.SetupRouter(manager)
+	// Initialize WebSocket manager; analyses are published to the analyzed
+	// user's topic
+	manager := ws.NewManager(eventWriter, processor)
+	processor.SetAnalysisHandler(manager.PushAnalysis)
 
-	// Add WebSocket endpoint
-	router.GET("/ws", func(c *gin.Context) {
-		// Use the already created manager
-		handler := ws.NewHandler(manager)
-		handler.HandleWebSocket(c.Writer, c.Request)
-	})
+	// Initialize router (includes the WebSocket endpoint)
+	router := routes.SetupRouter(manager, aiService, processor)
 
 	// Start server
 	port := os.Getenv("PORT")
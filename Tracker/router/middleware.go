@@ -38,20 +38,6 @@ func LoggerMiddleware() gin.HandlerFunc {
     }
 }
 
-// AuthMiddleware handles authentication
-func AuthMiddleware() gin.HandlerFunc {
-    return func(c *gin.Context) {
-        token := c.GetHeader("Authorization")
-        if token == "" {
-            c.JSON(401, gin.H{"error": "Authentication required"})
-            c.Abort()
-            return
-        }
-        // TODO: Implement proper token validation
-        c.Next()
-    }
-}
-
 // ErrorMiddleware handles errors globally
 func ErrorMiddleware() gin.HandlerFunc {
     return func(c *gin.Context) {
@@ -1,21 +1,26 @@
 package routes
 
 import (
+	"net/http"
+
+	auth "Tracker/Authatication"
 	"Tracker/internal/controllers"
+	"Tracker/internal/services"
 	"Tracker/internal/ws"
 
 	"github.com/gin-gonic/gin"
 )
 
-// SetupRouter configures all the routes for the application
-func SetupRouter(manager *ws.Manager) *gin.Engine {
+// SetupRouter configures all the routes for the application. The event
+// processor is the one the WebSocket manager forwards live events to.
+func SetupRouter(manager *ws.Manager, aiService *services.AIService, processor *services.EventProcessor) *gin.Engine {
 	router := gin.Default()
 
 	// Enable CORS
 	router.Use(func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
 			return
@@ -23,23 +28,117 @@ func SetupRouter(manager *ws.Manager) *gin.Engine {
 		c.Next()
 	})
 	// Initialize controller
-	activityController, err := controllers.NewActivityController()
-	if err != nil {
-		panic(err)
+	activityController := controllers.NewActivityController(aiService, processor)
+
+	// Public signing keys for other services verifying tracker tokens
+	router.GET("/.well-known/jwks.json", auth.JWKSHandler)
+
+	// Public auth routes
+	authRoutes := router.Group("/auth")
+	{
+		authRoutes.POST("/register", auth.RegisterHandler)
+		authRoutes.POST("/login", auth.LoginHandler)
+		authRoutes.POST("/login/mfa", auth.LoginMFAHandler)
+		authRoutes.POST("/refresh", auth.RefreshTokenHandler)
+		authRoutes.GET("/verify", auth.VerifyEmailHandler)
+		authRoutes.POST("/verify", auth.VerifyEmailHandler)
+		authRoutes.POST("/verify/resend", auth.AuthMiddleware(), auth.SessionOnly(), auth.ResendVerificationHandler)
+		authRoutes.POST("/reset/request", auth.RequestPasswordResetHandler)
+		authRoutes.POST("/reset", auth.ResetPasswordHandler)
+		authRoutes.GET("/oidc/login", auth.OIDCLoginHandler)
+		authRoutes.GET("/oidc/callback", auth.OIDCCallbackHandler)
+		authRoutes.POST("/logout", auth.AuthMiddleware(), auth.SessionOnly(), auth.LogoutHandler)
+		authRoutes.POST("/logout-all", auth.AuthMiddleware(), auth.SessionOnly(), auth.LogoutAllHandler)
 	}
 
+	// Protected API routes
+	api := router.Group("/api")
+	api.Use(auth.AuthMiddleware(), auth.RoleMiddleware(auth.RoleUser, auth.RoleAdmin))
+
 	// Activity routes
-	activities := router.Group("/api/activities")
+	activities := api.Group("/activities")
+	{
+		canRead := auth.RequirePermission(auth.PermActivitiesRead, auth.ConditionOwn)
+		canWrite := auth.RequirePermission(auth.PermActivitiesWrite, auth.ConditionOwn)
+		canAnalyze := auth.RequirePermission(auth.PermAnalysesRead, auth.ConditionOwn)
+
+		activities.POST("", canWrite, activityController.CreateActivity)
+		activities.GET("", canRead, activityController.GetActivities)
+		activities.GET("/summary", canAnalyze, activityController.GetActivitySummary)
+		activities.GET("/analysis", canAnalyze, activityController.AnalyzeActivity)
+		activities.GET("/:id", canRead, activityController.GetActivity)
+		activities.PUT("/:id", canWrite, activityController.UpdateActivity)
+		activities.DELETE("/:id", canWrite, activityController.DeleteActivity)
+	}
+
+	// Personal API key management, only from an interactive session
+	keys := api.Group("/keys")
+	keys.Use(auth.SessionOnly())
+	{
+		keys.POST("", auth.CreateAPIKeyHandler)
+		keys.GET("", auth.ListAPIKeysHandler)
+		keys.PATCH("/:id", auth.RenameAPIKeyHandler)
+		keys.DELETE("/:id", auth.RevokeAPIKeyHandler)
+	}
+
+	// Two-factor enrolment, only from an interactive session
+	mfa := api.Group("/mfa/totp")
+	mfa.Use(auth.SessionOnly())
+	{
+		mfa.POST("/enroll", auth.EnrollTOTPHandler)
+		mfa.POST("/confirm", auth.ConfirmTOTPHandler)
+		mfa.POST("/disable", auth.DisableTOTPHandler)
+	}
+
+	// Organization and team routes
+	orgController := controllers.NewOrganizationController()
+	org := api.Group("/org")
+	org.Use(auth.SessionOnly())
 	{
-		activities.POST("", activityController.CreateActivity)
-		activities.GET("", activityController.GetActivities)
-		activities.GET("/:id", activityController.GetActivity)
-		activities.PUT("/:id", activityController.UpdateActivity)
-		activities.DELETE("/:id", activityController.DeleteActivity)
+		org.GET("", orgController.GetOrganization)
+		org.POST("/join-code", orgController.RotateJoinCode)
+		org.GET("/members", auth.RequirePermission(auth.PermUsersRead, auth.ConditionTenant), orgController.ListMembers)
+		org.GET("/teams", orgController.ListTeams)
+		org.POST("/teams", orgController.CreateTeam)
+		org.PUT("/teams/:id/members", orgController.AddTeamMember)
+		org.DELETE("/teams/:id/members/:userId", orgController.RemoveTeamMember)
+		org.GET("/teams/:id/summary", auth.RequirePermission(auth.PermAnalysesRead, auth.ConditionTeam), orgController.GetTeamSummary)
+		org.GET("/members/:userId/summary", orgController.GetMemberSummary)
 	}
 
+	// Raw event timeline and batch uploads for clients without a WebSocket
+	registerEventRoutes(api, controllers.NewEventController(manager))
+
+	// Single-use tickets for opening the WebSocket from a browser
+	api.POST("/ws/ticket", auth.RequirePermission(auth.PermEventsWrite, auth.ConditionOwn), auth.WebSocketTicketHandler)
+
 	// AI suggestions route
-	router.GET("/api/suggestions", activityController.GetSuggestions)
+	api.GET("/suggestions", auth.SessionOnly(), auth.RequirePermission(auth.PermAnalysesRead, auth.ConditionOwn), activityController.GetSuggestions)
+
+	// Admin routes with an explicit cross-user view
+	admin := api.Group("/admin")
+	admin.Use(auth.SessionOnly(), auth.RequireMFA())
+	{
+		canReadAny := auth.RequirePermission(auth.PermActivitiesRead, auth.ConditionAny)
+
+		admin.GET("/activities", canReadAny, activityController.GetAllActivities)
+		admin.GET("/activities/:id", canReadAny, activityController.GetAnyActivity)
+
+		canManageUsers := auth.RequirePermission(auth.PermUsersWrite, auth.ConditionAny)
+		admin.GET("/lockouts", canManageUsers, auth.GetLockoutHandler)
+		admin.POST("/lockouts/unlock", canManageUsers, auth.UnlockHandler)
+		admin.PUT("/users/:id/role", canManageUsers, auth.SetUserRoleHandler)
+
+		auditController := controllers.NewAuditController()
+		canReadUsers := auth.RequirePermission(auth.PermUsersRead, auth.ConditionAny)
+		admin.GET("/audit", canReadUsers, auditController.QueryAuditLog)
+		admin.GET("/audit/verify", canReadUsers, auditController.VerifyAuditLog)
+
+		// Open WebSocket connections and those closed by the server, by cause
+		admin.GET("/ws/stats", canReadAny, func(c *gin.Context) {
+			c.JSON(http.StatusOK, manager.Stats())
+		})
+	}
 
 	// WebSocket endpoint
 	wsHandler := ws.NewHandler(manager)
@@ -49,3 +148,25 @@ func SetupRouter(manager *ws.Manager) *gin.Engine {
 
 	return router
 }
+
+// registerEventRoutes adds the event timeline and the custom methods of
+// /events, such as /events:batch
+func registerEventRoutes(api *gin.RouterGroup, eventController *controllers.EventController) {
+	api.GET("/events", auth.RequirePermission(auth.PermEventsRead, auth.ConditionOwn), eventController.GetTimeline)
+	api.POST("/events:action", requireAction(":batch"), auth.RequirePermission(auth.PermEventsWrite, auth.ConditionOwn), auth.RequireVerifiedEmail(), eventController.PostEventsAction)
+}
+
+// requireAction answers 404 for custom methods other than actions before
+// any authentication runs, so unknown methods are not found for every caller
+func requireAction(actions ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for _, action := range actions {
+			if c.Param("action") == action {
+				c.Next()
+				return
+			}
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown action"})
+		c.Abort()
+	}
+}